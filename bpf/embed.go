@@ -0,0 +1,41 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package bpf embeds the compiled eBPF objects into the binary, so the commands do not
+// depend on the location of the *.bpf.o files. Run `go generate ./bpf` (or `make build`)
+// to compile the objects before building the binary.
+package bpf
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+//go:generate make bpf.build
+
+const (
+	// XDPProxyObject is the object compiled from xdp-proxy.bpf.c, which holds the XDP
+	// program enforcing policies and the tracing programs.
+	XDPProxyObject = "xdp-proxy.bpf.o"
+
+	outputDir = "output"
+)
+
+// the "all:" prefix keeps output/.gitignore, so the package builds before the objects are
+// generated and reports the missing object at runtime instead
+//
+//go:embed all:output
+var objects embed.FS
+
+// Object returns the content of the embedded object with given name.
+func Object(name string) ([]byte, error) {
+	data, err := objects.ReadFile(path.Join(outputDir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("eBPF object %v was not embedded, run `go generate ./bpf` before building or give the path of object", name)
+	}
+	return data, err
+}
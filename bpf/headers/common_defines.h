@@ -1,29 +0,0 @@
-/*
- * Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
- * Use of this source code is governed by a MIT style
- * license that can be found in the LICENSE file.
- */
-
-#include <net/if.h>
-#include <linux/types.h>
-#include <stdbool.h>
-
-struct config {
-    __u32 xdp_flags;
-    int ifindex;
-    char *ifname;
-    char *filename;
-    char ifname_buf[IF_NAMESIZE];
-    int redirect_ifindex;
-    char *redirect_ifname;
-    char redirect_ifname_buf[IF_NAMESIZE];
-    bool do_unload;
-    bool reuse_maps;
-    char pin_dir[512];
-    char progsec[32];
-    char src_mac[18];
-    char dest_mac[18];
-    __u16 xsk_bind_flags;
-    int xsk_if_queue;
-    bool xsk_poll_mode;
-};
\ No newline at end of file
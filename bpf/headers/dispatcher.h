@@ -0,0 +1,334 @@
+/*
+ * Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+ * Use of this source code is governed by a MIT style
+ * license that can be found in the LICENSE file.
+ */
+
+#include <linux/bpf.h>
+#include <linux/if_ether.h>
+#include <linux/ip.h>
+#include <linux/in.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+
+/* maximum number of stages in the chain run by xdp_dispatcher,
+   it should be synchronized to XDP_DISPATCHER_MAX_STAGES in pkg/ebpf/dispatcher.go */
+#define XDP_DISPATCHER_MAX_STAGES 16
+
+/* xdp_stages holds two chains of stages in slots [0, MAX) and [MAX, 2 * MAX). Userspace
+   fills the inactive chain and then flips xdp_dispatcher_config.active, so packets always
+   run through a complete chain while stages are inserted, removed or reordered */
+struct {
+    __uint(type, BPF_MAP_TYPE_PROG_ARRAY);
+    __uint(max_entries, 2 * XDP_DISPATCHER_MAX_STAGES);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, sizeof(__u32));
+} xdp_stages SEC(".maps");
+
+struct xdp_dispatcher_config {
+    __u32 active; /* chain run by the dispatcher, 0 or 1 */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct xdp_dispatcher_config);
+} xdp_dispatcher_config SEC(".maps");
+
+/* xdp_stage_cursor is the slot of the running stage. Tail calls of a packet run on the
+   same CPU without being preempted, so a per-cpu slot carries it along the chain */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u32);
+} xdp_stage_cursor SEC(".maps");
+
+/* reasons of the packets dropped by the XDP programs,
+   they should be synchronized to XDP_DROP_* in pkg/ebpf/dispatcher.go */
+#define XDP_DROP_BLOCKLIST 0
+#define XDP_DROP_RATELIMIT 1
+#define XDP_DROP_ALLOWLIST 2
+#define XDP_DROP_REASONS 3
+
+/* xdp_drop_stats counts the packets dropped by the XDP programs by reasons */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, XDP_DROP_REASONS);
+    __type(key, __u32);
+    __type(value, __u64);
+} xdp_drop_stats SEC(".maps");
+
+static __always_inline void count_drop(__u32 reason)
+{
+    __u64 *count = bpf_map_lookup_elem(&xdp_drop_stats, &reason);
+    if (count)
+        *count += 1;
+}
+
+/*  xdp_stage_next passes the packet to the next stage of the chain, the packet is passed
+    to the kernel when the current stage is the last one
+    @param ctx: the XDP context of current packet
+ */
+static __always_inline int xdp_stage_next(struct xdp_md *ctx)
+{
+    __u32 zero = 0;
+    __u32 *cursor = bpf_map_lookup_elem(&xdp_stage_cursor, &zero);
+    if (!cursor)
+        return XDP_PASS;
+
+    __u32 slot = *cursor + 1;
+    /* never run into the other chain */
+    if (slot % XDP_DISPATCHER_MAX_STAGES == 0)
+        return XDP_PASS;
+
+    *cursor = slot;
+    bpf_tail_call(ctx, &xdp_stages, slot);
+
+    /* the slot is empty, the chain ends here */
+    return XDP_PASS;
+}
+
+SEC("xdp")
+int xdp_dispatcher(struct xdp_md *ctx)
+{
+    __u32 zero = 0;
+    struct xdp_dispatcher_config *config = bpf_map_lookup_elem(&xdp_dispatcher_config, &zero);
+    __u32 *cursor = bpf_map_lookup_elem(&xdp_stage_cursor, &zero);
+    if (!config || !cursor)
+        return XDP_PASS;
+
+    __u32 slot = config->active ? XDP_DISPATCHER_MAX_STAGES : 0;
+    *cursor = slot;
+    bpf_tail_call(ctx, &xdp_stages, slot);
+
+    /* no stage is installed */
+    return XDP_PASS;
+}
+
+/* parse_tcp4 returns the IPv4 header of TCP packets, NULL for other packets */
+static __always_inline struct iphdr *parse_tcp4(struct xdp_md *ctx)
+{
+    void *data = (void *)(long)ctx->data;
+    void *data_end = (void *)(long)ctx->data_end;
+
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end || eth->h_proto != bpf_htons(ETH_P_IP))
+        return NULL;
+
+    struct iphdr *iph = (void *)(eth + 1);
+    if ((void *)(iph + 1) > data_end || iph->protocol != IPPROTO_TCP)
+        return NULL;
+
+    return iph;
+}
+
+/* xdp_blocklist drops TCP packets whose source address is in the bridge map, the packets
+   from the addresses in the monitor map are counted and passed, xdp_sampler flags them */
+SEC("xdp")
+int xdp_blocklist(struct xdp_md *ctx)
+{
+    struct iphdr *iph = parse_tcp4(ctx);
+    if (!iph)
+        return xdp_stage_next(ctx);
+
+    __u32 key = bpf_ntohl(iph->saddr);
+    __u32 *drops = bpf_map_lookup_elem(&bridge, &key);
+    if (!drops) {
+        monitored(key);
+        return xdp_stage_next(ctx);
+    }
+
+    __sync_fetch_and_add(drops, 1);
+    count_drop(XDP_DROP_BLOCKLIST);
+    sample_packet(ctx, XDP_DROP);
+    return XDP_DROP;
+}
+
+/* filter modes of the interfaces, they should be synchronized to XDP_FILTER_* in
+   pkg/ebpf/allowlist.go */
+#define XDP_FILTER_BLOCKLIST 0
+#define XDP_FILTER_ALLOWLIST 1
+
+/* xdp_filter_mode holds the mode of the interfaces by their ifindex, an interface not in
+   the map is in blocklist mode */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 64);
+    __type(key, __u32);
+    __type(value, __u32);
+} xdp_filter_mode SEC(".maps");
+
+/* the key of the allowed sources, it should be synchronized to LPMKey in
+   pkg/ebpf/allowlist.go */
+struct lpm_v4_key {
+    __u32 prefixlen;
+    __u32 addr; /* network byte order */
+};
+
+/* the key of the allowed local ports, it should be synchronized to PortKey in
+   pkg/ebpf/allowlist.go */
+struct port_key {
+    __u16 port;
+    __u8 protocol;
+    __u8 pad;
+};
+
+/* sources and ports passed by the pass policies, the values count the packets passed */
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 1024);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct lpm_v4_key);
+    __type(value, __u32);
+} xdp_allow_sources SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 256);
+    __type(key, struct port_key);
+    __type(value, __u32);
+} xdp_allow_ports SEC(".maps");
+
+/* the management set is always passed, it is written from the configuration only so that
+   no policy removes it by accident */
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 64);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct lpm_v4_key);
+    __type(value, __u32);
+} xdp_mgmt_sources SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 64);
+    __type(key, struct port_key);
+    __type(value, __u32);
+} xdp_mgmt_ports SEC(".maps");
+
+static __always_inline int allow_source(void *map, __u32 saddr)
+{
+    struct lpm_v4_key key = {.prefixlen = 32, .addr = saddr};
+    __u32 *passes = bpf_map_lookup_elem(map, &key);
+    if (!passes)
+        return 0;
+    __sync_fetch_and_add(passes, 1);
+    return 1;
+}
+
+static __always_inline int allow_port(void *map, __u16 port, __u8 protocol)
+{
+    struct port_key key = {.port = port, .protocol = protocol};
+    __u32 *passes = bpf_map_lookup_elem(map, &key);
+    if (!passes)
+        return 0;
+    __sync_fetch_and_add(passes, 1);
+    return 1;
+}
+
+/* xdp_allowlist drops the IPv4 packets on the interfaces in allowlist mode unless their
+   sources or local TCP and UDP ports are allowed, other frames like ARP are passed */
+SEC("xdp")
+int xdp_allowlist(struct xdp_md *ctx)
+{
+    __u32 ifindex = ctx->ingress_ifindex;
+    __u32 *mode = bpf_map_lookup_elem(&xdp_filter_mode, &ifindex);
+    if (!mode || *mode != XDP_FILTER_ALLOWLIST)
+        return xdp_stage_next(ctx);
+
+    void *data = (void *)(long)ctx->data;
+    void *data_end = (void *)(long)ctx->data_end;
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end || eth->h_proto != bpf_htons(ETH_P_IP))
+        return xdp_stage_next(ctx);
+    struct iphdr *iph = (void *)(eth + 1);
+    if ((void *)(iph + 1) > data_end)
+        return XDP_DROP;
+
+    if (allow_source(&xdp_mgmt_sources, iph->saddr) || allow_source(&xdp_allow_sources, iph->saddr))
+        return xdp_stage_next(ctx);
+
+    if (iph->protocol == IPPROTO_TCP || iph->protocol == IPPROTO_UDP) {
+        /* the destination port is at the same offset of TCP and UDP headers */
+        __u16 *ports = (void *)iph + iph->ihl * 4;
+        if ((void *)(ports + 2) > data_end)
+            return XDP_DROP;
+
+        __u16 port = bpf_ntohs(ports[1]);
+        if (allow_port(&xdp_mgmt_ports, port, iph->protocol) ||
+            allow_port(&xdp_allow_ports, port, iph->protocol))
+            return xdp_stage_next(ctx);
+    }
+
+    count_drop(XDP_DROP_ALLOWLIST);
+    sample_packet(ctx, XDP_DROP);
+    return XDP_DROP;
+}
+
+/* xdp_ratelimit_config is written by userspace, it should be synchronized to
+   RateLimitConfig in pkg/ebpf/dispatcher.go */
+struct xdp_ratelimit_config {
+    __u64 interval_ns; /* nanoseconds between two packets of a source, 0 disables the limit */
+    __u64 burst_ns;    /* how far a source can run ahead of its rate, interval_ns * burst */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct xdp_ratelimit_config);
+} xdp_ratelimit_config SEC(".maps");
+
+/* theoretical arrival time of the next packet of every source address */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __u32);
+    __type(value, __u64);
+} xdp_ratelimit_tat SEC(".maps");
+
+/* xdp_ratelimit limits the packet rate of every TCP source address with GCRA */
+SEC("xdp")
+int xdp_ratelimit(struct xdp_md *ctx)
+{
+    __u32 zero = 0;
+    struct xdp_ratelimit_config *config = bpf_map_lookup_elem(&xdp_ratelimit_config, &zero);
+    if (!config || config->interval_ns == 0)
+        return xdp_stage_next(ctx);
+
+    struct iphdr *iph = parse_tcp4(ctx);
+    if (!iph)
+        return xdp_stage_next(ctx);
+
+    __u32 key = bpf_ntohl(iph->saddr);
+    __u64 now = bpf_ktime_get_ns();
+    __u64 tat = now;
+    __u64 *last = bpf_map_lookup_elem(&xdp_ratelimit_tat, &key);
+    if (last && *last > now)
+        tat = *last;
+
+    if (tat - now > config->burst_ns) {
+        count_drop(XDP_DROP_RATELIMIT);
+        sample_packet(ctx, XDP_DROP);
+        return XDP_DROP;
+    }
+
+    tat += config->interval_ns;
+    bpf_map_update_elem(&xdp_ratelimit_tat, &key, &tat, BPF_ANY);
+    return xdp_stage_next(ctx);
+}
+
+/* xdp_sampler samples the packets which reach it, once for every packet, the packets from
+   the addresses in the monitor map are flagged as they would have been dropped */
+SEC("xdp")
+int xdp_sampler(struct xdp_md *ctx)
+{
+    struct iphdr *iph = parse_tcp4(ctx);
+    if (iph && in_monitor(bpf_ntohl(iph->saddr)))
+        sample_packet(ctx, XDP_PASS | SAMPLE_MONITORED);
+    else
+        sample_packet(ctx, XDP_PASS);
+    return xdp_stage_next(ctx);
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+ * Use of this source code is governed by a MIT style
+ * license that can be found in the LICENSE file.
+ */
+
+#include <linux/bpf.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+
+#define TASK_COMM_LEN 16
+#define ARGSIZE 128
+#define TOTAL_MAX_ARGS 60
+#define FULL_MAX_ARGS_ARR (TOTAL_MAX_ARGS * ARGSIZE)
+#define LAST_ARG (FULL_MAX_ARGS_ARR - ARGSIZE)
+#define BASE_EXEC_EVENT_SIZE (__u64)(&((struct exec_event *)0)->args)
+#define EXEC_EVENT_SIZE(e) (BASE_EXEC_EVENT_SIZE + e->args_size)
+#define EXEC_RINGBUF_SIZE (256 * 1024)
+
+/* exec_event is pushed to userspace for every execve, args holds the NUL separated argv.
+   it should be synchronized to execEventHeader in pkg/ebpf/exec.go */
+struct exec_event {
+    __u64 timestamp;
+    __u64 cgroup_id;
+    __u32 pid;
+    __u32 tid;
+    __u32 ppid;
+    __u32 uid;
+    __s32 retval;
+    __u32 args_count;
+    __u32 args_size;
+    char comm[TASK_COMM_LEN];
+    char args[FULL_MAX_ARGS_ARR];
+};
+
+/* layout of the tracepoint context, see
+   /sys/kernel/debug/tracing/events/syscalls/sys_{enter,exit}_execve/format */
+struct sys_enter_execve_ctx {
+    __u64 __unused;
+    __s32 syscall_nr;
+    __u32 __pad;
+    const char *filename;
+    const char *const *argv;
+    const char *const *envp;
+};
+
+struct sys_exit_execve_ctx {
+    __u64 __unused;
+    __s32 syscall_nr;
+    __u32 __pad;
+    long ret;
+};
+
+/* only the fields we read, CO-RE relocates them against the kernel BTF */
+struct task_struct {
+    int tgid;
+    struct task_struct *real_parent;
+} __attribute__((preserve_access_index));
+
+static const struct exec_event empty_exec_event = {};
+
+/* in-flight execve keyed by thread id, filled at enter and pushed at exit */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u32);
+    __type(value, struct exec_event);
+} execs SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EXEC_RINGBUF_SIZE);
+} exec_events SEC(".maps");
+
+SEC("tracepoint/syscalls/sys_enter_execve")
+int tracepoint__syscalls__sys_enter_execve(struct sys_enter_execve_ctx *ctx)
+{
+    __u64 id = bpf_get_current_pid_tgid();
+    __u32 tid = (__u32)id;
+    struct exec_event *event;
+    struct task_struct *task;
+    const char *argp;
+    int ret;
+
+    if (bpf_map_update_elem(&execs, &tid, &empty_exec_event, BPF_NOEXIST))
+        return 0;
+
+    event = bpf_map_lookup_elem(&execs, &tid);
+    if (!event)
+        return 0;
+
+    event->timestamp = bpf_ktime_get_ns();
+    event->cgroup_id = bpf_get_current_cgroup_id();
+    event->pid = id >> 32;
+    event->tid = tid;
+    event->uid = (__u32)bpf_get_current_uid_gid();
+    task = (struct task_struct *)bpf_get_current_task();
+    event->ppid = BPF_CORE_READ(task, real_parent, tgid);
+
+    ret = bpf_probe_read_user_str(event->args, ARGSIZE, ctx->filename);
+    if (ret < 0)
+        return 0;
+    if (ret <= ARGSIZE) {
+        event->args_size += ret;
+    } else {
+        /* write an empty string */
+        event->args[0] = '\0';
+        event->args_size++;
+    }
+    event->args_count++;
+
+#pragma clang loop unroll(full)
+    for (int i = 1; i < TOTAL_MAX_ARGS; i++) {
+        if (bpf_probe_read_user(&argp, sizeof(argp), &ctx->argv[i]) || !argp)
+            return 0;
+
+        if (event->args_size > LAST_ARG)
+            return 0;
+
+        ret = bpf_probe_read_user_str(&event->args[event->args_size], ARGSIZE, argp);
+        if (ret < 0)
+            return 0;
+
+        event->args_count++;
+        event->args_size += ret;
+    }
+
+    /* try to read one more argument to check if there is one */
+    if (bpf_probe_read_user(&argp, sizeof(argp), &ctx->argv[TOTAL_MAX_ARGS]) || !argp)
+        return 0;
+
+    /* pointer to max_args+1 isn't null, assume we have more arguments */
+    event->args_count++;
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_execve")
+int tracepoint__syscalls__sys_exit_execve(struct sys_exit_execve_ctx *ctx)
+{
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    struct exec_event *event;
+    __u64 len;
+
+    event = bpf_map_lookup_elem(&execs, &tid);
+    if (!event)
+        return 0;
+
+    event->retval = ctx->ret;
+    bpf_get_current_comm(&event->comm, sizeof(event->comm));
+    len = EXEC_EVENT_SIZE(event);
+    if (len <= sizeof(*event))
+        bpf_ringbuf_output(&exec_events, event, len, 0);
+
+    bpf_map_delete_elem(&execs, &tid);
+    return 0;
+}
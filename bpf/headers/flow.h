@@ -0,0 +1,98 @@
+/*
+ * Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+ * Use of this source code is governed by a MIT style
+ * license that can be found in the LICENSE file.
+ */
+
+#include <linux/bpf.h>
+#include <linux/ptrace.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+#include "bpf_tracing.h"
+
+#ifndef TASK_COMM_LEN
+#define TASK_COMM_LEN 16
+#endif
+#define FLOW_CONNECT 1
+#define FLOW_ACCEPT 2
+#define AF_INET 2
+
+/* flow_key identifies a TCP connection from the view of local host, addresses are in
+   network byte order and ports are in host byte order.
+   it should be synchronized to flowKey in pkg/ebpf/flow.go */
+struct flow_key {
+    __u32 laddr;
+    __u32 raddr;
+    __u16 lport;
+    __u16 rport;
+};
+
+/* flow_owner records the process which opens the connection.
+   it should be synchronized to flowOwner in pkg/ebpf/flow.go */
+struct flow_owner {
+    __u64 timestamp;
+    __u64 cgroup_id;
+    __u32 pid;
+    __u32 uid;
+    __u32 direction;
+    __u32 __pad;
+    char comm[TASK_COMM_LEN];
+};
+
+/* only the fields we read, CO-RE relocates them against the kernel BTF */
+struct sock_common {
+    unsigned short skc_family;
+    volatile unsigned char skc_state;
+    __u32 skc_daddr;
+    __u32 skc_rcv_saddr;
+    __u16 skc_dport;
+    __u16 skc_num;
+} __attribute__((preserve_access_index));
+
+struct sock {
+    struct sock_common __sk_common;
+} __attribute__((preserve_access_index));
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, struct flow_key);
+    __type(value, struct flow_owner);
+} flows SEC(".maps");
+
+static __always_inline int record_flow(struct sock *sk, __u32 direction)
+{
+    struct flow_key key = {};
+    struct flow_owner owner = {};
+
+    if (!sk || BPF_CORE_READ(sk, __sk_common.skc_family) != AF_INET)
+        return 0;
+
+    key.laddr = BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr);
+    key.raddr = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+    key.lport = BPF_CORE_READ(sk, __sk_common.skc_num);
+    key.rport = bpf_ntohs(BPF_CORE_READ(sk, __sk_common.skc_dport));
+
+    owner.timestamp = bpf_ktime_get_ns();
+    owner.cgroup_id = bpf_get_current_cgroup_id();
+    owner.pid = bpf_get_current_pid_tgid() >> 32;
+    owner.uid = (__u32)bpf_get_current_uid_gid();
+    owner.direction = direction;
+    bpf_get_current_comm(&owner.comm, sizeof(owner.comm));
+
+    bpf_map_update_elem(&flows, &key, &owner, BPF_ANY);
+    return 0;
+}
+
+SEC("kprobe/tcp_connect")
+int BPF_KPROBE(kprobe__tcp_connect, struct sock *sk)
+{
+    return record_flow(sk, FLOW_CONNECT);
+}
+
+SEC("kretprobe/inet_csk_accept")
+int BPF_KRETPROBE(kretprobe__inet_csk_accept, struct sock *sk)
+{
+    return record_flow(sk, FLOW_ACCEPT);
+}
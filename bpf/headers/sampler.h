@@ -0,0 +1,84 @@
+/*
+ * Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+ * Use of this source code is governed by a MIT style
+ * license that can be found in the LICENSE file.
+ */
+
+#include <linux/bpf.h>
+#include <bpf/bpf_helpers.h>
+
+/* number of bytes copied from the head of every sampled packet */
+#define SAMPLE_SNAPLEN 128
+#define SAMPLE_RINGBUF_SIZE (256 * 1024)
+
+/* packet_sample is the record pushed to userspace for every sampled packet,
+   it should be synchronized to PacketSample in pkg/ebpf/sampler.go */
+/* SAMPLE_MONITORED is or-ed into the action of the packets passed by the monitor map which
+   would have been dropped, it should be synchronized to SampleMonitored in pkg/ebpf/sampler.go */
+#define SAMPLE_MONITORED (1 << 8)
+
+struct packet_sample {
+    __u64 timestamp;
+    __u32 ifindex;
+    __u32 pkt_len;
+    __u32 cap_len;
+    __u32 action;
+    __u8 data[SAMPLE_SNAPLEN];
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, SAMPLE_RINGBUF_SIZE);
+} samples SEC(".maps");
+
+/* sample_config is written by userspace, it should be synchronized to SampleConfig in
+   pkg/ebpf/sampler.go */
+struct sample_config {
+    __u32 rate; /* one of every rate packets is sampled, 0 and 1 sample every packet */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct sample_config);
+} sample_config SEC(".maps");
+
+/*  sample_packet copies the packet metadata and the first SAMPLE_SNAPLEN bytes
+    of the packet into the samples ring buffer, one of every sample_config.rate
+    packets is picked at random
+    @param ctx: the XDP context of current packet
+    @param action: the XDP verdict that will be returned for this packet
+ */
+static __always_inline void sample_packet(struct xdp_md *ctx, __u32 action)
+{
+    void *data = (void *)(long)ctx->data;
+    void *data_end = (void *)(long)ctx->data_end;
+    struct packet_sample *sample;
+    __u32 i, zero = 0;
+
+    struct sample_config *config = bpf_map_lookup_elem(&sample_config, &zero);
+    if (config && config->rate > 1 && bpf_get_prandom_u32() % config->rate != 0)
+        return;
+
+    sample = bpf_ringbuf_reserve(&samples, sizeof(*sample), 0);
+    if (!sample) {
+        // ring buffer is full, userspace consumer is too slow
+        return;
+    }
+
+    sample->timestamp = bpf_ktime_get_ns();
+    sample->ifindex = ctx->ingress_ifindex;
+    sample->pkt_len = data_end - data;
+    sample->action = action;
+
+#pragma clang loop unroll(full)
+    for (i = 0; i < SAMPLE_SNAPLEN; i++) {
+        if (data + i + 1 > data_end)
+            break;
+        sample->data[i] = *(__u8 *)(data + i);
+    }
+    sample->cap_len = i;
+
+    bpf_ringbuf_submit(sample, 0);
+}
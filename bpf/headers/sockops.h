@@ -7,9 +7,37 @@
 #define MAX_ENTRIES 1024
 #include <linux/bpf.h>
 
+/* bridge is the blocklist map keyed by source addresses in host byte order, the value
+   counts the packets dropped from the address */
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(key_size, sizeof(__u32));
     __uint(value_size, sizeof(__u32));
     __uint(max_entries, MAX_ENTRIES);
-} bridge SEC(".maps");
\ No newline at end of file
+} bridge SEC(".maps");
+
+/* monitor holds the source addresses of the monitor policies like bridge, the packets from
+   them are passed and the value counts the packets which would have been dropped */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, sizeof(__u32));
+    __uint(max_entries, MAX_ENTRIES);
+} monitor SEC(".maps");
+
+/* monitored counts the packets passed from the address in the monitor map, it reports
+   whether the address is monitored */
+static __always_inline int monitored(__u32 key)
+{
+    __u32 *would_drops = bpf_map_lookup_elem(&monitor, &key);
+    if (!would_drops)
+        return 0;
+    __sync_fetch_and_add(would_drops, 1);
+    return 1;
+}
+
+/* in_monitor reports whether the address is in the monitor map without counting the packet */
+static __always_inline int in_monitor(__u32 key)
+{
+    return bpf_map_lookup_elem(&monitor, &key) != NULL;
+}
\ No newline at end of file
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+ * Use of this source code is governed by a MIT style
+ * license that can be found in the LICENSE file.
+ */
+
+#include <linux/bpf.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+
+#define TCP_EVENT_RETRANSMIT 1
+#define TCP_EVENT_SEND_RESET 2
+#define TCP_EVENT_RECEIVE_RESET 3
+#define TCP_EVENT_STATE 4
+#define TCP_EVENTS_RINGBUF_SIZE (256 * 1024)
+
+#ifndef IPPROTO_TCP
+#define IPPROTO_TCP 6
+#endif
+
+/* tcp_event is pushed to userspace for every retransmission, reset and state change of
+   IPv4 TCP connections, the tuple follows struct flow_key in bpf/headers/flow.h.
+   it should be synchronized to tcpEventRecord in pkg/ebpf/tcpevents.go */
+struct tcp_event {
+    __u64 timestamp;
+    __u32 laddr;
+    __u32 raddr;
+    __u16 lport;
+    __u16 rport;
+    __u32 type;
+    __s32 oldstate; /* TCP_* states, only for TCP_EVENT_STATE */
+    __s32 newstate; /* TCP_* states, or the state of the socket when it is known */
+};
+
+/* layouts of the tracepoint contexts, only the fields we read, CO-RE relocates them
+   against the kernel BTF, see /sys/kernel/debug/tracing/events/{tcp,sock}/<event>/format */
+struct trace_event_raw_tcp_event_sk_skb {
+    const void *skaddr;
+} __attribute__((preserve_access_index));
+
+/* tcp_send_reset has its own class since 6.10, which also traces resets without a socket */
+struct trace_event_raw_tcp_send_reset {
+    const void *skaddr;
+    __u16 sport;
+    __u16 dport;
+    __u8 saddr[4];
+    __u8 daddr[4];
+} __attribute__((preserve_access_index));
+
+struct trace_event_raw_tcp_event_sk {
+    const void *skaddr;
+} __attribute__((preserve_access_index));
+
+struct trace_event_raw_inet_sock_set_state {
+    const void *skaddr;
+    int oldstate;
+    int newstate;
+    __u16 sport;
+    __u16 dport;
+    __u16 family;
+    __u16 protocol;
+    __u8 saddr[4];
+    __u8 daddr[4];
+} __attribute__((preserve_access_index));
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, TCP_EVENTS_RINGBUF_SIZE);
+} tcp_events SEC(".maps");
+
+/*  fill_tcp_event_sk fills the tuple and state of event from the socket
+    @return 0 when sk is an IPv4 socket
+ */
+static __always_inline int fill_tcp_event_sk(struct tcp_event *event, struct sock *sk)
+{
+    if (!sk || BPF_CORE_READ(sk, __sk_common.skc_family) != AF_INET)
+        return -1;
+
+    event->laddr = BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr);
+    event->raddr = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+    event->lport = BPF_CORE_READ(sk, __sk_common.skc_num);
+    event->rport = bpf_ntohs(BPF_CORE_READ(sk, __sk_common.skc_dport));
+    event->newstate = BPF_CORE_READ(sk, __sk_common.skc_state);
+    return 0;
+}
+
+/*  submit_tcp_event pushes the event of type about sk to userspace */
+static __always_inline int submit_tcp_event(struct sock *sk, __u32 type)
+{
+    struct tcp_event *event = bpf_ringbuf_reserve(&tcp_events, sizeof(*event), 0);
+    if (!event)
+        return 0;
+
+    __builtin_memset(event, 0, sizeof(*event));
+    if (fill_tcp_event_sk(event, sk) < 0) {
+        bpf_ringbuf_discard(event, 0);
+        return 0;
+    }
+    event->timestamp = bpf_ktime_get_ns();
+    event->type = type;
+
+    bpf_ringbuf_submit(event, 0);
+    return 0;
+}
+
+SEC("tracepoint/tcp/tcp_retransmit_skb")
+int tracepoint__tcp__tcp_retransmit_skb(struct trace_event_raw_tcp_event_sk_skb *ctx)
+{
+    return submit_tcp_event((struct sock *)BPF_CORE_READ(ctx, skaddr), TCP_EVENT_RETRANSMIT);
+}
+
+SEC("tracepoint/tcp/tcp_send_reset")
+int tracepoint__tcp__tcp_send_reset(void *ctx)
+{
+    if (!bpf_core_type_exists(struct trace_event_raw_tcp_send_reset)) {
+        struct trace_event_raw_tcp_event_sk_skb *args = ctx;
+        return submit_tcp_event((struct sock *)BPF_CORE_READ(args, skaddr), TCP_EVENT_SEND_RESET);
+    }
+
+    struct trace_event_raw_tcp_send_reset *args = ctx;
+    struct sock *sk = (struct sock *)BPF_CORE_READ(args, skaddr);
+    if (sk)
+        return submit_tcp_event(sk, TCP_EVENT_SEND_RESET);
+
+    /* reset of a packet without socket, the tuple is taken from the packet with the
+       local end as source */
+    struct tcp_event *event = bpf_ringbuf_reserve(&tcp_events, sizeof(*event), 0);
+    if (!event)
+        return 0;
+
+    __builtin_memset(event, 0, sizeof(*event));
+    event->timestamp = bpf_ktime_get_ns();
+    event->type = TCP_EVENT_SEND_RESET;
+    BPF_CORE_READ_INTO(&event->laddr, args, saddr);
+    BPF_CORE_READ_INTO(&event->raddr, args, daddr);
+    event->lport = BPF_CORE_READ(args, sport);
+    event->rport = BPF_CORE_READ(args, dport);
+
+    bpf_ringbuf_submit(event, 0);
+    return 0;
+}
+
+SEC("tracepoint/tcp/tcp_receive_reset")
+int tracepoint__tcp__tcp_receive_reset(struct trace_event_raw_tcp_event_sk *ctx)
+{
+    return submit_tcp_event((struct sock *)BPF_CORE_READ(ctx, skaddr), TCP_EVENT_RECEIVE_RESET);
+}
+
+SEC("tracepoint/sock/inet_sock_set_state")
+int tracepoint__sock__inet_sock_set_state(struct trace_event_raw_inet_sock_set_state *ctx)
+{
+    if (BPF_CORE_READ(ctx, protocol) != IPPROTO_TCP || BPF_CORE_READ(ctx, family) != AF_INET)
+        return 0;
+
+    struct tcp_event *event = bpf_ringbuf_reserve(&tcp_events, sizeof(*event), 0);
+    if (!event)
+        return 0;
+
+    __builtin_memset(event, 0, sizeof(*event));
+    event->timestamp = bpf_ktime_get_ns();
+    event->type = TCP_EVENT_STATE;
+    event->oldstate = BPF_CORE_READ(ctx, oldstate);
+    event->newstate = BPF_CORE_READ(ctx, newstate);
+    BPF_CORE_READ_INTO(&event->laddr, ctx, saddr);
+    BPF_CORE_READ_INTO(&event->raddr, ctx, daddr);
+    event->lport = BPF_CORE_READ(ctx, sport);
+    event->rport = BPF_CORE_READ(ctx, dport);
+
+    bpf_ringbuf_submit(event, 0);
+    return 0;
+}
@@ -14,6 +14,11 @@
 #include <bpf/bpf_endian.h>
 #include <linux/tcp.h>
 #include "headers/sockops.h"
+#include "headers/sampler.h"
+#include "headers/exec.h"
+#include "headers/flow.h"
+#include "headers/dispatcher.h"
+#include "headers/tcp_events.h"
 
 SEC("xdp")
 int xdp_proxy(struct xdp_md *ctx)
@@ -61,10 +66,15 @@ int xdp_proxy(struct xdp_md *ctx)
     __u32 value = 0;
     char *payload = data + nh_off;
 
-    if ( bpf_map_lookup_elem(&bridge, &key) == NULL) {
+    __u32 *drops = bpf_map_lookup_elem(&bridge, &key);
+    if (drops == NULL) {
+        sample_packet(ctx, monitored(key) ? XDP_PASS | SAMPLE_MONITORED : XDP_PASS);
         return XDP_PASS;
     }
 
+    __sync_fetch_and_add(drops, 1);
+    count_drop(XDP_DROP_BLOCKLIST);
+    sample_packet(ctx, XDP_DROP);
     return XDP_DROP;
 }
 
@@ -5,24 +5,31 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"errors"
 	"fmt"
 
-	"github.com/p1nant0m/xdp-tracing/bpf"
-	"github.com/p1nant0m/xdp-tracing/bpf/errors"
 	"github.com/p1nant0m/xdp-tracing/config"
+	"github.com/p1nant0m/xdp-tracing/handler/utils"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
 )
 
 const (
-	longDescription_Attach  = ""
-	shortDescription_Attach = ""
+	shortDescription_Attach = "Attach the XDP program to the given device"
+	longDescription_Attach  = `Attach the XDP program to the given device through a bpf_link pinned on bpffs,
+so the program keeps running after this command exits. Use command "detach" to remove it.`
+
+	DEFAULT_XDP_PROG_NAME = "xdp_proxy"
 )
 
 // attachCmd represents the attach command
 var attachCmd = &cobra.Command{
 	Use:   "attach",
-	Short: longDescription_Attach,
-	Long:  shortDescription_Attach,
+	Short: shortDescription_Attach,
+	Long:  longDescription_Attach,
 	Run:   attachCommandRunFunc,
 }
 
@@ -35,6 +42,8 @@ type attachMode struct {
 
 type attachFlags struct {
 	FileName string
+	ProgName string
+	PinPath  string
 	*attachMode
 }
 
@@ -44,17 +53,64 @@ var aFlags = &attachFlags{
 
 func attachCommandRunFunc(cmd *cobra.Command, args []string) {
 	gFlags, _ := getGlobalFlags(cmd)
+	if aFlags.PinPath == "" {
+		logrus.Fatal("[eBPF] pin path is required to keep the program attached after exit")
+	}
+
+	bpfManager, err := ebpf.NewBPFManager(
+		bpfModuleOption(aFlags.FileName),
+		ebpf.WithPinPath(aFlags.PinPath),
+		ebpf.WithBPFProgramList([]probe.BPFProgram{
+			probe.NewXDPProgram(aFlags.ProgName, gFlags.DevName, parseAttachMode(aFlags.attachMode)),
+		}),
+	)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to create BPFManager err=%v", err)
+	}
+	defer bpfManager.Close()
 
-	cfg := config.NewXDPConfig()
-	// reconfigure the XDP Config
-	cfg.Ifname = gFlags.DevName
-	cfg.Filename = aFlags.FileName
-	cfg.Xdp_flags = parseAttachMode(aFlags.attachMode)
+	if err := bpfManager.AttachAll(); err != nil {
+		logrus.Fatalf("[eBPF] failed to attach %v to %v err=%v%v", aFlags.ProgName, gFlags.DevName, err, explainErrno(err))
+	}
 
-	// Convert Go type to C type and call the function
-	errCode := bpf.Attach_bpf_prog_to_if(cfg)
+	if status := bpfManager.Status()[0]; !status.Pinned {
+		logrus.Warnf("[eBPF] the link of %v was not pinned, it will be detached on exit", aFlags.ProgName)
+	}
+	// the link pinned before is reused, so the program attached may not be the one loaded
+	progID, err := bpfManager.AttachedProgramID(aFlags.ProgName)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to get the id of %v err=%v", aFlags.ProgName, err)
+	}
 
-	fmt.Println(errors.GetErrorString(errCode))
+	fmt.Printf("%v attached to %v, prog id %v\n", aFlags.ProgName, gFlags.DevName, progID)
+	fmt.Println("🥳 " + utils.FontSet("XDP Program Attach Successfully!"))
+}
+
+// explainErrno gives the hint of the errno commonly returned when attaching XDP programs.
+func explainErrno(err error) string {
+	if errs, ok := err.(ebpf.ProgramErrors); ok && len(errs) > 0 {
+		err = errs[0]
+	}
+
+	var errno unix.Errno
+	if !errors.As(err, &errno) {
+		return ""
+	}
+
+	switch errno {
+	case unix.EBUSY, unix.EEXIST:
+		return " (another XDP program has been attached to the device, detach it first)"
+	case unix.EOPNOTSUPP:
+		return " (the driver does not support the XDP mode, try --sk-mode)"
+	case unix.ENODEV:
+		return " (no such device)"
+	case unix.EPERM:
+		return " (run as root or grant CAP_BPF and CAP_NET_ADMIN)"
+	}
+	if globalFlags.DevName == "" {
+		return fmt.Sprintf(" (run `%v doctor` to check the kernel features)", cliName)
+	}
+	return fmt.Sprintf(" (run `%v doctor --dev %v` to check the kernel features)", cliName, globalFlags.DevName)
 }
 
 func init() {
@@ -72,15 +128,15 @@ func init() {
 	attachCmd.PersistentFlags().BoolVarP(&aFlags.SKB_MODE, "sk-mode", "S", false, "Install XDP program in SKB (AKA generic) mode")
 	attachCmd.PersistentFlags().BoolVarP(&aFlags.NATIVE_MODE, "native-mode", "N", false, "Install XDP program in native mode")
 	attachCmd.PersistentFlags().BoolVarP(&aFlags.HW_MODE, "hw-mode", "H", false, "Install XDP program in hw mode")
-	attachCmd.PersistentFlags().StringVarP(&aFlags.FileName, "file-name", "f", "../../bpf/xdp_proxy_kern.o", "binary eBPF program path")
+	attachCmd.PersistentFlags().StringVarP(&aFlags.FileName, "file-name", "f", DEFAULT_BPF_OBJ_PATH, "path of the eBPF object overriding the embedded one")
+	attachCmd.PersistentFlags().StringVarP(&aFlags.ProgName, "prog", "P", DEFAULT_XDP_PROG_NAME, "name of the XDP program in the binary")
+	attachCmd.PersistentFlags().StringVarP(&aFlags.PinPath, "pin-path", "p", ebpf.DefaultPinPath, "directory on bpffs where the link is pinned")
 	attachCmd.PersistentFlags().StringVarP(&globalFlags.DevName, "dev", "d", "eth0", "Operate on device <ifname>")
 	attachCmd.MarkFlagRequired("dev")
-	attachCmd.MarkFlagRequired("file-name")
 }
 
 // parseAttachMode return the XDP Attaching Mode Flags based on CLI input
 func parseAttachMode(attachModeSel *attachMode) (flags uint32) {
-	flags = config.XDP_FLAGS_UPDATE_IF_NOEXIST
 	if attachModeSel.SKB_MODE {
 		flags |= config.XDP_FLAGS_SKB_MODE
 		goto out
@@ -13,6 +13,8 @@ import (
 	"syscall"
 
 	"github.com/p1nant0m/xdp-tracing/handler"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
@@ -29,6 +31,9 @@ type captureFlags struct {
 	DstIP   []string
 	SrcPort []string
 	DstPort []string
+
+	ObjPath   string
+	NoProcess bool
 }
 
 var cFlags = &captureFlags{}
@@ -71,6 +76,10 @@ func captureCommandRunFunc(cmd *cobra.Command, args []string) {
 	}()
 
 	makeRulesWithFlags(cmd.PersistentFlags())
+	var resolver *ebpf.FlowResolver
+	if !cFlags.NoProcess {
+		resolver = startFlowResolver(ctx)
+	}
 	go func() {
 		handler.StartTCPIPHandler(ctx, rules, observerCh)
 		// if everything goes well, it will not reach the block below
@@ -81,7 +90,13 @@ func captureCommandRunFunc(cmd *cobra.Command, args []string) {
 	go func(ctx context.Context) {
 		for packet := range observerCh {
 
-			fmt.Printf("[%s] %s:%d -> %s:%d [%s] TTL:%d\n", packet.Timestamp, packet.SrcIP, packet.SrcPort, packet.DstIP, packet.DstPort, packet.TcpFlagsS, packet.TTL)
+			fmt.Printf("[%s] %s:%d -> %s:%d [%s] TTL:%d", packet.Timestamp, packet.SrcIP, packet.SrcPort, packet.DstIP, packet.DstPort, packet.TcpFlagsS, packet.TTL)
+			if resolver != nil {
+				if owner, ok := resolver.Lookup(packet.SrcIP, packet.DstIP, uint16(packet.SrcPort), uint16(packet.DstPort)); ok {
+					fmt.Print(formatFlowOwner(owner))
+				}
+			}
+			fmt.Println()
 			if packet.PayloadExist {
 				fmt.Println(hex.Dump(*packet.Payload))
 			}
@@ -102,6 +117,45 @@ func captureCommandRunFunc(cmd *cobra.Command, args []string) {
 	<-ctx.Done()
 }
 
+// startFlowResolver attaches the TCP connection kprobes so that the captured packets can be
+// attributed to processes, packets are still captured without attribution if it fails
+func startFlowResolver(ctx context.Context) *ebpf.FlowResolver {
+	bpfManager, err := ebpf.NewBPFManager(
+		ebpf.WithContext(ctx),
+		bpfModuleOption(cFlags.ObjPath),
+		ebpf.WithBPFProgramList(ebpf.FlowTracePrograms()),
+	)
+	if err != nil {
+		logrus.Warnf("[eBPF] failed to create BPFManager, process attribution is disabled err=%v", err.Error())
+		return nil
+	}
+
+	if err := bpfManager.AttachAll(); err != nil {
+		logrus.Warnf("[eBPF] failed to attach flow kprobes, process attribution is disabled err=%v", err.Error())
+		bpfManager.Close()
+		return nil
+	}
+	go func() {
+		<-ctx.Done()
+		bpfManager.Close()
+	}()
+
+	resolver, err := ebpf.NewFlowResolver(bpfManager)
+	if err != nil {
+		logrus.Warnf("[eBPF] failed to create flow resolver, process attribution is disabled err=%v", err.Error())
+		return nil
+	}
+	return resolver
+}
+
+func formatFlowOwner(owner *ebpf.FlowOwner) string {
+	ret := fmt.Sprintf(" PID:%d COMM:%s UID:%d", owner.Pid, owner.Comm, owner.Uid)
+	if owner.ContainerID != "" {
+		ret += " CONTAINER:" + owner.ContainerID[:12]
+	}
+	return ret
+}
+
 func init() {
 	rootCmd.AddCommand(captureCmd)
 
@@ -118,4 +172,6 @@ func init() {
 	captureCmd.PersistentFlags().StringArrayVarP(&cFlags.DstIP, "dst-ip", "t", []string{}, "filter Destination IPv4 Address (format xxx.xxx.xxx.xxx)")
 	captureCmd.PersistentFlags().StringArrayVarP(&cFlags.SrcPort, "src-port", "p", []string{}, "filter Source Port")
 	captureCmd.PersistentFlags().StringArrayVarP(&cFlags.DstPort, "dst-port", "o", []string{}, "filter Destination Port")
+	captureCmd.PersistentFlags().StringVarP(&cFlags.ObjPath, "obj", "b", DEFAULT_BPF_OBJ_PATH, "path of the eBPF object overriding the embedded one")
+	captureCmd.PersistentFlags().BoolVarP(&cFlags.NoProcess, "no-process", "n", false, "do not attribute packets to processes, only connections established after capture starts can be attributed")
 }
@@ -0,0 +1,49 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/p1nant0m/xdp-tracing/handler/utils"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	shortDescription_Cleanup = "Remove the eBPF programs, links and maps pinned by service"
+	longDescription_Cleanup  = `Remove the eBPF programs, links and maps pinned by service.
+The XDP program keeps enforcing the policies after service exits when pinning is enabled,
+this command detaches it and releases the pinned maps.`
+)
+
+type cleanupFlags struct {
+	pinPath string
+}
+
+var clFlags cleanupFlags
+
+// cleanupCmd represents the cleanup command
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: shortDescription_Cleanup,
+	Long:  longDescription_Cleanup,
+	Run:   cleanupCommandRunFunc,
+}
+
+func cleanupCommandRunFunc(cmd *cobra.Command, args []string) {
+	if err := ebpf.UnpinAll(clFlags.pinPath); err != nil {
+		logrus.Fatalf("[eBPF] failed to cleanup pinned objects in %v err=%v", clFlags.pinPath, err)
+	}
+
+	fmt.Println("🥳 " + utils.FontSet("Pinned eBPF objects Cleanup Successfully!"))
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.PersistentFlags().StringVarP(&clFlags.pinPath, "pin-path", "p", ebpf.DefaultPinPath, "directory on bpffs where service pins eBPF objects")
+}
@@ -5,25 +5,29 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"errors"
 	"fmt"
 
-	"github.com/p1nant0m/xdp-tracing/bpf"
-	"github.com/p1nant0m/xdp-tracing/bpf/errors"
+	"github.com/p1nant0m/xdp-tracing/handler/utils"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
 )
 
 const (
-	shortDescription_Detach = ""
-	longDescription_Detach  = ""
+	shortDescription_Detach = "Detach the XDP program attached by command attach"
+	longDescription_Detach  = `Detach the XDP program through its link pinned on bpffs and remove the pin, even
+if the service still holds the link. The program is selected by the device it is attached
+to, by its name, or by both.`
 )
 
 type detachFlags struct {
-	prog_id int
+	progName string
+	pinPath  string
 }
 
-var dFlags = &detachFlags{
-	prog_id: 0,
-}
+var dFlags = &detachFlags{}
 
 // detachCmd represents the detach command
 var detachCmd = &cobra.Command{
@@ -35,9 +39,48 @@ var detachCmd = &cobra.Command{
 
 func detachCommandRunFunc(cmd *cobra.Command, args []string) {
 	gFlags, _ := getGlobalFlags(cmd)
-	errCode := bpf.Warp_do_detach(gFlags.DevName, dFlags.prog_id)
+	if gFlags.DevName == "" && dFlags.progName == "" {
+		logrus.Fatal("[eBPF] either --dev or --prog should be given")
+	}
+
+	links, err := ebpf.ListPinnedLinks(dFlags.pinPath)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to list pinned links in %v err=%v", dFlags.pinPath, err)
+	}
+
+	detached := 0
+	for _, link := range links {
+		if gFlags.DevName != "" && link.HookPoint != "xdp_"+gFlags.DevName {
+			continue
+		}
+		if dFlags.progName != "" && link.Program != dFlags.progName {
+			continue
+		}
+
+		err := link.Detach()
+		var errno unix.Errno
+		if errors.As(err, &errno) && (errno == unix.EOPNOTSUPP || errno == unix.EINVAL) {
+			// the kernel can not detach links, the program is detached once the last
+			// holder of the link, e.g. the agent, releases it
+			if err := link.Unpin(); err != nil {
+				logrus.Fatalf("[eBPF] failed to unpin %v err=%v", link.Program, err)
+			}
+			logrus.Warnf("[eBPF] %v stays attached to %v while other processes hold its link", link.Program, link.HookPoint)
+			fmt.Printf("%v unpinned from %v, prog id %v\n", link.Program, link.HookPoint, link.ProgID)
+			detached++
+			continue
+		}
+		if err != nil {
+			logrus.Fatalf("[eBPF] failed to detach %v err=%v", link.Program, err)
+		}
+		fmt.Printf("%v detached from %v, prog id %v\n", link.Program, link.HookPoint, link.ProgID)
+		detached++
+	}
 
-	fmt.Println(errors.GetErrorString(errCode))
+	if detached == 0 {
+		logrus.Fatalf("[eBPF] no program matching dev=%q prog=%q was found in %v", gFlags.DevName, dFlags.progName, dFlags.pinPath)
+	}
+	fmt.Println("🥳 " + utils.FontSet("XDP Program Detach Successfully!"))
 }
 
 func init() {
@@ -52,8 +95,7 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// detachCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	detachCmd.PersistentFlags().IntVarP(&dFlags.prog_id, "prog-id", "p", 0, "BPF program Index that need to detach <using bpftool prog to check>")
-	detachCmd.PersistentFlags().StringVarP(&globalFlags.DevName, "dev", "d", "eth0", "Operate on device <ifname>")
-	detachCmd.MarkFlagRequired("dev")
-	detachCmd.MarkFlagRequired("prog-id")
+	detachCmd.PersistentFlags().StringVarP(&dFlags.progName, "prog", "P", "", "name of the XDP program to detach")
+	detachCmd.PersistentFlags().StringVarP(&dFlags.pinPath, "pin-path", "p", ebpf.DefaultPinPath, "directory on bpffs where the link is pinned")
+	detachCmd.PersistentFlags().StringVarP(&globalFlags.DevName, "dev", "d", "", "Operate on device <ifname>")
 }
@@ -0,0 +1,120 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/p1nant0m/xdp-tracing/handler/utils"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	shortDescription_Doctor = "Check whether the host is ready to run the eBPF programs"
+	longDescription_Doctor  = `Probe the kernel version, BTF, supported program and map types, ringbuf, memlock
+limit, bpffs mount, capabilities and native XDP support of the devices, then print
+a readiness report explaining which features will be degraded.
+
+Native XDP support is guessed from the driver name unless --test-attach is given,
+which attaches a noop program in driver mode for a moment. Some drivers reset the
+NIC when an XDP program is attached.`
+)
+
+type doctorFlags struct {
+	devices    []string
+	pinPath    string
+	testAttach bool
+	json       bool
+}
+
+var docFlags = &doctorFlags{}
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: shortDescription_Doctor,
+	Long:  longDescription_Doctor,
+	Run:   doctorCommandRunFunc,
+}
+
+var featureStatusMarks = map[string]string{
+	ebpf.FEATURE_OK:       "[  OK  ]",
+	ebpf.FEATURE_DEGRADED: "[ WARN ]",
+	ebpf.FEATURE_MISSING:  "[ FAIL ]",
+	ebpf.FEATURE_UNKNOWN:  "[  ??  ]",
+}
+
+func doctorCommandRunFunc(cmd *cobra.Command, args []string) {
+	report := ebpf.ProbeFeatures(ebpf.ProbeOptions{
+		PinPath:    docFlags.pinPath,
+		Devices:    docFlags.devices,
+		TestAttach: docFlags.testAttach,
+	})
+
+	if docFlags.json {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			logrus.Fatalf("[eBPF] failed to encode the report err=%v", err)
+		}
+	} else {
+		printFeatureReport(report)
+	}
+
+	if !report.Ready() {
+		os.Exit(1)
+	}
+}
+
+func printFeatureReport(report *ebpf.FeatureReport) {
+	width := 0
+	for _, check := range report.Checks {
+		if len(check.Name) > width {
+			width = len(check.Name)
+		}
+	}
+
+	for _, check := range report.Checks {
+		fmt.Printf("%v %-*v  %v\n", featureStatusMarks[check.Status], width, check.Name, check.Detail)
+	}
+
+	degraded := report.Degraded()
+	if len(degraded) == 0 {
+		fmt.Println("🥳 " + utils.FontSet("All features are available!"))
+		return
+	}
+
+	fmt.Println("\nDegraded features:")
+	for _, check := range degraded {
+		fmt.Printf("  - %v: %v\n", check.Name, strings.TrimSpace(check.Impact))
+	}
+
+	if report.Ready() {
+		fmt.Println("🥳 " + utils.FontSet("Ready with degraded features"))
+	} else {
+		fmt.Println("😵 Not ready, fix the checks marked FAIL first")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// doctorCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// doctorCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	doctorCmd.PersistentFlags().StringSliceVarP(&docFlags.devices, "dev", "d", nil, "devices to check for XDP support, all non-loopback devices by default")
+	doctorCmd.PersistentFlags().StringVarP(&docFlags.pinPath, "pin-path", "p", ebpf.DefaultPinPath, "directory on bpffs where objects are pinned")
+	doctorCmd.PersistentFlags().BoolVar(&docFlags.testAttach, "test-attach", false, "verify native XDP by attaching a noop program in driver mode")
+	doctorCmd.PersistentFlags().BoolVar(&docFlags.json, "json", false, "print the report in JSON")
+}
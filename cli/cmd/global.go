@@ -4,7 +4,11 @@
 
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/p1nant0m/xdp-tracing/bpf"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/spf13/cobra"
+)
 
 var globalFlags = GlobalFlags{}
 
@@ -20,3 +24,19 @@ func getGlobalFlags(command *cobra.Command) (conf GlobalFlags, err error) {
 	}
 	return
 }
+
+// bpfModuleOption loads the eBPF object from objPath when it is given, which is useful
+// in development, otherwise the object embedded in the binary is used.
+func bpfModuleOption(objPath string) ebpf.Option {
+	if objPath != "" {
+		return ebpf.WithBPFModuleFromFile(objPath)
+	}
+
+	return func(b *ebpf.BPFManager) error {
+		obj, err := bpf.Object(bpf.XDPProxyObject)
+		if err != nil {
+			return err
+		}
+		return ebpf.WithBPFModuleFromBuffer(obj, bpf.XDPProxyObject)(b)
+	}
+}
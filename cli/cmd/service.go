@@ -14,9 +14,11 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/p1nant0m/xdp-tracing/bpf"
 	"github.com/p1nant0m/xdp-tracing/handler"
 	"github.com/p1nant0m/xdp-tracing/handler/utils"
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
 	"github.com/p1nant0m/xdp-tracing/service"
 	"github.com/p1nant0m/xdp-tracing/service/strategy"
 	"github.com/sirupsen/logrus"
@@ -33,6 +35,10 @@ const (
 	shortDescription_service = ""
 	longDescription_service  = ""
 	DEBUG_ENABLE             = false
+	BLOCKLIST_MAP_NAME       = "bridge"
+	MONITOR_MAP_NAME         = "monitor"
+	// SESSION_OWNERS_CACHE_SIZE bounds the sessions remembered as attributed by the agent
+	SESSION_OWNERS_CACHE_SIZE = 65536
 )
 
 func init() {
@@ -89,58 +95,259 @@ func serviceCommandRunFunc(cmd *cobra.Command, args []string) {
 	// Startup Redis Service
 	redisService := startRedisComponet(ctx)
 
+	// Load and Attach the XDP Program which enforces the policies
+	ebpfConfig := service.ExtractEbpfConfig()
+	if ebpfConfig == nil {
+		logrus.Fatal("[eBPF] missing ebpf section in config file")
+	}
+	bpfManager := startBPFManager(ctx, ebpfConfig)
+	blocklistMap, err := ebpf.GetMap[uint32, uint32](bpfManager, BLOCKLIST_MAP_NAME)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to get map %v err=%v", BLOCKLIST_MAP_NAME, err.Error())
+	}
+	blocklist := strategy.NewBlocklist(blocklistMap)
+	if ebpfConfig.PinPath != "" {
+		restoreBlocklist(blocklist)
+	}
+	// Drop everything but the pass policies and the management set on the interfaces in
+	// allowlist mode, the drop policies are still enforced on the blocklist map
+	enforcer := &strategy.Filter{
+		Blocklist: blocklist,
+		Monitor:   startMonitor(bpfManager, ebpfConfig.PinPath != ""),
+		Allowlist: startAllowlist(bpfManager, ebpfConfig, ebpfConfig.PinPath != ""),
+	}
+	maps := []string{BLOCKLIST_MAP_NAME, ebpf.XDPRateLimitTATMapName}
+	if enforcer.Monitor != nil {
+		maps = append(maps, MONITOR_MAP_NAME)
+	}
+	if enforcer.Allowlist != nil {
+		maps = append(maps, ebpf.XDPAllowSourcesMapName, ebpf.XDPAllowPortsMapName)
+	}
+
 	// StartUp Packets Capture
-	observeCh := startPacketsCap(ctx)
+	var sampler *ebpf.BPFManager
+	if ebpfConfig.PacketSource == service.PACKET_SOURCE_XDP {
+		sampler = bpfManager
+	}
+	observeCh := startPacketsCap(ctx, sampler)
+
+	// Attribute the captured sessions to the owning processes
+	var resolver *ebpf.FlowResolver
+	if ebpfConfig.Flow != nil && ebpfConfig.Flow.Enable {
+		if resolver, err = ebpf.NewFlowResolver(bpfManager); err != nil {
+			logrus.Fatalf("[eBPF] failed to create flow resolver err=%v", err.Error())
+		}
+	}
 
 	// Making Data Flow From local Capturer to remote RedisDB
 	redisService.Register("capturer") // capturer need to use Redis Service, so it need to regist first
-	streamFlow_Cap2Rdb(ctx, redisService, observeCh)
+	streamFlow_Cap2Rdb(ctx, redisService, observeCh, resolver)
 
-	// Make Registration in ETCD
-	etcdService := startEtcdComponet(ctx)
+	// StartUp Process Execution Tracing
+	if ebpfConfig.Exec != nil && ebpfConfig.Exec.Enable {
+		execCh := make(chan *ebpf.ExecEvent, 100)
+		if err := bpfManager.StartExecTracer(ctx, &ebpfConfig.Exec.Filter, execCh); err != nil {
+			logrus.Fatalf("[eBPF] failed to start exec tracer err=%v", err.Error())
+		}
+		redisService.Register("exectracer")
+		streamFlow_Exec2Rdb(ctx, redisService, execCh)
+	}
 
-	// Start gRPC Server For receiving New Policy Deployment
-	gRPCService := startgRPCServer(ctx)
+	// StartUp TCP Retransmission, Reset and State Tracing
+	if ebpfConfig.TCPEvents != nil && ebpfConfig.TCPEvents.Enable {
+		tcpCh := make(chan *ebpf.TCPConnStats, 100)
+		if err := bpfManager.StartTCPEventTracer(ctx, tcpCh); err != nil {
+			logrus.Fatalf("[eBPF] failed to start tcp event tracer err=%v", err.Error())
+		}
+		redisService.Register("tcptracer")
+		streamFlow_TCP2Rdb(ctx, redisService, tcpCh)
+	}
 
-	go func() {
-		for policy := range gRPCService.Server.LocalStrategyCh {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				logrus.Infof("[gRPC Server] receives new poliyOp %v", policy)
-				switch policy.Type {
-				case strategy.INSTALL:
-					h := utils.BytesToUInt32(net.ParseIP(policy.Rule).To4()) // host-endian uint representation
-					bpf.MapUpdate(h, gRPCService.Configs.MapID)
-				case strategy.REVOKE:
-					h := utils.BytesToUInt32(net.ParseIP(policy.Rule).To4())
-					bpf.MapRevoke(h, gRPCService.Configs.MapID)
-				}
+	server := &strategy.Server{
+		Enforcer:      enforcer,
+		Dataplane:     bpfManager,
+		Maps:          maps,
+		FilterModes:   ebpfConfig.Allowlist.FilterModes(),
+		RestoredGrace: strategy.RESTORED_GRACE_PERIOD,
+	}
+	if restConfig := service.ExtractRestConfig(); restConfig != nil {
+		// every change of the policies applied on this node is reported to the audit log
+		auditReporter := service.NewAuditReporter(restConfig)
+		server.Audit = auditReporter.Report
+		go auditReporter.Run(ctx)
+	}
 
-			}
-		}
-	}()
+	// Make Registration in ETCD, along with the traffic the monitor policies would have dropped
+	etcdService := startEtcdComponet(ctx, map[string]service.Reporter{
+		v1.MONITOR_KEY_PREFIX: func() (interface{}, error) { return server.MonitorReport() },
+	})
+
+	// Start gRPC Server For receiving New Policy Deployment, the policy controller pushes the
+	// full set of policies selecting this node, which replaces the policies on the maps
+	server.NodeID = etcdService.NodeID
+	startgRPCServer(ctx, server)
 
 	fmt.Println("🥳 " + utils.FontSet("All Services Start successfully! Enjoy your Days!"))
 	<-ctx.Done()
 
 	etcdService.Stop()
+	// the XDP program keeps enforcing the policies across restarts when it is pinned,
+	// use command "cleanup" to remove it
+	if err := bpfManager.Close(); err != nil {
+		logrus.Warnf("[eBPF] failed to close BPFManager err=%v", err)
+	}
+}
+
+// restoreBlocklist takes over the addresses blocked by the previous process in the pinned
+// blocklist map, they are kept blocked until the policy controller pushes the policies, or
+// strategy.RESTORED_GRACE_PERIOD passes without any
+func restoreBlocklist(blocklist *strategy.Blocklist) {
+	n, err := blocklist.Restore()
+	if err != nil {
+		logrus.Warnf("[eBPF] failed to restore policies from map %v err=%v", BLOCKLIST_MAP_NAME, err)
+		return
+	}
+	logrus.Infof("[eBPF] restored %v blocked addresses from map %v", n, BLOCKLIST_MAP_NAME)
+}
+
+// startMonitor returns the Blocklist enforcing the monitor policies on the monitor map, nil
+// if the eBPF object has no monitor map.
+func startMonitor(bpfManager *ebpf.BPFManager, restore bool) *strategy.Blocklist {
+	monitorMap, err := ebpf.GetMap[uint32, uint32](bpfManager, MONITOR_MAP_NAME)
+	if err != nil {
+		logrus.Warnf("[eBPF] monitor policies are not supported without map %v err=%v", MONITOR_MAP_NAME, err)
+		return nil
+	}
+
+	monitor := strategy.NewMonitor(monitorMap)
+	if restore {
+		n, err := monitor.Restore()
+		if err != nil {
+			logrus.Warnf("[eBPF] failed to restore policies from map %v err=%v", MONITOR_MAP_NAME, err)
+		} else {
+			logrus.Infof("[eBPF] restored %v monitored addresses from map %v", n, MONITOR_MAP_NAME)
+		}
+	}
+	return monitor
+}
+
+// startAllowlist puts the interfaces configured in allowlist mode and returns the Allowlist
+// enforcing the pass policies, nil if no interface is configured. The interfaces left in
+// allowlist mode by the previous process go back to blocklist mode. Before the mode is
+// switched, the sessions it would cut off are warned about.
+func startAllowlist(bpfManager *ebpf.BPFManager, ebpfConfig *service.EbpfConfig, restore bool) *strategy.Allowlist {
+	cfg := ebpfConfig.Allowlist
+	if cfg == nil || len(cfg.Interfaces) == 0 {
+		if err := bpfManager.SetFilterModes(nil); err != nil {
+			logrus.Warnf("[eBPF] failed to reset filter modes err=%v", err)
+		}
+		return nil
+	}
+	if ebpfConfig.Dispatcher == nil || !containsStage(ebpfConfig.Dispatcher.Stages, ebpf.XDP_STAGE_ALLOWLIST) {
+		logrus.Warnf("[eBPF] stage %v is not in the dispatcher, interfaces %v are not filtered",
+			ebpf.XDP_STAGE_ALLOWLIST, cfg.Interfaces)
+	}
+
+	var localIPs []net.IP
+	modes := make(map[uint32]uint32, len(cfg.Interfaces))
+	for _, name := range cfg.Interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			logrus.Fatalf("[eBPF] failed to find interface %v of allowlist err=%v", name, err.Error())
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			logrus.Fatalf("[eBPF] failed to read addresses of interface %v err=%v", name, err.Error())
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				localIPs = append(localIPs, ipNet.IP.To4())
+			}
+		}
+		if !cfg.DryRun {
+			modes[uint32(iface.Index)] = ebpf.XDP_FILTER_ALLOWLIST
+		}
+	}
+
+	management, err := service.ExtractManagementSet()
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to build the management set err=%v", err.Error())
+	}
+	if err := bpfManager.SetManagementSet(management.Sources, management.Ports); err != nil {
+		logrus.Fatalf("[eBPF] failed to install the management set err=%v", err.Error())
+	}
+
+	sources, err := ebpf.GetMap[ebpf.LPMKey, uint32](bpfManager, ebpf.XDPAllowSourcesMapName)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to get map %v err=%v", ebpf.XDPAllowSourcesMapName, err.Error())
+	}
+	ports, err := ebpf.GetMap[ebpf.PortKey, uint32](bpfManager, ebpf.XDPAllowPortsMapName)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to get map %v err=%v", ebpf.XDPAllowPortsMapName, err.Error())
+	}
+	allowlist := strategy.NewAllowlist(sources, ports, management)
+	allowlist.Sessions = func() ([]strategy.Session, error) {
+		return strategy.EstablishedSessions(localIPs)
+	}
+	if restore {
+		n, err := allowlist.Restore()
+		if err != nil {
+			logrus.Warnf("[eBPF] failed to restore policies from allowlist maps err=%v", err)
+		} else {
+			logrus.Infof("[eBPF] restored %v allowed entries from allowlist maps", n)
+		}
+	}
+
+	cut, err := allowlist.CutOff()
+	if err != nil {
+		logrus.Warnf("[eBPF] failed to check the sessions against the allowlist err=%v", err)
+	}
+	for _, session := range cut {
+		logrus.Warnf("[eBPF] the allowlist would cut off the session %v", session)
+	}
+	if err := bpfManager.SetFilterModes(modes); err != nil {
+		logrus.Fatalf("[eBPF] failed to set filter modes err=%v", err.Error())
+	}
+	logrus.Infof("[eBPF] interfaces %v in allowlist mode dryrun=%v with %v management sources and %v ports",
+		cfg.Interfaces, cfg.DryRun, len(management.Sources), len(management.Ports))
+	return allowlist
+}
+
+func containsStage(stages []string, stage string) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
 }
 
-func startgRPCServer(ctx context.Context) *service.GrpcService {
-	var gRPCService service.Service = service.NewGrpcService(ctx)
+func startgRPCServer(ctx context.Context, server *strategy.Server) *service.GrpcService {
+	var gRPCService service.Service = service.NewGrpcService(ctx, server)
 	if err := gRPCService.Conn(); err != nil {
 		logrus.Fatalf("[gRPC Server] failed to listen: %v", err.Error())
 	}
 
 	go gRPCService.Serve()
 	fmt.Println("🥳 " + utils.FontSet("gRPC Service Start Successfully!"))
-	return gRPCService.(*service.GrpcService)
+	// Expire the policies on this node by their lifetime, even without the policy controller
+	go server.Expire(ctx)
+
+	// Subscribe to the policy controller instead of waiting to be pushed to
+	grpcService := gRPCService.(*service.GrpcService)
+	if grpcService.Configs.Controller != "" {
+		if err := grpcService.WatchController(); err != nil {
+			logrus.Fatalf("[gRPC Server] failed to subscribe to policy controller %v err=%v",
+				grpcService.Configs.Controller, err)
+		}
+	}
+	return grpcService
 }
 
-func startEtcdComponet(ctx context.Context) *service.EtcdService {
-	var etcdService service.Service = service.NewEtcdService(ctx)
+func startEtcdComponet(ctx context.Context, reporters map[string]service.Reporter) *service.EtcdService {
+	etcd := service.NewEtcdService(ctx)
+	etcd.Reporters = reporters
+	var etcdService service.Service = etcd
 	if err := etcdService.Conn(); err != nil {
 		logrus.Fatalf("[etcd Service] failed to start etcd componet err=%v", err.Error())
 	}
@@ -149,9 +356,10 @@ func startEtcdComponet(ctx context.Context) *service.EtcdService {
 	return etcdService.(*service.EtcdService)
 }
 
-// streamFlow_Cap2Rdb make data flow from local capturer to Redis
+// streamFlow_Cap2Rdb make data flow from local capturer to Redis, the owner of each session is
+// recorded once when resolver is given
 func streamFlow_Cap2Rdb(ctx context.Context,
-	redisService *service.RedisService, packetCh <-chan *handler.TCP_IP_Handler) {
+	redisService *service.RedisService, packetCh <-chan *handler.TCP_IP_Handler, resolver *ebpf.FlowResolver) {
 	redisNotifyCh, err := redisService.RetrieveChannel("capturer")
 	if err != nil {
 		fmt.Println(err.Error())
@@ -174,15 +382,20 @@ func streamFlow_Cap2Rdb(ctx context.Context,
 
 	// this Goroutine Records the new filtered Packets to Redis
 	go func() {
+		attributed := make(map[string]struct{})
 		for packet := range packetCh {
 			select {
 			case <-ctx.Done():
 				return
 			default:
-				logrus.Debug("new packet arrives Packets:%v", packet)
+				logrus.Debugf("new packet arrives Packets:%v", packet)
 				// packet that satisfied the rules arrive,
 				// new task should be assgined to Redis Client
-				taskFunc, resultType := newRecordTask(ctx, packet)
+				var owner *ebpf.FlowOwner
+				if resolver != nil {
+					owner = resolveSessionOwner(resolver, attributed, packet)
+				}
+				taskFunc, resultType := newRecordTask(ctx, packet, owner)
 				redisService.TaskAssign(taskFunc, resultType, "capturer")
 			}
 		}
@@ -190,8 +403,153 @@ func streamFlow_Cap2Rdb(ctx context.Context,
 
 }
 
-// newRecordTask construct the Redis Task to make record of arriving packet
-func newRecordTask(ctx context.Context, packet *handler.TCP_IP_Handler) (func(rdb *redis.Client) (interface{}, error), string) {
+// streamFlow_Exec2Rdb make data flow from local exec tracer to Redis
+func streamFlow_Exec2Rdb(ctx context.Context,
+	redisService *service.RedisService, execCh <-chan *ebpf.ExecEvent) {
+	redisNotifyCh, err := redisService.RetrieveChannel("exectracer")
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	// drain the responses, failed records are only logged
+	go func() {
+		for notifyMsg := range redisNotifyCh {
+			if notifyMsg.ErrorMsg != nil {
+				logrus.Debugf("[Redis] failed to record exec event err=%v", notifyMsg.ErrorMsg)
+			}
+		}
+	}()
+
+	node := utils.LocalIPObtain()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-execCh:
+				event.Node = node
+				taskFunc, resultType, err := newExecRecordTask(ctx, event)
+				if err != nil {
+					logrus.Warnf("[eBPF] failed to encode exec event %v err=%v", event, err)
+					continue
+				}
+				redisService.TaskAssign(taskFunc, resultType, "exectracer")
+			}
+		}
+	}()
+}
+
+// newExecRecordTask construct the Redis Task to make record of exec event, only the
+// latest service.EXEC_EVENTS_MAX events are kept
+func newExecRecordTask(ctx context.Context, event *ebpf.ExecEvent) (func(rdb *redis.Client) (interface{}, error), string, error) {
+	eventS, err := service.EncodeExecEvent(event)
+	if err != nil {
+		return nil, "", err
+	}
+	score := float64(event.Timestamp.UnixNano()) / float64(time.Second)
+
+	taskFunc := func(rdb *redis.Client) (interface{}, error) {
+		cmds, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, service.EXEC_EVENTS_KEY, &redis.Z{Score: score, Member: eventS})
+			pipe.ZRemRangeByRank(ctx, service.EXEC_EVENTS_KEY, 0, -service.EXEC_EVENTS_MAX-1)
+			return nil
+		})
+		return cmds, err
+	}
+
+	return taskFunc, "[]redis.Cmder", nil
+}
+
+// streamFlow_TCP2Rdb make data flow from local tcp event tracer to Redis
+func streamFlow_TCP2Rdb(ctx context.Context,
+	redisService *service.RedisService, tcpCh <-chan *ebpf.TCPConnStats) {
+	redisNotifyCh, err := redisService.RetrieveChannel("tcptracer")
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	// drain the responses, failed records are only logged
+	go func() {
+		for notifyMsg := range redisNotifyCh {
+			if notifyMsg.ErrorMsg != nil {
+				logrus.Debugf("[Redis] failed to record tcp events err=%v", notifyMsg.ErrorMsg)
+			}
+		}
+	}()
+
+	node := utils.LocalIPObtain()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case stats := <-tcpCh:
+				stats.Node = node
+				taskFunc, resultType, err := newTCPStatsRecordTask(ctx, stats)
+				if err != nil {
+					logrus.Warnf("[eBPF] failed to encode tcp events of %v err=%v", stats.TCPConn, err)
+					continue
+				}
+				redisService.TaskAssign(taskFunc, resultType, "tcptracer")
+			}
+		}
+	}()
+}
+
+// newTCPStatsRecordTask construct the Redis Task to record the counters of connection under
+// both sessions it consists of, so they are joined into the session view
+func newTCPStatsRecordTask(ctx context.Context, stats *ebpf.TCPConnStats) (func(rdb *redis.Client) (interface{}, error), string, error) {
+	keys := service.TCPConnSessionKeys(&stats.TCPConn)
+	if keys == nil {
+		return nil, "", fmt.Errorf("connection %v is not IPv4", stats.TCPConn)
+	}
+	statsS, err := service.EncodeTCPConnStats(stats)
+	if err != nil {
+		return nil, "", err
+	}
+
+	taskFunc := func(rdb *redis.Client) (interface{}, error) {
+		cmds, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, keyS := range keys {
+				pipe.Set(ctx, service.SessionTCPStatsKey(keyS), statsS, service.SESSION_TCP_STATS_TTL)
+			}
+			return nil
+		})
+		return cmds, err
+	}
+
+	return taskFunc, "[]redis.Cmder", nil
+}
+
+// resolveSessionOwner returns the owner of the session which packet belongs to, it returns
+// nil if the owner is unknown or has been resolved before
+func resolveSessionOwner(resolver *ebpf.FlowResolver, attributed map[string]struct{},
+	packet *handler.TCP_IP_Handler) *ebpf.FlowOwner {
+	session := fmt.Sprintf("%v:%d-%v:%d", packet.SrcIP, packet.SrcPort, packet.DstIP, packet.DstPort)
+	if _, exists := attributed[session]; exists {
+		return nil
+	}
+
+	owner, ok := resolver.Lookup(packet.SrcIP, packet.DstIP, uint16(packet.SrcPort), uint16(packet.DstPort))
+	if !ok {
+		return nil
+	}
+
+	if len(attributed) >= SESSION_OWNERS_CACHE_SIZE {
+		// start over rather than tracking the age of sessions, an owner recorded twice is harmless
+		for session := range attributed {
+			delete(attributed, session)
+		}
+	}
+	attributed[session] = struct{}{}
+	return owner
+}
+
+// newRecordTask construct the Redis Task to make record of arriving packet, owner is
+// recorded along with the session when it is not nil
+func newRecordTask(ctx context.Context, packet *handler.TCP_IP_Handler, owner *ebpf.FlowOwner) (func(rdb *redis.Client) (interface{}, error), string) {
 	key := &service.Key{
 		SrcIP:   packet.SrcIP,
 		DstIP:   packet.DstIP,
@@ -213,10 +571,25 @@ func newRecordTask(ctx context.Context, packet *handler.TCP_IP_Handler) (func(rd
 	timeT, _ := time.Parse("2006-01-02 15:04:05.999999999", packet.Timestamp)
 	timeF := float64(timeT.Unix())
 
+	var ownerS string
+	if owner != nil {
+		var err error
+		if ownerS, err = service.EncodeFlowOwner(owner); err != nil {
+			logrus.Warnf("[eBPF] failed to encode owner %v of session err=%v", owner, err)
+		}
+	}
+
 	taskFunc := func(rdb *redis.Client) (interface{}, error) {
 		cmds, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
 			pipe.ZAdd(ctx, keyS, &redis.Z{Score: timeF, Member: valueS})
-			pipe.SAdd(ctx, "sessions", keyS)
+			pipe.SAdd(ctx, service.SESSIONS_KEY, keyS)
+			if ownerS != "" {
+				pipe.HSet(ctx, service.SESSION_OWNERS_KEY, keyS, ownerS)
+			}
+			if packet.Verdict != "" {
+				// the packet was dropped, or would have been by a monitor policy
+				pipe.HSet(ctx, service.SESSION_VERDICTS_KEY, keyS, packet.Verdict)
+			}
 			return nil
 		})
 		return cmds, err
@@ -233,12 +606,72 @@ func handleRespFromRdb(resp *service.NotifyMsg) {
 	// 	resp.ExecuteResult, resp.ResultType)
 }
 
-func startPacketsCap(ctx context.Context) <-chan *handler.TCP_IP_Handler {
+// startBPFManager loads the eBPF object and attaches the probes declared in configuration
+func startBPFManager(ctx context.Context, ebpfConfig *service.EbpfConfig) *ebpf.BPFManager {
+	programs, err := probe.NewBPFPrograms(ebpfConfig.Probes)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to parse probes in config file err=%v", err.Error())
+	}
+	if ebpfConfig.Exec != nil && ebpfConfig.Exec.Enable {
+		programs = append(programs, ebpf.ExecTracePrograms()...)
+	}
+	if ebpfConfig.Flow != nil && ebpfConfig.Flow.Enable {
+		programs = append(programs, ebpf.FlowTracePrograms()...)
+	}
+	if ebpfConfig.TCPEvents != nil && ebpfConfig.TCPEvents.Enable {
+		programs = append(programs, ebpf.TCPEventPrograms()...)
+	}
+
+	bpfManager, err := ebpf.NewBPFManager(
+		ebpf.WithContext(ctx),
+		bpfModuleOption(ebpfConfig.ObjPath),
+		ebpf.WithPinPath(ebpfConfig.PinPath),
+		ebpf.WithBPFProgramList(programs),
+	)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to create BPFManager err=%v", err.Error())
+	}
+
+	if err := bpfManager.AttachAll(); err != nil {
+		logrus.Fatalf("[eBPF] failed to attach probes err=%v", err.Error())
+	}
+	if err := bpfManager.SetSampleRate(ebpfConfig.SampleRate); err != nil {
+		logrus.Fatalf("[eBPF] failed to configure sample rate err=%v", err.Error())
+	}
+	for _, status := range bpfManager.Status() {
+		logrus.Debugf("[eBPF] %v at %v is %v pinned=%v", status.Name, status.HookPoint, status.State, status.Pinned)
+	}
+
+	if dispatcherConfig := ebpfConfig.Dispatcher; dispatcherConfig != nil {
+		dispatcher, err := ebpf.NewXDPDispatcher(bpfManager)
+		if err != nil {
+			logrus.Fatalf("[eBPF] failed to create XDPDispatcher err=%v", err.Error())
+		}
+		rateLimit := ebpf.NewRateLimitConfig(dispatcherConfig.RateLimit.PPS, dispatcherConfig.RateLimit.Burst)
+		if err := dispatcher.SetRateLimit(rateLimit); err != nil {
+			logrus.Fatalf("[eBPF] failed to configure rate limit err=%v", err.Error())
+		}
+		if err := dispatcher.Set(dispatcherConfig.Stages...); err != nil {
+			logrus.Fatalf("[eBPF] failed to set XDP stages err=%v", err.Error())
+		}
+		logrus.Debugf("[eBPF] XDP stages %v", dispatcher.Stages())
+	}
+
+	fmt.Println("🥳 " + utils.FontSet("eBPF Program Attach Successfully!"))
+	return bpfManager
+}
+
+// startPacketsCap starts the packets capturer, it uses the XDP sampler as packets source
+// when bpfManager is given, otherwise it listens on raw socket
+func startPacketsCap(ctx context.Context, bpfManager *ebpf.BPFManager) <-chan *handler.TCP_IP_Handler {
 	// Create New Instance of TCP_IPCapturer
 	capturer := service.NewTCP_IPCapturer(ctx)
 
 	capturer.MakeNewRules()
 	capturer.Conn()
+	if bpfManager != nil {
+		capturer.Handler = bpfManager.StartPacketSampler
+	}
 
 	observeCh := make(chan *handler.TCP_IP_Handler)
 	capturer.Serve(observeCh)
@@ -0,0 +1,135 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	shortDescription_Trace     = "Trace kernel events with eBPF programs"
+	longDescription_Trace      = ""
+	shortDescription_TraceExec = "Trace process executions through the execve tracepoints"
+	longDescription_TraceExec  = ""
+
+	DEFAULT_BPF_OBJ_PATH = "" // use the eBPF object embedded in the binary
+)
+
+type traceExecFlags struct {
+	objPath string
+	pids    []uint
+	ppids   []uint
+	uids    []uint
+	filter  ebpf.ExecFilter
+	json    bool
+}
+
+var teFlags traceExecFlags
+
+// traceCmd represents the trace command
+var traceCmd = &cobra.Command{
+	Use:   "trace",
+	Short: shortDescription_Trace,
+	Long:  longDescription_Trace,
+}
+
+// traceExecCmd represents the trace exec command
+var traceExecCmd = &cobra.Command{
+	Use:   "exec",
+	Short: shortDescription_TraceExec,
+	Long:  longDescription_TraceExec,
+	Run:   traceExecCommandRunFunc,
+}
+
+func traceExecCommandRunFunc(cmd *cobra.Command, args []string) {
+	watcher := make(chan os.Signal, 1)
+	signal.Notify(watcher, os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-watcher
+		// OS Signal Catched, exit the program gracefully
+		cancel()
+	}()
+
+	bpfManager, err := ebpf.NewBPFManager(
+		ebpf.WithContext(ctx),
+		bpfModuleOption(teFlags.objPath),
+		ebpf.WithBPFProgramList(ebpf.ExecTracePrograms()),
+	)
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to create BPFManager err=%v", err.Error())
+	}
+
+	if err := bpfManager.AttachAll(); err != nil {
+		logrus.Fatalf("[eBPF] failed to attach execve tracepoints err=%v", err.Error())
+	}
+	defer bpfManager.Close()
+
+	teFlags.filter.Pids = toUint32s(teFlags.pids)
+	teFlags.filter.PPids = toUint32s(teFlags.ppids)
+	teFlags.filter.Uids = toUint32s(teFlags.uids)
+
+	eventCh := make(chan *ebpf.ExecEvent, 100)
+	if err := bpfManager.StartExecTracer(ctx, &teFlags.filter, eventCh); err != nil {
+		logrus.Fatalf("[eBPF] failed to start exec tracer err=%v", err.Error())
+	}
+
+	if !teFlags.json {
+		fmt.Printf("%-15s %-16s %-7s %-7s %-6s %-4s %s\n", "TIME", "COMM", "PID", "PPID", "UID", "RET", "ARGS")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-eventCh:
+			if teFlags.json {
+				out, _ := json.Marshal(event)
+				fmt.Println(string(out))
+				continue
+			}
+
+			argv := strings.Join(event.Argv, " ")
+			if event.Truncated {
+				argv += " ..."
+			}
+			fmt.Printf("%-15s %-16s %-7d %-7d %-6d %-4d %s\n", event.Timestamp.Format("15:04:05.000000"),
+				event.Comm, event.Pid, event.PPid, event.Uid, event.Retval, argv)
+		}
+	}
+}
+
+func toUint32s(list []uint) []uint32 {
+	ret := make([]uint32, 0, len(list))
+	for _, v := range list {
+		ret = append(ret, uint32(v))
+	}
+	return ret
+}
+
+func init() {
+	rootCmd.AddCommand(traceCmd)
+	traceCmd.AddCommand(traceExecCmd)
+
+	traceExecCmd.PersistentFlags().StringVarP(&teFlags.objPath, "obj", "b", DEFAULT_BPF_OBJ_PATH, "path of the eBPF object overriding the embedded one")
+	traceExecCmd.PersistentFlags().UintSliceVarP(&teFlags.pids, "pid", "p", []uint{}, "only trace given process ids")
+	traceExecCmd.PersistentFlags().UintSliceVarP(&teFlags.ppids, "ppid", "P", []uint{}, "only trace processes whose parent is one of given process ids")
+	traceExecCmd.PersistentFlags().UintSliceVarP(&teFlags.uids, "uid", "u", []uint{}, "only trace processes of given user ids")
+	traceExecCmd.PersistentFlags().StringArrayVarP(&teFlags.filter.Comms, "comm", "n", []string{}, "only trace processes with given command name")
+	traceExecCmd.PersistentFlags().StringVarP(&teFlags.filter.Cgroup, "cgroup", "g", "", "only trace processes whose cgroup path contains given string")
+	traceExecCmd.PersistentFlags().BoolVarP(&teFlags.filter.FailedOnly, "failed", "x", false, "only trace failed execve")
+	traceExecCmd.PersistentFlags().BoolVarP(&teFlags.json, "json", "j", false, "print events in JSON lines")
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/p1nant0m/xdp-tracing/handler/utils"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	shortDescription_Upgrade = "Replace the attached XDP programs with the ones of a new object"
+	longDescription_Upgrade  = `Load the new eBPF object, migrate the content of the maps pinned on bpffs into it,
+and atomically replace the XDP programs attached through the pinned links, so the
+devices are never left without a program. Programs attached by other tools through
+netlink can be upgraded as well with --dev. Replaced programs are rolled back when
+the replacement fails or the new program fails verification.
+
+Stop the service before upgrading, or restart it afterwards, since it keeps using
+the maps of the old object.`
+)
+
+type upgradeFlags struct {
+	FileName string
+	PinPath  string
+	Devices  []string
+	JSON     bool
+}
+
+var uFlags = &upgradeFlags{}
+
+// upgradeCmd represents the upgrade command
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: shortDescription_Upgrade,
+	Long:  longDescription_Upgrade,
+	Run:   upgradeCommandRunFunc,
+}
+
+func upgradeCommandRunFunc(cmd *cobra.Command, args []string) {
+	bpfManager, err := ebpf.NewBPFManager(bpfModuleOption(uFlags.FileName))
+	if err != nil {
+		logrus.Fatalf("[eBPF] failed to create BPFManager err=%v", err)
+	}
+	defer bpfManager.Close()
+
+	report, err := ebpf.UpgradeXDP(bpfManager, uFlags.PinPath, uFlags.Devices...)
+	if report != nil && uFlags.JSON {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			logrus.Errorf("[eBPF] failed to encode the report err=%v", err)
+		}
+	} else if report != nil {
+		printUpgradeReport(report)
+	}
+	if err != nil {
+		logrus.Fatalf("[eBPF] upgrade failed err=%v%v", err, explainErrno(err))
+	}
+
+	fmt.Println("🥳 " + utils.FontSet("XDP Program Upgrade Successfully!"))
+}
+
+func printUpgradeReport(report *ebpf.UpgradeReport) {
+	for _, migration := range report.Maps {
+		if migration.Skipped != "" {
+			fmt.Printf("map %v: %v\n", migration.Name, migration.Skipped)
+		} else {
+			fmt.Printf("map %v: %v entries migrated\n", migration.Name, migration.Entries)
+		}
+	}
+	for _, program := range report.Programs {
+		fmt.Printf("%v at %v: prog id %v -> %v\n", program.Program, program.HookPoint, program.OldID, program.NewID)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// upgradeCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// upgradeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	upgradeCmd.PersistentFlags().StringVarP(&uFlags.FileName, "file-name", "f", DEFAULT_BPF_OBJ_PATH, "path of the new eBPF object overriding the embedded one")
+	upgradeCmd.PersistentFlags().StringVarP(&uFlags.PinPath, "pin-path", "p", ebpf.DefaultPinPath, "directory on bpffs where the old object is pinned")
+	upgradeCmd.PersistentFlags().StringSliceVarP(&uFlags.Devices, "dev", "d", nil, "devices whose XDP program was attached through netlink")
+	upgradeCmd.PersistentFlags().BoolVar(&uFlags.JSON, "json", false, "print the report in JSON")
+}
@@ -32,6 +32,12 @@ const (
 	PASS
 )
 
+// Verdicts of the XDP programs for the sampled packets, see TCP_IP_Handler.Verdict
+const (
+	VERDICT_DROP    = "drop"
+	VERDICT_MONITOR = "monitor" // passed, but a monitor policy would have dropped it
+)
+
 const (
 	None = iota
 	IPv4Packet
@@ -72,6 +78,9 @@ type TCP_IP_Handler struct {
 	// Application Payload
 	PayloadExist bool
 	*PayloadMeta
+
+	// Verdict of the XDP programs set by the XDP sampler, empty for the packets passed
+	Verdict string
 }
 
 // NewTCPFlags return the pointer of new tcpFlags Struct with TCP flags Settings
@@ -277,9 +286,9 @@ func StartTCPIPHandler(ctx context.Context, rules map[string][]string, observerC
 	defer syscall.Close(fd)
 	tcpHandler := NewTCPIPHandler()
 	buf := make([]byte, 4096)
-	logrus.Debug("In StartTCPIPHandler:274 rulesRaw:%v", rules)
+	logrus.Debugf("In StartTCPIPHandler:274 rulesRaw:%v", rules)
 	rulesApplied := MakeTCPIPRules(rules)
-	logrus.Debug("In StartTCPIPHandler:274 rulesApplied:%v", rulesApplied)
+	logrus.Debugf("In StartTCPIPHandler:274 rulesApplied:%v", rulesApplied)
 
 	for {
 		// long-routine
@@ -1,5 +1,69 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/p1nant0m/xdp-tracing/internal/pump/store"
+	"github.com/p1nant0m/xdp-tracing/internal/pump/store/boltdb"
+	"github.com/p1nant0m/xdp-tracing/internal/pump/store/mongodb"
+	"github.com/p1nant0m/xdp-tracing/pkg/options"
+	"github.com/p1nant0m/xdp-tracing/service"
+	"github.com/sirupsen/logrus"
+)
+
 func main() {
+	if len(os.Args) < 2 {
+		logrus.Fatal("./pump [required <path of config.yml>]")
+	}
+	if err := service.ReadAndParseConfig(os.Args[1]); err != nil {
+		logrus.Fatalf("[Pump] error occurs when ReadAndParseConfig err=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := make(chan os.Signal, 1)
+	signal.Notify(watcher, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-watcher
+		cancel()
+	}()
+
+	pumpConfig := service.ExtractPumpConfig()
+	factory, err := newStoreFactory(pumpConfig)
+	if err != nil {
+		logrus.Fatalf("[Pump] failed to open the store of sessions err=%v", err)
+	}
+	store.SetClient(factory)
+
+	// the nodes keep the sessions they capture in Redis, they are pumped into the store
+	redisService := service.NewRedisService(ctx)
+	redisService.Conn()
+	defer redisService.RDClient.Close()
+	period := PUMP_PERIOD
+	if pumpConfig != nil && pumpConfig.Period != 0 {
+		period = pumpConfig.Period
+	}
+	logrus.Infof("[Pump] pumping the sessions from redis %v every %v", redisService.Options.Addr, period)
+	newPump(redisService.RDClient, factory).Run(ctx, period)
+}
+
+// newStoreFactory returns the store of the sessions selected by pump.store of the config.
+func newStoreFactory(pumpConfig *service.PumpConfig) (store.Factory, error) {
+	kind := service.PUMP_STORE_MONGODB
+	if pumpConfig != nil && pumpConfig.Store != "" {
+		kind = pumpConfig.Store
+	}
 
+	switch kind {
+	case service.PUMP_STORE_MONGODB:
+		return mongodb.GetMongoDBFactoryOr(options.NewMongoDBOptions())
+	case service.PUMP_STORE_BOLT:
+		return boltdb.GetBoltFactoryOr(service.ExtractPumpBoltOptions())
+	}
+	return nil, fmt.Errorf("unknown store %q of the sessions, should be %v or %v",
+		kind, service.PUMP_STORE_MONGODB, service.PUMP_STORE_BOLT)
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/p1nant0m/xdp-tracing/internal/pump/store"
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	metav1 "github.com/p1nant0m/xdp-tracing/pkg/meta/v1"
+	"github.com/p1nant0m/xdp-tracing/service"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PUMP_PERIOD is how often the sessions captured by the nodes are pumped from Redis
+	// into the store, unless pump.period is set
+	PUMP_PERIOD = 10 * time.Second
+	// PUMP_DELAY is how long the packets are left in Redis before they are pumped, the
+	// nodes may still be adding the packets of the same second meanwhile
+	PUMP_DELAY = 5 * time.Second
+)
+
+// sessionCollection is where the sessions are saved in the store.
+var sessionCollection = &metav1.MongoDBGenericOptions{Database: "xdp-tracing", Collection: "session"}
+
+// pump saves the packets of the sessions the nodes keep in Redis into the store, so that they
+// outlive Redis. Each session is saved under its id, see sessionID, and its packets are saved
+// in the order of the seconds they are captured at. The packets of a session failed to be
+// saved are saved again by the next round.
+type pump struct {
+	rdb    *redis.Client
+	store  store.Factory
+	pumped map[string]int64 // the second up to which the packets are saved by session ids
+}
+
+func newPump(rdb *redis.Client, factory store.Factory) *pump {
+	return &pump{rdb: rdb, store: factory, pumped: make(map[string]int64)}
+}
+
+// Run pumps the sessions every period until ctx is done, a round failed is retried by the
+// next one.
+func (p *pump) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if n, err := p.pumpOnce(ctx, time.Now().Add(-PUMP_DELAY).Unix()); err != nil {
+			logrus.Warnf("[Pump] failed to pump the sessions, %v packets saved err=%v", n, err)
+		} else if n > 0 {
+			logrus.Debugf("[Pump] %v packets saved", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pumpOnce saves the packets captured up to the second until which are not saved yet, and
+// returns how many packets are saved.
+func (p *pump) pumpOnce(ctx context.Context, until int64) (int, error) {
+	keys, err := p.rdb.SMembers(ctx, service.SESSIONS_KEY).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	saved := 0
+	for _, keyS := range keys {
+		id := sessionID(keyS)
+		since, err := p.since(ctx, id)
+		if err != nil {
+			return saved, err
+		}
+		if since >= until {
+			continue
+		}
+
+		packets, err := p.rdb.ZRangeByScoreWithScores(ctx, keyS, &redis.ZRangeBy{
+			Min: "(" + strconv.FormatInt(since, 10),
+			Max: strconv.FormatInt(until, 10),
+		}).Result()
+		if err != nil {
+			return saved, err
+		}
+		key := service.DecodeKey(keyS)
+		for _, packet := range packets {
+			value, err := service.DecodeValue(packet.Member.(string))
+			if err != nil {
+				logrus.Warnf("[Pump] skip the malformed packet of session %v err=%v", id, err)
+				continue
+			}
+			session := newSession(id, key, value, int64(packet.Score))
+			if err := p.store.Session().SavingSession(ctx, session,
+				metav1.SavingSessionOptions{MongoDBGenericOptions: sessionCollection}); err != nil {
+				return saved, fmt.Errorf("failed to save the packet of session %v: %w", id, err)
+			}
+			saved++
+		}
+		p.pumped[id] = until
+	}
+	return saved, nil
+}
+
+// since returns the second up to which the packets of the session of id are saved, it is read
+// from the store once the pump starts.
+func (p *pump) since(ctx context.Context, id string) (int64, error) {
+	if since, exists := p.pumped[id]; exists {
+		return since, nil
+	}
+
+	packets, err := p.store.Session().GetSpecificSession(ctx, id,
+		metav1.GetSpecificSessionOptions{MongoDBGenericOptions: sessionCollection})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read session %v: %w", id, err)
+	}
+	var since int64
+	for _, packet := range packets {
+		if packet.Timestamp > since {
+			since = packet.Timestamp
+		}
+	}
+	p.pumped[id] = since
+	return since, nil
+}
+
+// sessionID returns the id of the session of the serialized key keyS in the store, the same
+// as the one of /get/session/:key.
+func sessionID(keyS string) string {
+	return base64.URLEncoding.EncodeToString([]byte(keyS))
+}
+
+// newSession returns the packet of value captured at the second timestamp in the session of
+// key, the payload is encoded in base64.
+func newSession(id string, key *service.Key, value *service.Value, timestamp int64) *v1.Session {
+	session := &v1.Session{
+		TCPIPIdentifier: metav1.TCPIPIdentifier{
+			SrcIP:   key.SrcIP.String(),
+			DstIP:   key.DstIP.String(),
+			SrcPort: int32(key.SrcPort),
+			DstPort: int32(key.DstPort),
+		},
+		InstanceID:   id,
+		Ttl:          int32(value.TTL),
+		TcpFlagS:     value.TcpFlagS,
+		PayloadExist: value.PayloadExist,
+		Timestamp:    timestamp,
+	}
+	if value.PayloadMeta != nil {
+		session.PayloadLen = int32(value.PayloadLen)
+		if value.PayloadExist && value.Payload != nil {
+			session.Payload = base64.StdEncoding.EncodeToString(*value.Payload)
+		}
+	}
+	return session
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/p1nant0m/xdp-tracing/handler"
+	"github.com/p1nant0m/xdp-tracing/internal/pump/store/boltdb"
+	metav1 "github.com/p1nant0m/xdp-tracing/pkg/meta/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/options"
+	"github.com/p1nant0m/xdp-tracing/service"
+)
+
+func TestPumpSince(t *testing.T) {
+	opts := options.NewBoltOptions()
+	opts.Path = filepath.Join(t.TempDir(), "pump.db")
+	factory, err := boltdb.GetBoltFactoryOr(opts)
+	if err != nil {
+		t.Fatalf("Expected the bolt store opened, got %v", err)
+	}
+
+	key := &service.Key{SrcIP: net.IPv4(192, 168, 176, 1), DstIP: net.IPv4(192, 168, 176, 128), SrcPort: layers.TCPPort(1080), DstPort: layers.TCPPort(44292)}
+	payload := []byte("GET /")
+	value := &service.Value{TTL: 64, TcpFlagS: "PSH ACK", PayloadExist: true,
+		PayloadMeta: &handler.PayloadMeta{Payload: &payload, PayloadLen: uint32(len(payload))}}
+	id := sessionID(service.EncodeKey(key))
+	for _, timestamp := range []int64{1660989600, 1660989602} {
+		session := newSession(id, key, value, timestamp)
+		if err := factory.Session().SavingSession(context.Background(), session,
+			metav1.SavingSessionOptions{MongoDBGenericOptions: sessionCollection}); err != nil {
+			t.Fatalf("Expected the packet saved, got %v", err)
+		}
+	}
+
+	packets, err := factory.Session().GetSpecificSession(context.Background(), id,
+		metav1.GetSpecificSessionOptions{MongoDBGenericOptions: sessionCollection})
+	if err != nil || len(packets) != 2 || packets[0].SrcIP != "192.168.176.1" || packets[0].DstPort != 44292 ||
+		packets[0].Payload != "R0VUIC8=" || packets[0].PayloadLen != 5 {
+		t.Fatalf("Expected 2 packets of the session saved, got %v err=%v", packets, err)
+	}
+
+	// a pump started again carries on from the packets saved
+	if since, err := newPump(nil, factory).since(context.Background(), id); err != nil || since != 1660989602 {
+		t.Fatalf("Expected the packets saved up to 1660989602, got %v err=%v", since, err)
+	}
+	if since, err := newPump(nil, factory).since(context.Background(), sessionID("unknown")); err != nil || since != 0 {
+		t.Fatalf("Expected nothing saved of an unknown session, got %v err=%v", since, err)
+	}
+}
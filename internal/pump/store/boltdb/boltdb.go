@@ -0,0 +1,50 @@
+package boltdb
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/p1nant0m/xdp-tracing/internal/pump/store"
+	"github.com/p1nant0m/xdp-tracing/pkg/db"
+	"github.com/p1nant0m/xdp-tracing/pkg/options"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltFactory store.Factory
+	once        sync.Once
+)
+
+type datastore struct {
+	db *bolt.DB
+}
+
+func (ds *datastore) Session() store.SessionStore {
+	return newSession(ds)
+}
+
+func (ds *datastore) Sessions() store.SessionsStore {
+	return newSessions(ds)
+}
+
+// GetBoltFactoryOr returns the store.Factory keeping sessions in the bolt file of opts, opts
+// is only used by the first call.
+func GetBoltFactoryOr(opts *options.BoltOptions) (store.Factory, error) {
+	if opts == nil && boltFactory == nil {
+		return nil, fmt.Errorf("failed to get bolt store factory")
+	}
+
+	var err error
+	once.Do(func() {
+		var dbIns *bolt.DB
+		if dbIns, err = db.NewBoltDB(opts.Path, opts.Timeout); err == nil {
+			boltFactory = &datastore{dbIns}
+		}
+	})
+
+	if boltFactory == nil || err != nil {
+		return nil, fmt.Errorf("failed to get bolt store factory, boltFactory: %+v, error: %w", boltFactory, err)
+	}
+
+	return boltFactory, nil
+}
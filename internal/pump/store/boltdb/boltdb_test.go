@@ -0,0 +1,44 @@
+package boltdb_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/p1nant0m/xdp-tracing/internal/pump/store/boltdb"
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	metav1 "github.com/p1nant0m/xdp-tracing/pkg/meta/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/options"
+)
+
+func TestBoltStorage(t *testing.T) {
+	opts := options.NewBoltOptions()
+	opts.Path = filepath.Join(t.TempDir(), "xdp-tracing.db")
+	storeIns, err := boltdb.GetBoltFactoryOr(opts)
+	if err != nil {
+		t.Fatal("fail to initialize the bolt instance.", "err=", err)
+	}
+
+	genericOpts := &metav1.MongoDBGenericOptions{Collection: "session"}
+	for _, srcIP := range []string{"192.168.176.128", "192.168.176.1"} {
+		err = storeIns.Session().SavingSession(context.Background(), &v1.Session{InstanceID: "123", TCPIPIdentifier: metav1.TCPIPIdentifier{SrcIP: srcIP}},
+			metav1.SavingSessionOptions{MongoDBGenericOptions: genericOpts})
+		if err != nil {
+			t.Fatal("fail to save id=123", "err=", err)
+		}
+	}
+
+	packets, err := storeIns.Session().GetSpecificSession(context.Background(), "123",
+		metav1.GetSpecificSessionOptions{MongoDBGenericOptions: genericOpts})
+	if err != nil || len(packets) != 2 {
+		t.Fatal("fail to get id=123", "err=", err, "packets", packets)
+	}
+	if packets[0].SrcIP != "192.168.176.128" {
+		t.Errorf("Expected srcIP 192.168.176.128 saved first, got %v", packets[0].SrcIP)
+	}
+
+	count, err := storeIns.Session().DeleteSession(context.Background(), "123", metav1.DeleteSessionOptions{MongoDBGenericOptions: genericOpts})
+	if count != 2 || err != nil {
+		t.Errorf("Expected count 2 no error,got %v %v", count, err)
+	}
+}
@@ -0,0 +1,107 @@
+package boltdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	metav1 "github.com/p1nant0m/xdp-tracing/pkg/meta/v1"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DeleteNothing int64 = 0
+)
+
+// defaultSessionBucket holds the sessions unless a collection is given in the options. A
+// session is kept in a nested bucket named by its identifier, with its packets keyed by the
+// order they were saved in.
+var defaultSessionBucket = []byte("session")
+
+func sessionBucket(opts *metav1.MongoDBGenericOptions) []byte {
+	if opts == nil || opts.Collection == "" {
+		return defaultSessionBucket
+	}
+	return []byte(opts.Collection)
+}
+
+type session struct {
+	db *bolt.DB
+}
+
+func newSession(ds *datastore) *session {
+	return &session{ds.db}
+}
+
+func (s *session) GetSpecificSession(ctx context.Context, id string, opts metav1.GetSpecificSessionOptions) ([]*v1.Session, error) {
+	packets := []*v1.Session{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		collection := tx.Bucket(sessionBucket(opts.MongoDBGenericOptions))
+		if collection == nil {
+			return nil
+		}
+		bucket := collection.Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			packet := &v1.Session{}
+			if err := json.Unmarshal(v, packet); err != nil {
+				return err
+			}
+			packets = append(packets, packet)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return packets, nil
+}
+
+func (s *session) SavingSession(ctx context.Context, packet *v1.Session, opts metav1.SavingSessionOptions) error {
+	value, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		collection, err := tx.CreateBucketIfNotExists(sessionBucket(opts.MongoDBGenericOptions))
+		if err != nil {
+			return err
+		}
+		bucket, err := collection.CreateBucketIfNotExists([]byte(packet.InstanceID))
+		if err != nil {
+			return err
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, value)
+	})
+}
+
+func (s *session) DeleteSession(ctx context.Context, id string, opts metav1.DeleteSessionOptions) (int64, error) {
+	deleted := DeleteNothing
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		collection := tx.Bucket(sessionBucket(opts.MongoDBGenericOptions))
+		if collection == nil || collection.Bucket([]byte(id)) == nil {
+			return nil
+		}
+
+		deleted = int64(collection.Bucket([]byte(id)).Stats().KeyN)
+		return collection.DeleteBucket([]byte(id))
+	})
+	if err != nil {
+		return DeleteNothing, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,20 @@
+package boltdb
+
+import (
+	"context"
+
+	metav1 "github.com/p1nant0m/xdp-tracing/pkg/meta/v1"
+	bolt "go.etcd.io/bbolt"
+)
+
+type sessions struct {
+	db *bolt.DB
+}
+
+func newSessions(ds *datastore) *sessions {
+	return &sessions{ds.db}
+}
+
+func (s *sessions) GetAllSessions(ctx context.Context, opts metav1.GetAllSessionsOptions) error {
+	return nil
+}
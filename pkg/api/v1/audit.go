@@ -0,0 +1,109 @@
+package v1
+
+import (
+	"fmt"
+	"time"
+)
+
+// Kinds of AuditEvent. The changes of policies are made by users through the REST API, and
+// the results of applying them are reported by the nodes.
+const (
+	AUDIT_CREATE  = "create"
+	AUDIT_DELETE  = "delete"
+	AUDIT_PROMOTE = "promote" // from monitor to drop
+	AUDIT_APPLY   = "apply"   // a node applied, rejected or failed to apply the policy
+	AUDIT_REVOKE  = "revoke"  // a node no longer enforces the policy
+)
+
+// Results of the events reported by the nodes.
+const (
+	AUDIT_RESULT_OK       = "ok"
+	AUDIT_RESULT_REJECTED = "rejected"
+	AUDIT_RESULT_FAILED   = "failed"
+)
+
+const (
+	AUDIT_DEFAULT_LIMIT = 100
+	AUDIT_MAX_LIMIT     = 1000
+)
+
+var nodeAuditKinds = map[string]bool{AUDIT_APPLY: true, AUDIT_REVOKE: true}
+
+// NODE_KEY_PREFIX is the prefix of the keys in the registry under which the nodes register
+// the address of their gRPC server, followed by their ids.
+const NODE_KEY_PREFIX = "node:"
+
+// AuditEvent is an entry of the audit log, which is only ever appended to.
+type AuditEvent struct {
+	Seq        int64     `json:"seq"` // set by the store, increasing in the order of appending
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	PolicyID   string    `json:"policyId"`
+	Actor      string    `json:"actor"`              // user of the change declared by the client, or the node reporting the result
+	SourceIP   string    `json:"sourceIp,omitempty"` // where the request came from
+	Reason     string    `json:"reason,omitempty"`
+	Before     *Policy   `json:"before,omitempty"` // the policy before the change, nil for creations
+	After      *Policy   `json:"after,omitempty"`  // the policy after the change, nil for deletions
+	NodeID     string    `json:"nodeId,omitempty"`
+	Generation uint64    `json:"generation,omitempty"` // generation of the policies applied by the node
+	Result     string    `json:"result,omitempty"`     // result reported by the node
+}
+
+// ValidateNodeEvent returns the reason why event can not be reported by a node, nil if it can.
+func (event *AuditEvent) ValidateNodeEvent() error {
+	switch {
+	case !nodeAuditKinds[event.Kind]:
+		return fmt.Errorf("kind %q should be apply or revoke", event.Kind)
+	case event.NodeID == "":
+		return fmt.Errorf("node id is required")
+	case event.PolicyID == "":
+		return fmt.Errorf("policy id is required")
+	case event.Time.IsZero():
+		return fmt.Errorf("time is required")
+	}
+	return nil
+}
+
+// AuditQuery selects the events of the audit log, the zero fields select every event.
+type AuditQuery struct {
+	Since    time.Time // inclusively
+	Until    time.Time // exclusively
+	Actor    string
+	PolicyID string
+	Limit    int // the most recent events kept, AUDIT_DEFAULT_LIMIT if 0
+}
+
+// Matches reports whether q selects event, regardless of Limit.
+func (q *AuditQuery) Matches(event *AuditEvent) bool {
+	switch {
+	case !q.Since.IsZero() && event.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !event.Time.Before(q.Until):
+		return false
+	case q.Actor != "" && event.Actor != q.Actor:
+		return false
+	case q.PolicyID != "" && event.PolicyID != q.PolicyID:
+		return false
+	}
+	return true
+}
+
+// Select returns the events selected by q, the events are in the order of appending and
+// only the most recent ones are kept by Limit.
+func (q *AuditQuery) Select(events []*AuditEvent) []*AuditEvent {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = AUDIT_DEFAULT_LIMIT
+	}
+
+	selected := []*AuditEvent{}
+	for _, event := range events {
+		if q.Matches(event) {
+			selected = append(selected, event)
+		}
+	}
+	if len(selected) > limit {
+		selected = selected[len(selected)-limit:]
+	}
+	return selected
+}
@@ -0,0 +1,35 @@
+package v1
+
+import "time"
+
+// MONITOR_KEY_PREFIX is the prefix of the keys of MonitorReport in the registry, each node
+// publishes its report under the prefix followed by its id.
+const MONITOR_KEY_PREFIX = "monitor:"
+
+// MonitorReport is what a node reports about the policies of ACTION_MONITOR applied on it.
+type MonitorReport struct {
+	Generation uint64            `json:"generation"` // generation of the policies applied
+	WouldDrop  map[string]uint64 `json:"wouldDrop"`  // packets passed which drop would have dropped, by policy ids
+	Timestamp  time.Time         `json:"timestamp"`
+}
+
+// MonitorTraffic is how much traffic a policy of ACTION_MONITOR would have dropped.
+type MonitorTraffic struct {
+	Policy *Policy           `json:"policy"`
+	Nodes  map[string]uint64 `json:"nodes"` // packets by node ids, the nodes which have not reported are missing
+	Total  uint64            `json:"total"`
+}
+
+// NewMonitorTraffic sums up the packets policy would have dropped from reports by node ids.
+func NewMonitorTraffic(policy *Policy, reports map[string]*MonitorReport) *MonitorTraffic {
+	traffic := &MonitorTraffic{Policy: policy, Nodes: make(map[string]uint64)}
+	for nodeID, report := range reports {
+		packets, exists := report.WouldDrop[policy.ID]
+		if !exists {
+			continue
+		}
+		traffic.Nodes[nodeID] = packets
+		traffic.Total += packets
+	}
+	return traffic
+}
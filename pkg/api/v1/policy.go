@@ -1,5 +1,316 @@
 package v1
 
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Protocols of the traffic matched by Policy.
+const (
+	PROTOCOL_TCP  = "tcp"
+	PROTOCOL_UDP  = "udp"
+	PROTOCOL_ICMP = "icmp"
+	PROTOCOL_ANY  = "any"
+)
+
+// Directions of the traffic matched by Policy, seen from the node enforcing it.
+const (
+	DIRECTION_INGRESS = "ingress"
+	DIRECTION_EGRESS  = "egress"
+)
+
+// Actions taken on the traffic matched by Policy.
+const (
+	ACTION_DROP    = "drop"
+	ACTION_PASS    = "pass"
+	ACTION_MONITOR = "monitor" // counts and samples the traffic drop would, but passes it
+)
+
+// Filter modes of the interfaces of nodes. An interface in blocklist mode passes everything
+// but the traffic of drop policies, while one in allowlist mode drops everything but the
+// traffic of pass policies and the management set of the node.
+const (
+	FILTER_MODE_BLOCKLIST = "blocklist"
+	FILTER_MODE_ALLOWLIST = "allowlist"
+)
+
+const (
+	// POLICY_MIN_DROP_PREFIX_LEN is the shortest prefix of drop and monitor policies, the
+	// blocklist of the nodes holds single addresses
+	POLICY_MIN_DROP_PREFIX_LEN = 24
+	// POLICY_MAX_PASS_PORTS is the most ports a pass policy may allow, the allowlist of the
+	// nodes holds single ports
+	POLICY_MAX_PASS_PORTS = 256
+
+	POLICY_MAX_PRIORITY        = 65535
+	POLICY_MAX_LABELS          = 32
+	POLICY_MAX_DESCRIPTION_LEN = 1024
+	policyMaxNameLen           = 63
+)
+
+var (
+	policyIDRegexp    = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._-]*[a-zA-Z0-9])?$`)
+	labelKeyRegexp    = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]*[a-z0-9])?/)?[a-zA-Z0-9]([-a-zA-Z0-9_.]*[a-zA-Z0-9])?$`)
+	labelValueRegexp  = regexp.MustCompile(`^([a-zA-Z0-9]([-a-zA-Z0-9_.]*[a-zA-Z0-9])?)?$`)
+	policyProtocols   = map[string]bool{PROTOCOL_TCP: true, PROTOCOL_UDP: true, PROTOCOL_ICMP: true, PROTOCOL_ANY: true}
+	policyDirections  = map[string]bool{DIRECTION_INGRESS: true, DIRECTION_EGRESS: true}
+	policyActions     = map[string]bool{ACTION_DROP: true, ACTION_PASS: true, ACTION_MONITOR: true}
+	protocolWithPorts = map[string]bool{PROTOCOL_TCP: true, PROTOCOL_UDP: true}
+)
+
+// PortRange is the range of ports from Start to End inclusively, End 0 stands for the
+// single port Start.
+type PortRange struct {
+	Start uint16 `json:"start"`
+	End   uint16 `json:"end,omitempty"`
+}
+
+func (r PortRange) String() string {
+	if r.End == 0 || r.End == r.Start {
+		return fmt.Sprintf("%d", r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// Policy is a rule enforced on the traffic of nodes: the packets of Protocol to or from
+// the addresses in CIDR and, for TCP and UDP, the ports in Ports are taken Action on.
+// The policies are created through the REST API and delivered to nodes through gRPC, see
+// service/strategy/strategy.proto for the wire representation.
 type Policy struct {
-	Policy string `json:"policy,omitempty"`
+	ID           string            `json:"id"`
+	CIDR         string            `json:"cidr"`            // IPv4 address or prefix of the peer, e.g. 10.0.0.0/24
+	Ports        []PortRange       `json:"ports,omitempty"` // ports of the local end, empty for all ports
+	Protocol     string            `json:"protocol"`
+	Direction    string            `json:"direction"`
+	Action       string            `json:"action"`
+	Priority     int32             `json:"priority"` // reserved for overlapping policies, see CheckEnforceable
+	Expiry       *time.Time        `json:"expiry,omitempty"`
+	Schedule     *Schedule         `json:"schedule,omitempty"` // windows the policy is enforced in, nil for always
+	Labels       map[string]string `json:"labels,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	NodeSelector *NodeSelector     `json:"nodeSelector,omitempty"` // nodes to enforce the policy on, nil for every node
+	Revision     int64             `json:"revision,omitempty"`     // revision of the store the policy was last written at, set by the store
+}
+
+// Default fills the omitted fields of policy, a policy with only CIDR drops the inbound
+// TCP traffic of the peers, and a single address in CIDR is turned into a /32 prefix. A
+// pass policy without ports passes every protocol of the peers.
+func (policy *Policy) Default() {
+	if policy.Action == "" {
+		policy.Action = ACTION_DROP
+	}
+	if policy.Protocol == "" {
+		policy.Protocol = PROTOCOL_TCP
+		if policy.Action == ACTION_PASS && len(policy.Ports) == 0 {
+			policy.Protocol = PROTOCOL_ANY
+		}
+	}
+	if policy.Direction == "" {
+		policy.Direction = DIRECTION_INGRESS
+	}
+	if policy.CIDR != "" && !strings.Contains(policy.CIDR, "/") {
+		policy.CIDR += "/32"
+	}
+}
+
+// ValidationError lists the reasons why a Policy is malformed.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// Validate returns a ValidationError if any field of policy is malformed, Default should
+// be called beforehand for policies given by users.
+func (policy *Policy) Validate() error {
+	var errs ValidationError
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case policy.ID == "":
+		invalid("id is required")
+	case len(policy.ID) > policyMaxNameLen || !policyIDRegexp.MatchString(policy.ID):
+		invalid("id %q should be at most %v alphanumeric characters, '-', '_' or '.'", policy.ID, policyMaxNameLen)
+	}
+
+	if _, err := policy.IPNet(); err != nil {
+		invalid("cidr: %v", err)
+	}
+
+	if !policyProtocols[policy.Protocol] {
+		invalid("protocol %q should be one of tcp, udp, icmp or any", policy.Protocol)
+	}
+	if len(policy.Ports) > 0 && !protocolWithPorts[policy.Protocol] {
+		invalid("ports are only allowed for tcp and udp, got protocol %q", policy.Protocol)
+	}
+	for _, r := range policy.Ports {
+		if r.Start == 0 || (r.End != 0 && r.End < r.Start) {
+			invalid("port range %v should be within 1-65535 and end after it starts", r)
+		}
+	}
+
+	if !policyDirections[policy.Direction] {
+		invalid("direction %q should be ingress or egress", policy.Direction)
+	}
+	if !policyActions[policy.Action] {
+		invalid("action %q should be drop, pass or monitor", policy.Action)
+	}
+	if policy.Priority < 0 || policy.Priority > POLICY_MAX_PRIORITY {
+		invalid("priority %v should be within 0-%v", policy.Priority, POLICY_MAX_PRIORITY)
+	}
+	if policy.Expiry != nil && policy.Expiry.IsZero() {
+		invalid("expiry should be omitted rather than zero")
+	}
+
+	if len(policy.Labels) > POLICY_MAX_LABELS {
+		invalid("at most %v labels are allowed, got %v", POLICY_MAX_LABELS, len(policy.Labels))
+	}
+	for key, value := range policy.Labels {
+		if len(key) > 2*policyMaxNameLen || !labelKeyRegexp.MatchString(key) {
+			invalid("label key %q is malformed", key)
+		}
+		if len(value) > policyMaxNameLen || !labelValueRegexp.MatchString(value) {
+			invalid("label value %q of %q is malformed", value, key)
+		}
+	}
+	if len(policy.Description) > POLICY_MAX_DESCRIPTION_LEN {
+		invalid("description should be at most %v bytes", POLICY_MAX_DESCRIPTION_LEN)
+	}
+	errs = append(errs, policy.Schedule.Validate()...)
+	errs = append(errs, policy.NodeSelector.Validate()...)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CheckEnforceable returns a ValidationError if the data plane of the nodes can not enforce
+// policy, which should have been validated. Only inbound traffic is filtered: drop and
+// monitor policies match TCP by the source prefix, while pass policies match either any
+// protocol by the source prefix, or TCP or UDP by the local ports from every source.
+func (policy *Policy) CheckEnforceable() error {
+	var errs ValidationError
+	unsupported := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...)+" is not supported by the nodes yet")
+	}
+
+	if policy.Direction != DIRECTION_INGRESS {
+		unsupported("direction %v", policy.Direction)
+	}
+	if policy.Priority != 0 {
+		unsupported("priority")
+	}
+
+	ipNet, err := policy.IPNet()
+	if err != nil {
+		return ValidationError{err.Error()}
+	}
+	ones, _ := ipNet.Mask.Size()
+	switch {
+	case policy.Action != ACTION_PASS && policy.Protocol != PROTOCOL_TCP:
+		unsupported("protocol %v of %v policies", policy.Protocol, policy.Action)
+	case policy.Action != ACTION_PASS && len(policy.Ports) > 0:
+		unsupported("ports of %v policies", policy.Action)
+	case policy.Action != ACTION_PASS && ones < POLICY_MIN_DROP_PREFIX_LEN:
+		unsupported("prefix %v shorter than /%v of %v policies", ipNet, POLICY_MIN_DROP_PREFIX_LEN, policy.Action)
+	case policy.Action == ACTION_PASS && len(policy.Ports) == 0 && policy.Protocol != PROTOCOL_ANY:
+		unsupported("protocol %v of pass policies without ports", policy.Protocol)
+	case policy.Action == ACTION_PASS && len(policy.Ports) > 0 && ones != 0:
+		unsupported("prefix %v of pass policies with ports, which pass every source 0.0.0.0/0,", ipNet)
+	case policy.Action == ACTION_PASS && policy.portCount() > POLICY_MAX_PASS_PORTS:
+		unsupported("more than %v ports of pass policies", POLICY_MAX_PASS_PORTS)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (policy *Policy) portCount() int {
+	count := 0
+	for _, r := range policy.Ports {
+		if r.End == 0 {
+			count++
+		} else {
+			count += int(r.End) - int(r.Start) + 1
+		}
+	}
+	return count
+}
+
+// IPNet parses CIDR, only IPv4 prefixes without host bits are accepted.
+func (policy *Policy) IPNet() (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(policy.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an IPv4 prefix like 10.0.0.0/24", policy.CIDR)
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("%q is not an IPv4 prefix, IPv6 is not supported", policy.CIDR)
+	}
+	if !ip.Equal(ipNet.IP) {
+		return nil, fmt.Errorf("%q has host bits set, did you mean %v", policy.CIDR, ipNet)
+	}
+	return ipNet, nil
+}
+
+// FilterMode returns the filter mode enforcing policy: pass policies are the entries of
+// the allowlist, drop and monitor policies the entries of the blocklist.
+func (policy *Policy) FilterMode() string {
+	if policy.Action == ACTION_PASS {
+		return FILTER_MODE_ALLOWLIST
+	}
+	return FILTER_MODE_BLOCKLIST
+}
+
+// Targets reports whether policy should be enforced on node.
+func (policy *Policy) Targets(node *NodeInfo) bool {
+	return policy.NodeSelector.Matches(node)
+}
+
+// Expired reports whether policy has expired at now.
+func (policy *Policy) Expired(now time.Time) bool {
+	return policy.Expiry != nil && !now.Before(*policy.Expiry)
+}
+
+// Active reports whether policy should be enforced at now, that is it has not expired and
+// now is in a window of its schedule.
+func (policy *Policy) Active(now time.Time) bool {
+	return !policy.Expired(now) && policy.Schedule.Contains(now)
+}
+
+// NextChange returns the first time after now Active of policy may change at, zero if never.
+func (policy *Policy) NextChange(now time.Time) time.Time {
+	if policy.Expired(now) {
+		return time.Time{}
+	}
+	next := policy.Schedule.Next(now)
+	if policy.Expiry != nil && (next.IsZero() || policy.Expiry.Before(next)) {
+		next = *policy.Expiry
+	}
+	return next
+}
+
+// ActivePolicies returns the policies active at now, and the first time after now any of
+// them may change at, zero if never.
+func ActivePolicies(policies []*Policy, now time.Time) ([]*Policy, time.Time) {
+	var (
+		active []*Policy
+		next   time.Time
+	)
+	for _, policy := range policies {
+		if policy.Active(now) {
+			active = append(active, policy)
+		}
+		if change := policy.NextChange(now); !change.IsZero() && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+	return active, next
 }
@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"testing"
+)
+
+func TestPolicyDefault(t *testing.T) {
+	policy := &Policy{ID: "block-1", CIDR: "172.17.0.11"}
+	policy.Default()
+
+	if policy.CIDR != "172.17.0.11/32" || policy.Protocol != PROTOCOL_TCP ||
+		policy.Direction != DIRECTION_INGRESS || policy.Action != ACTION_DROP {
+		t.Fatalf("Expected inbound tcp drop of 172.17.0.11/32, got %+v", policy)
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(*Policy)
+	}{
+		{"missing id", func(p *Policy) { p.ID = "" }},
+		{"malformed id", func(p *Policy) { p.ID = "block 1" }},
+		{"typo in address", func(p *Policy) { p.CIDR = "172.17.0.256/32" }},
+		{"not an address", func(p *Policy) { p.CIDR = "172.17.0.11 172.17.0.12/32" }},
+		{"host bits", func(p *Policy) { p.CIDR = "10.0.0.5/24" }},
+		{"ipv6", func(p *Policy) { p.CIDR = "fe80::/64" }},
+		{"protocol", func(p *Policy) { p.Protocol = "sctp" }},
+		{"ports of icmp", func(p *Policy) { p.Protocol = PROTOCOL_ICMP }},
+		{"port zero", func(p *Policy) { p.Ports = []PortRange{{Start: 0}} }},
+		{"reversed ports", func(p *Policy) { p.Ports = []PortRange{{Start: 8080, End: 80}} }},
+		{"direction", func(p *Policy) { p.Direction = "inbound" }},
+		{"action", func(p *Policy) { p.Action = "block" }},
+		{"priority", func(p *Policy) { p.Priority = -1 }},
+		{"label key", func(p *Policy) { p.Labels = map[string]string{"-role": "lb"} }},
+		{"label value", func(p *Policy) { p.Labels = map[string]string{"role": "load balancer"} }},
+		{"node name pattern", func(p *Policy) { p.NodeSelector = &NodeSelector{Name: "lb-["} }},
+	}
+
+	for _, c := range cases {
+		policy := &Policy{
+			ID: "block-1", CIDR: "10.0.0.0/24", Ports: []PortRange{{Start: 80}, {Start: 8000, End: 8080}},
+			Protocol: PROTOCOL_TCP, Direction: DIRECTION_INGRESS, Action: ACTION_DROP,
+			Labels: map[string]string{"app.io/role": "load-balancer"},
+		}
+		if err := policy.Validate(); err != nil {
+			t.Fatalf("Expected no error before %v, got %v", c.name, err)
+		}
+
+		c.mutate(policy)
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Expected an error for %v, got %v", c.name, err)
+		}
+	}
+}
+
+func TestPolicyCheckEnforceable(t *testing.T) {
+	for _, policy := range []*Policy{
+		{ID: "block", CIDR: "10.0.0.0/24"},
+		{ID: "host", CIDR: "10.0.0.1"},
+		{ID: "monitor", CIDR: "10.0.0.1", Action: ACTION_MONITOR},
+		{ID: "office", CIDR: "10.1.0.0/16", Action: ACTION_PASS},
+		{ID: "web", CIDR: "0.0.0.0/0", Action: ACTION_PASS, Ports: []PortRange{{Start: 80}, {Start: 8000, End: 8080}}},
+	} {
+		policy.Default()
+		if err := policy.CheckEnforceable(); err != nil {
+			t.Errorf("Expected policy %v enforceable, got %v", policy.ID, err)
+		}
+	}
+
+	for _, policy := range []*Policy{
+		{ID: "egress", CIDR: "10.0.0.1", Direction: DIRECTION_EGRESS},
+		{ID: "udp", CIDR: "10.0.0.1", Protocol: PROTOCOL_UDP},
+		{ID: "port", CIDR: "10.0.0.1", Ports: []PortRange{{Start: 22}}},
+		{ID: "wide", CIDR: "10.0.0.0/16"},
+		{ID: "priority", CIDR: "10.0.0.1", Priority: 10},
+		{ID: "tcp-source", CIDR: "10.0.0.1", Action: ACTION_PASS, Protocol: PROTOCOL_TCP},
+		{ID: "source-port", CIDR: "10.0.0.1", Action: ACTION_PASS, Ports: []PortRange{{Start: 22}}},
+		{ID: "many-ports", CIDR: "0.0.0.0/0", Action: ACTION_PASS, Ports: []PortRange{{Start: 1000, End: 2000}}},
+	} {
+		policy.Default()
+		if err := policy.Validate(); err != nil {
+			t.Fatalf("Expected policy %v valid, got %v", policy.ID, err)
+		}
+		if err := policy.CheckEnforceable(); err == nil {
+			t.Errorf("Expected policy %v not enforceable", policy.ID)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"fmt"
+	"time"
+)
+
+// SCHEDULE_TIME_LAYOUT is the layout of the times of day in Schedule.
+const SCHEDULE_TIME_LAYOUT = "15:04"
+
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Schedule restricts a Policy to a window of the day on some days of the week, e.g. on
+// weekdays from 09:00 to 18:00. A window ending before it starts spans midnight and belongs
+// to the day it starts on. A nil Schedule is always in its window.
+type Schedule struct {
+	Days     []string `json:"days,omitempty"`     // "mon" to "sun", empty for every day
+	Start    string   `json:"start"`              // time of day the window starts at, e.g. "09:00"
+	End      string   `json:"end"`                // time of day the window ends at, e.g. "18:00"
+	Location string   `json:"location,omitempty"` // IANA time zone of the times, e.g. "Asia/Shanghai", UTC if empty
+}
+
+// Validate returns the reasons why s is malformed.
+func (s *Schedule) Validate() []string {
+	if s == nil {
+		return nil
+	}
+
+	var errs []string
+	for _, day := range s.Days {
+		if _, exists := scheduleDays[day]; !exists {
+			errs = append(errs, fmt.Sprintf("schedule: day %q should be one of mon, tue, wed, thu, fri, sat or sun", day))
+		}
+	}
+	if _, _, _, err := s.window(); err != nil {
+		errs = append(errs, "schedule: "+err.Error())
+	}
+	return errs
+}
+
+// Contains reports whether t is in a window of s.
+func (s *Schedule) Contains(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	start, end, loc, err := s.window()
+	if err != nil {
+		return false
+	}
+
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	switch {
+	case start < end:
+		return minute >= start && minute < end && s.onDay(t.Weekday())
+	case minute >= start:
+		return s.onDay(t.Weekday())
+	default:
+		// in the part after midnight of the window started the day before
+		return minute < end && s.onDay((t.Weekday()+6)%7)
+	}
+}
+
+// Next returns the first time after t a window of s may start or end at, zero if s is nil.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	start, end, loc, err := s.window()
+	if err != nil {
+		return time.Time{}
+	}
+
+	var next time.Time
+	t = t.In(loc)
+	year, month, day := t.Date()
+	for d := 0; d <= 1; d++ {
+		for _, minute := range []int{start, end} {
+			boundary := time.Date(year, month, day+d, minute/60, minute%60, 0, 0, loc)
+			if boundary.After(t) && (next.IsZero() || boundary.Before(next)) {
+				next = boundary
+			}
+		}
+	}
+	return next
+}
+
+// window parses the minutes of the day the window of s starts and ends at, and its time zone.
+func (s *Schedule) window() (start int, end int, loc *time.Location, err error) {
+	for _, field := range []struct {
+		value   string
+		minutes *int
+	}{{s.Start, &start}, {s.End, &end}} {
+		t, err := time.Parse(SCHEDULE_TIME_LAYOUT, field.value)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("time %q should be like 09:00", field.value)
+		}
+		*field.minutes = t.Hour()*60 + t.Minute()
+	}
+	if start == end {
+		return 0, 0, nil, fmt.Errorf("start and end should differ, got %v", s.Start)
+	}
+
+	if loc, err = time.LoadLocation(s.Location); err != nil {
+		return 0, 0, nil, fmt.Errorf("location %q is unknown", s.Location)
+	}
+	return start, end, loc, nil
+}
+
+func (s *Schedule) onDay(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, name := range s.Days {
+		if scheduleDays[name] == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package v1
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleContains(t *testing.T) {
+	weekdays := &Schedule{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "18:00"}
+	overnight := &Schedule{Days: []string{"fri"}, Start: "22:00", End: "06:00"}
+	// 2022-08-19 is a Friday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2022, 8, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		schedule *Schedule
+		now      time.Time
+		expected bool
+	}{
+		{nil, at(19, 3, 0), true},
+		{weekdays, at(19, 9, 0), true},
+		{weekdays, at(19, 18, 0), false},
+		{weekdays, at(20, 12, 0), false},
+		{overnight, at(19, 23, 0), true},
+		{overnight, at(20, 5, 59), true},
+		{overnight, at(20, 23, 0), false},
+	}
+	for i, c := range cases {
+		if got := c.schedule.Contains(c.now); got != c.expected {
+			t.Errorf("case #%v: Expected %v at %v, got %v", i, c.expected, c.now, got)
+		}
+	}
+
+	if next := weekdays.Next(at(19, 12, 30)); !next.Equal(at(19, 18, 0)) {
+		t.Fatalf("Expected the window to end at 18:00, got %v", next)
+	}
+	if next := weekdays.Next(at(19, 18, 0)); !next.Equal(at(20, 9, 0)) {
+		t.Fatalf("Expected the next boundary at 09:00 the next day, got %v", next)
+	}
+}
+
+func TestActivePolicies(t *testing.T) {
+	now := time.Date(2022, 8, 19, 12, 0, 0, 0, time.UTC)
+	expiry := now.Add(30 * time.Minute)
+	expiring := &Policy{ID: "ttl", Expiry: &expiry}
+	scheduled := &Policy{ID: "night", Schedule: &Schedule{Start: "22:00", End: "06:00"}}
+
+	active, next := ActivePolicies([]*Policy{expiring, scheduled}, now)
+	if len(active) != 1 || active[0] != expiring || !next.Equal(expiry) {
+		t.Fatalf("Expected policy ttl active until %v, got %v until %v", expiry, active, next)
+	}
+	if active, next = ActivePolicies([]*Policy{expiring, scheduled}, expiry); len(active) != 0 || next.Hour() != 22 {
+		t.Fatalf("Expected no policy active until 22:00, got %v until %v", active, next)
+	}
+}
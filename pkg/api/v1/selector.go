@@ -0,0 +1,125 @@
+package v1
+
+import (
+	"fmt"
+	"path"
+)
+
+// NodeInfo is what a node advertises about itself in the registry, see SpecConfig in
+// service/config.go.
+type NodeInfo struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Roles  []string          `json:"roles,omitempty"` // ingress and egress entries of SpecConfig
+	Labels map[string]string `json:"labels,omitempty"`
+	// filter modes of the interfaces by their names, the interfaces missing are in
+	// FILTER_MODE_BLOCKLIST
+	FilterModes map[string]string `json:"filterModes,omitempty"`
+}
+
+// FilterMode returns FILTER_MODE_ALLOWLIST if any interface of node is in allowlist mode,
+// FILTER_MODE_BLOCKLIST otherwise.
+func (node *NodeInfo) FilterMode() string {
+	for _, mode := range node.FilterModes {
+		if mode == FILTER_MODE_ALLOWLIST {
+			return FILTER_MODE_ALLOWLIST
+		}
+	}
+	return FILTER_MODE_BLOCKLIST
+}
+
+// NodeSelector selects the nodes a Policy is enforced on. A node is selected when it
+// satisfies every non-empty field, a nil or empty NodeSelector selects every node.
+type NodeSelector struct {
+	NodeIDs []string          `json:"nodeIds,omitempty"` // any of the node ids
+	Name    string            `json:"name,omitempty"`    // shell pattern of the node name, e.g. "lb-*"
+	Roles   []string          `json:"roles,omitempty"`   // shell patterns, any of them matches any role of the node
+	Labels  map[string]string `json:"labels,omitempty"`  // all of the labels
+	// FilterMode of the node, e.g. "allowlist" selects the nodes with any interface in
+	// allowlist mode
+	FilterMode string `json:"filterMode,omitempty"`
+}
+
+// Empty reports whether s selects every node.
+func (s *NodeSelector) Empty() bool {
+	return s == nil || (len(s.NodeIDs) == 0 && s.Name == "" && len(s.Roles) == 0 && len(s.Labels) == 0 && s.FilterMode == "")
+}
+
+// Validate returns the reasons why s is malformed.
+func (s *NodeSelector) Validate() []string {
+	if s == nil {
+		return nil
+	}
+
+	var errs []string
+	for _, id := range s.NodeIDs {
+		if id == "" {
+			errs = append(errs, "nodeSelector: node id should not be empty")
+		}
+	}
+	if s.FilterMode != "" && s.FilterMode != FILTER_MODE_BLOCKLIST && s.FilterMode != FILTER_MODE_ALLOWLIST {
+		errs = append(errs, fmt.Sprintf("nodeSelector: filter mode %q should be blocklist or allowlist", s.FilterMode))
+	}
+	for _, pattern := range append([]string{s.Name}, s.Roles...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("nodeSelector: pattern %q is malformed", pattern))
+		}
+	}
+	for key, value := range s.Labels {
+		if len(key) > 2*policyMaxNameLen || !labelKeyRegexp.MatchString(key) {
+			errs = append(errs, fmt.Sprintf("nodeSelector: label key %q is malformed", key))
+		}
+		if len(value) > policyMaxNameLen || !labelValueRegexp.MatchString(value) {
+			errs = append(errs, fmt.Sprintf("nodeSelector: label value %q of %q is malformed", value, key))
+		}
+	}
+	return errs
+}
+
+// Matches reports whether s selects node.
+func (s *NodeSelector) Matches(node *NodeInfo) bool {
+	if s.Empty() {
+		return true
+	}
+
+	if len(s.NodeIDs) > 0 && !containsString(s.NodeIDs, node.ID) {
+		return false
+	}
+	if s.Name != "" {
+		if matched, _ := path.Match(s.Name, node.Name); !matched {
+			return false
+		}
+	}
+	if len(s.Roles) > 0 && !matchesAnyRole(s.Roles, node.Roles) {
+		return false
+	}
+	if s.FilterMode != "" && s.FilterMode != node.FilterMode() {
+		return false
+	}
+	for key, value := range s.Labels {
+		if v, exists := node.Labels[key]; !exists || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAnyRole(patterns []string, roles []string) bool {
+	for _, pattern := range patterns {
+		for _, role := range roles {
+			if matched, _ := path.Match(pattern, role); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
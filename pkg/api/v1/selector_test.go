@@ -0,0 +1,35 @@
+package v1
+
+import "testing"
+
+func TestNodeSelectorMatches(t *testing.T) {
+	node := &NodeInfo{
+		ID:     "3b1f",
+		Name:   "lb-1",
+		Roles:  []string{"nginx:load-balancer"},
+		Labels: map[string]string{"zone": "a", "tier": "edge"},
+	}
+
+	cases := []struct {
+		selector *NodeSelector
+		expected bool
+	}{
+		{nil, true},
+		{&NodeSelector{}, true},
+		{&NodeSelector{NodeIDs: []string{"0000", "3b1f"}}, true},
+		{&NodeSelector{NodeIDs: []string{"0000"}}, false},
+		{&NodeSelector{Name: "lb-*"}, true},
+		{&NodeSelector{Name: "db-*"}, false},
+		{&NodeSelector{Roles: []string{"*:load-balancer"}}, true},
+		{&NodeSelector{Roles: []string{"*:database"}}, false},
+		{&NodeSelector{Labels: map[string]string{"zone": "a"}}, true},
+		{&NodeSelector{Labels: map[string]string{"zone": "a", "tier": "core"}}, false},
+		{&NodeSelector{Name: "lb-*", Labels: map[string]string{"zone": "b"}}, false},
+	}
+
+	for i, c := range cases {
+		if matched := c.selector.Matches(node); matched != c.expected {
+			t.Errorf("Expected case #%v %+v matched=%v, got %v", i, c.selector, c.expected, matched)
+		}
+	}
+}
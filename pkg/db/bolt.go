@@ -0,0 +1,42 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltDBs holds the bolt files opened by the process by their absolute paths. A file is
+// locked by the process opening it, so the stores kept in the same file share the *bolt.DB.
+var (
+	boltDBs = make(map[string]*bolt.DB)
+	boltMu  sync.Mutex
+)
+
+// NewBoltDB opens the bolt file at path, which is created with its directory if it does not
+// exist. It fails after timeout if the file is locked by another process.
+func NewBoltDB(path string, timeout time.Duration) (*bolt.DB, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	boltMu.Lock()
+	defer boltMu.Unlock()
+	if db, exists := boltDBs[path]; exists {
+		return db, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	boltDBs[path] = db
+	return db, nil
+}
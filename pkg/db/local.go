@@ -39,7 +39,7 @@ func (ls *LocalStorage) Append(elem ...string) error {
 
 	for _, item := range elem {
 		if _, exists := ls.record[item]; exists {
-			return nil
+			continue
 		}
 		ls.storage = append(ls.storage, item)
 		ls.record[item] = empty
@@ -52,7 +52,7 @@ func (ls *LocalStorage) Delete(elem string) error {
 	ls.mu.Lock()
 	defer ls.mu.Unlock()
 
-	var match int
+	match := -1
 	for index, cur := range ls.storage {
 		if cur == elem {
 			match = index
@@ -60,7 +60,7 @@ func (ls *LocalStorage) Delete(elem string) error {
 		}
 	}
 
-	if match == len(ls.storage)-1 && ls.storage[match] != elem {
+	if match < 0 {
 		return errMaps[ErrNotElemFound]
 	}
 
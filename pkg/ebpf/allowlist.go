@@ -0,0 +1,168 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Maps of XDP_STAGE_ALLOWLIST defined in bpf/headers/dispatcher.h
+const (
+	XDPFilterModeMapName        = "xdp_filter_mode"
+	XDPAllowSourcesMapName      = "xdp_allow_sources"
+	XDPAllowPortsMapName        = "xdp_allow_ports"
+	XDPManagementSourcesMapName = "xdp_mgmt_sources"
+	XDPManagementPortsMapName   = "xdp_mgmt_ports"
+)
+
+// Filter modes of the interfaces, they should be synchronized to XDP_FILTER_* in
+// bpf/headers/dispatcher.h
+const (
+	// XDP_FILTER_BLOCKLIST passes everything but what the blocklist drops
+	XDP_FILTER_BLOCKLIST uint32 = iota
+	// XDP_FILTER_ALLOWLIST drops everything but the allowed sources and ports
+	XDP_FILTER_ALLOWLIST
+)
+
+// LPMKey is the key of the IPv4 LPM_TRIE maps. The layout should be synchronized to
+// struct lpm_v4_key in bpf/headers/dispatcher.h
+type LPMKey struct {
+	PrefixLen uint32
+	Addr      [4]byte // network byte order
+}
+
+// NewLPMKey returns the LPMKey of the IPv4 network ipNet.
+func NewLPMKey(ipNet *net.IPNet) (LPMKey, error) {
+	ip := ipNet.IP.To4()
+	ones, bits := ipNet.Mask.Size()
+	if ip == nil || bits != 32 {
+		return LPMKey{}, fmt.Errorf("%v is not an IPv4 network", ipNet)
+	}
+
+	key := LPMKey{PrefixLen: uint32(ones)}
+	copy(key.Addr[:], ip.Mask(ipNet.Mask))
+	return key, nil
+}
+
+// IPNet returns the network of key.
+func (key LPMKey) IPNet() *net.IPNet {
+	return &net.IPNet{
+		IP:   net.IP(key.Addr[:]).Mask(net.CIDRMask(int(key.PrefixLen), 32)),
+		Mask: net.CIDRMask(int(key.PrefixLen), 32),
+	}
+}
+
+func (key LPMKey) String() string {
+	return key.IPNet().String()
+}
+
+// PortKey is the key of the allowed local ports. The layout should be synchronized to
+// struct port_key in bpf/headers/dispatcher.h
+type PortKey struct {
+	Port     uint16
+	Protocol uint8 // IPPROTO_TCP or IPPROTO_UDP
+	Pad      uint8
+}
+
+func (key PortKey) String() string {
+	switch key.Protocol {
+	case IPPROTO_TCP:
+		return fmt.Sprintf("tcp/%v", key.Port)
+	case IPPROTO_UDP:
+		return fmt.Sprintf("udp/%v", key.Port)
+	}
+	return fmt.Sprintf("%v/%v", key.Protocol, key.Port)
+}
+
+// IP protocols of PortKey
+const (
+	IPPROTO_TCP = 6
+	IPPROTO_UDP = 17
+)
+
+// SetFilterModes replaces the filter modes of the interfaces with modes by their ifindex,
+// the interfaces missing from modes go back to XDP_FILTER_BLOCKLIST.
+func (manager *BPFManager) SetFilterModes(modes map[uint32]uint32) error {
+	m, err := GetMap[uint32, uint32](manager, XDPFilterModeMapName)
+	if err != nil {
+		return err
+	}
+
+	for ifindex, mode := range modes {
+		if err := m.Put(ifindex, mode); err != nil {
+			return fmt.Errorf("failed to set the filter mode of interface %v: %w", ifindex, err)
+		}
+	}
+
+	// the map may be pinned by the previous process with interfaces no longer configured
+	ifindexes, err := m.Keys()
+	if err != nil {
+		return err
+	}
+	for _, ifindex := range ifindexes {
+		if _, exists := modes[ifindex]; exists {
+			continue
+		}
+		if err := m.Delete(ifindex); err != nil && !errors.Is(err, ErrKeyNotExist) {
+			return fmt.Errorf("failed to reset the filter mode of interface %v: %w", ifindex, err)
+		}
+	}
+	return nil
+}
+
+// SetManagementSet replaces the management set, which XDP_STAGE_ALLOWLIST always passes
+// whatever the pass policies are.
+func (manager *BPFManager) SetManagementSet(sources []LPMKey, ports []PortKey) error {
+	sourceMap, err := GetMap[LPMKey, uint32](manager, XDPManagementSourcesMapName)
+	if err != nil {
+		return err
+	}
+	portMap, err := GetMap[PortKey, uint32](manager, XDPManagementPortsMapName)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceKeys(sourceMap, sources); err != nil {
+		return err
+	}
+	return replaceKeys(portMap, ports)
+}
+
+// replaceKeys puts keys into m before deleting the others, so the keys kept are never
+// missing from m. The installed keys are compared as a whole because a lookup of LPM_TRIE
+// maps matches the longest prefix instead of the exact key.
+func replaceKeys[K comparable](m *Map[K, uint32], keys []K) error {
+	installed, err := m.Keys()
+	if err != nil {
+		return err
+	}
+	exists := make(map[K]bool, len(installed))
+	for _, key := range installed {
+		exists[key] = true
+	}
+
+	keep := make(map[K]bool, len(keys))
+	for _, key := range keys {
+		keep[key] = true
+		if exists[key] {
+			continue
+		}
+		if err := m.Put(key, 0); err != nil {
+			return fmt.Errorf("failed to put %v into %v: %w", key, m.Name(), err)
+		}
+	}
+
+	for _, key := range installed {
+		if keep[key] {
+			continue
+		}
+		if err := m.Delete(key); err != nil && !errors.Is(err, ErrKeyNotExist) {
+			return fmt.Errorf("failed to delete %v from %v: %w", key, m.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	btfMagic       = 0xeb9f
+	btfSectionName = ".BTF"
+)
+
+// kinds of BTF types, see include/uapi/linux/btf.h
+const (
+	btfKindUnknown = iota
+	btfKindInt
+	btfKindPtr
+	btfKindArray
+	btfKindStruct
+	btfKindUnion
+	btfKindEnum
+	btfKindFwd
+	btfKindTypedef
+	btfKindVolatile
+	btfKindConst
+	btfKindRestrict
+	btfKindFunc
+	btfKindFuncProto
+	btfKindVar
+	btfKindDatasec
+	btfKindFloat
+	btfKindDeclTag
+	btfKindTypeTag
+	btfKindEnum64
+)
+
+// ErrBTFNotFound is returned when the object does not carry the .BTF section.
+var ErrBTFNotFound = errors.New("BTF section was not found in the object")
+
+// btfHeader is struct btf_header
+type btfHeader struct {
+	Magic   uint16
+	Version uint8
+	Flags   uint8
+	HdrLen  uint32
+	TypeOff uint32
+	TypeLen uint32
+	StrOff  uint32
+	StrLen  uint32
+}
+
+// btfType is the common part of every type, struct btf_type
+type btfType struct {
+	NameOff uint32
+	Info    uint32
+	// SizeOrType is the size of INT, ENUM, STRUCT, UNION and DATASEC, or the referred
+	// type id of PTR, TYPEDEF, VOLATILE, CONST, RESTRICT, FUNC, FUNC_PROTO and VAR
+	SizeOrType uint32
+}
+
+func (t *btfType) kind() int   { return int(t.Info>>24) & 0x1f }
+func (t *btfType) vlen() int   { return int(t.Info & 0xffff) }
+func (t *btfType) kflag() bool { return t.Info>>31 == 1 }
+
+// BTFMember is a member of the BTF struct or union.
+type BTFMember struct {
+	Name      string
+	Offset    uint32 // offset in bytes from the beginning of the struct
+	BitOffset uint32 // offset in bits, it is not a multiple of 8 for bitfields
+	BitSize   uint32 // size of the bitfield, 0 when the member is not a bitfield
+	Type      *BTFType
+}
+
+// BTFType is the resolved BTF type of the object, only the information needed to lay
+// out event records is kept.
+type BTFType struct {
+	ID      uint32
+	Name    string
+	Kind    int
+	Size    uint32      // size in bytes after resolving typedefs and modifiers
+	Elem    *BTFType    // element type of arrays, referred type of typedefs and modifiers
+	NElems  uint32      // number of elements of arrays
+	Members []BTFMember // members of structs and unions
+}
+
+// IsStruct reports whether t is a struct or union after resolving typedefs and modifiers.
+func (t *BTFType) IsStruct() bool {
+	t = t.resolve()
+	return t.Kind == btfKindStruct || t.Kind == btfKindUnion
+}
+
+// IsArray reports whether t is an array after resolving typedefs and modifiers.
+func (t *BTFType) IsArray() bool {
+	return t.resolve().Kind == btfKindArray
+}
+
+// resolve skips typedefs and modifiers.
+func (t *BTFType) resolve() *BTFType {
+	for t != nil && t.Elem != nil && isBTFModifier(t.Kind) {
+		t = t.Elem
+	}
+	return t
+}
+
+func isBTFModifier(kind int) bool {
+	switch kind {
+	case btfKindTypedef, btfKindVolatile, btfKindConst, btfKindRestrict, btfKindTypeTag:
+		return true
+	}
+	return false
+}
+
+// BTFSpec holds the types described by the .BTF section of an eBPF object.
+type BTFSpec struct {
+	types   []*BTFType // indexed by type id, id 0 is void
+	structs map[string]*BTFType
+}
+
+// LoadBTFSpec parses the .BTF section of the eBPF object at objPath.
+func LoadBTFSpec(objPath string) (*BTFSpec, error) {
+	f, err := elf.Open(objPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return loadBTFSpecFromELF(f)
+}
+
+// LoadBTFSpecFromReader parses the .BTF section of the eBPF object read from r.
+func LoadBTFSpecFromReader(r io.ReaderAt) (*BTFSpec, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return loadBTFSpecFromELF(f)
+}
+
+func loadBTFSpecFromELF(f *elf.File) (*BTFSpec, error) {
+	section := f.Section(btfSectionName)
+	if section == nil {
+		return nil, ErrBTFNotFound
+	}
+
+	raw, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v section: %w", btfSectionName, err)
+	}
+
+	return parseBTF(raw, f.ByteOrder)
+}
+
+// BTF returns the BTF carried by the object of the BPFModule, which lays out the records
+// decoded by EventDecoder, see WithBTFStruct.
+func (manager *BPFManager) BTF() (*BTFSpec, error) {
+	if manager.btfSpec != nil {
+		return manager.btfSpec, nil
+	}
+
+	var (
+		spec *BTFSpec
+		err  error
+	)
+	switch {
+	case manager.bpfObjPath != "":
+		spec, err = LoadBTFSpec(manager.bpfObjPath)
+	case manager.bpfObjBuff != nil:
+		spec, err = LoadBTFSpecFromReader(bytes.NewReader(manager.bpfObjBuff))
+	default:
+		return nil, ErrBTFNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manager.btfSpec = spec
+	return spec, nil
+}
+
+// FindStruct returns the struct or union named name.
+func (spec *BTFSpec) FindStruct(name string) (*BTFType, error) {
+	t, ok := spec.structs[name]
+	if !ok {
+		return nil, fmt.Errorf("struct %v was not found in BTF", name)
+	}
+	return t, nil
+}
+
+type rawBTFType struct {
+	btfType
+	arrayElem uint32
+	members   []rawBTFMember
+}
+
+type rawBTFMember struct {
+	NameOff uint32
+	Type    uint32
+	Offset  uint32
+}
+
+func parseBTF(raw []byte, order binary.ByteOrder) (*BTFSpec, error) {
+	header := &btfHeader{}
+	if err := binary.Read(bytes.NewReader(raw), order, header); err != nil {
+		return nil, fmt.Errorf("failed to read BTF header: %w", err)
+	}
+	if header.Magic != btfMagic {
+		return nil, fmt.Errorf("invalid BTF magic %#x", header.Magic)
+	}
+
+	typeStart := uint64(header.HdrLen) + uint64(header.TypeOff)
+	strStart := uint64(header.HdrLen) + uint64(header.StrOff)
+	if typeStart+uint64(header.TypeLen) > uint64(len(raw)) || strStart+uint64(header.StrLen) > uint64(len(raw)) {
+		return nil, fmt.Errorf("BTF sections exceed the %v bytes of data", len(raw))
+	}
+	strs := raw[strStart : strStart+uint64(header.StrLen)]
+
+	rawTypes, err := readBTFTypes(raw[typeStart:typeStart+uint64(header.TypeLen)], order)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &BTFSpec{
+		types:   make([]*BTFType, len(rawTypes)+1),
+		structs: map[string]*BTFType{},
+	}
+	spec.types[0] = &BTFType{Name: "void"}
+	for i, rt := range rawTypes {
+		spec.types[i+1] = &BTFType{
+			ID:   uint32(i + 1),
+			Name: btfString(strs, rt.NameOff),
+			Kind: rt.kind(),
+		}
+	}
+
+	lookup := func(id uint32) (*BTFType, error) {
+		if int(id) >= len(spec.types) {
+			return nil, fmt.Errorf("invalid BTF type id %v", id)
+		}
+		return spec.types[id], nil
+	}
+
+	for i, rt := range rawTypes {
+		t := spec.types[i+1]
+		switch t.Kind {
+		case btfKindInt, btfKindEnum, btfKindEnum64, btfKindFloat, btfKindDatasec:
+			t.Size = rt.SizeOrType
+		case btfKindPtr:
+			t.Size = 8
+		case btfKindArray:
+			if t.Elem, err = lookup(rt.arrayElem); err != nil {
+				return nil, err
+			}
+			t.NElems = rt.SizeOrType
+		case btfKindStruct, btfKindUnion:
+			t.Size = rt.SizeOrType
+			for _, rm := range rt.members {
+				member := BTFMember{Name: btfString(strs, rm.NameOff), BitOffset: rm.Offset}
+				if rt.kflag() {
+					member.BitOffset = rm.Offset & 0xffffff
+					member.BitSize = rm.Offset >> 24
+				}
+				member.Offset = member.BitOffset / 8
+				if member.Type, err = lookup(rm.Type); err != nil {
+					return nil, err
+				}
+				t.Members = append(t.Members, member)
+			}
+			if t.Name != "" {
+				spec.structs[t.Name] = t
+			}
+		case btfKindTypedef, btfKindVolatile, btfKindConst, btfKindRestrict, btfKindTypeTag:
+			if t.Elem, err = lookup(rt.SizeOrType); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// sizes of arrays and typedefs depend on the types they refer to
+	for _, t := range spec.types {
+		if _, err := btfSizeof(t, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return spec, nil
+}
+
+func btfSizeof(t *BTFType, depth int) (uint32, error) {
+	if depth > 32 {
+		return 0, fmt.Errorf("BTF type %v refers too deeply", t.ID)
+	}
+
+	switch {
+	case t.Kind == btfKindArray:
+		elemSize, err := btfSizeof(t.Elem, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		t.Size = elemSize * t.NElems
+	case isBTFModifier(t.Kind) && t.Elem != nil:
+		size, err := btfSizeof(t.Elem, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		t.Size = size
+	}
+	return t.Size, nil
+}
+
+func readBTFTypes(raw []byte, order binary.ByteOrder) ([]*rawBTFType, error) {
+	var types []*rawBTFType
+	r := bytes.NewReader(raw)
+
+	for r.Len() > 0 {
+		rt := &rawBTFType{}
+		if err := binary.Read(r, order, &rt.btfType); err != nil {
+			return nil, fmt.Errorf("failed to read BTF type %v: %w", len(types)+1, err)
+		}
+
+		var extra int64
+		switch rt.kind() {
+		case btfKindInt, btfKindVar, btfKindDeclTag:
+			extra = 4
+		case btfKindArray:
+			var array struct{ Type, IndexType, NElems uint32 }
+			if err := binary.Read(r, order, &array); err != nil {
+				return nil, fmt.Errorf("failed to read BTF array %v: %w", len(types)+1, err)
+			}
+			rt.arrayElem, rt.SizeOrType = array.Type, array.NElems
+		case btfKindStruct, btfKindUnion:
+			rt.members = make([]rawBTFMember, rt.vlen())
+			if err := binary.Read(r, order, rt.members); err != nil {
+				return nil, fmt.Errorf("failed to read BTF members of %v: %w", len(types)+1, err)
+			}
+		case btfKindEnum, btfKindFuncProto:
+			extra = int64(rt.vlen()) * 8
+		case btfKindDatasec, btfKindEnum64:
+			extra = int64(rt.vlen()) * 12
+		case btfKindPtr, btfKindFwd, btfKindTypedef, btfKindVolatile, btfKindConst,
+			btfKindRestrict, btfKindFunc, btfKindFloat, btfKindTypeTag:
+		default:
+			return nil, fmt.Errorf("unknown BTF kind %v of type %v", rt.kind(), len(types)+1)
+		}
+
+		if extra > int64(r.Len()) {
+			return nil, fmt.Errorf("BTF type %v exceeds the type section", len(types)+1)
+		}
+		if _, err := r.Seek(extra, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		types = append(types, rt)
+	}
+
+	return types, nil
+}
+
+func btfString(strs []byte, off uint32) string {
+	if int(off) >= len(strs) {
+		return ""
+	}
+	return cString(strs[off:])
+}
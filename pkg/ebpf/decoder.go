@@ -0,0 +1,538 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EventDecoder decodes the records pushed by eBPF programs through perf or ring buffers
+// into T, which should be a struct describing the C struct of the record.
+//
+// The layout of T follows the rules of C: every field is aligned to its natural alignment
+// and structs are padded to the alignment of their largest member, so T can be written
+// as a straightforward translation of the C struct. When the struct is found in the BTF
+// of the object (see WithBTFStruct), the offsets are taken from BTF instead, and the
+// fields of T are matched to the members by name, so T may only declare the members it
+// is interested in.
+//
+// The fields are described with the struct tag `ebpf:"name,opts..."`, where name is the
+// member name in BTF (defaults to the field name in snake case) and opts are:
+//
+//	union       the field is a struct whose fields share the same offset
+//	tail        the field is a slice holding the variable-length tail of the record, it
+//	            must be the last field
+//	len=Field   the tail holds as many bytes as the integer Field decoded before
+//
+// A string field is a NUL-terminated char array and needs the `size:"N"` tag. Anonymous
+// struct fields are flattened into the enclosing struct. Fields named _ occupy space but
+// are not decoded, which makes explicit padding possible. Platform dependent types like
+// int and uint are rejected, use sized integers instead.
+type EventDecoder[T any] struct {
+	order  binary.ByteOrder
+	layout *structLayout
+	minLen int
+}
+
+// DecoderOption defines optional parameters for creating the EventDecoder.
+type DecoderOption func(*decoderConfig) error
+
+type decoderConfig struct {
+	order binary.ByteOrder
+	btf   *BTFType
+}
+
+// WithByteOrder sets the byte order of the records, it defaults to the host byte order.
+func WithByteOrder(order binary.ByteOrder) DecoderOption {
+	return func(c *decoderConfig) error {
+		c.order = order
+		return nil
+	}
+}
+
+// WithBTFStruct lays out the decoded struct according to the struct named name in spec.
+func WithBTFStruct(spec *BTFSpec, name string) DecoderOption {
+	return func(c *decoderConfig) error {
+		if spec == nil {
+			return ErrBTFNotFound
+		}
+		t, err := spec.FindStruct(name)
+		if err != nil {
+			return err
+		}
+		c.btf = t
+		return nil
+	}
+}
+
+// NewEventDecoder creates the EventDecoder of T. It will return an error if T can not be
+// mapped to a C struct, or its fields mismatch the BTF struct given by WithBTFStruct.
+func NewEventDecoder[T any](opts ...DecoderOption) (*EventDecoder[T], error) {
+	config := &decoderConfig{order: hostEndian}
+	for _, opt := range opts {
+		if err := opt(config); err != nil {
+			return nil, err
+		}
+	}
+
+	var event T
+	typ := reflect.TypeOf(event)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("event type %v is not a struct", typ)
+	}
+
+	layout, err := newStructLayout(typ, config.btf, false, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lay out %v: %w", typ, err)
+	}
+
+	decoder := &EventDecoder[T]{order: config.order, layout: layout, minLen: layout.size}
+	if layout.tail != nil {
+		decoder.minLen = layout.tail.offset
+	}
+	return decoder, nil
+}
+
+// mustNewEventDecoder is like NewEventDecoder but panics on error, it is used to
+// initialize the decoders of the records defined in this package.
+func mustNewEventDecoder[T any](opts ...DecoderOption) *EventDecoder[T] {
+	decoder, err := NewEventDecoder[T](opts...)
+	if err != nil {
+		panic(err)
+	}
+	return decoder
+}
+
+// Size returns the minimum length of records, which is the size of the struct without
+// its variable-length tail.
+func (decoder *EventDecoder[T]) Size() int {
+	return decoder.minLen
+}
+
+// Decode decodes raw into a new T.
+func (decoder *EventDecoder[T]) Decode(raw []byte) (*T, error) {
+	event := new(T)
+	if err := decoder.DecodeInto(raw, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// DecodeInto decodes raw into event. Records longer than the struct are accepted since
+// perf buffers pad the records to 8 bytes.
+func (decoder *EventDecoder[T]) DecodeInto(raw []byte, event *T) error {
+	if len(raw) < decoder.minLen {
+		return fmt.Errorf("record too short: expected at least %v bytes, got %v", decoder.minLen, len(raw))
+	}
+
+	v := reflect.ValueOf(event).Elem()
+	if err := decoder.decodeStruct(decoder.layout, raw, v); err != nil {
+		return err
+	}
+
+	tail := decoder.layout.tail
+	if tail == nil {
+		return nil
+	}
+
+	n := len(raw) - tail.offset
+	if tail.lenIndex != nil {
+		length := v.FieldByIndex(tail.lenIndex)
+		var want uint64
+		if length.CanUint() {
+			want = length.Uint()
+		} else if length.Int() >= 0 {
+			want = uint64(length.Int())
+		}
+		if want > uint64(n) {
+			return fmt.Errorf("invalid %v length %v: only %v bytes left in the record of %v bytes",
+				tail.name, want, n, len(raw))
+		}
+		n = int(want)
+	}
+	if n%tail.elem.size != 0 {
+		return fmt.Errorf("%v bytes of %v is not a multiple of its element size %v", n, tail.name, tail.elem.size)
+	}
+
+	count := n / tail.elem.size
+	slice := reflect.MakeSlice(tail.typ, count, count)
+	data := raw[tail.offset : tail.offset+n]
+	if tail.elem.typ.Kind() == reflect.Uint8 {
+		reflect.Copy(slice, reflect.ValueOf(data))
+	} else {
+		for i := 0; i < count; i++ {
+			if err := decoder.decodeField(tail.elem, data[i*tail.elem.size:], slice.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	v.FieldByIndex(tail.index).Set(slice)
+
+	return nil
+}
+
+func (decoder *EventDecoder[T]) decodeStruct(layout *structLayout, raw []byte, v reflect.Value) error {
+	for _, field := range layout.fields {
+		if field.blank {
+			continue
+		}
+		if err := decoder.decodeField(field, raw[field.offset:], v.FieldByIndex(field.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (decoder *EventDecoder[T]) decodeField(field *fieldLayout, raw []byte, v reflect.Value) error {
+	raw = raw[:field.size]
+
+	switch field.kind {
+	case fieldScalar:
+		decodeScalar(decoder.order, raw, v)
+	case fieldCString:
+		v.SetString(cString(raw))
+	case fieldArray:
+		if field.elem.typ.Kind() == reflect.Uint8 {
+			reflect.Copy(v, reflect.ValueOf(raw))
+			return nil
+		}
+		for i := 0; i < field.nelems; i++ {
+			if err := decoder.decodeField(field.elem, raw[i*field.elem.size:], v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case fieldStruct:
+		return decoder.decodeStruct(field.nested, raw, v)
+	}
+	return nil
+}
+
+func decodeScalar(order binary.ByteOrder, raw []byte, v reflect.Value) {
+	var u uint64
+	switch len(raw) {
+	case 1:
+		u = uint64(raw[0])
+	case 2:
+		u = uint64(order.Uint16(raw))
+	case 4:
+		u = uint64(order.Uint32(raw))
+	case 8:
+		u = order.Uint64(raw)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(u != 0)
+	case reflect.Int8:
+		v.SetInt(int64(int8(u)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(u)))
+	case reflect.Int32:
+		v.SetInt(int64(int32(u)))
+	case reflect.Int64:
+		v.SetInt(int64(u))
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(u)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(u))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(u))
+	}
+}
+
+type fieldKind int
+
+const (
+	fieldScalar fieldKind = iota
+	fieldCString
+	fieldArray
+	fieldStruct
+	fieldTail
+)
+
+type fieldLayout struct {
+	name   string
+	index  []int // index of the field in the top-level struct, see reflect.Value.FieldByIndex
+	blank  bool
+	kind   fieldKind
+	typ    reflect.Type
+	offset int
+	size   int
+	align  int
+
+	elem     *fieldLayout  // element of arrays and tails
+	nelems   int           // number of elements of arrays
+	nested   *structLayout // layout of structs and unions
+	lenIndex []int         // index of the field holding the length of tail in bytes
+}
+
+type structLayout struct {
+	fields []*fieldLayout
+	tail   *fieldLayout
+	size   int
+	align  int
+}
+
+type fieldTag struct {
+	name    string
+	union   bool
+	tail    bool
+	lenName string
+}
+
+func parseFieldTag(field reflect.StructField) fieldTag {
+	tag := fieldTag{name: toSnakeCase(field.Name)}
+	opts := strings.Split(field.Tag.Get("ebpf"), ",")
+	if opts[0] != "" {
+		tag.name = opts[0]
+	}
+	for _, opt := range opts[1:] {
+		switch {
+		case opt == "union":
+			tag.union = true
+		case opt == "tail":
+			tag.tail = true
+		case strings.HasPrefix(opt, "len="):
+			tag.lenName = strings.TrimPrefix(opt, "len=")
+		}
+	}
+	return tag
+}
+
+// newStructLayout lays out the fields of typ, isUnion places all fields at offset 0 and
+// allowTail permits the variable-length tail as the last field.
+func newStructLayout(typ reflect.Type, btf *BTFType, isUnion bool, allowTail bool) (*structLayout, error) {
+	if btf != nil {
+		btf = btf.resolve()
+		if !btf.IsStruct() {
+			return nil, fmt.Errorf("BTF type %v is not a struct or union", btf.Name)
+		}
+	}
+
+	layout := &structLayout{align: 1}
+	if err := layout.addFields(typ, nil, btf, isUnion, allowTail, typ); err != nil {
+		return nil, err
+	}
+
+	if btf != nil {
+		layout.size = int(btf.Size)
+	} else {
+		layout.size = alignUp(layout.size, layout.align)
+	}
+	return layout, nil
+}
+
+func (layout *structLayout) addFields(typ reflect.Type, index []int, btf *BTFType, isUnion bool,
+	allowTail bool, top reflect.Type) error {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Tag.Get("ebpf") == "" {
+			if err := layout.addFields(sf.Type, fieldIndex, btf, isUnion, allowTail, top); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if layout.tail != nil {
+			return fmt.Errorf("field %v follows the tail %v", sf.Name, layout.tail.name)
+		}
+		if !sf.IsExported() && sf.Name != "_" {
+			return fmt.Errorf("field %v is unexported", sf.Name)
+		}
+
+		tag := parseFieldTag(sf)
+		var member *BTFMember
+		if btf != nil && sf.Name != "_" {
+			if member = findBTFMember(btf, tag.name); member == nil {
+				return fmt.Errorf("field %v: member %v was not found in BTF struct %v", sf.Name, tag.name, btf.Name)
+			}
+			if member.BitSize != 0 || member.BitOffset%8 != 0 {
+				return fmt.Errorf("field %v: bitfield member %v is not supported", sf.Name, tag.name)
+			}
+		}
+
+		var (
+			field *fieldLayout
+			err   error
+		)
+		if tag.tail {
+			if !allowTail {
+				return fmt.Errorf("field %v: tail is only allowed in the top-level struct", sf.Name)
+			}
+			field, err = newTailLayout(sf, tag, member, top)
+		} else {
+			var memberType *BTFType
+			if member != nil {
+				memberType = member.Type
+			}
+			field, err = newFieldLayout(sf.Name, sf.Type, sf.Tag, tag.union, memberType)
+		}
+		if err != nil {
+			return err
+		}
+		field.index = fieldIndex
+		field.blank = sf.Name == "_"
+
+		switch {
+		case member != nil:
+			field.offset = int(member.Offset)
+		case isUnion:
+			field.offset = 0
+		default:
+			field.offset = alignUp(layout.size, field.align)
+		}
+
+		if field.align > layout.align {
+			layout.align = field.align
+		}
+		if field.kind == fieldTail {
+			layout.tail = field
+			continue
+		}
+		if end := field.offset + field.size; end > layout.size {
+			layout.size = end
+		}
+		layout.fields = append(layout.fields, field)
+	}
+
+	return nil
+}
+
+func newTailLayout(sf reflect.StructField, tag fieldTag, member *BTFMember, top reflect.Type) (*fieldLayout, error) {
+	if sf.Type.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("field %v: tail should be a slice, got %v", sf.Name, sf.Type)
+	}
+
+	var elemBTF *BTFType
+	if member != nil {
+		if !member.Type.IsArray() {
+			return nil, fmt.Errorf("field %v: tail member %v is not an array in BTF", sf.Name, member.Name)
+		}
+		elemBTF = member.Type.resolve().Elem
+	}
+
+	elem, err := newFieldLayout(sf.Name+"[]", sf.Type.Elem(), "", false, elemBTF)
+	if err != nil {
+		return nil, err
+	}
+	if elem.size == 0 {
+		return nil, fmt.Errorf("field %v: tail element has no size", sf.Name)
+	}
+
+	field := &fieldLayout{name: sf.Name, kind: fieldTail, typ: sf.Type, elem: elem, align: elem.align}
+	if tag.lenName != "" {
+		lenField, ok := top.FieldByName(tag.lenName)
+		if !ok {
+			return nil, fmt.Errorf("field %v: length field %v was not found", sf.Name, tag.lenName)
+		}
+		switch lenField.Type.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, fmt.Errorf("field %v: length field %v is not an integer", sf.Name, tag.lenName)
+		}
+		field.lenIndex = lenField.Index
+	}
+	return field, nil
+}
+
+func newFieldLayout(name string, typ reflect.Type, structTag reflect.StructTag, isUnion bool, btf *BTFType) (*fieldLayout, error) {
+	field := &fieldLayout{name: name, typ: typ}
+
+	switch typ.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		field.kind = fieldScalar
+		field.size = int(typ.Size())
+		field.align = field.size
+	case reflect.String:
+		size, err := strconv.Atoi(structTag.Get("size"))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("field %v: string needs a positive size tag, got %q", name, structTag.Get("size"))
+		}
+		field.kind = fieldCString
+		field.size = size
+		field.align = 1
+	case reflect.Array:
+		var elemBTF *BTFType
+		if btf != nil {
+			if !btf.IsArray() {
+				return nil, fmt.Errorf("field %v: member is not an array in BTF", name)
+			}
+			resolved := btf.resolve()
+			if int(resolved.NElems) != typ.Len() {
+				return nil, fmt.Errorf("field %v: expected %v elements as BTF, got %v", name, resolved.NElems, typ.Len())
+			}
+			elemBTF = resolved.Elem
+		}
+		elem, err := newFieldLayout(name+"[]", typ.Elem(), "", false, elemBTF)
+		if err != nil {
+			return nil, err
+		}
+		field.kind = fieldArray
+		field.elem = elem
+		field.nelems = typ.Len()
+		field.size = elem.size * typ.Len()
+		field.align = elem.align
+		// the size of elements has been checked against BTF
+		return field, nil
+	case reflect.Struct:
+		nested, err := newStructLayout(typ, btf, isUnion, false)
+		if err != nil {
+			return nil, fmt.Errorf("field %v: %w", name, err)
+		}
+		field.kind = fieldStruct
+		field.nested = nested
+		field.size = nested.size
+		field.align = nested.align
+		return field, nil
+	default:
+		return nil, fmt.Errorf("field %v: type %v is not supported, use sized integers, arrays or structs", name, typ)
+	}
+
+	if btf != nil && int(btf.resolve().Size) != field.size {
+		return nil, fmt.Errorf("field %v: size mismatch, BTF member has %v bytes but %v has %v", name, btf.resolve().Size, typ, field.size)
+	}
+	return field, nil
+}
+
+func findBTFMember(btf *BTFType, name string) *BTFMember {
+	for i := range btf.Members {
+		if btf.Members[i].Name == name {
+			return &btf.Members[i]
+		}
+	}
+	return nil
+}
+
+func alignUp(n, align int) int {
+	if align <= 1 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
+
+// toSnakeCase converts ArgsSize to args_size, which is the name of the member in C.
+func toSnakeCase(name string) string {
+	var buf bytes.Buffer
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 && name[i-1] != '_' && !(name[i-1] >= 'A' && name[i-1] <= 'Z') {
+				buf.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
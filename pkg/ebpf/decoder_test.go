@@ -0,0 +1,211 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type testAddr struct {
+	Family uint16
+	Port   uint16
+}
+
+type testAddrUnion struct {
+	V4  uint32
+	Raw [16]byte
+}
+
+//	struct test_event {
+//	    __u8 kind;                               offset 0
+//	    __u64 timestamp;                         offset 8
+//	    char comm[8];                            offset 16
+//	    __u16 ports[2];                          offset 24
+//	    struct { __u16 family, port; } addr;     offset 28
+//	    union { __u32 v4; __u8 raw[16]; } ip;    offset 32
+//	    __u32 len;                               offset 48
+//	    char data[];                             offset 52
+//	};
+type testEvent struct {
+	Kind      uint8
+	Timestamp uint64
+	Comm      string `size:"8"`
+	Ports     [2]uint16
+	Addr      testAddr
+	IP        testAddrUnion `ebpf:"ip,union"`
+	Len       uint32
+	Data      []byte `ebpf:"data,tail,len=Len"`
+}
+
+func TestEventDecoderLayout(t *testing.T) {
+	raw := make([]byte, 60)
+	raw[0] = 7
+	hostEndian.PutUint64(raw[8:], 123456789)
+	copy(raw[16:], "curl\x00xyz")
+	hostEndian.PutUint16(raw[24:], 80)
+	hostEndian.PutUint16(raw[26:], 443)
+	hostEndian.PutUint16(raw[28:], 2)
+	hostEndian.PutUint16(raw[30:], 8080)
+	copy(raw[32:], []byte{10, 0, 0, 1})
+	hostEndian.PutUint32(raw[48:], 5)
+	copy(raw[52:], "hello\x00\x00\x00")
+
+	decoder, err := NewEventDecoder[testEvent]()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if decoder.Size() != 52 {
+		t.Fatalf("Expected size 52 without tail, got %v", decoder.Size())
+	}
+
+	event, err := decoder.Decode(raw)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := testEvent{
+		Kind:      7,
+		Timestamp: 123456789,
+		Comm:      "curl",
+		Ports:     [2]uint16{80, 443},
+		Addr:      testAddr{Family: 2, Port: 8080},
+		IP:        testAddrUnion{V4: hostEndian.Uint32([]byte{10, 0, 0, 1}), Raw: [16]byte{10, 0, 0, 1}},
+		Len:       5,
+		Data:      []byte("hello"),
+	}
+	if !reflect.DeepEqual(*event, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, *event)
+	}
+}
+
+func TestEventDecoderErrors(t *testing.T) {
+	decoder, err := NewEventDecoder[testEvent]()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := decoder.Decode(make([]byte, 51)); err == nil {
+		t.Fatalf("Expected an error when the record is too short, got %v", err)
+	}
+
+	raw := make([]byte, 56)
+	hostEndian.PutUint32(raw[48:], 5)
+	if _, err := decoder.Decode(raw); err == nil {
+		t.Fatalf("Expected an error when the tail length exceeds the record, got %v", err)
+	}
+
+	type platformInt struct{ Pid int }
+	if _, err := NewEventDecoder[platformInt](); err == nil {
+		t.Fatalf("Expected an error for platform dependent int, got %v", err)
+	}
+
+	type misplacedTail struct {
+		Data []byte `ebpf:"data,tail"`
+		Len  uint32
+	}
+	if _, err := NewEventDecoder[misplacedTail](); err == nil {
+		t.Fatalf("Expected an error when fields follow the tail, got %v", err)
+	}
+}
+
+func TestEventDecoderByteOrder(t *testing.T) {
+	type record struct {
+		Value uint32
+		Delta int16
+	}
+
+	decoder, err := NewEventDecoder[record](WithByteOrder(binary.BigEndian))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	event, err := decoder.Decode([]byte{0, 0, 1, 0, 0xff, 0xfe, 0, 0})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if event.Value != 256 || event.Delta != -2 {
+		t.Fatalf("Expected value 256 and delta -2, got %+v", event)
+	}
+}
+
+// makeTestBTF builds the BTF of
+//
+//	struct ev { __u32 a; __u64 b; __u32 c[2]; };
+func makeTestBTF(t *testing.T) *BTFSpec {
+	strs := []byte("\x00u32\x00u64\x00ev\x00a\x00b\x00c\x00")
+	name := func(s string) uint32 { return uint32(bytes.Index(strs, []byte("\x00"+s+"\x00")) + 1) }
+
+	types := &bytes.Buffer{}
+	write := func(data ...interface{}) {
+		for _, d := range data {
+			if err := binary.Write(types, hostEndian, d); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+	}
+	write(btfType{name("u32"), btfKindInt << 24, 4}, uint32(32)) // 1
+	write(btfType{name("u64"), btfKindInt << 24, 8}, uint32(64)) // 2
+	write(btfType{0, btfKindArray << 24, 0}, [3]uint32{1, 1, 2}) // 3
+	write(btfType{name("ev"), btfKindStruct<<24 | 3, 24},        // 4
+		rawBTFMember{name("a"), 1, 0}, rawBTFMember{name("b"), 2, 64}, rawBTFMember{name("c"), 3, 128})
+
+	header := btfHeader{Magic: btfMagic, Version: 1, HdrLen: 24, TypeLen: uint32(types.Len()),
+		StrOff: uint32(types.Len()), StrLen: uint32(len(strs))}
+	raw := &bytes.Buffer{}
+	if err := binary.Write(raw, hostEndian, &header); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	raw.Write(types.Bytes())
+	raw.Write(strs)
+
+	spec, err := parseBTF(raw.Bytes(), hostEndian)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return spec
+}
+
+func TestEventDecoderWithBTF(t *testing.T) {
+	spec := makeTestBTF(t)
+
+	ev, err := spec.FindStruct("ev")
+	if err != nil || ev.Size != 24 || len(ev.Members) != 3 || ev.Members[2].Type.Size != 8 {
+		t.Fatalf("Expected struct ev of 24 bytes with 3 members, got %+v err=%v", ev, err)
+	}
+
+	// only the members of interest are declared, their offsets come from BTF
+	type subset struct {
+		C [2]uint32
+		B uint64
+	}
+	decoder, err := NewEventDecoder[subset](WithBTFStruct(spec, "ev"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	raw := make([]byte, 24)
+	hostEndian.PutUint64(raw[8:], 42)
+	hostEndian.PutUint32(raw[16:], 1)
+	hostEndian.PutUint32(raw[20:], 2)
+	event, err := decoder.Decode(raw)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if event.B != 42 || event.C != [2]uint32{1, 2} {
+		t.Fatalf("Expected b 42 and c [1 2], got %+v", event)
+	}
+
+	type mismatch struct{ B uint32 }
+	if _, err := NewEventDecoder[mismatch](WithBTFStruct(spec, "ev")); err == nil {
+		t.Fatalf("Expected an error on size mismatch, got %v", err)
+	}
+	type unknown struct{ D uint32 }
+	if _, err := NewEventDecoder[unknown](WithBTFStruct(spec, "ev")); err == nil {
+		t.Fatalf("Expected an error on unknown member, got %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aquasecurity/libbpfgo"
+)
+
+const (
+	// XDPDispatcherProgName is the root XDP program which runs the chain of stages through
+	// tail calls, it is attached to the interface in place of a single XDP program.
+	// Names below are defined in bpf/headers/dispatcher.h
+	XDPDispatcherProgName      = "xdp_dispatcher"
+	XDPStagesMapName           = "xdp_stages"
+	XDPDispatcherConfigMapName = "xdp_dispatcher_config"
+	XDPRateLimitConfigMapName  = "xdp_ratelimit_config"
+	XDPRateLimitTATMapName     = "xdp_ratelimit_tat"
+	XDPDropStatsMapName        = "xdp_drop_stats"
+
+	// XDP_DISPATCHER_MAX_STAGES should be synchronized to XDP_DISPATCHER_MAX_STAGES
+	// in bpf/headers/dispatcher.h
+	XDP_DISPATCHER_MAX_STAGES = 16
+)
+
+// XDP stages shipped in the eBPF object, any other XDP program of the object can be
+// a stage as long as it ends with xdp_stage_next().
+const (
+	XDP_STAGE_BLOCKLIST = "xdp_blocklist" // drops the sources in the blocklist map
+	XDP_STAGE_ALLOWLIST = "xdp_allowlist" // drops what is not allowed on the interfaces in allowlist mode
+	XDP_STAGE_RATELIMIT = "xdp_ratelimit" // limits the packet rate of every source
+	XDP_STAGE_SAMPLER   = "xdp_sampler"   // samples the packets into the ring buffer
+)
+
+// Reasons of the packets dropped by the XDP programs, the index of XDPDropStatsMapName.
+// They should be synchronized to XDP_DROP_* in bpf/headers/dispatcher.h
+const (
+	XDP_DROP_BLOCKLIST = iota
+	XDP_DROP_RATELIMIT
+	XDP_DROP_ALLOWLIST
+)
+
+var xdpDropReasons = []string{
+	XDP_DROP_BLOCKLIST: "blocklist",
+	XDP_DROP_RATELIMIT: "ratelimit",
+	XDP_DROP_ALLOWLIST: "allowlist",
+}
+
+// DropStats returns the number of packets dropped by the XDP programs by reasons.
+func (manager *BPFManager) DropStats() (map[string]uint64, error) {
+	stats, err := GetMap[uint32, uint64](manager, XDPDropStatsMapName)
+	if err != nil {
+		return nil, err
+	}
+
+	drops := make(map[string]uint64, len(xdpDropReasons))
+	for reason, name := range xdpDropReasons {
+		if drops[name], err = SumPerCPU(stats, uint32(reason)); err != nil {
+			return nil, err
+		}
+	}
+	return drops, nil
+}
+
+// RateLimitConfig is the configuration of XDP_STAGE_RATELIMIT. The layout should be
+// synchronized to struct xdp_ratelimit_config in bpf/headers/dispatcher.h
+type RateLimitConfig struct {
+	IntervalNs uint64 // nanoseconds between two packets of a source, 0 disables the limit
+	BurstNs    uint64 // how far a source can run ahead of its rate
+}
+
+// NewRateLimitConfig returns the RateLimitConfig allowing pps packets per second with
+// bursts of burst packets for every source, pps 0 disables the limit.
+func NewRateLimitConfig(pps uint64, burst uint64) RateLimitConfig {
+	if pps == 0 {
+		return RateLimitConfig{}
+	}
+
+	interval := uint64(time.Second) / pps
+	if interval == 0 {
+		interval = 1
+	}
+	return RateLimitConfig{IntervalNs: interval, BurstNs: interval * burst}
+}
+
+// XDPDispatcher manages the chain of stages run by XDPDispatcherProgName. The chain is
+// double buffered in the PROG_ARRAY: a new chain is written to the inactive half and then
+// activated with a single map update, so stages are inserted, removed and reordered at
+// runtime without detaching the interface and without exposing a partial chain.
+type XDPDispatcher struct {
+	manager *BPFManager
+	stages  *Map[uint32, uint32]
+	config  *Map[uint32, uint32]
+
+	mu     sync.Mutex
+	active uint32
+	chain  []string
+}
+
+// NewXDPDispatcher returns the XDPDispatcher of the loaded BPFModule of manager. The chain
+// starts empty, which passes every packet, until it is set by Set or Insert.
+func NewXDPDispatcher(manager *BPFManager) (*XDPDispatcher, error) {
+	stages, err := GetMap[uint32, uint32](manager, XDPStagesMapName)
+	if err != nil {
+		return nil, err
+	}
+	config, err := GetMap[uint32, uint32](manager, XDPDispatcherConfigMapName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the config map may be pinned by the previous process, keep using its active half
+	active, err := config.Get(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v: %w", XDPDispatcherConfigMapName, err)
+	}
+
+	return &XDPDispatcher{
+		manager: manager,
+		stages:  stages,
+		config:  config,
+		active:  active & 1,
+	}, nil
+}
+
+// Stages returns the names of stages in the order they run.
+func (dispatcher *XDPDispatcher) Stages() []string {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+
+	return append([]string(nil), dispatcher.chain...)
+}
+
+// Set replaces the chain with stages.
+func (dispatcher *XDPDispatcher) Set(stages ...string) error {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+
+	return dispatcher.commit(append([]string(nil), stages...))
+}
+
+// Insert inserts stage at position of the chain, stage is appended when position is
+// negative or beyond the end of the chain.
+func (dispatcher *XDPDispatcher) Insert(stage string, position int) error {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+
+	return dispatcher.commit(insertStage(dispatcher.chain, stage, position))
+}
+
+// Remove removes stage from the chain.
+func (dispatcher *XDPDispatcher) Remove(stage string) error {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+
+	chain, err := removeStage(dispatcher.chain, stage)
+	if err != nil {
+		return err
+	}
+	return dispatcher.commit(chain)
+}
+
+// Move moves stage of the chain to position, see Insert for the meaning of position.
+func (dispatcher *XDPDispatcher) Move(stage string, position int) error {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+
+	chain, err := removeStage(dispatcher.chain, stage)
+	if err != nil {
+		return err
+	}
+	return dispatcher.commit(insertStage(chain, stage, position))
+}
+
+// Reorder runs the stages of the chain in the order of stages, which should contain
+// exactly the stages in the chain.
+func (dispatcher *XDPDispatcher) Reorder(stages ...string) error {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+
+	if !sameStages(dispatcher.chain, stages) {
+		return fmt.Errorf("stages %v are not a reordering of the chain %v", stages, dispatcher.chain)
+	}
+	return dispatcher.commit(append([]string(nil), stages...))
+}
+
+// SetRateLimit configures XDP_STAGE_RATELIMIT, it takes effect immediately whether or not
+// the stage is in the chain.
+func (dispatcher *XDPDispatcher) SetRateLimit(config RateLimitConfig) error {
+	m, err := GetMap[uint32, RateLimitConfig](dispatcher.manager, XDPRateLimitConfigMapName)
+	if err != nil {
+		return err
+	}
+	return m.Put(0, config)
+}
+
+// commit writes chain to the inactive half of the PROG_ARRAY and activates it.
+func (dispatcher *XDPDispatcher) commit(chain []string) error {
+	if err := validateChain(chain); err != nil {
+		return err
+	}
+
+	fds := make([]uint32, len(chain))
+	for i, stage := range chain {
+		prog, err := dispatcher.manager.bpfModule.GetProgram(stage)
+		if err != nil {
+			return fmt.Errorf("stage %v was not found: %w", stage, err)
+		}
+		if prog.GetType() != libbpfgo.BPFProgTypeXdp {
+			return fmt.Errorf("stage %v is not an XDP program", stage)
+		}
+		fds[i] = uint32(prog.GetFd())
+	}
+
+	next := dispatcher.active ^ 1
+	base := next * XDP_DISPATCHER_MAX_STAGES
+	for i, fd := range fds {
+		if err := dispatcher.stages.Put(base+uint32(i), fd); err != nil {
+			return fmt.Errorf("failed to install stage %v: %w", chain[i], err)
+		}
+	}
+	for slot := base + uint32(len(fds)); slot < base+XDP_DISPATCHER_MAX_STAGES; slot++ {
+		if err := dispatcher.stages.Delete(slot); err != nil && !errors.Is(err, ErrKeyNotExist) {
+			return fmt.Errorf("failed to clear slot %v: %w", slot, err)
+		}
+	}
+
+	if err := dispatcher.config.Put(0, next); err != nil {
+		return fmt.Errorf("failed to activate the chain: %w", err)
+	}
+	dispatcher.active = next
+	dispatcher.chain = chain
+
+	return nil
+}
+
+func validateChain(chain []string) error {
+	if len(chain) > XDP_DISPATCHER_MAX_STAGES {
+		return fmt.Errorf("at most %v stages are allowed, got %v", XDP_DISPATCHER_MAX_STAGES, len(chain))
+	}
+
+	seen := map[string]bool{}
+	for _, stage := range chain {
+		switch {
+		case stage == "":
+			return fmt.Errorf("empty stage name")
+		case stage == XDPDispatcherProgName:
+			return fmt.Errorf("%v can not be a stage of itself", XDPDispatcherProgName)
+		case seen[stage]:
+			return fmt.Errorf("stage %v appears more than once", stage)
+		}
+		seen[stage] = true
+	}
+	return nil
+}
+
+func insertStage(chain []string, stage string, position int) []string {
+	if position < 0 || position > len(chain) {
+		position = len(chain)
+	}
+
+	result := make([]string, 0, len(chain)+1)
+	result = append(result, chain[:position]...)
+	result = append(result, stage)
+	return append(result, chain[position:]...)
+}
+
+func removeStage(chain []string, stage string) ([]string, error) {
+	for i, s := range chain {
+		if s == stage {
+			result := make([]string, 0, len(chain)-1)
+			result = append(result, chain[:i]...)
+			return append(result, chain[i+1:]...), nil
+		}
+	}
+	return nil, fmt.Errorf("stage %v is not in the chain", stage)
+}
+
+func sameStages(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := map[string]int{}
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		if count[s] == 0 {
+			return false
+		}
+		count[s]--
+	}
+	return true
+}
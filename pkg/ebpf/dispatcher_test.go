@@ -0,0 +1,66 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDispatcherChainEditing(t *testing.T) {
+	chain := []string{XDP_STAGE_BLOCKLIST, XDP_STAGE_SAMPLER}
+
+	chain = insertStage(chain, XDP_STAGE_RATELIMIT, 1)
+	expected := []string{XDP_STAGE_BLOCKLIST, XDP_STAGE_RATELIMIT, XDP_STAGE_SAMPLER}
+	if !reflect.DeepEqual(chain, expected) {
+		t.Fatalf("Expected %v, got %v", expected, chain)
+	}
+	if appended := insertStage(chain, "xdp_custom", -1); appended[len(appended)-1] != "xdp_custom" {
+		t.Fatalf("Expected xdp_custom to be appended, got %v", appended)
+	}
+
+	removed, err := removeStage(chain, XDP_STAGE_BLOCKLIST)
+	if err != nil || !reflect.DeepEqual(removed, expected[1:]) {
+		t.Fatalf("Expected %v, got %v err=%v", expected[1:], removed, err)
+	}
+	if !reflect.DeepEqual(chain, expected) {
+		t.Fatalf("Expected the original chain to be untouched, got %v", chain)
+	}
+	if _, err := removeStage(chain, "xdp_custom"); err == nil {
+		t.Fatalf("Expected an error when removing unknown stage, got %v", err)
+	}
+
+	if !sameStages(chain, []string{XDP_STAGE_SAMPLER, XDP_STAGE_BLOCKLIST, XDP_STAGE_RATELIMIT}) ||
+		sameStages(chain, []string{XDP_STAGE_SAMPLER, XDP_STAGE_SAMPLER, XDP_STAGE_RATELIMIT}) {
+		t.Fatalf("Expected only permutations of %v to be a reordering", chain)
+	}
+}
+
+func TestValidateChain(t *testing.T) {
+	if err := validateChain([]string{XDP_STAGE_BLOCKLIST, XDP_STAGE_SAMPLER}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, chain := range [][]string{
+		{XDP_STAGE_SAMPLER, XDP_STAGE_SAMPLER},
+		{XDPDispatcherProgName},
+		{""},
+		make([]string, XDP_DISPATCHER_MAX_STAGES+1),
+	} {
+		if err := validateChain(chain); err == nil {
+			t.Fatalf("Expected an error for chain %v, got %v", chain, err)
+		}
+	}
+}
+
+func TestNewRateLimitConfig(t *testing.T) {
+	config := NewRateLimitConfig(1000, 10)
+	if config.IntervalNs != 1000000 || config.BurstNs != 10000000 {
+		t.Fatalf("Expected interval 1ms and burst 10ms, got %+v", config)
+	}
+	if config := NewRateLimitConfig(0, 10); config.IntervalNs != 0 {
+		t.Fatalf("Expected the limit to be disabled, got %+v", config)
+	}
+}
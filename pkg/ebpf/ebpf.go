@@ -24,8 +24,6 @@ import (
 
 const ()
 
-var once sync.Once
-
 // Option defines optional parameters for initializing the BPFManager struct,
 // and it will return an error when something goes wrong in initializing.
 type Option func(*BPFManager) error
@@ -34,10 +32,20 @@ type Option func(*BPFManager) error
 //  a specific eBPF type. Or in some not very strict scenerio, we can put different types of
 // eBPF programs in a single *.bpf.c file
 type BPFManager struct {
-	programMaps map[string]probe.BPFProgram
+	programMaps map[string][]probe.BPFProgram
 	bpfModule   *libbpfgo.Module
 	bpfPrograms []probe.BPFProgram
 	ctx         context.Context
+	pinPath     string
+	bpfObjPath  string
+	bpfObjBuff  []byte
+	btfSpec     *BTFSpec
+
+	loadOnce sync.Once
+	loadErr  error
+	loaded   bool
+	closed   bool
+	status   map[probe.BPFProgram]*ProgramStatus
 }
 
 // WithContext allows passing context parameter to BPFManager in order
@@ -62,6 +70,7 @@ func WithBPFModuleFromFile(bpfObjPath string) Option {
 			return err
 		}
 		b.bpfModule = bpfModule
+		b.bpfObjPath = bpfObjPath
 
 		return nil
 	}
@@ -75,12 +84,13 @@ func WithBPFModuleFromBuffer(bpfObjBuff []byte, bpfObjName string) Option {
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"err":        err,
-				"bpfObjBuff": bpfObjBuff,
+				"bpfObjSize": len(bpfObjBuff),
 				"bpfObjName": bpfObjName,
 			}).Warning("error occurs when create bpfModule from Buffer")
 			return err
 		}
 		b.bpfModule = bpfModule
+		b.bpfObjBuff = bpfObjBuff
 
 		return nil
 	}
@@ -93,7 +103,8 @@ func WithBPFProgramList(programList []probe.BPFProgram) Option {
 	return func(b *BPFManager) error {
 		b.bpfPrograms = append(b.bpfPrograms, programList...)
 		for _, prog := range programList {
-			b.programMaps[prog.GetName()] = prog
+			b.programMaps[prog.GetName()] = append(b.programMaps[prog.GetName()], prog)
+			b.status[prog] = &ProgramStatus{Name: prog.GetName(), HookPoint: prog.GetHookPoint(), State: STATE_DETACHED}
 		}
 		return nil
 	}
@@ -104,7 +115,8 @@ func WithBPFProgramList(programList []probe.BPFProgram) Option {
 // the parameters with give options.
 func NewBPFManager(opts ...Option) (*BPFManager, error) {
 	ins := &BPFManager{
-		programMaps: map[string]probe.BPFProgram{},
+		programMaps: map[string][]probe.BPFProgram{},
+		status:      map[probe.BPFProgram]*ProgramStatus{},
 	}
 
 	for _, opt := range opts {
@@ -113,31 +125,81 @@ func NewBPFManager(opts ...Option) (*BPFManager, error) {
 		}
 	}
 
+	if err := ins.validatePrograms(); err != nil {
+		return nil, err
+	}
+
 	return ins, nil
 }
 
-func checkBPFObjLoadOr(bpfModule *libbpfgo.Module) error {
-	var err error
+// validatePrograms checks every registered BPFProgram against the programs present in
+// the BPFModule, so misconfigured programs are reported before anything gets loaded.
+func (manager *BPFManager) validatePrograms() error {
+	if manager.bpfModule == nil || len(manager.bpfPrograms) == 0 {
+		return nil
+	}
+
+	var available []string
+	iter := manager.bpfModule.Iterator()
+	for bpfProg := iter.NextProgram(); bpfProg != nil; bpfProg = iter.NextProgram() {
+		available = append(available, bpfProg.GetName())
+	}
+
+	for progName, progs := range manager.programMaps {
+		bpfProg, err := manager.bpfModule.GetProgram(progName)
+		if err != nil {
+			return fmt.Errorf("bpfProgram %v is not present in the object, available programs: %v", progName, available)
+		}
+
+		for _, prog := range progs {
+			if bpfProg.GetType() != prog.GetProgType() {
+				return fmt.Errorf("bpfProgram %v is %v in the object, but it is attached to %v which requires %v",
+					progName, bpfProg.GetType(), prog.GetHookPoint(), prog.GetProgType())
+			}
+		}
+	}
 
-	if bpfModule == nil {
+	return nil
+}
+
+func checkBPFObjLoadOr(manager *BPFManager) error {
+	if manager.closed {
+		return ErrManagerClosed
+	}
+	if manager.bpfModule == nil {
 		return fmt.Errorf("bpfModule has not been initialized properly yet")
 	}
 
-	once.Do(func() {
-		err = bpfModule.BPFLoadObject()
+	// the object can only be loaded once, remember the result for later callers
+	manager.loadOnce.Do(func() {
+		manager.loadErr = manager.loadBPFObj()
+		manager.loaded = manager.loadErr == nil
 	})
-	if err != nil {
+
+	return manager.loadErr
+}
+
+func (manager *BPFManager) loadBPFObj() error {
+	for _, prog := range manager.bpfPrograms {
+		if preLoader, ok := prog.(probe.PreLoader); ok {
+			if err := preLoader.PreLoad(manager.bpfModule); err != nil {
+				return err
+			}
+		}
+	}
+	if err := manager.pinMapsOr(); err != nil {
 		return err
 	}
-
-	return nil
+	if err := manager.bpfModule.BPFLoadObject(); err != nil {
+		return err
+	}
+	return manager.pinProgramsOr()
 }
 
-// AttachAll attach all registed BPFProgram in the manager.
+// AttachAll attach all registed BPFProgram in the manager. It tries every program
+// even if some of them fail, and returns ProgramErrors describing all the failures.
 func (manager *BPFManager) AttachAll() error {
-	var err error
-
-	err = checkBPFObjLoadOr(manager.bpfModule)
+	err := checkBPFObjLoadOr(manager)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err":      err,
@@ -146,16 +208,22 @@ func (manager *BPFManager) AttachAll() error {
 		return err
 	}
 
+	var errs ProgramErrors
 	for _, prog := range manager.bpfPrograms {
-		// try to attach all registed BPFProgram to their Point
-		err = prog.Attach(manager.bpfModule)
+		if manager.status[prog].State == STATE_ATTACHED {
+			continue
+		}
+		if err := manager.attach(prog); err != nil {
+			errs = append(errs, &ProgramError{Op: "attach", Name: prog.GetName(), HookPoint: prog.GetHookPoint(), Err: err})
+		}
 	}
 
-	// maybe not immediately return when error occurs, that will be helpful
-	// to find all possible mistakes in one shot. We just return an error while
-	// there maybe more than one error occurs.
-	if err != nil {
-		return fmt.Errorf("error occurs when doing BPFProgram Attach")
+	if len(errs) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"err":      errs,
+			"location": "(*BPFManager) AttachAll",
+		}).Warningf("error occurs when doing BPFProgram Attach")
+		return errs
 	}
 
 	return nil
@@ -165,9 +233,7 @@ func (manager *BPFManager) AttachAll() error {
 // It will return the first error where it fails to attach eBPF program.
 // and the rest of them will not try to attach.
 func (manager *BPFManager) AttachGiven(progNames ...string) error {
-	var err error
-
-	err = checkBPFObjLoadOr(manager.bpfModule)
+	err := checkBPFObjLoadOr(manager)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err":      err,
@@ -177,17 +243,22 @@ func (manager *BPFManager) AttachGiven(progNames ...string) error {
 	}
 
 	for _, progName := range progNames {
-		if prog, exists := manager.programMaps[progName]; exists {
-			err := prog.Attach(manager.bpfModule)
-			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"err":       err,
-					"location":  "(*BPFManager) AttachGiven",
-					"progName":  progName,
-					"hookpoint": prog.GetHookPoint(),
-				}).Warningf("error occurs when attach bpfProgram to its Hook point")
-
-				return err
+		if progs, exists := manager.programMaps[progName]; exists {
+			for _, prog := range progs {
+				if manager.status[prog].State == STATE_ATTACHED {
+					continue
+				}
+				err := manager.attach(prog)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"err":       err,
+						"location":  "(*BPFManager) AttachGiven",
+						"progName":  progName,
+						"hookpoint": prog.GetHookPoint(),
+					}).Warningf("error occurs when attach bpfProgram to its Hook point")
+
+					return &ProgramError{Op: "attach", Name: progName, HookPoint: prog.GetHookPoint(), Err: err}
+				}
 			}
 		} else {
 			logrus.WithFields(logrus.Fields{
@@ -202,33 +273,42 @@ func (manager *BPFManager) AttachGiven(progNames ...string) error {
 	return nil
 }
 
+// DetachAll detach all attached BPFProgram in the manager. Like AttachAll, it returns
+// ProgramErrors describing all the failures.
 func (manager *BPFManager) DetachAll() error {
-	var err error
-
-	err = checkBPFObjLoadOr(manager.bpfModule)
+	err := checkBPFObjLoadOr(manager)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err":      err,
-			"location": "(*BPFManager) DetachGiven",
+			"location": "(*BPFManager) DetachAll",
 		}).Warningf("validation of BPFObj fails")
 		return err
 	}
 
+	var errs ProgramErrors
 	for _, prog := range manager.bpfPrograms {
-		err = prog.Detach(manager.bpfModule)
+		if manager.status[prog].State != STATE_ATTACHED {
+			continue
+		}
+		if err := manager.detach(prog); err != nil {
+			errs = append(errs, &ProgramError{Op: "detach", Name: prog.GetName(), HookPoint: prog.GetHookPoint(), Err: err})
+		}
 	}
 
-	if err != nil {
-		return fmt.Errorf("error occurs when doing BPFProgram Attach")
+	if len(errs) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"err":      errs,
+			"location": "(*BPFManager) DetachAll",
+		}).Warningf("error occurs when doing BPFProgram Detach")
+		return errs
 	}
 
 	return nil
 }
 
+// DetachGiven detach BPFProgram which was registed with given name.
 func (manager *BPFManager) DetachGiven(progName string) error {
-	var err error
-
-	err = checkBPFObjLoadOr(manager.bpfModule)
+	err := checkBPFObjLoadOr(manager)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err":      err,
@@ -237,17 +317,22 @@ func (manager *BPFManager) DetachGiven(progName string) error {
 		return err
 	}
 
-	if prog, exists := manager.programMaps[progName]; exists {
-		err := prog.Detach(manager.bpfModule)
-		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"err":       err,
-				"location":  "(*BPFManager) DetachGiven",
-				"progName":  progName,
-				"hookpoint": prog.GetHookPoint(),
-			}).Warningf("error occurs when detach bpfProgram from its Hook point")
+	if progs, exists := manager.programMaps[progName]; exists {
+		for _, prog := range progs {
+			if manager.status[prog].State != STATE_ATTACHED {
+				continue
+			}
+			err := manager.detach(prog)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"err":       err,
+					"location":  "(*BPFManager) DetachGiven",
+					"progName":  progName,
+					"hookpoint": prog.GetHookPoint(),
+				}).Warningf("error occurs when detach bpfProgram from its Hook point")
 
-			return err
+				return &ProgramError{Op: "detach", Name: progName, HookPoint: prog.GetHookPoint(), Err: err}
+			}
 		}
 	} else {
 		logrus.WithFields(logrus.Fields{
@@ -260,3 +345,24 @@ func (manager *BPFManager) DetachGiven(progName string) error {
 
 	return nil
 }
+
+// Close releases the BPFModule, the manager can not be used any more after closing.
+// Programs attached by the manager are detached first unless pinning is enabled, in
+// which case they keep running through their pinned links, see UnpinAll.
+func (manager *BPFManager) Close() error {
+	if manager.closed {
+		return nil
+	}
+
+	var err error
+	if manager.pinPath == "" && manager.loaded {
+		err = manager.DetachAll()
+	}
+
+	if manager.bpfModule != nil {
+		manager.bpfModule.Close()
+	}
+	manager.closed = true
+
+	return err
+}
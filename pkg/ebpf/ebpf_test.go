@@ -5,29 +5,39 @@
 package ebpf
 
 import (
-	"encoding/binary"
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"reflect"
-	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/p1nant0m/xdp-tracing/bpf"
 	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
 	"github.com/sirupsen/logrus"
 )
 
-var bpfObjPath string = "../../bpf/output/core.bpf.o"
 var bpfManager *BPFManager
 var once1 sync.Once
 
+// withEmbeddedBPFModule loads the object embedded by package bpf, run `go generate ./bpf`
+// before running the tests.
+func withEmbeddedBPFModule() Option {
+	return func(b *BPFManager) error {
+		obj, err := bpf.Object(bpf.XDPProxyObject)
+		if err != nil {
+			return err
+		}
+		return WithBPFModuleFromBuffer(obj, bpf.XDPProxyObject)(b)
+	}
+}
+
 func getSameManager() (*BPFManager, error) {
 	var err error
 	once1.Do(func() {
-		bpfManager, err = NewBPFManager(WithBPFModuleFromFile(bpfObjPath), WithBPFProgramList(probe.GetAllBPFProgs()))
+		bpfManager, err = NewBPFManager(withEmbeddedBPFModule(), WithBPFProgramList(append(ExecTracePrograms(),
+			probe.NewXDPProgram("xdp_proxy", "ens33", 0),
+		)))
 	})
 
 	if err != nil {
@@ -37,64 +47,6 @@ func getSameManager() (*BPFManager, error) {
 	return bpfManager, nil
 }
 
-type eventS struct {
-	Comm       string `size:"16"`
-	Pid        int
-	Tgid       int
-	Ppid       int
-	Uid        uint
-	Retval     int
-	Args_count int
-	Args_size  uint
-	Args       string `size:"7680"`
-}
-
-func findTruncate(buf []byte) int {
-	bufLen := len(buf)
-	for i := bufLen - 1; i > 0; i-- {
-		if buf[i] != 0 {
-			return i + 1
-		}
-	}
-
-	return bufLen - 1
-}
-
-func MapToStruct(buf []byte, data interface{}) error {
-	off := 0
-	bufSize := len(buf)
-
-	for i := 0; i < reflect.ValueOf(data).Elem().NumField(); i++ {
-		v := reflect.ValueOf(data).Elem().Field(i)
-		t := reflect.TypeOf(data).Elem().Field(i)
-		switch v.Kind() {
-		case reflect.String:
-			sizeS, ok := t.Tag.Lookup("size")
-			if !ok {
-				return errors.New("error when parse tag")
-			}
-
-			sizeI, _ := strconv.Atoi(sizeS)
-			trunidx := off
-			if off+sizeI > bufSize {
-				trunidx += findTruncate(buf[off:])
-			} else {
-				trunidx += findTruncate(buf[off : off+sizeI])
-			}
-
-			v.SetString(string(buf[off:trunidx]))
-			off += sizeI
-		case reflect.Uint:
-			v.SetUint(uint64(binary.LittleEndian.Uint32(buf[off : off+4])))
-			off += 4
-		case reflect.Int:
-			v.SetInt(int64(binary.LittleEndian.Uint32(buf[off : off+4])))
-			off += 4
-		}
-	}
-	return nil
-}
-
 func TestNewBPFManagerWithInvaildBPFObjPath(t *testing.T) {
 	invaildPath := "foo.bpf.o"
 	_, err := NewBPFManager(WithBPFModuleFromFile(invaildPath))
@@ -104,7 +56,7 @@ func TestNewBPFManagerWithInvaildBPFObjPath(t *testing.T) {
 }
 
 func TestLoadValidbpfObj(t *testing.T) {
-	m, err := NewBPFManager(WithBPFModuleFromFile(bpfObjPath))
+	m, err := NewBPFManager(withEmbeddedBPFModule())
 	if err != nil || m == nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -122,32 +74,28 @@ func TestTracingProgram(t *testing.T) {
 	}
 
 	eventsCh := make(chan []byte)
-	lostCh := make(chan uint64)
-	pb, err := m.bpfModule.InitPerfBuf("events", eventsCh, lostCh, 1)
+	rb, err := m.bpfModule.InitRingBuf(ExecEventsMapName, eventsCh)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
-	pb.Start()
-	defer pb.Stop()
-
-	eventT := &eventS{}
+	rb.Start()
+	defer rb.Stop()
 
 	for event := range eventsCh {
-		err = MapToStruct(event, eventT)
+		eventT, err := DecodeExecEvent(event)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(-1)
+			t.Fatalf("Expected no error, got %v", err)
 		}
 
 		logrus.Infof(
-			"Comm:%v pid:%v tgid:%v ppid:%v uid:%v retval:%v args:%v \n\n",
+			"Comm:%v pid:%v tid:%v ppid:%v uid:%v retval:%v args:%v \n\n",
 			eventT.Comm,
 			eventT.Pid,
-			eventT.Tgid,
-			eventT.Ppid,
+			eventT.Tid,
+			eventT.PPid,
 			eventT.Uid,
 			eventT.Retval,
-			eventT.Args,
+			eventT.Argv,
 		)
 		break
 	}
@@ -159,13 +107,13 @@ func TestAttachXDP(t *testing.T) {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 
-	err = m.AttachGiven("__test_trace_xdp")
+	err = m.AttachGiven("xdp_proxy")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 
 	eventCh := make(chan []byte)
-	rb, err := m.bpfModule.InitRingBuf("perfs", eventCh)
+	rb, err := m.bpfModule.InitRingBuf(SamplerMapName, eventCh)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -185,8 +133,8 @@ func TestAttachXDP(t *testing.T) {
 	for {
 		select {
 		case event := <-eventCh:
-			if got := binary.LittleEndian.Uint32(event); got != 7070 {
-				t.Errorf("Expeted 7070 from eventCh, got %v", got)
+			if sample, err := DecodePacketSample(event); err != nil || sample.CapLen == 0 {
+				t.Errorf("Expected packet sample from eventCh, got %v err=%v", sample, err)
 			}
 			if count = count + 1; count > 15 {
 				goto out
@@ -0,0 +1,283 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ExecEventsMapName is the name of BPF_MAP_TYPE_RINGBUF map which the execve tracepoints
+	// push exec events into. It is defined in bpf/headers/exec.h
+	ExecEventsMapName = "exec_events"
+	// ExecEnterProgName and ExecExitProgName are the programs tracing execve in the object.
+	ExecEnterProgName = "tracepoint__syscalls__sys_enter_execve"
+	ExecExitProgName  = "tracepoint__syscalls__sys_exit_execve"
+
+	execCommLen      = 16
+	execEventsBuffer = 1024
+)
+
+// ExecTracePrograms returns the BPFPrograms which should be registered in BPFManager
+// before calling StartExecTracer.
+func ExecTracePrograms() []probe.BPFProgram {
+	return []probe.BPFProgram{
+		probe.NewTracepointProgram(ExecEnterProgName, "syscalls", "sys_enter_execve"),
+		probe.NewTracepointProgram(ExecExitProgName, "syscalls", "sys_exit_execve"),
+	}
+}
+
+// execEventHeader is the fixed-size part of the record pushed by the execve tracepoints,
+// it should be synchronized to struct exec_event in bpf/headers/exec.h
+type execEventHeader struct {
+	Timestamp uint64
+	CgroupID  uint64
+	Pid       uint32
+	Tid       uint32
+	PPid      uint32
+	Uid       uint32
+	Retval    int32
+	ArgsCount uint32
+	ArgsSize  uint32
+	Comm      [execCommLen]byte
+}
+
+// execEventRecord is the whole record of struct exec_event, args is sent only up to
+// args_size bytes.
+type execEventRecord struct {
+	execEventHeader
+	Args []byte `ebpf:"args,tail,len=ArgsSize"`
+}
+
+var execEventDecoder = mustNewEventDecoder[execEventRecord]()
+
+// ExecEvent describes a process execution observed by the execve tracepoints.
+type ExecEvent struct {
+	Node      string    `json:"node,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Pid       uint32    `json:"pid"`
+	Tid       uint32    `json:"tid"`
+	PPid      uint32    `json:"ppid"`
+	Uid       uint32    `json:"uid"`
+	Comm      string    `json:"comm"`
+	Argv      []string  `json:"argv"`
+	Truncated bool      `json:"truncated"` // more arguments than the tracepoint can hold
+	Retval    int32     `json:"retval"`
+	CgroupID  uint64    `json:"cgroupid"`
+	Cgroup    string    `json:"cgroup,omitempty"`
+}
+
+// ExecFilter selects the ExecEvents to report, an empty field matches every event.
+type ExecFilter struct {
+	Pids       []uint32 `yaml:"pids"`
+	PPids      []uint32 `yaml:"ppids"`
+	Uids       []uint32 `yaml:"uids"`
+	Comms      []string `yaml:"comms"`
+	Cgroup     string   `yaml:"cgroup"`     // substring of the cgroup path
+	FailedOnly bool     `yaml:"failedonly"` // only report failed execve
+}
+
+// Match reports whether event satisfies the filter.
+func (filter *ExecFilter) Match(event *ExecEvent) bool {
+	if filter == nil {
+		return true
+	}
+
+	if len(filter.Pids) > 0 && !containsUint32(filter.Pids, event.Pid) {
+		return false
+	}
+	if len(filter.PPids) > 0 && !containsUint32(filter.PPids, event.PPid) {
+		return false
+	}
+	if len(filter.Uids) > 0 && !containsUint32(filter.Uids, event.Uid) {
+		return false
+	}
+	if len(filter.Comms) > 0 {
+		matched := false
+		for _, comm := range filter.Comms {
+			if comm == event.Comm {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if filter.Cgroup != "" && !strings.Contains(event.Cgroup, filter.Cgroup) {
+		return false
+	}
+	if filter.FailedOnly && event.Retval >= 0 {
+		return false
+	}
+
+	return true
+}
+
+func containsUint32(list []uint32, v uint32) bool {
+	for _, elem := range list {
+		if elem == v {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeExecEvent decodes the raw record received from ring buffer into ExecEvent. The
+// timestamp is left as the monotonic time since boot, see StartExecTracer.
+func DecodeExecEvent(raw []byte) (*ExecEvent, error) {
+	record, err := execEventDecoder.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exec event: %w", err)
+	}
+
+	header := &record.execEventHeader
+	event := &ExecEvent{
+		Timestamp: time.Unix(0, int64(header.Timestamp)),
+		Pid:       header.Pid,
+		Tid:       header.Tid,
+		PPid:      header.PPid,
+		Uid:       header.Uid,
+		Comm:      cString(header.Comm[:]),
+		Retval:    header.Retval,
+		CgroupID:  header.CgroupID,
+	}
+
+	args := record.Args
+	for len(args) > 0 {
+		end := bytes.IndexByte(args, 0)
+		if end < 0 {
+			end = len(args)
+		}
+		event.Argv = append(event.Argv, string(args[:end]))
+		args = args[minInt(end+1, len(args)):]
+	}
+	event.Truncated = int(header.ArgsCount) > len(event.Argv)
+
+	return event, nil
+}
+
+// StartExecTracer consumes the exec events pushed by the execve tracepoints, and sends
+// the events that satisfy filter to observerCh until ctx is done. ExecTracePrograms should
+// have been attached.
+func (manager *BPFManager) StartExecTracer(ctx context.Context, filter *ExecFilter, observerCh chan<- *ExecEvent) error {
+	err := checkBPFObjLoadOr(manager)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err,
+			"location": "(*BPFManager) StartExecTracer",
+		}).Warningf("validation of BPFObj fails")
+		return err
+	}
+
+	eventsCh := make(chan []byte, execEventsBuffer)
+	rb, err := manager.bpfModule.InitRingBuf(ExecEventsMapName, eventsCh)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err,
+			"location": "(*BPFManager) StartExecTracer",
+			"mapName":  ExecEventsMapName,
+		}).Warningf("error occurs when init ring buffer")
+		return err
+	}
+	rb.Start()
+
+	go func() {
+		defer rb.Stop()
+
+		bootTime := getBootTime()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case raw := <-eventsCh:
+				event, err := DecodeExecEvent(raw)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"err":      err,
+						"location": "(*BPFManager) StartExecTracer",
+					}).Debug("drop invalid exec event")
+					continue
+				}
+				event.Timestamp = bootTime.Add(time.Duration(event.Timestamp.UnixNano()))
+				event.Cgroup = readCgroupPath(event.Pid)
+
+				if !filter.Match(event) {
+					continue
+				}
+
+				select {
+				case observerCh <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// getBootTime returns the wall clock time when the system booted, which converts the
+// bpf_ktime_get_ns() timestamps into wall clock time.
+func getBootTime() time.Time {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return time.Unix(0, 0)
+	}
+	return time.Now().Add(-time.Duration(ts.Nano()))
+}
+
+// readCgroupPath returns the cgroup v2 path of process pid, or the path of the first
+// hierarchy on cgroup v1. It returns an empty string when the process has exited.
+func readCgroupPath(pid uint32) string {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var path string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			return fields[2]
+		}
+		if path == "" {
+			path = fields[2]
+		}
+	}
+
+	return path
+}
+
+func cString(buf []byte) string {
+	if end := bytes.IndexByte(buf, 0); end >= 0 {
+		return string(buf[:end])
+	}
+	return string(buf)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func makeRawExecEvent(t *testing.T, header execEventHeader, args []byte) []byte {
+	header.ArgsSize = uint32(len(args))
+
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, hostEndian, &header); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	buf.Write(args)
+	return buf.Bytes()
+}
+
+func TestDecodeExecEvent(t *testing.T) {
+	header := execEventHeader{Pid: 42, Tid: 42, PPid: 1, Uid: 1000, Retval: -2, ArgsCount: 4}
+	copy(header.Comm[:], "curl")
+	raw := makeRawExecEvent(t, header, []byte("/usr/bin/curl\x00-s\x00\x00example.com\x00"))
+
+	event, err := DecodeExecEvent(raw)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if event.Comm != "curl" || event.Pid != 42 || event.PPid != 1 || event.Retval != -2 {
+		t.Errorf("Expected curl pid 42 ppid 1 retval -2, got %+v", event)
+	}
+	if expected := []string{"/usr/bin/curl", "-s", "", "example.com"}; !reflect.DeepEqual(event.Argv, expected) {
+		t.Errorf("Expected argv %q, got %q", expected, event.Argv)
+	}
+	if event.Truncated {
+		t.Errorf("Expected argv not truncated, got %v", event.Truncated)
+	}
+}
+
+func TestDecodeExecEventInvalidArgsSize(t *testing.T) {
+	raw := makeRawExecEvent(t, execEventHeader{}, []byte("ls\x00"))
+	if _, err := DecodeExecEvent(raw[:len(raw)-1]); err == nil {
+		t.Fatalf("Expected an error when args size exceeds the record, got %v", err)
+	}
+}
+
+func TestExecFilterMatch(t *testing.T) {
+	event := &ExecEvent{Pid: 42, PPid: 1, Uid: 1000, Comm: "curl", Retval: 0, Cgroup: "/system.slice/docker-abc.scope"}
+
+	cases := []struct {
+		filter   *ExecFilter
+		expected bool
+	}{
+		{nil, true},
+		{&ExecFilter{}, true},
+		{&ExecFilter{Uids: []uint32{0, 1000}, Comms: []string{"curl"}}, true},
+		{&ExecFilter{Cgroup: "docker-abc"}, true},
+		{&ExecFilter{PPids: []uint32{2}}, false},
+		{&ExecFilter{Comms: []string{"wget"}}, false},
+		{&ExecFilter{FailedOnly: true}, false},
+	}
+
+	for _, c := range cases {
+		if got := c.filter.Match(event); got != c.expected {
+			t.Errorf("Expected %v for filter %+v, got %v", c.expected, c.filter, got)
+		}
+	}
+}
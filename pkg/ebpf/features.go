@@ -0,0 +1,513 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/p1nant0m/xdp-tracing/config"
+	"golang.org/x/sys/unix"
+)
+
+// status of a FeatureCheck
+const (
+	FEATURE_OK       = "ok"
+	FEATURE_DEGRADED = "degraded" // usable, but some features fall back or are disabled
+	FEATURE_MISSING  = "missing"  // the features depending on it will not work
+	FEATURE_UNKNOWN  = "unknown"  // the check could not be carried out
+)
+
+const (
+	vmlinuxBTFPath = "/sys/kernel/btf/vmlinux"
+
+	// memlock needed by the maps of the BPFModule on kernels charging rlimit for bpf memory
+	minMemlockBytes = 64 << 20
+
+	// capabilities defined in include/uapi/linux/capability.h
+	capNetAdmin = 12
+	capSysAdmin = 21
+	capPerfmon  = 38
+	capBPF      = 39
+)
+
+// kernel releases introducing the features we depend on
+var (
+	kernelMinimal  = KernelVersion{4, 18, 0} // bpffs, XDP generic mode, raw tracepoints
+	kernelRingbuf  = KernelVersion{5, 8, 0}
+	kernelXDPLink  = KernelVersion{5, 9, 0}
+	kernelMemcgBPF = KernelVersion{5, 11, 0} // bpf memory is charged to memcg instead of memlock
+)
+
+// FeatureCheck is the result of probing a single kernel capability.
+type FeatureCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Impact string `json:"impact,omitempty"` // what will be degraded when the check is not ok
+}
+
+// FeatureReport is the readiness of the host to run the eBPF programs.
+type FeatureReport struct {
+	Kernel string         `json:"kernel"`
+	Checks []FeatureCheck `json:"checks"`
+}
+
+// Ready reports whether none of the checks is missing.
+func (report *FeatureReport) Ready() bool {
+	for _, check := range report.Checks {
+		if check.Status == FEATURE_MISSING {
+			return false
+		}
+	}
+	return true
+}
+
+// Degraded returns the checks that are not ok.
+func (report *FeatureReport) Degraded() []FeatureCheck {
+	var checks []FeatureCheck
+	for _, check := range report.Checks {
+		if check.Status != FEATURE_OK {
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+func (report *FeatureReport) add(name, status, detail, impact string) {
+	if status == FEATURE_OK {
+		impact = ""
+	}
+	report.Checks = append(report.Checks, FeatureCheck{Name: name, Status: status, Detail: detail, Impact: impact})
+}
+
+// ProbeOptions controls what ProbeFeatures checks.
+type ProbeOptions struct {
+	PinPath string   // directory expected to be on bpffs, DefaultPinPath when empty
+	Devices []string // devices probed for XDP support, every non-loopback device when empty
+	// TestAttach attaches a noop XDP program in driver mode to verify native support
+	// instead of guessing from the driver name. Some drivers reset the NIC on attach.
+	TestAttach bool
+}
+
+// KernelVersion is the version of the running kernel.
+type KernelVersion struct {
+	Major, Minor, Patch int
+}
+
+func (v KernelVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Less reports whether v is older than o.
+func (v KernelVersion) Less(o KernelVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// code is LINUX_VERSION_CODE which is expected by kprobe programs on old kernels.
+func (v KernelVersion) code() uint32 {
+	patch := v.Patch
+	if patch > 255 {
+		patch = 255
+	}
+	return uint32(v.Major)<<16 | uint32(v.Minor)<<8 | uint32(patch)
+}
+
+// ParseKernelVersion parses the kernel release like 5.15.0-48-generic.
+func ParseKernelVersion(release string) (KernelVersion, error) {
+	var (
+		v     KernelVersion
+		parts = []*int{&v.Major, &v.Minor, &v.Patch}
+	)
+
+	fields := strings.SplitN(release, ".", 3)
+	if len(fields) < 2 {
+		return v, fmt.Errorf("invalid kernel release %q", release)
+	}
+	for i, field := range fields {
+		end := strings.IndexFunc(field, func(r rune) bool { return r < '0' || r > '9' })
+		if end == 0 {
+			return v, fmt.Errorf("invalid kernel release %q", release)
+		} else if end > 0 {
+			field = field[:end]
+		}
+		*parts[i], _ = strconv.Atoi(field)
+		if end > 0 {
+			break
+		}
+	}
+	return v, nil
+}
+
+// ProbeFeatures checks the running kernel and the privileges of the process for the
+// features needed by the eBPF programs, every check tells what is degraded without it.
+// Program and map types are probed by loading trivial ones, which needs privileges.
+func ProbeFeatures(opts ProbeOptions) *FeatureReport {
+	report := &FeatureReport{}
+
+	kernel := probeKernel(report)
+	privileged := probeCapabilities(report)
+	probeMemlock(report, kernel)
+	probeBTF(report)
+	probeBPFFS(report, opts.PinPath)
+	probeProgramTypes(report, kernel, privileged)
+	probeMapTypes(report, kernel, privileged)
+	probeXDPDevices(report, kernel, privileged, opts)
+
+	return report
+}
+
+func probeKernel(report *FeatureReport) KernelVersion {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		report.add("kernel version", FEATURE_UNKNOWN, err.Error(), "the features below are probed anyway")
+		return KernelVersion{}
+	}
+
+	report.Kernel = unix.ByteSliceToString(uname.Release[:])
+	kernel, err := ParseKernelVersion(report.Kernel)
+	switch {
+	case err != nil:
+		report.add("kernel version", FEATURE_UNKNOWN, err.Error(), "the features below are probed anyway")
+	case kernel.Less(kernelMinimal):
+		report.add("kernel version", FEATURE_MISSING, report.Kernel,
+			fmt.Sprintf("kernel %v or newer is required", kernelMinimal))
+	case kernel.Less(kernelXDPLink):
+		report.add("kernel version", FEATURE_DEGRADED, report.Kernel,
+			fmt.Sprintf("ringbuf needs %v and XDP bpf_link needs %v, see the checks below", kernelRingbuf, kernelXDPLink))
+	default:
+		report.add("kernel version", FEATURE_OK, report.Kernel, "")
+	}
+	return kernel
+}
+
+// probeCapabilities reports whether the process is able to load programs of every type.
+func probeCapabilities(report *FeatureReport) bool {
+	capEff, err := readCapEff("/proc/self/status")
+	if err != nil {
+		report.add("capabilities", FEATURE_UNKNOWN, err.Error(), "loading programs may fail with EPERM")
+		return true
+	}
+
+	has := func(capability uint) bool { return capEff&(1<<capability) != 0 }
+	if has(capSysAdmin) {
+		report.add("capabilities", FEATURE_OK, "CAP_SYS_ADMIN", "")
+		return true
+	}
+
+	var missing []string
+	for _, c := range []struct {
+		name string
+		bit  uint
+	}{{"CAP_BPF", capBPF}, {"CAP_PERFMON", capPerfmon}, {"CAP_NET_ADMIN", capNetAdmin}} {
+		if !has(c.bit) {
+			missing = append(missing, c.name)
+		}
+	}
+	if len(missing) == 0 {
+		report.add("capabilities", FEATURE_OK, "CAP_BPF, CAP_PERFMON, CAP_NET_ADMIN", "")
+		return true
+	}
+
+	report.add("capabilities", FEATURE_MISSING, "missing CAP_SYS_ADMIN or "+strings.Join(missing, ", "),
+		"no program can be loaded or attached, run as root")
+	return false
+}
+
+// readCapEff returns the effective capability set from the status file of /proc.
+func readCapEff(statusPath string) (uint64, error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "CapEff:") {
+			return strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("CapEff was not found in %v", statusPath)
+}
+
+func probeMemlock(report *FeatureReport, kernel KernelVersion) {
+	if !kernel.Less(kernelMemcgBPF) {
+		report.add("memlock limit", FEATURE_OK, "bpf memory is accounted by memcg", "")
+		return
+	}
+
+	var rlimit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &rlimit); err != nil {
+		report.add("memlock limit", FEATURE_UNKNOWN, err.Error(), "creating maps may fail with EPERM")
+		return
+	}
+	if rlimit.Cur == unix.RLIM_INFINITY || rlimit.Cur >= minMemlockBytes {
+		report.add("memlock limit", FEATURE_OK, formatRlimit(rlimit.Cur), "")
+		return
+	}
+
+	report.add("memlock limit", FEATURE_DEGRADED, formatRlimit(rlimit.Cur),
+		fmt.Sprintf("creating maps may fail with EPERM, raise it to %v or unlimited (ulimit -l unlimited)", formatRlimit(minMemlockBytes)))
+}
+
+func formatRlimit(limit uint64) string {
+	if limit == unix.RLIM_INFINITY {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d KiB", limit>>10)
+}
+
+func probeBTF(report *FeatureReport) {
+	if _, err := os.Stat(vmlinuxBTFPath); err != nil {
+		report.add("kernel BTF", FEATURE_MISSING, vmlinuxBTFPath+" is not available",
+			"CO-RE programs (exec tracing, flow attribution) fail to load and fentry/fexit probes are unavailable, "+
+				"enable CONFIG_DEBUG_INFO_BTF")
+		return
+	}
+	report.add("kernel BTF", FEATURE_OK, vmlinuxBTFPath, "")
+}
+
+func probeBPFFS(report *FeatureReport, pinPath string) {
+	if pinPath == "" {
+		pinPath = DefaultPinPath
+	}
+
+	mount := filepath.Dir(pinPath)
+	if !isBPFFS(mount) {
+		report.add("bpffs", FEATURE_DEGRADED, mount+" is not a mounted bpffs",
+			"pinning is disabled, so the attach command does not work and programs are detached on restart, "+
+				"mount it with `mount -t bpf bpf "+mount+"`")
+		return
+	}
+	report.add("bpffs", FEATURE_OK, "mounted on "+mount, "")
+}
+
+// progLoadAttr is the prog_load part of union bpf_attr used by BPF_PROG_LOAD.
+type progLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+}
+
+// noopInsns is `r0 = 0; exit`, which is accepted by every program type we probe.
+var noopInsns = [16]byte{0: 0xb7, 8: 0x95}
+
+func loadNoopProgram(progType uint32, kernel KernelVersion) (int, error) {
+	license := []byte("GPL\x00")
+	insns := noopInsns
+	attr := progLoadAttr{
+		progType:    progType,
+		insnCnt:     uint32(len(insns) / 8),
+		insns:       uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:     uint64(uintptr(unsafe.Pointer(&license[0]))),
+		kernVersion: kernel.code(),
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(&insns)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// mapCreateAttr is the map_create part of union bpf_attr used by BPF_MAP_CREATE.
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+func createMap(attr mapCreateAttr) error {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_MAP_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return errno
+	}
+	return unix.Close(int(fd))
+}
+
+// probeResult turns the error of loading a program or creating a map into the status.
+func probeResult(err error, privileged bool) (string, string) {
+	switch {
+	case err == nil:
+		return FEATURE_OK, "supported"
+	case errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES):
+		if !privileged {
+			return FEATURE_UNKNOWN, "not permitted to probe"
+		}
+		return FEATURE_UNKNOWN, fmt.Sprintf("not permitted to probe (%v), check the memlock limit", err)
+	default:
+		return FEATURE_MISSING, fmt.Sprintf("not supported (%v)", err)
+	}
+}
+
+func probeProgramTypes(report *FeatureReport, kernel KernelVersion, privileged bool) {
+	for _, p := range []struct {
+		name     string
+		progType uint32
+		impact   string
+	}{
+		{"xdp", unix.BPF_PROG_TYPE_XDP, "strategies can not be enforced and packets can not be sampled"},
+		{"sched_cls", unix.BPF_PROG_TYPE_SCHED_CLS, "tc probes are unavailable"},
+		{"kprobe", unix.BPF_PROG_TYPE_KPROBE, "flow attribution and kprobe/uprobe probes are unavailable"},
+		{"tracepoint", unix.BPF_PROG_TYPE_TRACEPOINT, "exec tracing and tracepoint probes are unavailable"},
+		{"raw_tracepoint", unix.BPF_PROG_TYPE_RAW_TRACEPOINT, "raw tracepoint probes are unavailable"},
+		{"cgroup_skb", unix.BPF_PROG_TYPE_CGROUP_SKB, "cgroup probes are unavailable"},
+	} {
+		fd, err := loadNoopProgram(p.progType, kernel)
+		if err == nil {
+			unix.Close(fd)
+		}
+		status, detail := probeResult(err, privileged)
+		report.add("program type "+p.name, status, detail, p.impact)
+	}
+}
+
+func probeMapTypes(report *FeatureReport, kernel KernelVersion, privileged bool) {
+	for _, m := range []struct {
+		name   string
+		attr   mapCreateAttr
+		impact string
+	}{
+		{"hash", mapCreateAttr{unix.BPF_MAP_TYPE_HASH, 4, 4, 1, 0}, "strategies can not be stored"},
+		{"array", mapCreateAttr{unix.BPF_MAP_TYPE_ARRAY, 4, 4, 1, 0}, "configuration maps can not be created"},
+		{"percpu_array", mapCreateAttr{unix.BPF_MAP_TYPE_PERCPU_ARRAY, 4, 4, 1, 0}, "per-cpu counters are unavailable"},
+		{"lru_hash", mapCreateAttr{unix.BPF_MAP_TYPE_LRU_HASH, 4, 4, 1, 0}, "flow attribution is unavailable"},
+		{"prog_array", mapCreateAttr{unix.BPF_MAP_TYPE_PROG_ARRAY, 4, 4, 1, 0}, "tail calls are unavailable"},
+		{"perf_event_array", mapCreateAttr{unix.BPF_MAP_TYPE_PERF_EVENT_ARRAY, 4, 4, 1, 0}, "perf buffers are unavailable"},
+		{"ringbuf", mapCreateAttr{unix.BPF_MAP_TYPE_RINGBUF, 0, 0, uint32(os.Getpagesize()), 0},
+			fmt.Sprintf("the XDP packet sampler and exec tracing need ringbuf (kernel %v), capture with packetsource: socket instead", kernelRingbuf)},
+	} {
+		status, detail := probeResult(createMap(m.attr), privileged)
+		if m.attr.mapType == unix.BPF_MAP_TYPE_RINGBUF && status == FEATURE_MISSING && kernel.Less(kernelRingbuf) {
+			detail = fmt.Sprintf("not supported before kernel %v", kernelRingbuf)
+		}
+		report.add("map type "+m.name, status, detail, m.impact)
+	}
+}
+
+// nativeXDPDrivers are drivers known to support XDP in driver mode.
+var nativeXDPDrivers = map[string]bool{
+	"bnxt_en": true, "dpaa2-eth": true, "ena": true, "enetc": true, "fec": true, "hv_netvsc": true,
+	"i40e": true, "ice": true, "igb": true, "igc": true, "ixgbe": true, "ixgbevf": true,
+	"mlx4_en": true, "mlx5_core": true, "mvneta": true, "mvpp2": true, "netsec": true, "nfp": true,
+	"nicvf": true, "qede": true, "sfc": true, "stmmac": true, "tun": true, "veth": true,
+	"virtio_net": true, "xen-netfront": true,
+}
+
+func probeXDPDevices(report *FeatureReport, kernel KernelVersion, privileged bool, opts ProbeOptions) {
+	devices := opts.Devices
+	if len(devices) == 0 {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			report.add("xdp devices", FEATURE_UNKNOWN, err.Error(), "")
+			return
+		}
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagLoopback == 0 {
+				devices = append(devices, iface.Name)
+			}
+		}
+	}
+
+	for _, dev := range devices {
+		name := "xdp native " + dev
+		impact := "XDP runs in generic (SKB) mode on " + dev + " with lower throughput"
+
+		iface, err := net.InterfaceByName(dev)
+		if err != nil {
+			report.add(name, FEATURE_MISSING, err.Error(), "strategies can not be applied on "+dev)
+			continue
+		}
+
+		driver, err := deviceDriver(dev)
+		if err != nil {
+			report.add(name, FEATURE_UNKNOWN, fmt.Sprintf("failed to get driver: %v", err), impact)
+			continue
+		}
+
+		if !opts.TestAttach || !privileged || kernel.Less(kernelXDPLink) {
+			if nativeXDPDrivers[driver] {
+				report.add(name, FEATURE_OK, "driver "+driver+" supports native XDP", "")
+			} else {
+				report.add(name, FEATURE_UNKNOWN, "driver "+driver+" is not known to support native XDP", impact)
+			}
+			continue
+		}
+
+		err = testAttachXDP(iface.Index, kernel)
+		switch {
+		case err == nil:
+			report.add(name, FEATURE_OK, "driver "+driver+" attached in driver mode", "")
+		case errors.Is(err, unix.EBUSY) || errors.Is(err, unix.EEXIST):
+			report.add(name, FEATURE_UNKNOWN, "driver "+driver+", another XDP program is attached", impact)
+		default:
+			report.add(name, FEATURE_DEGRADED, fmt.Sprintf("driver %v failed to attach in driver mode (%v)", driver, err), impact)
+		}
+	}
+}
+
+func deviceDriver(dev string) (string, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return "", err
+	}
+	defer unix.Close(fd)
+
+	info, err := unix.IoctlGetEthtoolDrvinfo(fd, dev)
+	if err != nil {
+		return "", err
+	}
+	return unix.ByteSliceToString(info.Driver[:]), nil
+}
+
+// testAttachXDP attaches the noop XDP program to ifindex in driver mode through a bpf_link
+// which is destroyed right away.
+func testAttachXDP(ifindex int, kernel KernelVersion) error {
+	progFd, err := loadNoopProgram(unix.BPF_PROG_TYPE_XDP, kernel)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(progFd)
+
+	attr := struct {
+		progFd, targetFd, attachType, flags uint32
+	}{uint32(progFd), uint32(ifindex), unix.BPF_XDP, config.XDP_FLAGS_DRV_MODE}
+	linkFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return errno
+	}
+	return unix.Close(int(linkFd))
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseKernelVersion(t *testing.T) {
+	for release, expected := range map[string]KernelVersion{
+		"5.15.0-48-generic":     {5, 15, 0},
+		"6.1":                   {6, 1, 0},
+		"5.10-rc1":              {5, 10, 0},
+		"4.18.0-372.el8.x86_64": {4, 18, 0},
+	} {
+		v, err := ParseKernelVersion(release)
+		if err != nil || v != expected {
+			t.Fatalf("Expected %v for %q, got %v err=%v", expected, release, v, err)
+		}
+	}
+
+	if _, err := ParseKernelVersion("linux"); err == nil {
+		t.Fatalf("Expected an error for invalid release, got %v", err)
+	}
+	if !(KernelVersion{5, 4, 0}).Less(kernelRingbuf) || kernelRingbuf.Less(KernelVersion{5, 8, 0}) {
+		t.Fatalf("Expected 5.4.0 to be older than %v", kernelRingbuf)
+	}
+}
+
+func TestReadCapEff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status")
+	if err := os.WriteFile(path, []byte("Name:\tcat\nCapPrm:\t0000000000000000\nCapEff:\t000001ffffffffff\n"), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	capEff, err := readCapEff(path)
+	if err != nil || capEff != 0x1ffffffffff {
+		t.Fatalf("Expected 0x1ffffffffff, got %#x err=%v", capEff, err)
+	}
+	if capEff&(1<<capBPF) == 0 {
+		t.Fatalf("Expected CAP_BPF to be set in %#x", capEff)
+	}
+}
+
+func TestFeatureReport(t *testing.T) {
+	report := &FeatureReport{}
+	report.add("kernel BTF", FEATURE_OK, "", "dropped")
+	report.add("map type ringbuf", FEATURE_DEGRADED, "", "sampler")
+	if !report.Ready() || len(report.Degraded()) != 1 || report.Checks[0].Impact != "" {
+		t.Fatalf("Expected a ready report with one degraded check, got %+v", report)
+	}
+
+	report.add("capabilities", FEATURE_MISSING, "", "root")
+	if report.Ready() {
+		t.Fatalf("Expected the report not to be ready, got %+v", report)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
+)
+
+const (
+	// FlowsMapName is the name of BPF_MAP_TYPE_LRU_HASH map which maps the 4-tuple of TCP
+	// connections to their owning process. It is defined in bpf/headers/flow.h
+	FlowsMapName = "flows"
+	// FlowConnectProgName and FlowAcceptProgName are the programs recording TCP connections
+	// in the object.
+	FlowConnectProgName = "kprobe__tcp_connect"
+	FlowAcceptProgName  = "kretprobe__inet_csk_accept"
+
+	FLOW_CONNECT = 1
+	FLOW_ACCEPT  = 2
+)
+
+var containerIDRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// FlowTracePrograms returns the BPFPrograms which should be registered in BPFManager
+// before calling NewFlowResolver.
+func FlowTracePrograms() []probe.BPFProgram {
+	return []probe.BPFProgram{
+		probe.NewKprobeProgram(FlowConnectProgName, "tcp_connect"),
+		probe.NewKretprobeProgram(FlowAcceptProgName, "inet_csk_accept"),
+	}
+}
+
+// flowKey should be synchronized to struct flow_key in bpf/headers/flow.h, addresses
+// keep the network byte order of the kernel while ports are in host byte order.
+type flowKey struct {
+	LAddr uint32
+	RAddr uint32
+	LPort uint16
+	RPort uint16
+}
+
+// flowOwner should be synchronized to struct flow_owner in bpf/headers/flow.h
+type flowOwner struct {
+	Timestamp uint64
+	CgroupID  uint64
+	Pid       uint32
+	Uid       uint32
+	Direction uint32
+	_         uint32
+	Comm      [execCommLen]byte
+}
+
+// FlowOwner describes the process which opened a TCP connection.
+type FlowOwner struct {
+	Pid         uint32    `json:"pid"`
+	Uid         uint32    `json:"uid"`
+	Comm        string    `json:"comm"`
+	CgroupID    uint64    `json:"cgroupid"`
+	Cgroup      string    `json:"cgroup,omitempty"`
+	ContainerID string    `json:"containerid,omitempty"`
+	Direction   string    `json:"direction"` // connect or accept
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// FlowResolver looks up the owner of TCP connections recorded by FlowTracePrograms.
+// Only the connections established after the programs are attached can be resolved.
+type FlowResolver struct {
+	flows    *Map[flowKey, flowOwner]
+	bootTime time.Time
+}
+
+// NewFlowResolver returns a FlowResolver backed by the flows map of manager.
+func NewFlowResolver(manager *BPFManager) (*FlowResolver, error) {
+	flows, err := GetMap[flowKey, flowOwner](manager, FlowsMapName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FlowResolver{flows: flows, bootTime: getBootTime()}, nil
+}
+
+// Lookup returns the owner of the TCP connection which the packet from srcIP:srcPort to
+// dstIP:dstPort belongs to, packets of both directions are accepted.
+func (resolver *FlowResolver) Lookup(srcIP, dstIP net.IP, srcPort, dstPort uint16) (*FlowOwner, bool) {
+	for _, key := range []flowKey{
+		newFlowKey(dstIP, srcIP, dstPort, srcPort), // ingress, the local end is the destination
+		newFlowKey(srcIP, dstIP, srcPort, dstPort),
+	} {
+		owner, err := resolver.flows.Get(key)
+		if err != nil {
+			continue
+		}
+		return resolver.newFlowOwner(&owner), true
+	}
+
+	return nil, false
+}
+
+func (resolver *FlowResolver) newFlowOwner(owner *flowOwner) *FlowOwner {
+	ret := &FlowOwner{
+		Pid:       owner.Pid,
+		Uid:       owner.Uid,
+		Comm:      cString(owner.Comm[:]),
+		CgroupID:  owner.CgroupID,
+		Direction: "connect",
+		Timestamp: resolver.bootTime.Add(time.Duration(owner.Timestamp)),
+	}
+	if owner.Direction == FLOW_ACCEPT {
+		ret.Direction = "accept"
+	}
+	ret.Cgroup = readCgroupPath(owner.Pid)
+	ret.ContainerID = containerIDFromCgroup(ret.Cgroup)
+
+	return ret
+}
+
+func newFlowKey(localIP, remoteIP net.IP, localPort, remotePort uint16) flowKey {
+	key := flowKey{LPort: localPort, RPort: remotePort}
+	if ip := localIP.To4(); ip != nil {
+		key.LAddr = hostEndian.Uint32(ip)
+	}
+	if ip := remoteIP.To4(); ip != nil {
+		key.RAddr = hostEndian.Uint32(ip)
+	}
+	return key
+}
+
+// containerIDFromCgroup extracts the container id from cgroup paths created by container
+// runtimes, e.g. /system.slice/docker-<id>.scope or /kubepods/besteffort/pod<uid>/<id>
+func containerIDFromCgroup(cgroup string) string {
+	ids := containerIDRegexp.FindAllString(cgroup, -1)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[len(ids)-1]
+}
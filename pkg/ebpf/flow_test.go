@@ -0,0 +1,48 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNewFlowKey(t *testing.T) {
+	key := newFlowKey(net.ParseIP("192.168.1.2"), net.ParseIP("10.0.0.1"), 40000, 80)
+
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, hostEndian, &key); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// addresses should be laid out in network byte order as the kernel stores them
+	expected := []byte{192, 168, 1, 2, 10, 0, 0, 1}
+	if !bytes.Equal(buf.Bytes()[:8], expected) {
+		t.Fatalf("Expected address bytes %v, got %v", expected, buf.Bytes()[:8])
+	}
+	if key.LPort != 40000 || key.RPort != 80 {
+		t.Fatalf("Expected ports 40000 and 80, got %v and %v", key.LPort, key.RPort)
+	}
+}
+
+func TestContainerIDFromCgroup(t *testing.T) {
+	id := strings.Repeat("ab12", 16)
+
+	cases := map[string]string{
+		"/system.slice/docker-" + id + ".scope":       id,
+		"/kubepods/besteffort/pod1234/" + id:          id,
+		"/user.slice/user-1000.slice/session-2.scope": "",
+		"": "",
+	}
+
+	for cgroup, expected := range cases {
+		if got := containerIDFromCgroup(cgroup); got != expected {
+			t.Errorf("Expected container id %q for %q, got %q", expected, cgroup, got)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// objGetAttr is the part of union bpf_attr used by BPF_OBJ_GET.
+type objGetAttr struct {
+	pathname  uint64
+	bpfFd     uint32
+	fileFlags uint32
+}
+
+// objInfoAttr is the info part of union bpf_attr used by BPF_OBJ_GET_INFO_BY_FD.
+type objInfoAttr struct {
+	bpfFd   uint32
+	infoLen uint32
+	info    uint64
+}
+
+// progInfo is the head of struct bpf_prog_info, the kernel fills as many bytes as we ask.
+type progInfo struct {
+	progType        uint32
+	id              uint32
+	tag             [8]byte
+	jitedProgLen    uint32
+	xlatedProgLen   uint32
+	jitedProgInsns  uint64
+	xlatedProgInsns uint64
+	loadTime        uint64
+	createdByUID    uint32
+	nrMapIDs        uint32
+	mapIDs          uint64
+	name            [16]byte // truncated to BPF_OBJ_NAME_LEN - 1
+}
+
+// mapInfo is the head of struct bpf_map_info.
+type mapInfo struct {
+	mapType    uint32
+	id         uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+	name       [16]byte
+}
+
+// getFdByIDAttr is the part of union bpf_attr used by BPF_*_GET_FD_BY_ID.
+type getFdByIDAttr struct {
+	id        uint32
+	nextID    uint32
+	openFlags uint32
+}
+
+// linkDetachAttr is the part of union bpf_attr used by BPF_LINK_DETACH.
+type linkDetachAttr struct {
+	linkFd uint32
+}
+
+// linkInfo is the head of struct bpf_link_info, up to the first word of its union which
+// identifies the target of cgroup, netns and xdp links.
+type linkInfo struct {
+	linkType uint32
+	id       uint32
+	progID   uint32
+	_        uint32    // padding before the union
+	target   [2]uint32 // cgroup_id, or netns_ino and ifindex in the first half
+}
+
+// defunct reports whether the link has lost its target, e.g. it has been detached by
+// BPF_LINK_DETACH or its interface has been removed, while it is still pinned.
+func (info *linkInfo) defunct() bool {
+	switch info.linkType {
+	case unix.BPF_LINK_TYPE_CGROUP:
+		return info.target[0] == 0 && info.target[1] == 0
+	case unix.BPF_LINK_TYPE_NETNS, unix.BPF_LINK_TYPE_XDP:
+		return info.target[0] == 0
+	}
+	return false
+}
+
+// PinnedLink describes a link pinned under the links directory of the pin path, which
+// keeps the program attached after the process creating it exits.
+type PinnedLink struct {
+	Path      string `json:"path"`
+	Program   string `json:"program"`
+	HookPoint string `json:"hookpoint"` // hook point with "/" replaced by "_", e.g. xdp_eth0
+	ID        uint32 `json:"id"`
+	ProgID    uint32 `json:"progid"`
+}
+
+// ProgramID returns the kernel id of the loaded program with given name, which is the
+// id shown by `bpftool prog`.
+func (manager *BPFManager) ProgramID(progName string) (uint32, error) {
+	if err := checkBPFObjLoadOr(manager); err != nil {
+		return 0, err
+	}
+
+	bpfProg, err := manager.bpfModule.GetProgram(progName)
+	if err != nil {
+		return 0, fmt.Errorf("bpfProgram with name %v was not found: %w", progName, err)
+	}
+
+	info := progInfo{}
+	if err := objGetInfo(bpfProg.GetFd(), unsafe.Pointer(&info), unsafe.Sizeof(info)); err != nil {
+		return 0, fmt.Errorf("failed to get info of bpfProgram %v: %w", progName, err)
+	}
+	return info.id, nil
+}
+
+// AttachedProgramID returns the kernel id of the program attached through the link pinned
+// for the program with given name, which is not the one loaded by manager when the link
+// pinned by a previous process is reused. It is ProgramID if the link is not pinned.
+func (manager *BPFManager) AttachedProgramID(progName string) (uint32, error) {
+	if err := checkBPFObjLoadOr(manager); err != nil {
+		return 0, err
+	}
+
+	for _, prog := range manager.programMaps[progName] {
+		if manager.isAttachedByPin(prog) {
+			_, progID, err := pinnedLinkInfo(manager.linkPinPath(prog))
+			return progID, err
+		}
+	}
+	return manager.ProgramID(progName)
+}
+
+// ListPinnedLinks returns the links pinned under pinPath.
+func ListPinnedLinks(pinPath string) ([]PinnedLink, error) {
+	entries, err := os.ReadDir(filepath.Join(pinPath, pinLinksDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var links []PinnedLink
+	for _, entry := range entries {
+		progName, hookPoint, ok := strings.Cut(entry.Name(), "@")
+		if !ok {
+			continue
+		}
+
+		link := PinnedLink{
+			Path:      filepath.Join(pinPath, pinLinksDir, entry.Name()),
+			Program:   progName,
+			HookPoint: hookPoint,
+		}
+		if link.ID, link.ProgID, err = pinnedLinkInfo(link.Path); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// Unpin removes the pin of link, the program is detached once no one holds the link.
+func (link *PinnedLink) Unpin() error {
+	return os.Remove(link.Path)
+}
+
+// Detach detaches the program of link from its hook point and removes the pin. Unlike
+// Unpin, the program is detached even if other processes, e.g. the agent, still hold the
+// link. The error is unix.Errno if the kernel can not detach the link, e.g. before 5.9.
+func (link *PinnedLink) Detach() error {
+	fd, err := objGet(link.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open pinned link %v: %w", link.Path, err)
+	}
+	defer unix.Close(fd)
+
+	attr := linkDetachAttr{linkFd: uint32(fd)}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_DETACH, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr)); errno != 0 {
+		return fmt.Errorf("failed to detach link %v: %w", link.Path, errno)
+	}
+	return link.Unpin()
+}
+
+func pinnedLinkInfo(path string) (uint32, uint32, error) {
+	info, err := readPinnedLink(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.id, info.progID, nil
+}
+
+// readPinnedLink returns the information of the link pinned at path.
+func readPinnedLink(path string) (*linkInfo, error) {
+	fd, err := objGet(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pinned link %v: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	info := &linkInfo{}
+	if err := objGetInfo(fd, unsafe.Pointer(info), unsafe.Sizeof(*info)); err != nil {
+		return nil, fmt.Errorf("failed to get info of pinned link %v: %w", path, err)
+	}
+	return info, nil
+}
+
+// objGet opens the object pinned at path and returns its fd, the error is unix.Errno.
+func objGet(path string) (int, error) {
+	pathname, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+
+	attr := objGetAttr{pathname: uint64(uintptr(unsafe.Pointer(pathname)))}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(pathname)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// progGetFdByID opens the loaded program with given id, the error is unix.Errno.
+func progGetFdByID(id uint32) (int, error) {
+	attr := getFdByIDAttr{id: id}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_GET_FD_BY_ID, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// progInfoByFd returns the information of the program fd refers to.
+func progInfoByFd(fd int) (*progInfo, error) {
+	info := &progInfo{}
+	if err := objGetInfo(fd, unsafe.Pointer(info), unsafe.Sizeof(*info)); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// objGetInfo fills info of size with the information of the object fd refers to.
+func objGetInfo(fd int, info unsafe.Pointer, size uintptr) error {
+	attr := objInfoAttr{
+		bpfFd:   uint32(fd),
+		infoLen: uint32(size),
+		info:    uint64(uintptr(info)),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLinkInfoDefunct(t *testing.T) {
+	cases := []struct {
+		info    linkInfo
+		defunct bool
+	}{
+		{linkInfo{linkType: unix.BPF_LINK_TYPE_XDP, target: [2]uint32{2, 0}}, false},
+		{linkInfo{linkType: unix.BPF_LINK_TYPE_XDP}, true},
+		{linkInfo{linkType: unix.BPF_LINK_TYPE_CGROUP, target: [2]uint32{0, 1}}, false},
+		{linkInfo{linkType: unix.BPF_LINK_TYPE_CGROUP}, true},
+		{linkInfo{linkType: unix.BPF_LINK_TYPE_NETNS, target: [2]uint32{0, unix.BPF_FLOW_DISSECTOR}}, true},
+		// the links of other types do not report their targets
+		{linkInfo{linkType: unix.BPF_LINK_TYPE_TRACING}, false},
+	}
+	for _, c := range cases {
+		if defunct := c.info.defunct(); defunct != c.defunct {
+			t.Errorf("Expected link of type %v with target %v defunct %v, got %v",
+				c.info.linkType, c.info.target, c.defunct, defunct)
+		}
+	}
+}
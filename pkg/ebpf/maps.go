@@ -0,0 +1,403 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/aquasecurity/libbpfgo"
+	"github.com/sirupsen/logrus"
+)
+
+const possibleCPUsFile = "/sys/devices/system/cpu/possible"
+
+var (
+	// ErrKeyNotExist is returned when the given key can not be found in the BPF map.
+	ErrKeyNotExist = errors.New("key does not exist in the BPF map")
+	// ErrMapTypeMismatch is returned when using per-CPU methods on a normal map or vice versa.
+	ErrMapTypeMismatch = errors.New("operation does not match the type of BPF map")
+)
+
+// hostEndian is the byte order used by the kernel to store keys and values of BPF maps.
+var hostEndian binary.ByteOrder = func() binary.ByteOrder {
+	var probe uint16 = 1
+	if *(*byte)(unsafe.Pointer(&probe)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Number is the constraint of values which can be aggregated across CPUs.
+type Number interface {
+	~int8 | ~int16 | ~int32 | ~int64 | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// Map is a typed view of the BPF map defined in the BPFModule. Keys and values are
+// encoded in host byte order with encoding/binary, so K and V should be fixed-size
+// types whose layout is the same as the C definition (add explicit padding fields
+// to the Go struct when the C struct has holes).
+type Map[K any, V any] struct {
+	name      string
+	bpfMap    *libbpfgo.BPFMap
+	perCPU    bool
+	nCPU      int
+	keySize   int
+	valueSize int
+}
+
+// GetMap looks up the BPF map with given name from the loaded BPFModule of manager, and
+// returns its typed view. It will return an error if the map can not be found or the size
+// of K and V mismatches the definition of the map.
+func GetMap[K any, V any](manager *BPFManager, mapName string) (*Map[K, V], error) {
+	err := checkBPFObjLoadOr(manager)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err,
+			"location": "GetMap",
+		}).Warningf("validation of BPFObj fails")
+		return nil, err
+	}
+
+	bpfMap, err := manager.bpfModule.GetMap(mapName)
+	if err != nil {
+		return nil, fmt.Errorf("bpfMap with name %v was not found: %w", mapName, err)
+	}
+
+	var (
+		key   K
+		value V
+	)
+	m := &Map[K, V]{
+		name:      mapName,
+		bpfMap:    bpfMap,
+		keySize:   binary.Size(key),
+		valueSize: binary.Size(value),
+	}
+
+	if m.keySize != bpfMap.KeySize() {
+		return nil, fmt.Errorf("key size of bpfMap %v is %v, but %T has size %v", mapName, bpfMap.KeySize(), key, m.keySize)
+	}
+	if m.valueSize != bpfMap.ValueSize() {
+		return nil, fmt.Errorf("value size of bpfMap %v is %v, but %T has size %v", mapName, bpfMap.ValueSize(), value, m.valueSize)
+	}
+
+	switch bpfMap.Type() {
+	case libbpfgo.MapTypePerCPUArray, libbpfgo.MapTypePerCPUHash, libbpfgo.MapTypeLRUPerCPUHash:
+		m.perCPU = true
+		if m.nCPU, err = possibleCPUs(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Name returns the name of the BPF map.
+func (m *Map[K, V]) Name() string {
+	return m.name
+}
+
+// MaxEntries returns the capacity of the map.
+func (m *Map[K, V]) MaxEntries() int {
+	return int(m.bpfMap.GetMaxEntries())
+}
+
+// IsPerCPU reports whether the map keeps a separate value for every possible CPU.
+func (m *Map[K, V]) IsPerCPU() bool {
+	return m.perCPU
+}
+
+// Get returns the value associated with key.
+func (m *Map[K, V]) Get(key K) (V, error) {
+	var value V
+	if m.perCPU {
+		return value, ErrMapTypeMismatch
+	}
+
+	keyBuf, err := encode(key)
+	if err != nil {
+		return value, err
+	}
+
+	raw, err := m.bpfMap.GetValue(unsafe.Pointer(&keyBuf[0]))
+	if err != nil {
+		return value, m.wrapError(err)
+	}
+
+	err = decode(raw, &value)
+	return value, err
+}
+
+// GetPerCPU returns the values of key on every possible CPU for per-CPU maps.
+func (m *Map[K, V]) GetPerCPU(key K) ([]V, error) {
+	if !m.perCPU {
+		return nil, ErrMapTypeMismatch
+	}
+
+	keyBuf, err := encode(key)
+	if err != nil {
+		return nil, err
+	}
+
+	stride := perCPUStride(m.valueSize)
+	raw := make([]byte, stride*m.nCPU)
+	if err := m.bpfMap.GetValueReadInto(unsafe.Pointer(&keyBuf[0]), &raw); err != nil {
+		return nil, m.wrapError(err)
+	}
+
+	values := make([]V, m.nCPU)
+	for cpu := range values {
+		if err := decode(raw[cpu*stride:cpu*stride+m.valueSize], &values[cpu]); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// Put creates or updates the element associated with key. For per-CPU maps the same
+// value is set on every CPU.
+func (m *Map[K, V]) Put(key K, value V) error {
+	return m.Update(key, value, libbpfgo.MapFlagUpdateAny)
+}
+
+// Update creates or updates the element associated with key according to flags.
+func (m *Map[K, V]) Update(key K, value V, flags libbpfgo.MapFlag) error {
+	keyBuf, err := encode(key)
+	if err != nil {
+		return err
+	}
+
+	valueBuf, err := encode(value)
+	if err != nil {
+		return err
+	}
+
+	if m.perCPU {
+		stride := perCPUStride(m.valueSize)
+		perCPUBuf := make([]byte, stride*m.nCPU)
+		for cpu := 0; cpu < m.nCPU; cpu++ {
+			copy(perCPUBuf[cpu*stride:], valueBuf)
+		}
+		valueBuf = perCPUBuf
+	}
+
+	err = m.bpfMap.UpdateValueFlags(unsafe.Pointer(&keyBuf[0]), unsafe.Pointer(&valueBuf[0]), flags)
+	return m.wrapError(err)
+}
+
+// Delete removes the element associated with key.
+func (m *Map[K, V]) Delete(key K) error {
+	keyBuf, err := encode(key)
+	if err != nil {
+		return err
+	}
+
+	return m.wrapError(m.bpfMap.DeleteKey(unsafe.Pointer(&keyBuf[0])))
+}
+
+// Keys returns all keys in the map.
+func (m *Map[K, V]) Keys() ([]K, error) {
+	var keys []K
+
+	iter := m.bpfMap.Iterator()
+	for iter.Next() {
+		var key K
+		if err := decode(iter.Key(), &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, m.wrapError(err)
+	}
+
+	return keys, nil
+}
+
+// Iterate calls fn for every element in the map until fn returns false. The element
+// removed during the iteration will be skipped. Per-CPU maps should use IteratePerCPU.
+func (m *Map[K, V]) Iterate(fn func(key K, value V) bool) error {
+	if m.perCPU {
+		return ErrMapTypeMismatch
+	}
+
+	keys, err := m.Keys()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		value, err := m.Get(key)
+		if errors.Is(err, ErrKeyNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !fn(key, value) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// IteratePerCPU is the same as Iterate, but passes the values of every CPU to fn.
+func (m *Map[K, V]) IteratePerCPU(fn func(key K, values []V) bool) error {
+	if !m.perCPU {
+		return ErrMapTypeMismatch
+	}
+
+	keys, err := m.Keys()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		values, err := m.GetPerCPU(key)
+		if errors.Is(err, ErrKeyNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !fn(key, values) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// BatchUpdate creates or updates all given elements in a single syscall. It falls back
+// to update elements one by one when the kernel does not support batch operations.
+func (m *Map[K, V]) BatchUpdate(keys []K, values []V) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("batch update bpfMap %v with %v keys but %v values", m.name, len(keys), len(values))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if !m.perCPU {
+		keysBuf, err := encode(keys)
+		if err != nil {
+			return err
+		}
+		valuesBuf, err := encode(values)
+		if err != nil {
+			return err
+		}
+
+		err = m.bpfMap.UpdateBatch(unsafe.Pointer(&keysBuf[0]), unsafe.Pointer(&valuesBuf[0]), uint32(len(keys)))
+		if err == nil || !(errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTSUP)) {
+			return m.wrapError(err)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"err":     err,
+			"mapName": m.name,
+		}).Debug("batch update is not supported, fall back to update elements one by one")
+	}
+
+	for i := range keys {
+		if err := m.Put(keys[i], values[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wrapError converts the errno returned from libbpf into the errors defined in this package.
+func (m *Map[K, V]) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("%w: %v", ErrKeyNotExist, err)
+	}
+
+	return err
+}
+
+// SumPerCPU returns the sum of values of key across all CPUs in a per-CPU map.
+func SumPerCPU[K any, V Number](m *Map[K, V], key K) (V, error) {
+	var sum V
+
+	values, err := m.GetPerCPU(key)
+	if err != nil {
+		return sum, err
+	}
+
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum, nil
+}
+
+func encode(data interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, hostEndian, data); err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", data, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(raw []byte, data interface{}) error {
+	if err := binary.Read(bytes.NewReader(raw), hostEndian, data); err != nil {
+		return fmt.Errorf("failed to decode %T: %w", data, err)
+	}
+	return nil
+}
+
+// perCPUStride returns the size that each CPU's value occupies, the kernel rounds
+// the value size up to 8 bytes for per-CPU maps.
+func perCPUStride(valueSize int) int {
+	return (valueSize + 7) &^ 7
+}
+
+// possibleCPUs returns the number of possible CPUs, which is the number of values
+// stored for every key of per-CPU maps.
+func possibleCPUs() (int, error) {
+	content, err := os.ReadFile(possibleCPUsFile)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseCPURange(strings.TrimSpace(string(content)))
+}
+
+// parseCPURange parses the cpu list format like "0-3,5,7-8" and returns the number of
+// CPUs in the list, the CPU index starts from 0.
+func parseCPURange(cpus string) (int, error) {
+	max := -1
+	for _, part := range strings.Split(cpus, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		last, err := strconv.Atoi(bounds[len(bounds)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpu range %q: %w", cpus, err)
+		}
+		if last > max {
+			max = last
+		}
+	}
+
+	return max + 1, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import "testing"
+
+func TestParseCPURange(t *testing.T) {
+	cases := map[string]int{
+		"0":       1,
+		"0-7":     8,
+		"0-3,5,7": 8,
+		"0,2-3":   4,
+	}
+
+	for cpus, expected := range cases {
+		got, err := parseCPURange(cpus)
+		if err != nil || got != expected {
+			t.Errorf("Expected %v CPUs for %q, got %v err=%v", expected, cpus, got, err)
+		}
+	}
+
+	if _, err := parseCPURange("foo"); err == nil {
+		t.Errorf("Expected an error when parsing invalid cpu range, got %v", err)
+	}
+}
+
+func TestEncodeDecodeStruct(t *testing.T) {
+	type value struct {
+		Packets uint64
+		Bytes   uint32
+		Pad     uint32
+	}
+
+	raw, err := encode(value{Packets: 1, Bytes: 2})
+	if err != nil || len(raw) != 16 {
+		t.Fatalf("Expected 16 bytes without error, got %v err=%v", len(raw), err)
+	}
+
+	var got value
+	if err := decode(raw, &got); err != nil || got.Packets != 1 || got.Bytes != 2 {
+		t.Errorf("Expected {1 2 0}, got %v err=%v", got, err)
+	}
+
+	if perCPUStride(12) != 16 || perCPUStride(8) != 8 {
+		t.Errorf("Expected per-CPU value size rounded up to 8 bytes")
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/p1nant0m/xdp-tracing/config"
+	"golang.org/x/sys/unix"
+)
+
+// values of IFLA_XDP_ATTACHED defined in include/uapi/linux/if_link.h
+const (
+	xdpAttachedNone = iota
+	xdpAttachedDrv
+	xdpAttachedSkb
+	xdpAttachedHw
+	xdpAttachedMulti
+)
+
+// xdpAttachment is the XDP program attached to a device, as reported by netlink.
+type xdpAttachment struct {
+	ifindex int
+	progID  uint32
+	mode    uint32 // XDP_FLAGS_*_MODE of the attachment
+}
+
+// queryXDP returns the XDP program attached to the device of ifindex, progID is 0 when
+// there is none.
+func queryXDP(ifindex int) (*xdpAttachment, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETLINK, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump links: %w", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse links: %w", err)
+	}
+
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.Header.Type != unix.RTM_NEWLINK || len(msg.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		if ifinfo := (*unix.IfInfomsg)(unsafe.Pointer(&msg.Data[0])); int(ifinfo.Index) != ifindex {
+			continue
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse attributes of link %v: %w", ifindex, err)
+		}
+
+		attachment := &xdpAttachment{ifindex: ifindex}
+		for _, attr := range attrs {
+			if attr.Attr.Type&^unix.NLA_F_NESTED != unix.IFLA_XDP {
+				continue
+			}
+			for typ, value := range parseNestedAttrs(attr.Value) {
+				switch {
+				case typ == unix.IFLA_XDP_PROG_ID && len(value) >= 4:
+					attachment.progID = hostEndian.Uint32(value)
+				case typ == unix.IFLA_XDP_ATTACHED && len(value) >= 1:
+					if attachment.mode, err = xdpAttachMode(value[0]); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		return attachment, nil
+	}
+
+	return nil, fmt.Errorf("link %v was not found", ifindex)
+}
+
+func xdpAttachMode(attached uint8) (uint32, error) {
+	switch attached {
+	case xdpAttachedNone:
+		return 0, nil
+	case xdpAttachedDrv:
+		return config.XDP_FLAGS_DRV_MODE, nil
+	case xdpAttachedSkb:
+		return config.XDP_FLAGS_SKB_MODE, nil
+	case xdpAttachedHw:
+		return config.XDP_FLAGS_HW_MODE, nil
+	}
+	return 0, fmt.Errorf("programs attached in multiple XDP modes are not supported")
+}
+
+// replaceXDP atomically replaces the XDP program attached through netlink with newFd.
+// The kernel refuses the replacement with EEXIST when the attached program is not oldFd.
+func replaceXDP(ifindex int, newFd int, oldFd int, mode uint32) error {
+	xdp := netlinkAttr(unix.IFLA_XDP_FD, uint32(newFd))
+	xdp = append(xdp, netlinkAttr(unix.IFLA_XDP_FLAGS, mode|config.XDP_FLAGS_REPLACE)...)
+	xdp = append(xdp, netlinkAttr(unix.IFLA_XDP_EXPECTED_FD, uint32(oldFd))...)
+
+	ifinfo := unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(ifindex)}
+	body := append((*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&ifinfo))[:], netlinkAttr(unix.IFLA_XDP|unix.NLA_F_NESTED, xdp)...)
+
+	return netlinkRequest(unix.RTM_SETLINK, body)
+}
+
+// netlinkRequest sends the route request and waits for its acknowledgement.
+func netlinkRequest(typ uint16, body []byte) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	header := unix.NlMsghdr{
+		Len:   uint32(unix.SizeofNlMsghdr + len(body)),
+		Type:  typ,
+		Flags: unix.NLM_F_REQUEST | unix.NLM_F_ACK,
+		Seq:   1,
+	}
+	req := append((*[unix.SizeofNlMsghdr]byte)(unsafe.Pointer(&header))[:], body...)
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if msg.Header.Seq != header.Seq || msg.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(msg.Data) < 4 {
+				return fmt.Errorf("truncated netlink acknowledgement")
+			}
+			if errno := int32(hostEndian.Uint32(msg.Data)); errno != 0 {
+				return unix.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+// netlinkAttr encodes the attribute whose value is either a uint32 or encoded attributes.
+func netlinkAttr(typ uint16, value interface{}) []byte {
+	var data []byte
+	switch v := value.(type) {
+	case uint32:
+		data = make([]byte, 4)
+		hostEndian.PutUint32(data, v)
+	case []byte:
+		data = v
+	}
+
+	// the padding is not counted in the length
+	attr := make([]byte, nlaAlign(unix.SizeofRtAttr+len(data)))
+	hostEndian.PutUint16(attr[0:], uint16(unix.SizeofRtAttr+len(data)))
+	hostEndian.PutUint16(attr[2:], typ)
+	copy(attr[unix.SizeofRtAttr:], data)
+	return attr
+}
+
+// parseNestedAttrs parses the attributes nested in data, indexed by their types.
+func parseNestedAttrs(data []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(data) >= unix.SizeofRtAttr {
+		length := int(hostEndian.Uint16(data[0:]))
+		typ := hostEndian.Uint16(data[2:]) &^ unix.NLA_F_NESTED
+		if length < unix.SizeofRtAttr || length > len(data) {
+			break
+		}
+		attrs[typ] = data[unix.SizeofRtAttr:length]
+
+		if length = nlaAlign(length); length > len(data) {
+			break
+		}
+		data = data[length:]
+	}
+	return attrs
+}
+
+func nlaAlign(length int) int {
+	return (length + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
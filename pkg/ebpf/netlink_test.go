@@ -0,0 +1,33 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/p1nant0m/xdp-tracing/config"
+	"golang.org/x/sys/unix"
+)
+
+func TestNetlinkAttr(t *testing.T) {
+	nested := netlinkAttr(unix.IFLA_XDP_PROG_ID, uint32(42))
+	nested = append(nested, netlinkAttr(unix.IFLA_XDP_ATTACHED, []byte{xdpAttachedSkb})...)
+	if len(nested) != 16 {
+		t.Fatalf("Expected attributes padded to 16 bytes, got %v", len(nested))
+	}
+
+	attrs := parseNestedAttrs(netlinkAttr(unix.IFLA_XDP|unix.NLA_F_NESTED, nested)[unix.SizeofRtAttr:])
+	if id := hostEndian.Uint32(attrs[unix.IFLA_XDP_PROG_ID]); id != 42 {
+		t.Fatalf("Expected prog id 42, got %v", id)
+	}
+
+	mode, err := xdpAttachMode(attrs[unix.IFLA_XDP_ATTACHED][0])
+	if err != nil || mode != config.XDP_FLAGS_SKB_MODE {
+		t.Fatalf("Expected SKB mode, got %v err=%v", mode, err)
+	}
+	if _, err := xdpAttachMode(xdpAttachedMulti); err == nil {
+		t.Fatalf("Expected an error for multiple attach modes, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPinPath is the directory on bpffs where maps, programs and links are pinned.
+	DefaultPinPath = "/sys/fs/bpf/xdp-tracing"
+
+	pinMapsDir  = "maps"
+	pinProgsDir = "progs"
+	pinLinksDir = "links"
+
+	// BPF_FS_MAGIC defined in include/uapi/linux/magic.h
+	bpfFSMagic = 0xcafe4a11
+)
+
+// WithPinPath enables pinning of maps, programs and links under pinPath, which should
+// be a directory on bpffs. Maps pinned by a previous process will be reused when the
+// BPFModule is loaded, and programs whose links were pinned will be treated as attached,
+// so the state of the eBPF programs survives the restart of the process.
+func WithPinPath(pinPath string) Option {
+	return func(b *BPFManager) error {
+		if pinPath == "" {
+			return nil
+		}
+
+		if !isBPFFS(filepath.Dir(pinPath)) {
+			return fmt.Errorf("pin path %v is not on a mounted bpffs", pinPath)
+		}
+
+		for _, dir := range []string{pinMapsDir, pinProgsDir, pinLinksDir} {
+			if err := os.MkdirAll(filepath.Join(pinPath, dir), 0700); err != nil {
+				return err
+			}
+		}
+		b.pinPath = pinPath
+
+		return nil
+	}
+}
+
+// UnpinAll removes everything pinned under pinPath. Links are removed first so the programs
+// get detached from their hook points, then programs and maps are released.
+func UnpinAll(pinPath string) error {
+	if _, err := os.Stat(pinPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if !isBPFFS(pinPath) {
+		return fmt.Errorf("refuse to remove %v which is not on a mounted bpffs", pinPath)
+	}
+
+	for _, dir := range []string{pinLinksDir, pinProgsDir, pinMapsDir} {
+		if err := os.RemoveAll(filepath.Join(pinPath, dir)); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(pinPath)
+}
+
+func isBPFFS(path string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return false
+	}
+
+	return uint32(stat.Type) == bpfFSMagic
+}
+
+// pinMapsOr sets the pin path of every map before the BPFModule is loaded, libbpf
+// will reuse the map if it has already been pinned, or pin the newly created one.
+func (manager *BPFManager) pinMapsOr() error {
+	if manager.pinPath == "" {
+		return nil
+	}
+
+	iter := manager.bpfModule.Iterator()
+	for bpfMap := iter.NextMap(); bpfMap != nil; bpfMap = iter.NextMap() {
+		if strings.Contains(bpfMap.Name(), ".") {
+			// internal maps like .rodata and .bss belong to this object only
+			continue
+		}
+
+		if err := bpfMap.SetPinPath(filepath.Join(manager.pinPath, pinMapsDir, bpfMap.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pinProgramsOr pins every program of the loaded BPFModule, replacing the programs
+// pinned by a previous process.
+func (manager *BPFManager) pinProgramsOr() error {
+	if manager.pinPath == "" {
+		return nil
+	}
+
+	iter := manager.bpfModule.Iterator()
+	for bpfProg := iter.NextProgram(); bpfProg != nil; bpfProg = iter.NextProgram() {
+		progPinPath := filepath.Join(manager.pinPath, pinProgsDir, bpfProg.GetName())
+		if err := os.Remove(progPinPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if err := bpfProg.Pin(progPinPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// linkPinPath returns where the link of prog is pinned, or an empty string when
+// pinning is disabled.
+func (manager *BPFManager) linkPinPath(prog probe.BPFProgram) string {
+	if manager.pinPath == "" {
+		return ""
+	}
+
+	hookPoint := strings.ReplaceAll(prog.GetHookPoint(), "/", "_")
+	return filepath.Join(manager.pinPath, pinLinksDir, prog.GetName()+"@"+hookPoint)
+}
+
+// isAttachedByPin reports whether prog is still attached through the link pinned
+// by a previous process, a defunct link left pinned is not.
+func (manager *BPFManager) isAttachedByPin(prog probe.BPFProgram) bool {
+	linkPinPath := manager.linkPinPath(prog)
+	if linkPinPath == "" || prog.GetBPFLink() != nil {
+		return false
+	}
+
+	info, err := readPinnedLink(linkPinPath)
+	return err == nil && !info.defunct()
+}
+
+// attachOr attaches prog to its hook point and pins the link, it does nothing when the
+// program is still attached through the pinned link.
+func (manager *BPFManager) attachOr(prog probe.BPFProgram) error {
+	if manager.isAttachedByPin(prog) {
+		logrus.WithFields(logrus.Fields{
+			"progName":  prog.GetName(),
+			"hookpoint": prog.GetHookPoint(),
+		}).Info("reuse the bpfProgram attached through pinned link")
+		return nil
+	}
+
+	if err := prog.Attach(manager.bpfModule); err != nil {
+		return err
+	}
+
+	// programs attached without bpf_link, e.g. tc programs, can not be pinned
+	if linkPinPath := manager.linkPinPath(prog); linkPinPath != "" && prog.GetBPFLink() != nil {
+		// replaces the defunct link pinned by a previous process
+		if err := os.Remove(linkPinPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := prog.GetBPFLink().Pin(linkPinPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// detachOr detaches prog from its hook point and removes its pinned link.
+func (manager *BPFManager) detachOr(prog probe.BPFProgram) error {
+	if err := prog.Detach(manager.bpfModule); err != nil {
+		return err
+	}
+
+	if linkPinPath := manager.linkPinPath(prog); linkPinPath != "" {
+		// the program keeps attached until the last reference of link is released
+		if err := os.Remove(linkPinPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package probe
+
+import (
+	"fmt"
+
+	"github.com/aquasecurity/libbpfgo"
+	"golang.org/x/sys/unix"
+)
+
+// NewCgroupSkbProgram returns a BPFProgram which attaches the cgroup_skb program with given
+// progName to the ingress or egress of cgroup, e.g. "/sys/fs/cgroup/system.slice".
+func NewCgroupSkbProgram(progName string, cgroupPath string, direction string) BPFProgram {
+	return &cgroupSkbProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: "cgroup_skb"}, cgroupPath, direction}
+}
+
+type cgroupSkbProgram struct {
+	*genericMeta
+	cgroupPath string
+	direction  string
+}
+
+func (prog *cgroupSkbProgram) GetHookPoint() string {
+	return prog.hookPoint + "/" + prog.cgroupPath + "/" + prog.direction
+}
+
+func (prog *cgroupSkbProgram) GetProgType() libbpfgo.BPFProgType {
+	return libbpfgo.BPFProgTypeCgroupSkb
+}
+
+// Attach creates a bpf_link between the program and the cgroup by BPF_LINK_CREATE, since
+// libbpfgo has not supported attaching cgroup programs yet.
+func (prog *cgroupSkbProgram) Attach(bpfModule *libbpfgo.Module) error {
+	return prog.attachLink(bpfModule, "(*cgroupSkbProgram) attach", prog.GetHookPoint(),
+		func(bpfProg *libbpfgo.BPFProg) (Link, error) {
+			var attachType uint32
+			switch prog.direction {
+			case INGRESS:
+				attachType = unix.BPF_CGROUP_INET_INGRESS
+			case EGRESS:
+				attachType = unix.BPF_CGROUP_INET_EGRESS
+			default:
+				return nil, fmt.Errorf("unknown cgroup_skb direction %v", prog.direction)
+			}
+
+			cgroupFd, err := unix.Open(prog.cgroupPath, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+			if err != nil {
+				return nil, fmt.Errorf("cannot open cgroup %v: %w", prog.cgroupPath, err)
+			}
+			defer unix.Close(cgroupFd)
+
+			return createLink(bpfProg.GetFd(), cgroupFd, attachType, 0)
+		})
+}
+
+func (prog *cgroupSkbProgram) Detach(bpfModule *libbpfgo.Module) error {
+	return prog.detachLink("(*cgroupSkbProgram) detach", prog.GetHookPoint())
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package probe
+
+import (
+	"fmt"
+
+	"github.com/aquasecurity/libbpfgo"
+	"github.com/aquasecurity/libbpfgo/helpers"
+)
+
+// NewKprobeProgram returns a BPFProgram which attaches the kprobe program with given
+// progName to the entry of kernel function symbol.
+func NewKprobeProgram(progName string, symbol string) BPFProgram {
+	return &kprobeProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: "kprobe"}, symbol, false}
+}
+
+// NewKretprobeProgram returns a BPFProgram which attaches the kretprobe program with given
+// progName to the return of kernel function symbol.
+func NewKretprobeProgram(progName string, symbol string) BPFProgram {
+	return &kprobeProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: "kretprobe"}, symbol, true}
+}
+
+type kprobeProgram struct {
+	*genericMeta
+	symbol   string
+	isReturn bool
+}
+
+func (prog *kprobeProgram) GetHookPoint() string {
+	return prog.hookPoint + "/" + prog.symbol
+}
+
+func (prog *kprobeProgram) GetProgType() libbpfgo.BPFProgType {
+	return libbpfgo.BPFProgTypeKprobe
+}
+
+func (prog *kprobeProgram) Attach(bpfModule *libbpfgo.Module) error {
+	return prog.attachLink(bpfModule, "(*kprobeProgram) attach", prog.GetHookPoint(),
+		func(bpfProg *libbpfgo.BPFProg) (Link, error) {
+			if prog.isReturn {
+				return bpfProg.AttachKretprobe(prog.symbol)
+			}
+			return bpfProg.AttachKprobe(prog.symbol)
+		})
+}
+
+func (prog *kprobeProgram) Detach(bpfModule *libbpfgo.Module) error {
+	return prog.detachLink("(*kprobeProgram) detach", prog.GetHookPoint())
+}
+
+// NewUprobeProgram returns a BPFProgram which attaches the uprobe program with given progName
+// to the entry of function symbol in the binary. A pid of -1 traces all processes.
+func NewUprobeProgram(progName string, binaryPath string, symbol string, pid int) BPFProgram {
+	return &uprobeProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: "uprobe"}, binaryPath, symbol, pid, false}
+}
+
+// NewUretprobeProgram returns a BPFProgram which attaches the uretprobe program with given progName
+// to the return of function symbol in the binary. A pid of -1 traces all processes.
+func NewUretprobeProgram(progName string, binaryPath string, symbol string, pid int) BPFProgram {
+	return &uprobeProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: "uretprobe"}, binaryPath, symbol, pid, true}
+}
+
+type uprobeProgram struct {
+	*genericMeta
+	binaryPath string
+	symbol     string
+	pid        int
+	isReturn   bool
+}
+
+func (prog *uprobeProgram) GetHookPoint() string {
+	return prog.hookPoint + "/" + prog.binaryPath + ":" + prog.symbol
+}
+
+func (prog *uprobeProgram) GetProgType() libbpfgo.BPFProgType {
+	return libbpfgo.BPFProgTypeKprobe
+}
+
+func (prog *uprobeProgram) Attach(bpfModule *libbpfgo.Module) error {
+	return prog.attachLink(bpfModule, "(*uprobeProgram) attach", prog.GetHookPoint(),
+		func(bpfProg *libbpfgo.BPFProg) (Link, error) {
+			offset, err := helpers.SymbolToOffset(prog.binaryPath, prog.symbol)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve symbol %v in %v: %w", prog.symbol, prog.binaryPath, err)
+			}
+
+			if prog.isReturn {
+				return bpfProg.AttachURetprobe(prog.pid, prog.binaryPath, offset)
+			}
+			return bpfProg.AttachUprobe(prog.pid, prog.binaryPath, offset)
+		})
+}
+
+func (prog *uprobeProgram) Detach(bpfModule *libbpfgo.Module) error {
+	return prog.detachLink("(*uprobeProgram) detach", prog.GetHookPoint())
+}
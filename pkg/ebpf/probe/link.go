@@ -0,0 +1,87 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package probe
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Link is the attachment of an eBPF program to its hook point, the program will be
+// detached once the link is destroyed and all its pins are removed. *libbpfgo.BPFLink
+// satisfies the interface.
+type Link interface {
+	Pin(pinPath string) error
+	GetFd() int
+	Destroy() error
+}
+
+// fdLink is a bpf_link created by BPF_LINK_CREATE directly, it is used where libbpfgo
+// can not create the link we need, e.g. cgroup programs and XDP programs with attach flags.
+type fdLink struct {
+	fd int
+}
+
+// linkCreateAttr is the link_create part of union bpf_attr used by BPF_LINK_CREATE.
+type linkCreateAttr struct {
+	progFd     uint32
+	targetFd   uint32
+	attachType uint32
+	flags      uint32
+}
+
+// objPinAttr is the part of union bpf_attr used by BPF_OBJ_PIN.
+type objPinAttr struct {
+	pathname  uint64
+	bpfFd     uint32
+	fileFlags uint32
+}
+
+func createLink(progFd int, targetFd int, attachType uint32, flags uint32) (*fdLink, error) {
+	attr := linkCreateAttr{
+		progFd:     uint32(progFd),
+		targetFd:   uint32(targetFd),
+		attachType: attachType,
+		flags:      flags,
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return nil, fmt.Errorf("failed to create bpf_link: %w", errno)
+	}
+
+	return &fdLink{fd: int(fd)}, nil
+}
+
+func (link *fdLink) Pin(pinPath string) error {
+	path, err := unix.BytePtrFromString(pinPath)
+	if err != nil {
+		return err
+	}
+
+	attr := objPinAttr{
+		pathname: uint64(uintptr(unsafe.Pointer(path))),
+		bpfFd:    uint32(link.fd),
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_PIN, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(path)
+	if errno != 0 {
+		return fmt.Errorf("failed to pin bpf_link to %v: %w", pinPath, errno)
+	}
+
+	return nil
+}
+
+func (link *fdLink) GetFd() int {
+	return link.fd
+}
+
+func (link *fdLink) Destroy() error {
+	return unix.Close(link.fd)
+}
@@ -6,94 +6,173 @@ package probe
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/aquasecurity/libbpfgo"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 // BPFProgram is an interface that used in BPFManager for maintaing different BPFProgram type.
 // It should provide these method for BPFManager, which can help to manage their lifecycle.
 // attach will attach specific BPFProgram to its hook point
 // detach will detach specific BPFProgram from its hook point
+// GetProgType returns the type which the eBPF program should be loaded as
+// GetBPFLink returns the link created by attach, it will be nil if the program is not attached
 type BPFProgram interface {
 	Attach(*libbpfgo.Module) error
 	Detach(*libbpfgo.Module) error
 	GetName() string
 	GetHookPoint() string
+	GetProgType() libbpfgo.BPFProgType
+	GetBPFLink() Link
 }
 
-var allBPFProgs []BPFProgram = []BPFProgram{
-	// &xdpProgram{&genericMeta{programName: "__test_trace_xdp", bpfLink: nil, hookPoint: "xdp"}, "ens33", 1},
-	&tracepointProgram{&genericMeta{programName: "tracepoint__syscalls__sys_enter_execve", bpfLink: nil, hookPoint: "syscalls"}, "sys_enter_execve"},
-	&tracepointProgram{&genericMeta{programName: "tracepoint__syscalls__sys_exit_execve", bpfLink: nil, hookPoint: "syscalls"}, "sys_exit_execve"},
-	&xdpProgram{&genericMeta{programName: "xdp_proxy", bpfLink: nil, hookPoint: "xdp"}, "ens33", 0},
-	&xdpProgram{&genericMeta{programName: "__test_trace_xdp", bpfLink: nil, hookPoint: "xdp"}, "ens33", 0},
+// PreLoader is implemented by the BPFProgram which needs to configure its eBPF program
+// before the BPFModule is loaded, e.g. setting the attach target of fentry program.
+type PreLoader interface {
+	PreLoad(*libbpfgo.Module) error
 }
 
-func GetAllBPFProgs() []BPFProgram {
-	return allBPFProgs
+// NewTracepointProgram returns a BPFProgram which attaches the tracepoint program with given
+// progName to the tracePoint of category, e.g. "syscalls" and "sys_enter_execve".
+func NewTracepointProgram(progName string, category string, tracePoint string) BPFProgram {
+	return &tracepointProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: category}, tracePoint}
+}
+
+// NewXDPProgram returns a BPFProgram which will attach the XDP program with given progName
+// to the targetDevice in the given attachMode.
+func NewXDPProgram(progName string, targetDevice string, attachMode uint32) BPFProgram {
+	return &xdpProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: "xdp"}, targetDevice, attachMode}
 }
 
 type genericMeta struct {
 	programName string
-	bpfLink     *libbpfgo.BPFLink
+	bpfLink     Link
 	hookPoint   string
 }
 
-type xdpProgram struct {
-	*genericMeta
-	targetDevice string
-	attachMode   uint32
+func (meta *genericMeta) GetBPFLink() Link {
+	return meta.bpfLink
 }
 
-func (prog *xdpProgram) GetHookPoint() string {
-	return "xdp"
+func (meta *genericMeta) GetName() string {
+	return meta.programName
 }
 
-func (prog *xdpProgram) GetName() string {
-	return prog.programName
+// getProgram returns the eBPF program with given name in the loaded bpfModule.
+func getProgram(bpfModule *libbpfgo.Module, progName string) (*libbpfgo.BPFProg, error) {
+	if bpfModule == nil {
+		return nil, fmt.Errorf("bpfModule has not been initialized properly yet")
+	}
+
+	bpfProg, err := bpfModule.GetProgram(progName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get specific eBPF program from name=%v: %w", progName, err)
+	}
+
+	return bpfProg, nil
 }
 
-func (prog *xdpProgram) Attach(bpfModule *libbpfgo.Module) error {
-	if prog.bpfLink != nil {
+// attachLink attaches the program with attachFunc and keeps the returned link, it is
+// shared by the BPFPrograms whose attachment is represented by a bpf_link.
+func (meta *genericMeta) attachLink(bpfModule *libbpfgo.Module, location string, hookPoint string,
+	attachFunc func(*libbpfgo.BPFProg) (Link, error)) error {
+	if meta.bpfLink != nil {
 		logrus.WithFields(logrus.Fields{
-			"location":  "(*xdpProgram) attach",
-			"interface": prog.targetDevice,
-		}).Warning("the xdpProgram has already been attached")
+			"location":  location,
+			"hookpoint": hookPoint,
+		}).Warningf("bpfProgram %v has already been attached", meta.programName)
 		return nil
 	}
 
-	if bpfModule == nil {
+	bpfProg, err := getProgram(bpfModule, meta.programName)
+	if err != nil {
 		logrus.WithFields(logrus.Fields{
-			"location":  "(*xdpProgram) attach",
-			"interface": prog.targetDevice,
-		}).Warning("bpfModule has not been initialized properly yet")
-		return fmt.Errorf("bpfModule has not been initialized properly yet")
+			"err":       err,
+			"location":  location,
+			"hookpoint": hookPoint,
+		}).Warning("cannot get specific eBPF program")
+		return err
 	}
 
-	bpfProg, err := bpfModule.GetProgram(string(prog.programName))
+	bpfLink, err := attachFunc(bpfProg)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err":       err,
-			"location":  "(*xdpProgram) attach",
-			"interface": prog.targetDevice,
-		}).Warning("cannot get specific eBPF program from name=%v", prog.programName)
+			"location":  location,
+			"hookpoint": hookPoint,
+		}).Warning("error occurs when attach target BPF Program to its hook point")
 		return err
 	}
 
-	prog.bpfLink, err = bpfProg.AttachXDP(prog.targetDevice)
-	if err != nil {
+	meta.bpfLink = bpfLink
+
+	logrus.Infof("Attach %v to %v", meta.programName, hookPoint)
+	return nil
+}
+
+// detachLink destroys the link kept by attachLink.
+func (meta *genericMeta) detachLink(location string, hookPoint string) error {
+	if meta.bpfLink == nil {
+		// BPFProgram has already been detached, it's okay that method was called more than once.
+		logrus.WithFields(logrus.Fields{
+			"location":  location,
+			"hookpoint": hookPoint,
+		}).Warningf("bpfProgram %v has already been detached, but method get called", meta.programName)
+		return nil
+	}
+
+	if err := meta.bpfLink.Destroy(); err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err":       err,
-			"location":  "(*xdpProgram) attach",
-			"interface": prog.targetDevice,
-		}).Warning("error occurs when attach target BPF Program to given interface")
+			"location":  location,
+			"hookpoint": hookPoint,
+		}).Warningf("error occurs when try to detach bpfProgram %v", meta.programName)
 		return err
 	}
 
+	meta.bpfLink = nil
+
 	return nil
 }
 
+type xdpProgram struct {
+	*genericMeta
+	targetDevice string
+	attachMode   uint32
+}
+
+func (prog *xdpProgram) GetHookPoint() string {
+	return "xdp/" + prog.targetDevice
+}
+
+func (prog *xdpProgram) GetProgType() libbpfgo.BPFProgType {
+	return libbpfgo.BPFProgTypeXdp
+}
+
+func (prog *xdpProgram) GetName() string {
+	return prog.programName
+}
+
+// Attach attaches the XDP program to targetDevice through bpf_link. The link is created by
+// BPF_LINK_CREATE directly when attachMode is given, since libbpfgo always attaches
+// XDP program without flags.
+func (prog *xdpProgram) Attach(bpfModule *libbpfgo.Module) error {
+	return prog.attachLink(bpfModule, "(*xdpProgram) attach", prog.GetHookPoint(),
+		func(bpfProg *libbpfgo.BPFProg) (Link, error) {
+			if prog.attachMode == 0 {
+				return bpfProg.AttachXDP(prog.targetDevice)
+			}
+
+			iface, err := net.InterfaceByName(prog.targetDevice)
+			if err != nil {
+				return nil, err
+			}
+			return createLink(bpfProg.GetFd(), iface.Index, unix.BPF_XDP, prog.attachMode)
+		})
+}
+
 func (prog *xdpProgram) Detach(bpfModule *libbpfgo.Module) error {
 	if prog.bpfLink == nil {
 		// BPFProgram has already been detached, it's okay that method was called more than once.
@@ -136,6 +215,10 @@ func (prog *tracepointProgram) GetHookPoint() string {
 	return prog.tracePoint
 }
 
+func (prog *tracepointProgram) GetProgType() libbpfgo.BPFProgType {
+	return libbpfgo.BPFProgTypeTracepoint
+}
+
 func (prog *tracepointProgram) GetName() string {
 	return prog.programName
 }
@@ -163,7 +246,7 @@ func (prog *tracepointProgram) Attach(bpfModule *libbpfgo.Module) error {
 			"err":        err,
 			"location":   "(*tracepointProgram) attach",
 			"tracepoint": prog.tracePoint,
-		}).Warning("cannot get specific eBPF program from name=%v", prog.programName)
+		}).Warningf("cannot get specific eBPF program from name=%v", prog.programName)
 		return err
 	}
 
@@ -0,0 +1,168 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package probe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/p1nant0m/xdp-tracing/config"
+)
+
+// Kinds of BPFProgram that can be declared in ProbeSpec.
+const (
+	KIND_XDP        = "xdp"
+	KIND_TC         = "tc"
+	KIND_TRACEPOINT = "tracepoint"
+	KIND_RAW_TP     = "raw_tp"
+	KIND_KPROBE     = "kprobe"
+	KIND_KRETPROBE  = "kretprobe"
+	KIND_UPROBE     = "uprobe"
+	KIND_URETPROBE  = "uretprobe"
+	KIND_CGROUP_SKB = "cgroup_skb"
+	KIND_FENTRY     = "fentry"
+)
+
+// Attach modes of XDP program that can be declared in ProbeSpec.
+const (
+	XDP_MODE_SKB = "skb"
+	XDP_MODE_DRV = "drv"
+	XDP_MODE_HW  = "hw"
+)
+
+// ProbeSpec declares an eBPF program in the object and where it should be attached,
+// it is usually read from the probes section of configuration file.
+type ProbeSpec struct {
+	Name       string   `yaml:"name"`       // name of the program in the object
+	Kind       string   `yaml:"kind"`       // one of KIND_*
+	Interfaces []string `yaml:"interfaces"` // xdp and tc: the program is attached to every interface
+	Mode       string   `yaml:"mode"`       // xdp: skb, drv or hw, empty to let the kernel choose
+	Direction  string   `yaml:"direction"`  // tc and cgroup_skb: ingress or egress
+	Target     string   `yaml:"target"`     // "category:name" of tracepoint, raw tracepoint, kernel function, user symbol or cgroup path
+	Binary     string   `yaml:"binary"`     // uprobe: path of the binary
+	Pid        int      `yaml:"pid"`        // uprobe: pid of the traced process, 0 for all processes
+}
+
+// NewBPFPrograms returns the BPFPrograms declared by specs. The programs attached to
+// interfaces are expanded into one BPFProgram per interface.
+func NewBPFPrograms(specs []ProbeSpec) ([]BPFProgram, error) {
+	var programs []BPFProgram
+
+	for _, spec := range specs {
+		progs, err := spec.newBPFPrograms()
+		if err != nil {
+			return nil, fmt.Errorf("invalid probe %v: %w", spec.Name, err)
+		}
+		programs = append(programs, progs...)
+	}
+
+	return programs, nil
+}
+
+func (spec *ProbeSpec) newBPFPrograms() ([]BPFProgram, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("missing program name")
+	}
+
+	switch spec.Kind {
+	case KIND_XDP, KIND_TC:
+		return spec.newInterfacePrograms()
+	}
+
+	if spec.Target == "" && spec.Kind != KIND_FENTRY {
+		return nil, fmt.Errorf("missing target of %v program", spec.Kind)
+	}
+
+	var prog BPFProgram
+	switch spec.Kind {
+	case KIND_TRACEPOINT:
+		category, name, found := strings.Cut(spec.Target, ":")
+		if !found {
+			return nil, fmt.Errorf("tracepoint target %v should be in format category:name", spec.Target)
+		}
+		prog = NewTracepointProgram(spec.Name, category, name)
+	case KIND_RAW_TP:
+		prog = NewRawTracepointProgram(spec.Name, spec.Target)
+	case KIND_KPROBE:
+		prog = NewKprobeProgram(spec.Name, spec.Target)
+	case KIND_KRETPROBE:
+		prog = NewKretprobeProgram(spec.Name, spec.Target)
+	case KIND_UPROBE, KIND_URETPROBE:
+		if spec.Binary == "" {
+			return nil, fmt.Errorf("missing binary of %v program", spec.Kind)
+		}
+		pid := spec.Pid
+		if pid == 0 {
+			pid = -1
+		}
+		if spec.Kind == KIND_UPROBE {
+			prog = NewUprobeProgram(spec.Name, spec.Binary, spec.Target, pid)
+		} else {
+			prog = NewUretprobeProgram(spec.Name, spec.Binary, spec.Target, pid)
+		}
+	case KIND_CGROUP_SKB:
+		if err := checkDirection(spec.Direction); err != nil {
+			return nil, err
+		}
+		prog = NewCgroupSkbProgram(spec.Name, spec.Target, spec.Direction)
+	case KIND_FENTRY:
+		prog = NewFentryProgram(spec.Name, spec.Target)
+	default:
+		return nil, fmt.Errorf("unknown probe kind %q", spec.Kind)
+	}
+
+	return []BPFProgram{prog}, nil
+}
+
+func (spec *ProbeSpec) newInterfacePrograms() ([]BPFProgram, error) {
+	if len(spec.Interfaces) == 0 {
+		return nil, fmt.Errorf("missing interfaces of %v program", spec.Kind)
+	}
+
+	var progs []BPFProgram
+	switch spec.Kind {
+	case KIND_XDP:
+		attachMode, err := ParseXDPMode(spec.Mode)
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range spec.Interfaces {
+			progs = append(progs, NewXDPProgram(spec.Name, iface, attachMode))
+		}
+	case KIND_TC:
+		if err := checkDirection(spec.Direction); err != nil {
+			return nil, err
+		}
+		for _, iface := range spec.Interfaces {
+			progs = append(progs, NewTCProgram(spec.Name, iface, spec.Direction))
+		}
+	}
+
+	return progs, nil
+}
+
+// ParseXDPMode converts the XDP mode name into XDP_FLAGS_*_MODE, an empty mode
+// returns 0 which lets the kernel choose the best mode.
+func ParseXDPMode(mode string) (uint32, error) {
+	switch mode {
+	case "":
+		return 0, nil
+	case XDP_MODE_SKB:
+		return config.XDP_FLAGS_SKB_MODE, nil
+	case XDP_MODE_DRV:
+		return config.XDP_FLAGS_DRV_MODE, nil
+	case XDP_MODE_HW:
+		return config.XDP_FLAGS_HW_MODE, nil
+	}
+
+	return 0, fmt.Errorf("unknown xdp mode %q, should be one of skb, drv and hw", mode)
+}
+
+func checkDirection(direction string) error {
+	if direction != INGRESS && direction != EGRESS {
+		return fmt.Errorf("direction should be %v or %v, got %q", INGRESS, EGRESS, direction)
+	}
+	return nil
+}
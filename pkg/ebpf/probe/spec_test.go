@@ -0,0 +1,54 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package probe
+
+import (
+	"testing"
+
+	"github.com/p1nant0m/xdp-tracing/config"
+)
+
+func TestNewBPFProgramsPerInterface(t *testing.T) {
+	progs, err := NewBPFPrograms([]ProbeSpec{
+		{Name: "xdp_proxy", Kind: KIND_XDP, Interfaces: []string{"eth0", "eth1"}, Mode: XDP_MODE_DRV},
+		{Name: "handle_execve", Kind: KIND_TRACEPOINT, Target: "syscalls:sys_enter_execve"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(progs) != 3 {
+		t.Fatalf("Expected 3 programs, got %v", len(progs))
+	}
+
+	for i, iface := range []string{"eth0", "eth1"} {
+		xdp, ok := progs[i].(*xdpProgram)
+		if !ok || xdp.targetDevice != iface || xdp.attachMode != config.XDP_FLAGS_DRV_MODE {
+			t.Errorf("Expected xdp program on %v in drv mode, got %+v", iface, progs[i])
+		}
+	}
+
+	if got := progs[2].GetHookPoint(); got != "sys_enter_execve" {
+		t.Errorf("Expected hook point sys_enter_execve, got %v", got)
+	}
+}
+
+func TestNewBPFProgramsInvalidSpec(t *testing.T) {
+	invalidSpecs := []ProbeSpec{
+		{Name: "xdp_proxy", Kind: KIND_XDP},
+		{Name: "xdp_proxy", Kind: KIND_XDP, Interfaces: []string{"eth0"}, Mode: "native"},
+		{Name: "tc_egress", Kind: KIND_TC, Interfaces: []string{"eth0"}, Direction: "both"},
+		{Name: "handle_execve", Kind: KIND_TRACEPOINT, Target: "sys_enter_execve"},
+		{Name: "handle_readline", Kind: KIND_UPROBE, Target: "readline"},
+		{Name: "foo", Kind: "perf_event", Target: "foo"},
+		{Kind: KIND_KPROBE, Target: "tcp_connect"},
+	}
+
+	for _, spec := range invalidSpecs {
+		if _, err := NewBPFPrograms([]ProbeSpec{spec}); err == nil {
+			t.Errorf("Expected an error for spec %+v, got %v", spec, err)
+		}
+	}
+}
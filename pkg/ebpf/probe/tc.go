@@ -0,0 +1,175 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"github.com/aquasecurity/libbpfgo"
+	"github.com/sirupsen/logrus"
+)
+
+// Directions of the traffic that tc and cgroup_skb programs are attached to.
+const (
+	INGRESS = "ingress"
+	EGRESS  = "egress"
+)
+
+// clsactRefs counts the tc programs attached to the clsact qdisc created by us on every
+// interface, the qdisc is destroyed when the last of them is detached. The qdisc which
+// already exists before attaching is left untouched.
+var clsactRefs = struct {
+	sync.Mutex
+	refs map[int]int
+}{refs: map[int]int{}}
+
+// NewTCProgram returns a BPFProgram which attaches the classifier program with given progName
+// to the ingress or egress hook of targetDevice. The clsact qdisc will be created when
+// it does not exist on the device.
+func NewTCProgram(progName string, targetDevice string, direction string) BPFProgram {
+	return &tcProgram{genericMeta: &genericMeta{programName: progName, bpfLink: nil, hookPoint: "tc"},
+		targetDevice: targetDevice, direction: direction}
+}
+
+type tcProgram struct {
+	*genericMeta
+	targetDevice string
+	direction    string
+	tcHook       *libbpfgo.TcHook
+	tcOpts       *libbpfgo.TcOpts
+	ownQdisc     bool
+}
+
+func (prog *tcProgram) GetHookPoint() string {
+	return prog.hookPoint + "/" + prog.targetDevice + "/" + prog.direction
+}
+
+func (prog *tcProgram) GetProgType() libbpfgo.BPFProgType {
+	return libbpfgo.BPFProgTypeSchedCls
+}
+
+func (prog *tcProgram) Attach(bpfModule *libbpfgo.Module) error {
+	if prog.tcHook != nil {
+		logrus.WithFields(logrus.Fields{
+			"location":  "(*tcProgram) attach",
+			"hookpoint": prog.GetHookPoint(),
+		}).Warning("the tcProgram has already been attached")
+		return nil
+	}
+
+	err := prog.attach(bpfModule)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":       err,
+			"location":  "(*tcProgram) attach",
+			"hookpoint": prog.GetHookPoint(),
+		}).Warning("error occurs when attach target BPF Program to given interface")
+		return err
+	}
+
+	logrus.Infof("Attach %v to %v", prog.programName, prog.GetHookPoint())
+	return nil
+}
+
+func (prog *tcProgram) attach(bpfModule *libbpfgo.Module) error {
+	bpfProg, err := getProgram(bpfModule, prog.programName)
+	if err != nil {
+		return err
+	}
+
+	var attachPoint libbpfgo.TcAttachPoint
+	switch prog.direction {
+	case INGRESS:
+		attachPoint = libbpfgo.BPFTcIngress
+	case EGRESS:
+		attachPoint = libbpfgo.BPFTcEgress
+	default:
+		return fmt.Errorf("unknown tc direction %v", prog.direction)
+	}
+
+	tcHook := bpfModule.TcHookInit()
+	if err := tcHook.SetInterfaceByName(prog.targetDevice); err != nil {
+		return err
+	}
+	tcHook.SetAttachPoint(attachPoint)
+
+	clsactRefs.Lock()
+	defer clsactRefs.Unlock()
+
+	ownQdisc := true
+	if err := tcHook.Create(); err != nil {
+		if !errors.Is(err, syscall.EEXIST) {
+			return err
+		}
+		// the clsact qdisc was created by others or by our other tc programs
+		ownQdisc = clsactRefs.refs[tcHook.GetInterfaceIndex()] > 0
+	}
+
+	// the handle and the priority of the filter are left to the kernel so that the programs
+	// attached to the same hook never replace each other, they are filled in by Attach and
+	// kept in tcOpts for Detach
+	tcOpts := &libbpfgo.TcOpts{ProgFd: bpfProg.GetFd()}
+	if err := tcHook.Attach(tcOpts); err != nil {
+		if ownQdisc && clsactRefs.refs[tcHook.GetInterfaceIndex()] == 0 {
+			tcHook.SetAttachPoint(libbpfgo.BPFTcIngressEgress)
+			tcHook.Destroy()
+		}
+		return err
+	}
+
+	if ownQdisc {
+		clsactRefs.refs[tcHook.GetInterfaceIndex()]++
+	}
+	prog.tcHook, prog.tcOpts, prog.ownQdisc = tcHook, tcOpts, ownQdisc
+
+	return nil
+}
+
+func (prog *tcProgram) Detach(bpfModule *libbpfgo.Module) error {
+	if prog.tcHook == nil {
+		// BPFProgram has already been detached, it's okay that method was called more than once.
+		logrus.WithFields(logrus.Fields{
+			"location":  "(*tcProgram) detach",
+			"hookpoint": prog.GetHookPoint(),
+		}).Warningf("bpfProgram %v has already been detached, but method get called", prog.programName)
+		return nil
+	}
+
+	// bpf_tc_detach requires prog_fd, prog_id and flags to be zero, the filter is
+	// identified by handle and priority
+	tcOpts := &libbpfgo.TcOpts{Handle: prog.tcOpts.Handle, Priority: prog.tcOpts.Priority}
+	if err := prog.tcHook.Detach(tcOpts); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"location":  "(*tcProgram) detach",
+			"err":       err,
+			"hookpoint": prog.GetHookPoint(),
+		}).Warningf("error occurs when try to detach tcProgram %v from %v", prog.programName, prog.GetHookPoint())
+		return err
+	}
+
+	if prog.ownQdisc {
+		clsactRefs.Lock()
+		ifindex := prog.tcHook.GetInterfaceIndex()
+		if clsactRefs.refs[ifindex]--; clsactRefs.refs[ifindex] <= 0 {
+			delete(clsactRefs.refs, ifindex)
+			prog.tcHook.SetAttachPoint(libbpfgo.BPFTcIngressEgress)
+			if err := prog.tcHook.Destroy(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"location":  "(*tcProgram) detach",
+					"err":       err,
+					"interface": prog.targetDevice,
+				}).Warning("error occurs when try to remove clsact qdisc")
+			}
+		}
+		clsactRefs.Unlock()
+	}
+
+	prog.tcHook, prog.tcOpts, prog.ownQdisc = nil, nil, false
+
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package probe
+
+import (
+	"github.com/aquasecurity/libbpfgo"
+)
+
+// NewRawTracepointProgram returns a BPFProgram which attaches the raw tracepoint program
+// with given progName to tracePoint, e.g. "sched_process_exec".
+func NewRawTracepointProgram(progName string, tracePoint string) BPFProgram {
+	return &rawTracepointProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: "raw_tp"}, tracePoint}
+}
+
+type rawTracepointProgram struct {
+	*genericMeta
+	tracePoint string
+}
+
+func (prog *rawTracepointProgram) GetHookPoint() string {
+	return prog.hookPoint + "/" + prog.tracePoint
+}
+
+func (prog *rawTracepointProgram) GetProgType() libbpfgo.BPFProgType {
+	return libbpfgo.BPFProgTypeRawTracepoint
+}
+
+func (prog *rawTracepointProgram) Attach(bpfModule *libbpfgo.Module) error {
+	return prog.attachLink(bpfModule, "(*rawTracepointProgram) attach", prog.GetHookPoint(),
+		func(bpfProg *libbpfgo.BPFProg) (Link, error) {
+			return bpfProg.AttachRawTracepoint(prog.tracePoint)
+		})
+}
+
+func (prog *rawTracepointProgram) Detach(bpfModule *libbpfgo.Module) error {
+	return prog.detachLink("(*rawTracepointProgram) detach", prog.GetHookPoint())
+}
+
+// NewFentryProgram returns a BPFProgram which attaches the fentry/fexit program with given
+// progName to kernel function. The function overrides the target defined in SEC() of the
+// program, leave it empty to use the one in SEC().
+func NewFentryProgram(progName string, function string) BPFProgram {
+	return &fentryProgram{&genericMeta{programName: progName, bpfLink: nil, hookPoint: "fentry"}, function}
+}
+
+type fentryProgram struct {
+	*genericMeta
+	function string
+}
+
+func (prog *fentryProgram) GetHookPoint() string {
+	if prog.function == "" {
+		return prog.hookPoint + "/" + prog.programName
+	}
+	return prog.hookPoint + "/" + prog.function
+}
+
+func (prog *fentryProgram) GetProgType() libbpfgo.BPFProgType {
+	return libbpfgo.BPFProgTypeTracing
+}
+
+// PreLoad sets the attach target of fentry program, which must be done before the
+// BPFModule is loaded since the verifier checks the program against the BTF of target.
+func (prog *fentryProgram) PreLoad(bpfModule *libbpfgo.Module) error {
+	if prog.function == "" {
+		return nil
+	}
+
+	bpfProg, err := getProgram(bpfModule, prog.programName)
+	if err != nil {
+		return err
+	}
+
+	return bpfProg.SetAttachTarget(0, prog.function)
+}
+
+func (prog *fentryProgram) Attach(bpfModule *libbpfgo.Module) error {
+	return prog.attachLink(bpfModule, "(*fentryProgram) attach", prog.GetHookPoint(),
+		func(bpfProg *libbpfgo.BPFProg) (Link, error) {
+			return bpfProg.AttachGeneric()
+		})
+}
+
+func (prog *fentryProgram) Detach(bpfModule *libbpfgo.Module) error {
+	return prog.detachLink("(*fentryProgram) detach", prog.GetHookPoint())
+}
@@ -0,0 +1,183 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/p1nant0m/xdp-tracing/handler"
+	"github.com/p1nant0m/xdp-tracing/handler/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SamplerMapName is the name of BPF_MAP_TYPE_RINGBUF map which the XDP program
+	// pushes packet samples into. It is defined in bpf/headers/sampler.h
+	SamplerMapName = "samples"
+	// SampleConfigMapName is the name of the map holding the SampleConfig
+	SampleConfigMapName = "sample_config"
+	// SampleSnapLen is the maximum number of bytes copied from the head of a packet,
+	// it should be synchronized to SAMPLE_SNAPLEN in bpf/headers/sampler.h
+	SampleSnapLen = 128
+
+	sampleEventsBuffer = 1024
+)
+
+// PacketSample is the record pushed by the XDP sampler for every sampled packet.
+// The layout should be synchronized to struct packet_sample in bpf/headers/sampler.h
+type PacketSample struct {
+	Timestamp uint64 // bpf_ktime_get_ns() when the packet was sampled
+	Ifindex   uint32 // index of the interface the packet arrives at
+	PktLen    uint32 // length of the whole packet
+	CapLen    uint32 // length of bytes captured in Data
+	Action    uint32 // XDP verdict which the program returns for the packet, see Monitored
+	Data      [SampleSnapLen]byte
+}
+
+// SampleConfig is the configuration of the XDP sampler. The layout should be synchronized
+// to struct sample_config in bpf/headers/sampler.h
+type SampleConfig struct {
+	Rate uint32 // one of every Rate packets is sampled, 0 and 1 sample every packet
+}
+
+// SetSampleRate has the XDP programs sample one of every rate packets instead of every
+// packet, so the ring buffer is not flooded under load. It takes effect immediately.
+func (manager *BPFManager) SetSampleRate(rate uint32) error {
+	m, err := GetMap[uint32, SampleConfig](manager, SampleConfigMapName)
+	if err != nil {
+		return err
+	}
+	return m.Put(0, SampleConfig{Rate: rate})
+}
+
+// Verdicts returned by the XDP programs, the values of enum xdp_action in linux/bpf.h
+const (
+	XDP_ABORTED = iota
+	XDP_DROP
+	XDP_PASS
+)
+
+// SampleMonitored is or-ed into the Action of the packets passed by the monitor map which
+// would have been dropped, it should be synchronized to SAMPLE_MONITORED in
+// bpf/headers/sampler.h
+const SampleMonitored = 1 << 8
+
+// Monitored reports whether the packet would have been dropped by a monitor policy.
+func (sample *PacketSample) Monitored() bool {
+	return sample.Action&SampleMonitored != 0
+}
+
+// Verdict returns the XDP verdict returned for the packet.
+func (sample *PacketSample) Verdict() uint32 {
+	return sample.Action &^ SampleMonitored
+}
+
+// DecodePacketSample decodes the raw record received from ring buffer into PacketSample.
+func DecodePacketSample(raw []byte) (*PacketSample, error) {
+	sample := &PacketSample{}
+	if len(raw) < binary.Size(sample) {
+		return nil, fmt.Errorf("packet sample too short: expected %v bytes, got %v", binary.Size(sample), len(raw))
+	}
+
+	if err := binary.Read(bytes.NewReader(raw), hostEndian, sample); err != nil {
+		return nil, err
+	}
+
+	if sample.CapLen > SampleSnapLen {
+		return nil, fmt.Errorf("invalid capture length %v in packet sample", sample.CapLen)
+	}
+
+	return sample, nil
+}
+
+// ToTCPIPHandler parses the captured bytes of the sample and returns the TCP_IP_Handler
+// which is the same as the one produced by handler.StartTCPIPHandler, tagged with the
+// verdict of the XDP programs.
+func (sample *PacketSample) ToTCPIPHandler() (*handler.TCP_IP_Handler, error) {
+	packet := gopacket.NewPacket(sample.Data[:sample.CapLen], layers.LayerTypeEthernet, gopacket.Default)
+	tcpHandler := handler.NewTCPIPHandler()
+	if err := tcpHandler.Handle(packet); err != nil {
+		return nil, err
+	}
+
+	if sample.PktLen > sample.CapLen && tcpHandler.PayloadExist {
+		// the payload was truncated by the sampler, recover its real length
+		tcpHandler.PayloadLen += sample.PktLen - sample.CapLen
+	}
+
+	switch {
+	case sample.Monitored():
+		tcpHandler.Verdict = handler.VERDICT_MONITOR
+	case sample.Verdict() == XDP_DROP:
+		tcpHandler.Verdict = handler.VERDICT_DROP
+	}
+
+	return tcpHandler, nil
+}
+
+// StartPacketSampler consumes the packet samples pushed by the XDP program through the
+// ring buffer, and sends the samples that satisfy the rules to observerCh. Its signature
+// is the same as handler.StartTCPIPHandler, so it can be used as the packet source of
+// service.TCP_IPCapturer.
+func (manager *BPFManager) StartPacketSampler(ctx context.Context, rules map[string][]string,
+	observerCh chan<- *handler.TCP_IP_Handler) {
+	err := checkBPFObjLoadOr(manager)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err,
+			"location": "(*BPFManager) StartPacketSampler",
+		}).Warningf("validation of BPFObj fails")
+		return
+	}
+
+	eventsCh := make(chan []byte, sampleEventsBuffer)
+	rb, err := manager.bpfModule.InitRingBuf(SamplerMapName, eventsCh)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err,
+			"location": "(*BPFManager) StartPacketSampler",
+			"mapName":  SamplerMapName,
+		}).Warningf("error occurs when init ring buffer")
+		return
+	}
+	rb.Start()
+	defer rb.Stop()
+
+	fmt.Println("😁 " + utils.FontSet("Capturer is listening on XDP sampler"))
+	rulesApplied := handler.MakeTCPIPRules(rules)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopping Capturing the Packets")
+			return
+		case raw := <-eventsCh:
+			sample, err := DecodePacketSample(raw)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"err":      err,
+					"location": "(*BPFManager) StartPacketSampler",
+				}).Debug("drop invalid packet sample")
+				continue
+			}
+
+			tcpHandler, err := sample.ToTCPIPHandler()
+			if err != nil {
+				continue
+			}
+
+			if tcpHandler.Filter(rulesApplied) == handler.DROP {
+				continue
+			}
+
+			observerCh <- tcpHandler
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/p1nant0m/xdp-tracing/handler"
+)
+
+func makeRawSample(t *testing.T, pktLen uint32, frame []byte) []byte {
+	sample := PacketSample{Timestamp: 1, Ifindex: 2, PktLen: pktLen, CapLen: uint32(len(frame))}
+	copy(sample.Data[:], frame)
+
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, hostEndian, &sample); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodePacketSampleTooShort(t *testing.T) {
+	if _, err := DecodePacketSample(make([]byte, 10)); err == nil {
+		t.Fatalf("Expected an error when decoding short record, got %v", err)
+	}
+}
+
+func TestPacketSampleToTCPIPHandler(t *testing.T) {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IP{192, 168, 176, 1},
+		DstIP:    net.IP{192, 168, 176, 128},
+	}
+	tcp := &layers.TCP{SrcPort: 44292, DstPort: 8000, ACK: true, PSH: true}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	payload := gopacket.Payload(bytes.Repeat([]byte{'a'}, 10))
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, payload); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	frame := buf.Bytes()
+
+	// pretend the packet carries 100 more bytes of payload which were not captured
+	sample, err := DecodePacketSample(makeRawSample(t, uint32(len(frame))+100, frame))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	got, err := sample.ToTCPIPHandler()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !got.SrcIP.Equal(ip.SrcIP) || got.DstPort != tcp.DstPort || got.TTL != 64 {
+		t.Errorf("Expected %v:%v TTL 64, got %v:%v TTL %v", ip.SrcIP, tcp.DstPort, got.SrcIP, got.DstPort, got.TTL)
+	}
+	if got.PayloadLen != 110 {
+		t.Errorf("Expected PayloadLen 110, got %v", got.PayloadLen)
+	}
+	if got.Verdict != "" {
+		t.Errorf("Expected the passed packet without verdict, got %v", got.Verdict)
+	}
+
+	// the packet passed from an address a monitor policy would have dropped
+	sample.Action = XDP_PASS | SampleMonitored
+	if got, err = sample.ToTCPIPHandler(); err != nil || got.Verdict != handler.VERDICT_MONITOR {
+		t.Errorf("Expected verdict %v, got %v err=%v", handler.VERDICT_MONITOR, got, err)
+	}
+	sample.Action = XDP_DROP
+	if got, err = sample.ToTCPIPHandler(); err != nil || got.Verdict != handler.VERDICT_DROP {
+		t.Errorf("Expected verdict %v, got %v err=%v", handler.VERDICT_DROP, got, err)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
+)
+
+// attach states of the BPFProgram reported by (*BPFManager) Status
+const (
+	STATE_DETACHED = "detached"
+	STATE_ATTACHED = "attached"
+	STATE_FAILED   = "failed" // the last attach failed, see ProgramStatus.Error
+)
+
+// ErrManagerClosed is returned when using the BPFManager after Close.
+var ErrManagerClosed = errors.New("BPFManager has been closed")
+
+// ProgramStatus describes the attach state of a registered BPFProgram.
+type ProgramStatus struct {
+	Name      string `json:"name"`
+	HookPoint string `json:"hookpoint"`
+	State     string `json:"state"`
+	Pinned    bool   `json:"pinned"`          // attached through the link pinned on bpffs
+	Error     string `json:"error,omitempty"` // error of the last attach or detach
+}
+
+// ProgramError is the error occurs when attaching or detaching a BPFProgram.
+type ProgramError struct {
+	Op        string // "attach" or "detach"
+	Name      string
+	HookPoint string
+	Err       error
+}
+
+func (e *ProgramError) Error() string {
+	return fmt.Sprintf("failed to %v %v at %v: %v", e.Op, e.Name, e.HookPoint, e.Err)
+}
+
+func (e *ProgramError) Unwrap() error {
+	return e.Err
+}
+
+// ProgramErrors collects the ProgramError of every failing BPFProgram, so all mistakes
+// can be found in one shot.
+type ProgramErrors []*ProgramError
+
+func (errs ProgramErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d bpfProgram(s) failed: %v", len(errs), strings.Join(msgs, "; "))
+}
+
+// Status returns the attach state of every registered BPFProgram in the order of
+// registration.
+func (manager *BPFManager) Status() []ProgramStatus {
+	ret := make([]ProgramStatus, 0, len(manager.bpfPrograms))
+	for _, prog := range manager.bpfPrograms {
+		ret = append(ret, *manager.status[prog])
+	}
+	return ret
+}
+
+// MapUsage describes how full a BPF map is.
+type MapUsage struct {
+	Name       string `json:"name"`
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"maxentries"`
+}
+
+// MapUsage returns the usage of the maps with given names, the entries of an array are
+// always as many as its capacity.
+func (manager *BPFManager) MapUsage(mapNames ...string) ([]MapUsage, error) {
+	if err := checkBPFObjLoadOr(manager); err != nil {
+		return nil, err
+	}
+
+	ret := make([]MapUsage, 0, len(mapNames))
+	for _, name := range mapNames {
+		bpfMap, err := manager.bpfModule.GetMap(name)
+		if err != nil {
+			return nil, fmt.Errorf("bpfMap with name %v was not found: %w", name, err)
+		}
+
+		usage := MapUsage{Name: name, MaxEntries: int(bpfMap.GetMaxEntries())}
+		iter := bpfMap.Iterator()
+		for iter.Next() {
+			usage.Entries++
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to iterate bpfMap %v: %w", name, err)
+		}
+		ret = append(ret, usage)
+	}
+	return ret, nil
+}
+
+// IsLoaded reports whether the object of BPFModule has been loaded into the kernel.
+func (manager *BPFManager) IsLoaded() bool {
+	return manager.loaded
+}
+
+// attach attaches prog and records its state.
+func (manager *BPFManager) attach(prog probe.BPFProgram) error {
+	status := manager.status[prog]
+	status.HookPoint = prog.GetHookPoint()
+	status.Pinned = manager.isAttachedByPin(prog)
+
+	if err := manager.attachOr(prog); err != nil {
+		status.State, status.Error = STATE_FAILED, err.Error()
+		return err
+	}
+
+	status.State, status.Error = STATE_ATTACHED, ""
+	if !status.Pinned {
+		status.Pinned = manager.linkPinPath(prog) != "" && prog.GetBPFLink() != nil
+	}
+	return nil
+}
+
+// detach detaches prog and records its state, prog is considered attached if it fails.
+func (manager *BPFManager) detach(prog probe.BPFProgram) error {
+	status := manager.status[prog]
+
+	if err := manager.detachOr(prog); err != nil {
+		status.Error = err.Error()
+		return err
+	}
+
+	status.State, status.Error, status.Pinned = STATE_DETACHED, "", false
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
+)
+
+func TestProgramErrors(t *testing.T) {
+	cause := errors.New("no such device")
+	errs := ProgramErrors{
+		&ProgramError{Op: "attach", Name: "xdp_proxy", HookPoint: "xdp/eth0", Err: cause},
+		&ProgramError{Op: "attach", Name: "xdp_proxy", HookPoint: "xdp/eth1", Err: cause},
+	}
+
+	msg := errs.Error()
+	if !strings.Contains(msg, "xdp/eth0") || !strings.Contains(msg, "xdp/eth1") {
+		t.Fatalf("Expected both hook points in the error, got %v", msg)
+	}
+	if !errors.Is(errs[0], cause) {
+		t.Fatalf("Expected ProgramError to wrap its cause, got %v", errs[0])
+	}
+}
+
+func TestBPFManagerStatusAndClose(t *testing.T) {
+	m, err := NewBPFManager(WithBPFProgramList([]probe.BPFProgram{
+		probe.NewXDPProgram("xdp_proxy", "eth0", 0),
+		probe.NewXDPProgram("xdp_proxy", "eth1", 0),
+	}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	status := m.Status()
+	if len(status) != 2 || status[1].HookPoint != "xdp/eth1" || status[1].State != STATE_DETACHED {
+		t.Fatalf("Expected two detached programs, got %+v", status)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := m.AttachAll(); !errors.Is(err, ErrManagerClosed) {
+		t.Fatalf("Expected ErrManagerClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TCPEventsMapName is the name of BPF_MAP_TYPE_RINGBUF map which the TCP tracepoints
+	// push events into. It is defined in bpf/headers/tcp_events.h
+	TCPEventsMapName = "tcp_events"
+	// Programs tracing TCP retransmissions, resets and state changes in the object.
+	TCPRetransmitProgName   = "tracepoint__tcp__tcp_retransmit_skb"
+	TCPSendResetProgName    = "tracepoint__tcp__tcp_send_reset"
+	TCPReceiveResetProgName = "tracepoint__tcp__tcp_receive_reset"
+	TCPSetStateProgName     = "tracepoint__sock__inet_sock_set_state"
+
+	// TCP_CONN_TRACKER_MAXSIZE is the number of connections counted by StartTCPEventTracer.
+	TCP_CONN_TRACKER_MAXSIZE = 65536
+
+	tcpEventsBuffer  = 1024
+	tcpConnEventsMax = 16
+	tcpStateClose    = 7
+)
+
+// Types of TCP events, they should be synchronized to TCP_EVENT_* in bpf/headers/tcp_events.h
+const (
+	TCP_EVENT_RETRANSMIT    = 1
+	TCP_EVENT_SEND_RESET    = 2
+	TCP_EVENT_RECEIVE_RESET = 3
+	TCP_EVENT_STATE         = 4
+)
+
+var tcpEventTypes = map[uint32]string{
+	TCP_EVENT_RETRANSMIT:    "retransmit",
+	TCP_EVENT_SEND_RESET:    "send_reset",
+	TCP_EVENT_RECEIVE_RESET: "receive_reset",
+	TCP_EVENT_STATE:         "state",
+}
+
+// tcpStates are the names of TCP states defined in include/net/tcp_states.h
+var tcpStates = map[int32]string{
+	1:  "ESTABLISHED",
+	2:  "SYN_SENT",
+	3:  "SYN_RECV",
+	4:  "FIN_WAIT1",
+	5:  "FIN_WAIT2",
+	6:  "TIME_WAIT",
+	7:  "CLOSE",
+	8:  "CLOSE_WAIT",
+	9:  "LAST_ACK",
+	10: "LISTEN",
+	11: "CLOSING",
+	12: "NEW_SYN_RECV",
+}
+
+// TCPEventPrograms returns the BPFPrograms which should be registered in BPFManager
+// before calling StartTCPEventTracer.
+func TCPEventPrograms() []probe.BPFProgram {
+	return []probe.BPFProgram{
+		probe.NewTracepointProgram(TCPRetransmitProgName, "tcp", "tcp_retransmit_skb"),
+		probe.NewTracepointProgram(TCPSendResetProgName, "tcp", "tcp_send_reset"),
+		probe.NewTracepointProgram(TCPReceiveResetProgName, "tcp", "tcp_receive_reset"),
+		probe.NewTracepointProgram(TCPSetStateProgName, "sock", "inet_sock_set_state"),
+	}
+}
+
+// tcpEventRecord should be synchronized to struct tcp_event in bpf/headers/tcp_events.h,
+// the tuple follows flowKey.
+type tcpEventRecord struct {
+	Timestamp uint64
+	LAddr     uint32
+	RAddr     uint32
+	LPort     uint16
+	RPort     uint16
+	Type      uint32
+	OldState  int32
+	NewState  int32
+}
+
+var tcpEventDecoder = mustNewEventDecoder[tcpEventRecord]()
+
+// TCPConn identifies a TCP connection from the view of local host.
+type TCPConn struct {
+	LocalIP    string `json:"localip"`
+	LocalPort  uint16 `json:"localport"`
+	RemoteIP   string `json:"remoteip"`
+	RemotePort uint16 `json:"remoteport"`
+}
+
+func (conn TCPConn) String() string {
+	return fmt.Sprintf("%v:%d-%v:%d", conn.LocalIP, conn.LocalPort, conn.RemoteIP, conn.RemotePort)
+}
+
+// TCPEvent is a retransmission, reset or state change of a TCP connection.
+type TCPEvent struct {
+	TCPConn
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	OldState  string    `json:"oldstate,omitempty"` // only for state changes
+	NewState  string    `json:"newstate,omitempty"` // state of the connection when it is known
+}
+
+// TCPConnStats counts the TCP events of a connection.
+type TCPConnStats struct {
+	TCPConn
+	Node           string      `json:"node,omitempty"`
+	Retransmits    uint64      `json:"retransmits"`
+	SentResets     uint64      `json:"sentresets"`
+	ReceivedResets uint64      `json:"receivedresets"`
+	State          string      `json:"state,omitempty"`
+	FirstSeen      time.Time   `json:"firstseen"`
+	LastSeen       time.Time   `json:"lastseen"`
+	Events         []*TCPEvent `json:"events"` // the latest events, oldest first
+}
+
+// DecodeTCPEvent decodes the raw record received from ring buffer into TCPEvent. The
+// timestamp is left as the monotonic time since boot, see StartTCPEventTracer.
+func DecodeTCPEvent(raw []byte) (*TCPEvent, error) {
+	record, err := tcpEventDecoder.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tcp event: %w", err)
+	}
+
+	typ, ok := tcpEventTypes[record.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown tcp event type %v", record.Type)
+	}
+
+	event := &TCPEvent{
+		TCPConn: TCPConn{
+			LocalIP:    ipFromKernel(record.LAddr).String(),
+			LocalPort:  record.LPort,
+			RemoteIP:   ipFromKernel(record.RAddr).String(),
+			RemotePort: record.RPort,
+		},
+		Type:      typ,
+		Timestamp: time.Unix(0, int64(record.Timestamp)),
+		NewState:  tcpStates[record.NewState],
+	}
+	if record.Type == TCP_EVENT_STATE {
+		event.OldState = tcpStates[record.OldState]
+	}
+
+	return event, nil
+}
+
+// ipFromKernel converts the IPv4 address kept in network byte order by the kernel.
+func ipFromKernel(addr uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	hostEndian.PutUint32(ip, addr)
+	return ip
+}
+
+// TCPConnTracker aggregates TCPEvents into the counters of their connections. It keeps at
+// most maxSize connections, the least recently seen one is forgotten to make room for a new
+// one and a connection is forgotten once it is closed.
+type TCPConnTracker struct {
+	maxSize int
+	conns   map[TCPConn]*TCPConnStats
+}
+
+// NewTCPConnTracker returns a TCPConnTracker keeping at most maxSize connections.
+func NewTCPConnTracker(maxSize int) *TCPConnTracker {
+	return &TCPConnTracker{maxSize: maxSize, conns: map[TCPConn]*TCPConnStats{}}
+}
+
+// Observe counts event into its connection and returns a snapshot of the counters.
+func (tracker *TCPConnTracker) Observe(event *TCPEvent) *TCPConnStats {
+	stats, exists := tracker.conns[event.TCPConn]
+	if !exists {
+		if len(tracker.conns) >= tracker.maxSize {
+			tracker.evict()
+		}
+		stats = &TCPConnStats{TCPConn: event.TCPConn, FirstSeen: event.Timestamp}
+		tracker.conns[event.TCPConn] = stats
+	}
+
+	switch event.Type {
+	case tcpEventTypes[TCP_EVENT_RETRANSMIT]:
+		stats.Retransmits++
+	case tcpEventTypes[TCP_EVENT_SEND_RESET]:
+		stats.SentResets++
+	case tcpEventTypes[TCP_EVENT_RECEIVE_RESET]:
+		stats.ReceivedResets++
+	}
+	if event.NewState != "" {
+		stats.State = event.NewState
+	}
+	stats.LastSeen = event.Timestamp
+	stats.Events = append(stats.Events, event)
+	if len(stats.Events) > tcpConnEventsMax {
+		stats.Events = stats.Events[len(stats.Events)-tcpConnEventsMax:]
+	}
+
+	snapshot := *stats
+	snapshot.Events = append([]*TCPEvent(nil), stats.Events...)
+	if event.Type == tcpEventTypes[TCP_EVENT_STATE] && event.NewState == tcpStates[tcpStateClose] {
+		delete(tracker.conns, event.TCPConn)
+	}
+	return &snapshot
+}
+
+// Len returns the number of connections being tracked.
+func (tracker *TCPConnTracker) Len() int {
+	return len(tracker.conns)
+}
+
+func (tracker *TCPConnTracker) evict() {
+	var oldest *TCPConnStats
+	for _, stats := range tracker.conns {
+		if oldest == nil || stats.LastSeen.Before(oldest.LastSeen) {
+			oldest = stats
+		}
+	}
+	if oldest != nil {
+		delete(tracker.conns, oldest.TCPConn)
+	}
+}
+
+// StartTCPEventTracer consumes the events pushed by TCPEventPrograms, and sends the
+// counters of the connection to observerCh on every event until ctx is done. The
+// triggering event is the last one of TCPConnStats.Events. TCPEventPrograms should have
+// been attached.
+func (manager *BPFManager) StartTCPEventTracer(ctx context.Context, observerCh chan<- *TCPConnStats) error {
+	err := checkBPFObjLoadOr(manager)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err,
+			"location": "(*BPFManager) StartTCPEventTracer",
+		}).Warningf("validation of BPFObj fails")
+		return err
+	}
+
+	eventsCh := make(chan []byte, tcpEventsBuffer)
+	rb, err := manager.bpfModule.InitRingBuf(TCPEventsMapName, eventsCh)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err,
+			"location": "(*BPFManager) StartTCPEventTracer",
+			"mapName":  TCPEventsMapName,
+		}).Warningf("error occurs when init ring buffer")
+		return err
+	}
+	rb.Start()
+
+	go func() {
+		defer rb.Stop()
+
+		bootTime := getBootTime()
+		tracker := NewTCPConnTracker(TCP_CONN_TRACKER_MAXSIZE)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case raw := <-eventsCh:
+				event, err := DecodeTCPEvent(raw)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"err":      err,
+						"location": "(*BPFManager) StartTCPEventTracer",
+					}).Debug("drop invalid tcp event")
+					continue
+				}
+				event.Timestamp = bootTime.Add(time.Duration(event.Timestamp.UnixNano()))
+
+				select {
+				case observerCh <- tracker.Observe(event):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
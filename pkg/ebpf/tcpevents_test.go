@@ -0,0 +1,75 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func makeRawTCPEvent(t *testing.T, record tcpEventRecord) []byte {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, hostEndian, &record); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeTCPEvent(t *testing.T) {
+	key := newFlowKey(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), 44292, 80)
+	raw := makeRawTCPEvent(t, tcpEventRecord{
+		Timestamp: 42, LAddr: key.LAddr, RAddr: key.RAddr, LPort: key.LPort, RPort: key.RPort,
+		Type: TCP_EVENT_STATE, OldState: 1, NewState: 4,
+	})
+
+	event, err := DecodeTCPEvent(raw)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := TCPConn{LocalIP: "10.0.0.1", LocalPort: 44292, RemoteIP: "10.0.0.2", RemotePort: 80}
+	if event.TCPConn != expected {
+		t.Errorf("Expected connection %v, got %v", expected, event.TCPConn)
+	}
+	if event.Type != "state" || event.OldState != "ESTABLISHED" || event.NewState != "FIN_WAIT1" {
+		t.Errorf("Expected state ESTABLISHED -> FIN_WAIT1, got %+v", event)
+	}
+
+	if _, err := DecodeTCPEvent(makeRawTCPEvent(t, tcpEventRecord{Type: 42})); err == nil {
+		t.Fatalf("Expected an error for unknown event type, got %v", err)
+	}
+}
+
+func TestTCPConnTracker(t *testing.T) {
+	conn := TCPConn{LocalIP: "10.0.0.1", LocalPort: 44292, RemoteIP: "10.0.0.2", RemotePort: 80}
+	other := TCPConn{LocalIP: "10.0.0.1", LocalPort: 44293, RemoteIP: "10.0.0.2", RemotePort: 80}
+	now := time.Now()
+
+	tracker := NewTCPConnTracker(1)
+	tracker.Observe(&TCPEvent{TCPConn: conn, Type: "retransmit", Timestamp: now, NewState: "ESTABLISHED"})
+	stats := tracker.Observe(&TCPEvent{TCPConn: conn, Type: "retransmit", Timestamp: now.Add(time.Second)})
+	if stats.Retransmits != 2 || stats.State != "ESTABLISHED" || len(stats.Events) != 2 {
+		t.Fatalf("Expected 2 retransmits in ESTABLISHED, got %+v", stats)
+	}
+
+	stats = tracker.Observe(&TCPEvent{TCPConn: conn, Type: "send_reset", Timestamp: now.Add(2 * time.Second)})
+	if stats.SentResets != 1 || stats.Retransmits != 2 || !stats.FirstSeen.Equal(now) {
+		t.Fatalf("Expected 1 sent reset counted along with retransmits, got %+v", stats)
+	}
+
+	// the tracker is full, the new connection evicts the old one
+	tracker.Observe(&TCPEvent{TCPConn: other, Type: "receive_reset", Timestamp: now})
+	if stats = tracker.Observe(&TCPEvent{TCPConn: conn, Type: "retransmit", Timestamp: now}); stats.Retransmits != 1 {
+		t.Fatalf("Expected counters of the evicted connection start over, got %+v", stats)
+	}
+
+	tracker.Observe(&TCPEvent{TCPConn: conn, Type: "state", Timestamp: now, OldState: "LAST_ACK", NewState: "CLOSE"})
+	if tracker.Len() != 0 {
+		t.Fatalf("Expected closed connection forgotten, got %v connections", tracker.Len())
+	}
+}
@@ -0,0 +1,556 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/aquasecurity/libbpfgo"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// XDP verdicts defined in include/uapi/linux/bpf.h
+const (
+	xdpAborted  = 0
+	xdpRedirect = 4
+)
+
+// errnoENOTSUPP is ENOTSUPP of the kernel, which is not exposed to userspace headers
+const errnoENOTSUPP = unix.Errno(524)
+
+// mapElemAttr is the part of union bpf_attr used by BPF_MAP_*_ELEM and BPF_MAP_GET_NEXT_KEY.
+type mapElemAttr struct {
+	mapFd uint32
+	_     uint32
+	key   uint64
+	value uint64 // value, or next_key of BPF_MAP_GET_NEXT_KEY
+	flags uint64
+}
+
+// linkUpdateAttr is the link_update part of union bpf_attr used by BPF_LINK_UPDATE.
+type linkUpdateAttr struct {
+	linkFd    uint32
+	newProgFd uint32
+	flags     uint32
+	oldProgFd uint32
+}
+
+// testRunAttr is the test part of union bpf_attr used by BPF_PROG_TEST_RUN.
+type testRunAttr struct {
+	progFd      uint32
+	retval      uint32
+	dataSizeIn  uint32
+	dataSizeOut uint32
+	dataIn      uint64
+	dataOut     uint64
+	repeat      uint32
+	duration    uint32
+}
+
+// MapMigration is the result of migrating the content of a pinned map into the new object.
+type MapMigration struct {
+	Name    string `json:"name"`
+	Entries int    `json:"entries"`           // entries copied from the pinned map
+	Skipped string `json:"skipped,omitempty"` // why the content was not migrated
+}
+
+// ProgramUpgrade is the XDP program replaced on a device.
+type ProgramUpgrade struct {
+	Program   string `json:"program"`
+	HookPoint string `json:"hookpoint"`
+	OldID     uint32 `json:"oldid"`
+	NewID     uint32 `json:"newid"`
+	Pinned    bool   `json:"pinned"` // replaced through the pinned bpf_link, otherwise through netlink
+}
+
+// UpgradeReport is the result of UpgradeXDP.
+type UpgradeReport struct {
+	Maps     []MapMigration   `json:"maps"`
+	Programs []ProgramUpgrade `json:"programs"`
+}
+
+// upgradeTarget is an attached XDP program to be replaced.
+type upgradeTarget struct {
+	ProgramUpgrade
+	link       *PinnedLink    // replaced with BPF_LINK_UPDATE when it is not nil
+	netlink    *xdpAttachment // otherwise replaced with netlink and XDP_FLAGS_REPLACE
+	oldFd      int
+	newFd      int
+	replaced   bool
+	newProgram *libbpfgo.BPFProg
+}
+
+// UpgradeXDP replaces the XDP programs attached through the links pinned under pinPath,
+// and the programs attached through netlink to devices, with the programs of the same
+// name in the BPFModule of manager, without a moment when the devices are unprotected.
+//
+// The BPFModule of manager should be created without WithPinPath. The content of the maps
+// pinned under pinPath is copied into the maps of the same name first, then every program
+// is replaced atomically, expecting the old program to be still attached, and verified.
+// Replaced programs are rolled back when any replacement or verification fails. At last
+// the maps and programs of the new object are pinned in place of the old ones.
+//
+// Entries written to the old maps after they are copied are lost, so writers of the maps
+// should be paused during the upgrade.
+func UpgradeXDP(manager *BPFManager, pinPath string, devices ...string) (*UpgradeReport, error) {
+	if manager.pinPath != "" {
+		return nil, fmt.Errorf("the BPFModule of the new object should not be pinned before upgrade")
+	}
+	if err := checkBPFObjLoadOr(manager); err != nil {
+		return nil, err
+	}
+
+	targets, err := manager.upgradeTargets(pinPath, devices)
+	defer func() {
+		for _, target := range targets {
+			unix.Close(target.oldFd)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no XDP program attached through %v or devices %v was found", pinPath, devices)
+	}
+
+	report := &UpgradeReport{}
+	if report.Maps, err = manager.migrateMaps(pinPath); err != nil {
+		return report, err
+	}
+
+	for _, target := range targets {
+		if err := verifyXDPProgram(target.newFd); err != nil {
+			return report, fmt.Errorf("verification of %v failed before upgrade: %w", target.Program, err)
+		}
+	}
+
+	for _, target := range targets {
+		if err := target.replace(target.newFd, target.oldFd); err != nil {
+			err = fmt.Errorf("failed to replace %v at %v: %w", target.Program, target.HookPoint, err)
+			return report, rollbackUpgrade(targets, err)
+		}
+		target.replaced = true
+
+		if err := target.verify(target.NewID); err != nil {
+			err = fmt.Errorf("verification of %v at %v failed: %w", target.Program, target.HookPoint, err)
+			return report, rollbackUpgrade(targets, err)
+		}
+	}
+
+	for _, target := range targets {
+		report.Programs = append(report.Programs, target.ProgramUpgrade)
+	}
+
+	if err := manager.repin(pinPath); err != nil {
+		return report, fmt.Errorf("programs were upgraded, but pinning the new object failed: %w", err)
+	}
+	return report, nil
+}
+
+// upgradeTargets collects the attached XDP programs and the programs replacing them.
+func (manager *BPFManager) upgradeTargets(pinPath string, devices []string) ([]*upgradeTarget, error) {
+	var targets []*upgradeTarget
+
+	links, err := ListPinnedLinks(pinPath)
+	if err != nil {
+		return nil, err
+	}
+	linked := map[string]bool{}
+	for i := range links {
+		if !strings.HasPrefix(links[i].HookPoint, "xdp_") {
+			continue
+		}
+		linked[strings.TrimPrefix(links[i].HookPoint, "xdp_")] = true
+
+		target := &upgradeTarget{link: &links[i], ProgramUpgrade: ProgramUpgrade{HookPoint: links[i].HookPoint, Pinned: true}}
+		targets = append(targets, target)
+		if err := manager.resolveTarget(target, links[i].ProgID); err != nil {
+			return targets, err
+		}
+	}
+
+	for _, dev := range devices {
+		if linked[dev] {
+			continue
+		}
+
+		iface, err := net.InterfaceByName(dev)
+		if err != nil {
+			return targets, err
+		}
+		attachment, err := queryXDP(iface.Index)
+		if err != nil {
+			return targets, err
+		}
+		if attachment.progID == 0 {
+			return targets, fmt.Errorf("no XDP program is attached to %v", dev)
+		}
+
+		target := &upgradeTarget{netlink: attachment, ProgramUpgrade: ProgramUpgrade{HookPoint: "xdp_" + dev}}
+		targets = append(targets, target)
+		if err := manager.resolveTarget(target, attachment.progID); err != nil {
+			return targets, err
+		}
+	}
+
+	return targets, nil
+}
+
+// resolveTarget opens the attached program of oldID and finds the program of the same
+// name in the new object.
+func (manager *BPFManager) resolveTarget(target *upgradeTarget, oldID uint32) error {
+	target.oldFd = -1
+	fd, err := progGetFdByID(oldID)
+	if err != nil {
+		return fmt.Errorf("failed to open the program %v attached at %v: %w", oldID, target.HookPoint, err)
+	}
+	target.oldFd = fd
+
+	info, err := progInfoByFd(fd)
+	if err != nil {
+		return fmt.Errorf("failed to get info of program %v: %w", oldID, err)
+	}
+	target.OldID = oldID
+	target.Program = cString(info.name[:])
+
+	// names of programs are truncated by the kernel
+	target.newProgram, err = manager.findProgramByKernelName(target.Program)
+	if err != nil {
+		return fmt.Errorf("program %v attached at %v: %w", target.Program, target.HookPoint, err)
+	}
+	target.Program = target.newProgram.GetName()
+	target.newFd = target.newProgram.GetFd()
+
+	if target.NewID, err = manager.ProgramID(target.Program); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (manager *BPFManager) findProgramByKernelName(name string) (*libbpfgo.BPFProg, error) {
+	iter := manager.bpfModule.Iterator()
+	for bpfProg := iter.NextProgram(); bpfProg != nil; bpfProg = iter.NextProgram() {
+		progName := bpfProg.GetName()
+		if len(progName) > len(name) {
+			progName = progName[:len(name)]
+		}
+		if progName == name && bpfProg.GetType() == libbpfgo.BPFProgTypeXdp {
+			return bpfProg, nil
+		}
+	}
+	return nil, fmt.Errorf("no XDP program of the same name in the new object")
+}
+
+// replace replaces the attached program with newFd, expecting oldFd to be attached.
+func (target *upgradeTarget) replace(newFd int, oldFd int) error {
+	if target.netlink != nil {
+		return replaceXDP(target.netlink.ifindex, newFd, oldFd, target.netlink.mode)
+	}
+
+	linkFd, err := objGet(target.link.Path)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(linkFd)
+
+	attr := linkUpdateAttr{
+		linkFd:    uint32(linkFd),
+		newProgFd: uint32(newFd),
+		flags:     unix.BPF_F_REPLACE,
+		oldProgFd: uint32(oldFd),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_UPDATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// verify checks the program attached at the hook point is the one of progID.
+func (target *upgradeTarget) verify(progID uint32) error {
+	var (
+		attached uint32
+		err      error
+	)
+	if target.netlink != nil {
+		var attachment *xdpAttachment
+		if attachment, err = queryXDP(target.netlink.ifindex); err == nil {
+			attached = attachment.progID
+		}
+	} else {
+		_, attached, err = pinnedLinkInfo(target.link.Path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if attached != progID {
+		return fmt.Errorf("program %v is attached instead of %v", attached, progID)
+	}
+	return nil
+}
+
+// rollbackUpgrade attaches the old programs back to where they have been replaced.
+func rollbackUpgrade(targets []*upgradeTarget, cause error) error {
+	for i := len(targets) - 1; i >= 0; i-- {
+		target := targets[i]
+		if !target.replaced {
+			continue
+		}
+
+		if err := target.replace(target.oldFd, target.newFd); err != nil {
+			return fmt.Errorf("%v, and rollback of %v at %v failed: %w", cause, target.Program, target.HookPoint, err)
+		}
+		target.replaced = false
+		logrus.WithFields(logrus.Fields{
+			"progName":  target.Program,
+			"hookpoint": target.HookPoint,
+		}).Warning("upgrade was rolled back")
+	}
+	return cause
+}
+
+// verifyXDPProgram runs the program against a TCP packet with BPF_PROG_TEST_RUN, a sane
+// program should neither abort nor return an unknown verdict.
+func verifyXDPProgram(progFd int) error {
+	packet := make([]byte, 14+20+20)
+	packet[12], packet[13] = 0x08, 0x00 // ETH_P_IP
+	packet[14] = 0x45                   // IPv4 without options
+	packet[16], packet[17] = 0, 40      // total length
+	packet[22], packet[23] = 64, unix.IPPROTO_TCP
+	copy(packet[26:], []byte{192, 0, 2, 1, 192, 0, 2, 2}) // TEST-NET-1 addresses
+	packet[46] = 0x50                                     // TCP data offset
+
+	attr := testRunAttr{
+		progFd:     uint32(progFd),
+		dataSizeIn: uint32(len(packet)),
+		dataIn:     uint64(uintptr(unsafe.Pointer(&packet[0]))),
+		repeat:     1,
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_TEST_RUN, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(packet)
+	if errno == errnoENOTSUPP || errno == unix.EINVAL {
+		logrus.WithFields(logrus.Fields{
+			"err":      errno,
+			"location": "verifyXDPProgram",
+		}).Warning("BPF_PROG_TEST_RUN is not supported, skip verification")
+		return nil
+	} else if errno != 0 {
+		return errno
+	}
+
+	if attr.retval == xdpAborted || attr.retval > xdpRedirect {
+		return fmt.Errorf("the program returns verdict %v for a TCP packet", attr.retval)
+	}
+	return nil
+}
+
+// migrateMaps copies the content of the maps pinned under pinPath into the maps of the
+// same name in the new object.
+func (manager *BPFManager) migrateMaps(pinPath string) ([]MapMigration, error) {
+	var migrations []MapMigration
+
+	iter := manager.bpfModule.Iterator()
+	for bpfMap := iter.NextMap(); bpfMap != nil; bpfMap = iter.NextMap() {
+		if strings.Contains(bpfMap.Name(), ".") {
+			// internal maps like .rodata and .bss belong to the object
+			continue
+		}
+
+		migration, err := manager.migrateMap(bpfMap, filepath.Join(pinPath, pinMapsDir, bpfMap.Name()))
+		if err != nil {
+			return migrations, fmt.Errorf("failed to migrate map %v: %w", bpfMap.Name(), err)
+		}
+		migrations = append(migrations, migration)
+	}
+
+	return migrations, nil
+}
+
+func (manager *BPFManager) migrateMap(bpfMap *libbpfgo.BPFMap, oldPath string) (MapMigration, error) {
+	migration := MapMigration{Name: bpfMap.Name()}
+
+	oldFd, err := objGet(oldPath)
+	if errors.Is(err, unix.ENOENT) {
+		migration.Skipped = "not pinned before"
+		return migration, nil
+	} else if err != nil {
+		return migration, err
+	}
+	defer unix.Close(oldFd)
+
+	old := mapInfo{}
+	if err := objGetInfo(oldFd, unsafe.Pointer(&old), unsafe.Sizeof(old)); err != nil {
+		return migration, err
+	}
+
+	switch {
+	case old.mapType != uint32(bpfMap.Type()):
+		migration.Skipped = fmt.Sprintf("type changed from %v to %v", old.mapType, bpfMap.Type())
+		return migration, nil
+	case int(old.keySize) != bpfMap.KeySize() || int(old.valueSize) != bpfMap.ValueSize():
+		migration.Skipped = fmt.Sprintf("key/value size changed from %v/%v to %v/%v",
+			old.keySize, old.valueSize, bpfMap.KeySize(), bpfMap.ValueSize())
+		return migration, nil
+	}
+
+	valueSize := int(old.valueSize)
+	switch bpfMap.Type() {
+	case libbpfgo.MapTypeHash, libbpfgo.MapTypeArray, libbpfgo.MapTypeLRUHash, libbpfgo.MapTypeLPMTrie:
+	case libbpfgo.MapTypePerCPUArray, libbpfgo.MapTypePerCPUHash, libbpfgo.MapTypeLRUPerCPUHash:
+		nCPU, err := possibleCPUs()
+		if err != nil {
+			return migration, err
+		}
+		valueSize = perCPUStride(valueSize) * nCPU
+	case libbpfgo.MapTypeProgArray:
+		migration.Entries, err = manager.migrateProgArray(oldFd, bpfMap)
+		return migration, err
+	default:
+		// ring buffers, perf event arrays and maps of kernel objects hold no data to carry
+		migration.Skipped = "content can not be migrated"
+		return migration, nil
+	}
+
+	if old.keySize == 0 || valueSize == 0 {
+		migration.Skipped = "content can not be migrated"
+		return migration, nil
+	}
+	key := make([]byte, old.keySize)
+	value := make([]byte, valueSize)
+	err = iterateMapFd(oldFd, key, func() error {
+		if err := mapLookupFd(oldFd, key, value); errors.Is(err, unix.ENOENT) {
+			return nil // deleted during iteration
+		} else if err != nil {
+			return err
+		}
+		if err := mapUpdateFd(bpfMap.GetFd(), key, value); err != nil {
+			return err
+		}
+		migration.Entries++
+		return nil
+	})
+	return migration, err
+}
+
+// migrateProgArray fills the new PROG_ARRAY with the programs of the same name in the new
+// object, e.g. the stages of XDPDispatcher.
+func (manager *BPFManager) migrateProgArray(oldFd int, bpfMap *libbpfgo.BPFMap) (int, error) {
+	entries := 0
+	key := make([]byte, 4)
+	value := make([]byte, 4)
+
+	err := iterateMapFd(oldFd, key, func() error {
+		if err := mapLookupFd(oldFd, key, value); errors.Is(err, unix.ENOENT) {
+			return nil // array slots without program
+		} else if err != nil {
+			return err
+		}
+
+		// userspace reads the program id from PROG_ARRAY
+		progFd, err := progGetFdByID(hostEndian.Uint32(value))
+		if err != nil {
+			return err
+		}
+		info, err := progInfoByFd(progFd)
+		unix.Close(progFd)
+		if err != nil {
+			return err
+		}
+
+		prog, err := manager.findProgramByKernelName(cString(info.name[:]))
+		if err != nil {
+			return fmt.Errorf("slot %v: program %v: %w", hostEndian.Uint32(key), cString(info.name[:]), err)
+		}
+		hostEndian.PutUint32(value, uint32(prog.GetFd()))
+		if err := mapUpdateFd(bpfMap.GetFd(), key, value); err != nil {
+			return err
+		}
+		entries++
+		return nil
+	})
+	return entries, err
+}
+
+// repin pins the maps and programs of the new object in place of the old ones.
+func (manager *BPFManager) repin(pinPath string) error {
+	iter := manager.bpfModule.Iterator()
+	for bpfMap := iter.NextMap(); bpfMap != nil; bpfMap = iter.NextMap() {
+		if strings.Contains(bpfMap.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(pinPath, pinMapsDir, bpfMap.Name())
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := bpfMap.Pin(path); err != nil {
+			return err
+		}
+	}
+
+	// links keep their pins, they refer to the new programs now
+	manager.pinPath = pinPath
+	return manager.pinProgramsOr()
+}
+
+// iterateMapFd calls fn with every key of the map, key is updated in place.
+func iterateMapFd(fd int, key []byte, fn func() error) error {
+	next := make([]byte, len(key))
+	var prev unsafe.Pointer // nil to get the first key
+
+	for {
+		attr := mapElemAttr{
+			mapFd: uint32(fd),
+			key:   uint64(uintptr(prev)),
+			value: uint64(uintptr(unsafe.Pointer(&next[0]))),
+		}
+		_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_MAP_GET_NEXT_KEY, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+		runtime.KeepAlive(next)
+		if errno == unix.ENOENT {
+			return nil
+		} else if errno != 0 {
+			return errno
+		}
+
+		copy(key, next)
+		if err := fn(); err != nil {
+			return err
+		}
+		prev = unsafe.Pointer(&key[0])
+	}
+}
+
+func mapLookupFd(fd int, key []byte, value []byte) error {
+	return mapElemOp(unix.BPF_MAP_LOOKUP_ELEM, fd, key, value, 0)
+}
+
+func mapUpdateFd(fd int, key []byte, value []byte) error {
+	return mapElemOp(unix.BPF_MAP_UPDATE_ELEM, fd, key, value, unix.BPF_ANY)
+}
+
+func mapElemOp(cmd uintptr, fd int, key []byte, value []byte, flags uint64) error {
+	attr := mapElemAttr{
+		mapFd: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+		flags: flags,
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, cmd, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package options
+
+import "time"
+
+// BoltOptions defines options for the embedded bolt file.
+type BoltOptions struct {
+	Path    string
+	Timeout time.Duration // how long to wait for the lock of the file held by another process
+}
+
+// NewBoltOptions create a `zero` value instance.
+func NewBoltOptions() *BoltOptions {
+	return &BoltOptions{
+		Path:    "/var/lib/xdp-tracing/xdp-tracing.db",
+		Timeout: time.Second,
+	}
+}
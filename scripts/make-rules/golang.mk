@@ -13,6 +13,17 @@ ifneq ($(shell $(GO) version | grep -q -E '\bgo($(GO_SUPPORTED_VERSIONS))\b' &&
 	$(error unsupported go version. Please make install one of the following supported version: '$(GO_SUPPORTED_VERSIONS)')
 endif
 
+# go.generate compiles the eBPF objects which are embedded by package bpf
+.PHONY: go.generate
+go.generate:
+	@echo -e "\033[32m===========> Compile eBPF objects\033[0m"
+	@cd $(ROOT_DIR) && $(GO) generate ./bpf
+
+.PHONY: go.build
+go.build: go.generate
+	@mkdir -p $(OUTPUT)
+	cd $(ROOT_DIR) && $(GO) build -o $(OUTPUT)/xdp-tracing ./cli
+
 # go.revive will do check syntax and styling of go sources using revive
 .PHONY: go.revive
 go.revive: tools.verify.revive
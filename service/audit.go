@@ -0,0 +1,94 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/imroc/req/v3"
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	AUDIT_NODES_PATH   = "/v1/audit/nodes" // where the nodes report to the REST server
+	AUDIT_QUEUE_SIZE   = 64                // batches of events waiting to be reported
+	AUDIT_RETRY_PERIOD = 5 * time.Second
+	AUDIT_TIMEOUT      = 5 * time.Second
+)
+
+// AuditReporter reports the results of applying the policies on this node to the audit log
+// of the REST server in the background, see strategy.Server.Audit. A batch of events is
+// retried until the REST server takes or refuses it, the batches coming meanwhile wait in a
+// queue of AUDIT_QUEUE_SIZE and are dropped when it is full. The REST server only takes the
+// events sent from the address the node registered in etcd.
+type AuditReporter struct {
+	client *req.Client
+	queue  chan []*v1.AuditEvent
+}
+
+// NewAuditReporter returns the AuditReporter to the REST server of restConfig.
+func NewAuditReporter(restConfig *RestConfig) *AuditReporter {
+	return &AuditReporter{
+		client: req.C().
+			SetUserAgent("xdp-tracing-agent/v1").
+			SetTimeout(AUDIT_TIMEOUT).
+			SetCommonHeader("Accept", "application/json").
+			SetBaseURL("http://" + restConfig.Addr),
+		queue: make(chan []*v1.AuditEvent, AUDIT_QUEUE_SIZE),
+	}
+}
+
+// Report queues events to be reported, it never blocks.
+func (r *AuditReporter) Report(events []*v1.AuditEvent) {
+	select {
+	case r.queue <- events:
+	default:
+		logrus.Warnf("[Audit Reporter] %v events dropped as %v batches are waiting", len(events), AUDIT_QUEUE_SIZE)
+	}
+}
+
+// Run reports the events queued in order until ctx is done.
+func (r *AuditReporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case events := <-r.queue:
+			r.send(ctx, events)
+		}
+	}
+}
+
+func (r *AuditReporter) send(ctx context.Context, events []*v1.AuditEvent) {
+	body := struct {
+		Events []*v1.AuditEvent `json:"events"`
+	}{events}
+	for {
+		resp, err := r.client.R().SetContext(ctx).SetBody(&body).Post(AUDIT_NODES_PATH)
+		switch {
+		case err == nil && resp.IsSuccess():
+			return
+		case err == nil && resp.StatusCode == http.StatusBadRequest:
+			// sending the events again would be refused the same way
+			logrus.Warnf("[Audit Reporter] %v events refused by the REST server msg=%v", len(events), resp.String())
+			return
+		case err == nil:
+			logrus.Warnf("[Audit Reporter] failed to report %v events, retry in %v status=%v",
+				len(events), AUDIT_RETRY_PERIOD, resp.Status)
+		default:
+			logrus.Warnf("[Audit Reporter] failed to report %v events, retry in %v err=%v",
+				len(events), AUDIT_RETRY_PERIOD, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(AUDIT_RETRY_PERIOD):
+		}
+	}
+}
@@ -5,10 +5,19 @@
 package service
 
 import (
+	"fmt"
+	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf/probe"
+	"github.com/p1nant0m/xdp-tracing/pkg/options"
+	"github.com/p1nant0m/xdp-tracing/service/strategy"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -24,27 +33,168 @@ type Config struct {
 	Grpc         *GrpcConfig         `yaml:"grpc"`
 	Rest         *RestConfig         `yaml:"rest"`
 	Spec         *SpecConfig         `yaml:"spec"`
+	Ebpf         *EbpfConfig         `yaml:"ebpf"`
+	Pump         *PumpConfig         `yaml:"pump"`
 }
 
 var gConfig *Config
 
 type stringList []string
 
+// SpecConfig is advertised by the node in the registry, policies select the nodes to be
+// enforced on by its name, roles (the ingress and egress entries) and labels.
 type SpecConfig struct {
-	Name    string     `yaml:"name"`
-	Ingress stringList `yaml:"ingress"`
-	Egress  stringList `yaml:"egress"`
+	Name    string            `yaml:"name"`
+	Ingress stringList        `yaml:"ingress"`
+	Egress  stringList        `yaml:"egress"`
+	Labels  map[string]string `yaml:"labels"`
+	// filter modes of the interfaces, filled from AllowlistConfig when advertised
+	FilterModes map[string]string `yaml:"-"`
 }
 
+// NodeInfo returns the NodeInfo of the node nodeID advertising spec, spec may be nil if
+// the node has not advertised it yet.
+func (spec *SpecConfig) NodeInfo(nodeID string) *v1.NodeInfo {
+	node := &v1.NodeInfo{ID: nodeID}
+	if spec == nil {
+		return node
+	}
+	node.Name = spec.Name
+	node.Roles = append(append(node.Roles, spec.Ingress...), spec.Egress...)
+	node.Labels = spec.Labels
+	node.FilterModes = spec.FilterModes
+	return node
+}
+
+const (
+	PACKET_SOURCE_SOCKET = "socket"
+	PACKET_SOURCE_XDP    = "xdp"
+)
+
+// EbpfConfig describes the eBPF object used by the agent and where its programs
+// should be attached.
+type EbpfConfig struct {
+	ObjPath      string            `yaml:"objpath"`      // overrides the eBPF object embedded in the binary
+	PacketSource string            `yaml:"packetsource"` // "socket" (AF_PACKET raw socket) or "xdp" (XDP sampler)
+	SampleRate   uint32            `yaml:"samplerate"`   // the XDP sampler samples one of every samplerate packets, 0 for every packet
+	PinPath      string            `yaml:"pinpath"`      // directory on bpffs to pin maps, programs and links, empty to disable
+	Probes       []probe.ProbeSpec `yaml:"probes"`
+	Exec         *ExecTraceConfig  `yaml:"exec"`
+	Flow         *FlowTraceConfig  `yaml:"flow"`
+	TCPEvents    *TCPEventsConfig  `yaml:"tcpevents"`
+	Dispatcher   *DispatcherConfig `yaml:"dispatcher"`
+	Allowlist    *AllowlistConfig  `yaml:"allowlist"`
+}
+
+// ExecTraceConfig enables the agent to trace process executions and report the events
+// which satisfy the filter.
+type ExecTraceConfig struct {
+	Enable bool            `yaml:"enable"`
+	Filter ebpf.ExecFilter `yaml:"filter"`
+}
+
+// FlowTraceConfig enables the agent to attribute captured TCP sessions to the process
+// and container which own them.
+type FlowTraceConfig struct {
+	Enable bool `yaml:"enable"`
+}
+
+// TCPEventsConfig enables the agent to trace retransmissions, resets and state changes of
+// TCP connections through the kernel tracepoints, and join their counters into sessions.
+type TCPEventsConfig struct {
+	Enable bool `yaml:"enable"`
+}
+
+// DispatcherConfig sets the chain of XDP stages run by the xdp_dispatcher program, which
+// should be declared in probes to be attached to the interfaces.
+type DispatcherConfig struct {
+	Stages    []string        `yaml:"stages"`
+	RateLimit RateLimitConfig `yaml:"ratelimit"`
+}
+
+// AllowlistConfig puts the interfaces in allowlist mode, where the xdp_allowlist stage drops
+// everything but the traffic of pass policies and of the management set.
+type AllowlistConfig struct {
+	Interfaces []string `yaml:"interfaces"`
+	// DryRun keeps the interfaces in blocklist mode, only the sessions the allowlist would
+	// cut off are warned about
+	DryRun     bool             `yaml:"dryrun"`
+	Management ManagementConfig `yaml:"management"`
+}
+
+// ManagementConfig is always passed on the interfaces in allowlist mode whatever the policies
+// are. The addresses of etcd, redis and the policy controller and the gRPC port of the agent
+// are added to it, so the node is never cut off from the control plane.
+type ManagementConfig struct {
+	Sources []string `yaml:"sources"` // IPv4 addresses or prefixes
+	Ports   []string `yaml:"ports"`   // local ports like "22" for tcp, or "udp/53"
+}
+
+// FilterModes returns the filter modes of the interfaces by their names, the interfaces
+// stay in blocklist mode during a dry run.
+func (cfg *AllowlistConfig) FilterModes() map[string]string {
+	if cfg == nil || cfg.DryRun || len(cfg.Interfaces) == 0 {
+		return nil
+	}
+
+	modes := make(map[string]string, len(cfg.Interfaces))
+	for _, name := range cfg.Interfaces {
+		modes[name] = v1.FILTER_MODE_ALLOWLIST
+	}
+	return modes
+}
+
+// RateLimitConfig limits the packet rate of every source address in the xdp_ratelimit stage.
+type RateLimitConfig struct {
+	PPS   uint64 `yaml:"pps"` // packets per second, 0 disables the limit
+	Burst uint64 `yaml:"burst"`
+}
+
+// Stores of the policies created through the REST API, see service/rest/store.
+const (
+	REST_STORE_MEMORY = "memory" // lost when the REST server restarts
+	REST_STORE_ETCD   = "etcd"
+	REST_STORE_BOLT   = "bolt" // kept in the file of RestConfig.Bolt
+)
+
+// Stores of the sessions saved by the pump, see internal/pump/store.
+const (
+	PUMP_STORE_MONGODB = "mongodb"
+	PUMP_STORE_BOLT    = "bolt" // kept in the file of PumpConfig.Bolt
+)
+
+// Files of the bolt stores if their paths are omitted. bolt locks the file it opens, so the
+// REST server and the pump never share one.
+const (
+	REST_BOLT_PATH = "/var/lib/xdp-tracing/rest.db"
+	PUMP_BOLT_PATH = "/var/lib/xdp-tracing/pump.db"
+)
+
 type RestConfig struct {
-	Addr       string `yaml:"addr"`
-	Production bool   `yaml:"production"`
+	Addr       string      `yaml:"addr"`
+	Production bool        `yaml:"production"`
+	Store      string      `yaml:"store"` // REST_STORE_MEMORY if empty
+	Bolt       *BoltConfig `yaml:"bolt"`  // file of REST_STORE_BOLT, REST_BOLT_PATH if omitted
+}
+
+// BoltConfig is the embedded single file of a bolt store, it is opened by a single process.
+type BoltConfig struct {
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout"` // how long to wait for the file locked by another process
+}
+
+// PumpConfig selects the store the pump saves the sessions captured by the nodes into, see
+// internal/pump.
+type PumpConfig struct {
+	Store  string        `yaml:"store"`  // PUMP_STORE_MONGODB if empty
+	Bolt   *BoltConfig   `yaml:"bolt"`   // file of PUMP_STORE_BOLT, PUMP_BOLT_PATH if omitted
+	Period time.Duration `yaml:"period"` // how often the sessions are pumped, PUMP_PERIOD if 0
 }
 
 type GrpcConfig struct {
 	Port           int    `yaml:"port"`
-	MapID          uint32 `yaml:"mapid"`
 	CredentialPath string `yaml:"credentialpath"`
+	Controller     string `yaml:"controller"` // address of the policy controller to subscribe to, empty to be pushed to
 }
 
 type EtcdConfig struct {
@@ -108,7 +258,7 @@ func (redisService *RedisService) MakeNewRedisOptions() {
 // Rules field
 func (capturer *TCP_IPCapturer) MakeNewRules() {
 	filterRules := extractPacketFilterConfig()
-	logrus.Debug("In MakeNewRules:77 FilterRules:%v", filterRules)
+	logrus.Debugf("In MakeNewRules:77 FilterRules:%v", filterRules)
 	rules := make(map[string][]string)
 	v := reflect.ValueOf(filterRules).Elem()
 	for i := 0; i < v.NumField(); i++ {
@@ -140,6 +290,120 @@ func extractPacketFilterConfig() *PacketFilterConfig {
 	return gConfig.PacketFilter
 }
 
+func extractEbpfConfig() *EbpfConfig {
+	return gConfig.Ebpf
+}
+
+func ExtractEbpfConfig() *EbpfConfig {
+	return extractEbpfConfig()
+}
+
+func ExtractgRPCConfig() *GrpcConfig {
+	return extractgRPCConfig()
+}
+
+// ExtractManagementSet returns the management set of the allowlist config along with the
+// addresses of etcd, redis, the REST server and the policy controller and the gRPC port of
+// the agent.
+func ExtractManagementSet() (*strategy.ManagementSet, error) {
+	var sources, ports []string
+	if ebpfConfig := extractEbpfConfig(); ebpfConfig != nil && ebpfConfig.Allowlist != nil {
+		sources = append(sources, ebpfConfig.Allowlist.Management.Sources...)
+		ports = append(ports, ebpfConfig.Allowlist.Management.Ports...)
+	}
+
+	var hostPorts []string
+	if etcdConfig := extractEtcdConfig(); etcdConfig != nil {
+		hostPorts = append(hostPorts, etcdConfig.EndPoints...)
+	}
+	if redisConfig := extractRedisConfig(); redisConfig != nil {
+		hostPorts = append(hostPorts, redisConfig.Addr)
+	}
+	if restConfig := extractRestConfig(); restConfig != nil {
+		// the nodes report the results of applying the policies to the audit log
+		hostPorts = append(hostPorts, restConfig.Addr)
+	}
+	if grpcConfig := extractgRPCConfig(); grpcConfig != nil {
+		hostPorts = append(hostPorts, grpcConfig.Controller)
+		ports = append(ports, strconv.Itoa(grpcConfig.Port))
+	}
+	for _, hostPort := range hostPorts {
+		addrs, err := resolveHost(hostPort)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, addrs...)
+	}
+
+	return parseManagementSet(sources, ports)
+}
+
+// resolveHost returns the IPv4 addresses of the host of endpoint like "http://etcd:2379".
+func resolveHost(endpoint string) ([]string, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		endpoint = endpoint[i+len("://"):]
+	}
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %v: %w", host, err)
+	}
+	var addrs []string
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			addrs = append(addrs, ip.String())
+		}
+	}
+	return addrs, nil
+}
+
+func parseManagementSet(sources []string, ports []string) (*strategy.ManagementSet, error) {
+	set := &strategy.ManagementSet{}
+	for _, source := range sources {
+		if !strings.Contains(source, "/") {
+			source += "/32"
+		}
+		_, ipNet, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, fmt.Errorf("management source %q is malformed", source)
+		}
+		key, err := ebpf.NewLPMKey(ipNet)
+		if err != nil {
+			return nil, fmt.Errorf("management source %q: %w", source, err)
+		}
+		set.Sources = append(set.Sources, key)
+	}
+
+	for _, port := range ports {
+		protocol, number, found := strings.Cut(port, "/")
+		if !found {
+			protocol, number = v1.PROTOCOL_TCP, port
+		}
+		p, err := strconv.ParseUint(number, 10, 16)
+		if err != nil || p == 0 {
+			return nil, fmt.Errorf("management port %q is malformed", port)
+		}
+		key := ebpf.PortKey{Port: uint16(p)}
+		switch protocol {
+		case v1.PROTOCOL_TCP:
+			key.Protocol = ebpf.IPPROTO_TCP
+		case v1.PROTOCOL_UDP:
+			key.Protocol = ebpf.IPPROTO_UDP
+		default:
+			return nil, fmt.Errorf("management port %q should be tcp or udp", port)
+		}
+		set.Ports = append(set.Ports, key)
+	}
+	return set, nil
+}
+
 func extractRestConfig() *RestConfig {
 	return gConfig.Rest
 }
@@ -148,6 +412,48 @@ func ExtractRestConfig() *RestConfig {
 	return extractRestConfig()
 }
 
+// ExtractRestBoltOptions returns the options of the bolt file of the REST server.
+func ExtractRestBoltOptions() *options.BoltOptions {
+	var boltConfig *BoltConfig
+	if restConfig := extractRestConfig(); restConfig != nil {
+		boltConfig = restConfig.Bolt
+	}
+	return boltOptions(boltConfig, REST_BOLT_PATH)
+}
+
+// ExtractPumpBoltOptions returns the options of the bolt file of the pump.
+func ExtractPumpBoltOptions() *options.BoltOptions {
+	var boltConfig *BoltConfig
+	if pumpConfig := extractPumpConfig(); pumpConfig != nil {
+		boltConfig = pumpConfig.Bolt
+	}
+	return boltOptions(boltConfig, PUMP_BOLT_PATH)
+}
+
+// boltOptions returns the options of the bolt file of boltConfig, path and the defaults of
+// options.NewBoltOptions are used for the fields omitted.
+func boltOptions(boltConfig *BoltConfig, path string) *options.BoltOptions {
+	opts := options.NewBoltOptions()
+	opts.Path = path
+	if boltConfig != nil {
+		if boltConfig.Path != "" {
+			opts.Path = boltConfig.Path
+		}
+		if boltConfig.Timeout != 0 {
+			opts.Timeout = boltConfig.Timeout
+		}
+	}
+	return opts
+}
+
+func extractPumpConfig() *PumpConfig {
+	return gConfig.Pump
+}
+
+func ExtractPumpConfig() *PumpConfig {
+	return extractPumpConfig()
+}
+
 func ReadAndParseConfig(filePath string) error {
 	viper.SetConfigType("yaml")
 	viper.SetConfigFile(filePath)
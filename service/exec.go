@@ -0,0 +1,35 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+)
+
+const (
+	// EXEC_EVENTS_KEY is the Redis sorted set which keeps the exec events reported by all
+	// nodes, scored by the unix time of execution.
+	EXEC_EVENTS_KEY = "execs"
+	// EXEC_EVENTS_MAX is the number of the latest exec events kept in Redis.
+	EXEC_EVENTS_MAX = 10000
+)
+
+func EncodeExecEvent(event *ebpf.ExecEvent) (string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func DecodeExecEvent(eventSerdString string) (*ebpf.ExecEvent, error) {
+	event := &ebpf.ExecEvent{}
+	if err := json.Unmarshal([]byte(eventSerdString), event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
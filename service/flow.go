@@ -0,0 +1,39 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+)
+
+// SESSIONS_KEY is the Redis set of the serialized keys of the sessions captured, each of
+// them is a sorted set of the serialized packets scored by the second they are captured at.
+const SESSIONS_KEY = "sessions"
+
+// SESSION_OWNERS_KEY is the Redis hash which maps the serialized session key to the
+// process and container owning the session.
+const SESSION_OWNERS_KEY = "session-owners"
+
+// SESSION_VERDICTS_KEY is the Redis hash which maps the serialized session key to the
+// verdict of the XDP programs for its latest packet dropped or monitored, handler.VERDICT_*.
+const SESSION_VERDICTS_KEY = "session-verdicts"
+
+func EncodeFlowOwner(owner *ebpf.FlowOwner) (string, error) {
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func DecodeFlowOwner(ownerSerdString string) (*ebpf.FlowOwner, error) {
+	owner := &ebpf.FlowOwner{}
+	if err := json.Unmarshal([]byte(ownerSerdString), owner); err != nil {
+		return nil, err
+	}
+	return owner, nil
+}
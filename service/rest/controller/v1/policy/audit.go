@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apiv1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+// reportRequest carries the results of applying the policies reported by a node.
+type reportRequest struct {
+	Events []*apiv1.AuditEvent `json:"events"`
+}
+
+// Audit queries the audit log by the parameters since and until in RFC 3339, actor, policy
+// and limit, the most recent events are kept by limit.
+func (p *PolicyController) Audit(c *gin.Context) {
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 13,
+			"msg":  err.Error(),
+			"data": nil,
+		})
+
+		return
+	}
+
+	events, err := p.srv.Audit().List(c, query)
+	if err != nil {
+		c.JSON(httpStatusOf(err), gin.H{
+			"code": 13,
+			"msg":  err.Error(),
+			"data": nil,
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 14,
+		"msg":  "response from Audit list",
+		"data": events,
+	})
+}
+
+// Report appends the results of applying the policies reported by a node to the audit log.
+func (p *PolicyController) Report(c *gin.Context) {
+	var r reportRequest
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 15,
+			"msg":  "malformed events: " + err.Error(),
+			"data": nil,
+		})
+
+		return
+	}
+
+	if err := p.srv.Audit().Report(withChange(c), r.Events); err != nil {
+		c.JSON(httpStatusOf(err), gin.H{
+			"code": 15,
+			"msg":  err.Error(),
+			"data": nil,
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 16,
+		"msg":  fmt.Sprintf("ok %v events recorded", len(r.Events)),
+		"data": nil,
+	})
+}
+
+func parseAuditQuery(c *gin.Context) (*apiv1.AuditQuery, error) {
+	query := &apiv1.AuditQuery{Actor: c.Query("actor"), PolicyID: c.Query("policy")}
+	for param, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		var err error
+		if *t, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("invalid %v %q, should be in RFC 3339 like 2022-08-20T10:21:05+08:00", param, value)
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", value)
+		}
+	}
+	return query, nil
+}
@@ -1,29 +1,47 @@
 package policy
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
 )
 
+// createRequest is the policy to be created, its lifetime can be given by TTL instead of
+// expiry.
+type createRequest struct {
+	v1.Policy
+	TTL string `json:"ttl,omitempty"` // lifetime from the creation, e.g. "30m"
+}
+
+// Create creates the policy in request body, unknown fields are rejected so that a typo
+// in the field names does not silently fall back to the defaults.
 func (p *PolicyController) Create(c *gin.Context) {
-	var r v1.Policy
-	if err := c.ShouldBindJSON(&r); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	var r createRequest
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&r)
+	if err == nil {
+		err = r.setExpiry(time.Now())
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"code": 3,
 			"data": nil,
-			"msg":  err,
+			"msg":  "malformed policy: " + err.Error(),
 		})
 
 		return
 	}
 
-	if err := p.srv.Policy().Create(c, r); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	if err := p.srv.Policy().Create(withChange(c), &r.Policy); err != nil {
+		c.JSON(httpStatusOf(err), gin.H{
 			"code": 3,
 			"data": nil,
-			"msg":  err,
+			"msg":  err.Error(),
 		})
 
 		return
@@ -31,7 +49,25 @@ func (p *PolicyController) Create(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"code": 4,
-		"data": nil,
-		"msg":  "ok " + r.Policy,
+		"data": r.Policy,
+		"msg":  "ok " + r.ID,
 	})
 }
+
+// setExpiry turns TTL into the expiry of the policy created at now.
+func (r *createRequest) setExpiry(now time.Time) error {
+	if r.TTL == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(r.TTL)
+	switch {
+	case err != nil || ttl <= 0:
+		return fmt.Errorf("ttl %q should be a positive duration like 30m", r.TTL)
+	case r.Expiry != nil:
+		return fmt.Errorf("ttl and expiry are exclusive")
+	}
+
+	expiry := now.Add(ttl)
+	r.Expiry = &expiry
+	return nil
+}
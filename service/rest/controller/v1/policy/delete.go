@@ -7,10 +7,10 @@ import (
 )
 
 func (p *PolicyController) Delete(c *gin.Context) {
-	if err := p.srv.Policy().Delete(c, c.Param("ip")); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	if err := p.srv.Policy().Delete(withChange(c), c.Param("id")); err != nil {
+		c.JSON(httpStatusOf(err), gin.H{
 			"code": 5,
-			"msg":  err,
+			"msg":  err.Error(),
 			"data": nil,
 		})
 
@@ -1,9 +1,26 @@
 package policy
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 )
 
 func (p *PolicyController) Get(c *gin.Context) {
+	policy, err := p.srv.Policy().Get(c, c.Param("id"))
+	if err != nil {
+		c.JSON(httpStatusOf(err), gin.H{
+			"code": 7,
+			"msg":  err.Error(),
+			"data": nil,
+		})
+
+		return
+	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"code": 8,
+		"msg":  "response from Policy get",
+		"data": policy,
+	})
 }
@@ -2,24 +2,52 @@ package policy
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
 )
 
+// policyStatus is a policy listed with its lifetime at the time of listing.
+type policyStatus struct {
+	*v1.Policy
+	Active    bool   `json:"active"`              // whether the policy is enforced now
+	Remaining string `json:"remaining,omitempty"` // lifetime left until expiry, e.g. "29m10s"
+}
+
+func newPolicyStatus(policy *v1.Policy, now time.Time) *policyStatus {
+	status := &policyStatus{Policy: policy, Active: policy.Active(now)}
+	if policy.Expiry != nil {
+		remaining := policy.Expiry.Sub(now)
+		if remaining < 0 {
+			remaining = 0
+		}
+		status.Remaining = remaining.Truncate(time.Second).String()
+	}
+	return status
+}
+
+// List lists the policies, each with whether it is active and how long it has left.
 func (p *PolicyController) List(c *gin.Context) {
 	policies, err := p.srv.Policy().List(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 1,
 			"data": nil,
-			"msg":  err,
+			"msg":  err.Error(),
 		})
 		return
 	}
 
+	now := time.Now()
+	statuses := make([]*policyStatus, 0, len(policies))
+	for _, policy := range policies {
+		statuses = append(statuses, newPolicyStatus(policy, now))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code": 2,
-		"data": policies,
+		"data": statuses,
 		"msg":  "response from Policies List",
 	})
 }
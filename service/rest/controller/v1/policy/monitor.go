@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Monitor reports how much traffic every monitor policy would have dropped, by node.
+func (p *PolicyController) Monitor(c *gin.Context) {
+	traffic, err := p.srv.Monitor().List(c)
+	if err != nil {
+		c.JSON(httpStatusOf(err), gin.H{
+			"code": 11,
+			"msg":  err.Error(),
+			"data": nil,
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 12,
+		"msg":  "response from Policies monitor",
+		"data": traffic,
+	})
+}
+
+// MonitorPolicy reports how much traffic the monitor policy would have dropped, by node.
+func (p *PolicyController) MonitorPolicy(c *gin.Context) {
+	traffic, err := p.srv.Monitor().Get(c, c.Param("id"))
+	if err != nil {
+		c.JSON(httpStatusOf(err), gin.H{
+			"code": 11,
+			"msg":  err.Error(),
+			"data": nil,
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 12,
+		"msg":  "response from Policy monitor",
+		"data": traffic,
+	})
+}
+
+// Enforce promotes the monitor policy to drop the traffic it has been counting.
+func (p *PolicyController) Enforce(c *gin.Context) {
+	policy, err := p.srv.Policy().Promote(withChange(c), c.Param("id"))
+	if err != nil {
+		c.JSON(httpStatusOf(err), gin.H{
+			"code": 9,
+			"msg":  err.Error(),
+			"data": nil,
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 10,
+		"msg":  "ok " + policy.ID + " enforced",
+		"data": policy,
+	})
+}
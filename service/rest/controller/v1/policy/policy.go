@@ -1,6 +1,12 @@
 package policy
 
 import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	apiv1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
 	v1 "github.com/p1nant0m/xdp-tracing/service/rest/service/v1"
 	"github.com/p1nant0m/xdp-tracing/service/rest/store"
 )
@@ -9,6 +15,43 @@ type PolicyController struct {
 	srv v1.Service
 }
 
-func NewPolicyController(store store.Factory) *PolicyController {
-	return &PolicyController{srv: v1.NewService(store)}
+// NewPolicyController returns the PolicyController on store, reports and nodes may be nil if
+// the reports and the registration of the nodes are not available.
+func NewPolicyController(store store.Factory, reports v1.MonitorReports, nodes v1.NodeAddress) *PolicyController {
+	return &PolicyController{srv: v1.NewService(store, reports, nodes)}
+}
+
+// Headers of the requests which change the policies, they are recorded in the audit log
+// with the source ip of the request. The actor is not authenticated, it is whoever the
+// client declares until the REST API authenticates its users.
+const (
+	HEADER_ACTOR  = "X-Actor"
+	HEADER_REASON = "X-Reason"
+)
+
+// withChange returns the context of the request made by the actor of c.
+func withChange(c *gin.Context) context.Context {
+	return v1.WithChange(c, &v1.Change{
+		Actor:    c.GetHeader(HEADER_ACTOR),
+		SourceIP: c.ClientIP(),
+		Reason:   c.GetHeader(HEADER_REASON),
+	})
+}
+
+// httpStatusOf returns the HTTP status code responding to err
+func httpStatusOf(err error) int {
+	var validationErr apiv1.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrPolicyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrPolicyExists), errors.Is(err, store.ErrPolicyConflict):
+		return http.StatusConflict
+	case errors.Is(err, v1.ErrNodeUnverified):
+		return http.StatusForbidden
+	case errors.Is(err, v1.ErrMonitorUnavailable), errors.Is(err, v1.ErrRegistryUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
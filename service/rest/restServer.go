@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -21,9 +22,15 @@ import (
 	"github.com/google/uuid"
 	"github.com/p1nant0m/xdp-tracing/handler/utils"
 	"github.com/p1nant0m/xdp-tracing/perf"
+	apiv1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
 	"github.com/p1nant0m/xdp-tracing/service"
 	loganalysis "github.com/p1nant0m/xdp-tracing/service/rest/controller/logAnalysis"
 	"github.com/p1nant0m/xdp-tracing/service/rest/controller/v1/policy"
+	svcv1 "github.com/p1nant0m/xdp-tracing/service/rest/service/v1"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store/boltdb"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store/etcd"
 	"github.com/p1nant0m/xdp-tracing/service/rest/store/local"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
@@ -32,6 +39,11 @@ const (
 	REDIS_GET_COMM      = iota
 	REDIS_QUERY_TIMEOUT = time.Second * 3
 	REDIS_SERVICE       = "redis-service"
+
+	// bounds of the exec events read by /get/execs
+	EXEC_QUERY_DEFAULT_LIMIT = 100
+	EXEC_QUERY_MAX_LIMIT     = 1000
+	EXEC_QUERY_PAGE          = 500
 )
 
 var empty struct{}
@@ -50,6 +62,9 @@ var (
 
 	ENABLE_PRODUCTION bool
 	restConfig        *service.RestConfig
+	storeFactory      store.Factory // store of the policies, selected by rest.store of the config
+	monitorReports    svcv1.MonitorReports // reports of the monitor policies published by the nodes in etcd
+	nodeAddress       svcv1.NodeAddress    // addresses registered by the nodes in etcd, verifying their reports
 )
 
 func RunRestServer(configPath string) {
@@ -79,8 +94,23 @@ func RunRestServer(configPath string) {
 
 	var etcdService *service.EtcdService = service.NewEtcdService(ctx)
 	etcdService.Conn()
+	if storeFactory, err = newStoreFactory(etcdService.Client); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	monitorReports = func(ctx context.Context) (map[string]*apiv1.MonitorReport, error) {
+		return etcd.ListMonitorReports(ctx, etcdService.Client)
+	}
+	nodeAddress = func(ctx context.Context, id string) (string, error) {
+		return etcd.GetNodeAddress(ctx, etcdService.Client, id)
+	}
 	go func(client *clientv3.Client) {
-		resps, _ := client.Get(ctx, "host-info", clientv3.WithPrefix())
+		resps, err := client.Get(ctx, "host-info", clientv3.WithPrefix())
+		if err != nil {
+			// the instances are unknown without etcd, while the other APIs still work
+			fmt.Println("failed to list the instances in etcd: " + err.Error())
+			return
+		}
 		for _, kv := range resps.Kvs {
 			newHostInfo := &struct {
 				*service.SpecConfig
@@ -133,25 +163,33 @@ func RestServe(ctx context.Context) {
 	getAllSessionHandler := preparegetAllSessionHandler(redisService)
 	getSessionPackets := preparegetSessionPackets(redisService)
 	getInstancesHandler := prepareGetInstancesHandler()
+	getExecsHandler := preparegetExecsHandler(redisService)
 
 	r := gin.Default()
 	r.Use(CORSMiddleware())
-	dbIns, _ := local.GetLocalStorageFactoryOr()
 	v1 := r.Group("/v1")
 	{
+		policyController := policy.NewPolicyController(storeFactory, monitorReports, nodeAddress)
 		policyv1 := v1.Group("/policies")
 		{
-			policyController := policy.NewPolicyController(dbIns)
-
 			policyv1.GET("", policyController.List)
-			policyv1.GET(":ip", policyController.Get)
-			policyv1.DELETE(":ip", policyController.Delete)
+			policyv1.GET(":id", policyController.Get)
+			policyv1.DELETE(":id", policyController.Delete)
 			policyv1.POST("", policyController.Create)
+			// monitor policies count the traffic they would drop until they are enforced
+			policyv1.GET(":id/monitor", policyController.MonitorPolicy)
+			policyv1.POST(":id/enforce", policyController.Enforce)
 		}
+		v1.GET("/monitor", policyController.Monitor)
+		// every change of the policies and the results of the nodes applying them, the nodes
+		// report from the address they registered
+		v1.GET("/audit", policyController.Audit)
+		v1.POST("/audit/nodes", policyController.Report)
 	}
 	r.GET("get/session/all", getAllSessionHandler)
 	r.GET("get/session/:key", getSessionPackets)
 	r.GET("get/instances", getInstancesHandler)
+	r.GET("get/execs", getExecsHandler)
 	r.GET("healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"msg": "response form healthz", "timestamp": time.Now().Unix()})
 	})
@@ -188,11 +226,25 @@ func RestServe(ctx context.Context) {
 	}
 }
 
+// newStoreFactory returns the store of the policies selected by rest.store of the config.
+func newStoreFactory(etcdClient *clientv3.Client) (store.Factory, error) {
+	switch restConfig.Store {
+	case "", service.REST_STORE_MEMORY:
+		return local.GetLocalStorageFactoryOr()
+	case service.REST_STORE_ETCD:
+		return etcd.GetEtcdFactoryOr(etcdClient)
+	case service.REST_STORE_BOLT:
+		return boltdb.GetBoltFactoryOr(service.ExtractRestBoltOptions())
+	}
+	return nil, fmt.Errorf("unknown store %q of the policies, should be %v, %v or %v",
+		restConfig.Store, service.REST_STORE_MEMORY, service.REST_STORE_ETCD, service.REST_STORE_BOLT)
+}
+
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Actor, X-Reason")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
 
 		if c.Request.Method == "OPTIONS" {
@@ -246,7 +298,10 @@ func prepareGetInstancesHandler() (fn gin.HandlerFunc) {
 // 		"DstPort": 1080,
 // 		"Timestamp": 1652732483,
 // 		"Direction": "Egress"
-// 	}]}
+// 	}],
+// "owner": {"pid": 1024, "comm": "curl", ...},
+// "tcp": {"retransmits": 3, "sentresets": 0, "receivedresets": 1, "state": "CLOSE", "events": [...], ...}}
+// owner and tcp are null when they are unknown.
 func preparegetSessionPackets(redisService *service.RedisService) (fn gin.HandlerFunc) {
 	fn = func(c *gin.Context) {
 		// Setting Redis query timeout
@@ -324,9 +379,12 @@ func preparegetSessionPackets(redisService *service.RedisService) (fn gin.Handle
 					}
 				}
 				c.JSON(http.StatusOK, gin.H{
-					"msg":  "/get/session/:key response",
-					"code": 0,
-					"data": value_list,
+					"msg":     "/get/session/:key response",
+					"code":    0,
+					"data":    value_list,
+					"owner":   getSessionOwner(ctx, redisService, uuID, notifyCh, string(key)),
+					"tcp":     getSessionTCPStats(ctx, redisService, uuID, notifyCh, string(key)),
+					"verdict": getSessionVerdict(ctx, redisService, uuID, notifyCh, string(key)),
 				})
 			}
 
@@ -339,6 +397,75 @@ func preparegetSessionPackets(redisService *service.RedisService) (fn gin.Handle
 	return
 }
 
+// getSessionOwner queries the process and container owning the session keyed by key, it
+// returns nil if the owner is unknown
+func getSessionOwner(ctx context.Context, redisService *service.RedisService, uuID string,
+	notifyCh <-chan *service.NotifyMsg, key string) *ebpf.FlowOwner {
+	task := func(rdb *redis.Client) (interface{}, error) {
+		return rdb.HGet(ctx, service.SESSION_OWNERS_KEY, key).Result()
+	}
+	redisService.TaskAssign(task, "string", uuID)
+
+	select {
+	case notifyMsg := <-notifyCh:
+		if notifyMsg.ErrorMsg != nil || notifyMsg.ResultType != "string" {
+			return nil
+		}
+		owner, err := service.DecodeFlowOwner(notifyMsg.ExecuteResult.(string))
+		if err != nil {
+			return nil
+		}
+		return owner
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// getSessionVerdict queries the verdict of the XDP programs for the session keyed by key,
+// handler.VERDICT_DROP or handler.VERDICT_MONITOR, it returns "" if the session is passed
+func getSessionVerdict(ctx context.Context, redisService *service.RedisService, uuID string,
+	notifyCh <-chan *service.NotifyMsg, key string) string {
+	task := func(rdb *redis.Client) (interface{}, error) {
+		return rdb.HGet(ctx, service.SESSION_VERDICTS_KEY, key).Result()
+	}
+	redisService.TaskAssign(task, "string", uuID)
+
+	select {
+	case notifyMsg := <-notifyCh:
+		if notifyMsg.ErrorMsg != nil || notifyMsg.ResultType != "string" {
+			return ""
+		}
+		return notifyMsg.ExecuteResult.(string)
+	case <-ctx.Done():
+		return ""
+	}
+}
+
+// getSessionTCPStats queries the counters of TCP retransmissions, resets and state changes
+// of the connection which the session keyed by key belongs to, it returns nil if none of
+// them has been observed
+func getSessionTCPStats(ctx context.Context, redisService *service.RedisService, uuID string,
+	notifyCh <-chan *service.NotifyMsg, key string) *ebpf.TCPConnStats {
+	task := func(rdb *redis.Client) (interface{}, error) {
+		return rdb.Get(ctx, service.SessionTCPStatsKey(key)).Result()
+	}
+	redisService.TaskAssign(task, "string", uuID)
+
+	select {
+	case notifyMsg := <-notifyCh:
+		if notifyMsg.ErrorMsg != nil || notifyMsg.ResultType != "string" {
+			return nil
+		}
+		stats, err := service.DecodeTCPConnStats(notifyMsg.ExecuteResult.(string))
+		if err != nil {
+			return nil
+		}
+		return stats
+	case <-ctx.Done():
+		return nil
+	}
+}
+
 // preparegetALLSessionHandler implement the RESTFUL API /get/all/session
 // Its reponse will be like if everything goes well
 // {
@@ -375,7 +502,7 @@ func preparegetAllSessionHandler(redisService *service.RedisService) (fn gin.Han
 		notifyCh, _ := redisService.RetrieveChannel(uuID)
 
 		task := func(rdb *redis.Client) (interface{}, error) {
-			value, err := rdb.SMembers(ctx, "sessions").Result()
+			value, err := rdb.SMembers(ctx, service.SESSIONS_KEY).Result()
 			return value, err
 		}
 		ResultType := "[]string"
@@ -428,3 +555,126 @@ func preparegetAllSessionHandler(redisService *service.RedisService) (fn gin.Han
 	}
 	return
 }
+
+// preparegetExecsHandler implement the RESTFUL API /get/execs, it returns the latest exec
+// events reported by the nodes, newest first. The events can be filtered by query parameters
+// node, pid, ppid, uid, comm, cgroup and failed, and limit (default 100, at most 1000) bounds
+// the number of events in response.
+// Its response will be like if everything goes well
+// {
+// "data": [
+// {
+// 		"node": "192.168.176.128",
+// 		"timestamp": "2022-08-20T10:21:05.123456+08:00",
+// 		"pid": 4271,
+// 		"tid": 4271,
+// 		"ppid": 1022,
+// 		"uid": 0,
+// 		"comm": "curl",
+// 		"argv": ["/usr/bin/curl", "-s", "192.168.176.1:1080"],
+// 		"truncated": false,
+// 		"retval": 0,
+// 		"cgroupid": 7234,
+// 		"cgroup": "/user.slice/user-0.slice/session-3.scope"
+// 	}]}
+func preparegetExecsHandler(redisService *service.RedisService) (fn gin.HandlerFunc) {
+	fn = func(c *gin.Context) {
+		// Setting Redis Query timeout
+		ctx, cancel := context.WithDeadline(context.TODO(), time.Now().Add(REDIS_QUERY_TIMEOUT))
+		defer cancel()
+
+		filter, limit, err := parseExecQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		node := c.Query("node")
+
+		uuID := uuid.New().String()
+		redisService.Register(uuID)
+		defer redisService.Destory(uuID)
+		notifyCh, _ := redisService.RetrieveChannel(uuID)
+
+		// the events are read page by page from the newest, and no more is read once limit
+		// events are matched
+		task := func(rdb *redis.Client) (interface{}, error) {
+			eventList := []*ebpf.ExecEvent{}
+			for start := int64(0); len(eventList) < limit; start += EXEC_QUERY_PAGE {
+				page, err := rdb.ZRevRange(ctx, service.EXEC_EVENTS_KEY, start, start+EXEC_QUERY_PAGE-1).Result()
+				if err != nil {
+					return nil, err
+				}
+				for _, eventS := range page {
+					event, err := service.DecodeExecEvent(eventS)
+					if err != nil {
+						continue
+					}
+					if (node == "" || node == event.Node) && filter.Match(event) && len(eventList) < limit {
+						eventList = append(eventList, event)
+					}
+				}
+				if len(page) < EXEC_QUERY_PAGE {
+					break
+				}
+			}
+			return eventList, nil
+		}
+		ResultType := "[]*ebpf.ExecEvent"
+		redisService.TaskAssign(task, ResultType, uuID)
+
+		select {
+		case notifyMsg := <-notifyCh:
+			if notifyMsg.ErrorMsg != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": notifyMsg.ErrorMsg.Error()})
+				return
+			}
+			if notifyMsg.ResultType != ResultType {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": fmt.Sprintf("inconsitency between expeted Type %v and received Type %v", ResultType, notifyMsg.ResultType),
+				})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"msg":  "/get/execs response",
+				"code": 0,
+				"data": notifyMsg.ExecuteResult.([]*ebpf.ExecEvent),
+			})
+
+		case <-ctx.Done():
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "timeout happens when quering redisDB",
+			})
+		}
+	}
+	return
+}
+
+// parseExecQuery makes the ExecFilter and limit from query parameters of /get/execs
+func parseExecQuery(c *gin.Context) (*ebpf.ExecFilter, int, error) {
+	filter := &ebpf.ExecFilter{
+		Comms:      c.QueryArray("comm"),
+		Cgroup:     c.Query("cgroup"),
+		FailedOnly: c.Query("failed") == "true",
+	}
+
+	for param, list := range map[string]*[]uint32{"pid": &filter.Pids, "ppid": &filter.PPids, "uid": &filter.Uids} {
+		for _, value := range c.QueryArray(param) {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid %v %q", param, value)
+			}
+			*list = append(*list, uint32(id))
+		}
+	}
+
+	limit := EXEC_QUERY_DEFAULT_LIMIT
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > EXEC_QUERY_MAX_LIMIT {
+			return nil, 0, fmt.Errorf("invalid limit %q, should be in [1, %v]", value, EXEC_QUERY_MAX_LIMIT)
+		}
+	}
+
+	return filter, limit, nil
+}
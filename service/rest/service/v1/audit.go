@@ -0,0 +1,159 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+)
+
+// ErrNodeUnverified is returned when the events are reported by a node which is not
+// registered, or from an address other than the one it registered.
+var ErrNodeUnverified = errors.New("the node reporting the events is not registered from the source ip")
+
+// ErrRegistryUnavailable is returned when the registration of the nodes can not be read.
+var ErrRegistryUnavailable = errors.New("the registration of the nodes is not available")
+
+// NodeAddress returns the address of the gRPC server which the node of id registered, ""
+// if it is not registered.
+type NodeAddress func(ctx context.Context, id string) (string, error)
+
+// ANONYMOUS_ACTOR is recorded as the actor of the changes made without one.
+const ANONYMOUS_ACTOR = "anonymous"
+
+// Change is who makes a change of policies, from where and why, it is recorded with the
+// change in the audit log. The actor is declared by the client until the REST API
+// authenticates its users, only the source ip is observed.
+type Change struct {
+	Actor    string
+	SourceIP string
+	Reason   string
+}
+
+type changeKey struct{}
+
+// WithChange returns the context of the requests made by change.
+func WithChange(ctx context.Context, change *Change) context.Context {
+	return context.WithValue(ctx, changeKey{}, change)
+}
+
+func changeFrom(ctx context.Context) *Change {
+	change, _ := ctx.Value(changeKey{}).(*Change)
+	if change == nil {
+		change = &Change{}
+	}
+	if change.Actor == "" {
+		change.Actor = ANONYMOUS_ACTOR
+	}
+	return change
+}
+
+type AuditSrv interface {
+	// List returns the events of the audit log selected by the query
+	List(context.Context, *v1.AuditQuery) ([]*v1.AuditEvent, error)
+	// Report appends the results of applying the policies reported by a node, the node is
+	// recorded as the actor once the source ip of the context is found to be the address it
+	// registered
+	Report(context.Context, []*v1.AuditEvent) error
+}
+
+type auditService struct {
+	store store.Factory
+	nodes NodeAddress
+}
+
+func newAudit(srv *service) *auditService {
+	return &auditService{store: srv.store, nodes: srv.nodes}
+}
+
+// List returns a v1.ValidationError if the query selects too many events.
+func (a *auditService) List(ctx context.Context, query *v1.AuditQuery) ([]*v1.AuditEvent, error) {
+	if query.Limit > v1.AUDIT_MAX_LIMIT {
+		return nil, v1.ValidationError{fmt.Sprintf("limit %v is more than %v", query.Limit, v1.AUDIT_MAX_LIMIT)}
+	}
+	return a.store.Audit().List(query)
+}
+
+// Report returns a v1.ValidationError before anything is appended if any of events can not
+// be reported by a node, and ErrNodeUnverified if any of the nodes is not registered from
+// the source ip of ctx.
+func (a *auditService) Report(ctx context.Context, events []*v1.AuditEvent) error {
+	for i, event := range events {
+		if err := event.ValidateNodeEvent(); err != nil {
+			return v1.ValidationError{fmt.Sprintf("event %v: %v", i, err)}
+		}
+	}
+
+	change := changeFrom(ctx)
+	verified := make(map[string]bool)
+	for _, event := range events {
+		if verified[event.NodeID] {
+			continue
+		}
+		if err := a.verifyNode(ctx, event.NodeID, change.SourceIP); err != nil {
+			return err
+		}
+		verified[event.NodeID] = true
+	}
+
+	for _, event := range events {
+		event.Actor = event.NodeID
+		event.SourceIP = change.SourceIP
+		if err := a.store.Audit().Append(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyNode returns ErrNodeUnverified unless the node of id registered an address on
+// sourceIP. The node id is declared by the node itself, so the address it registered is the
+// only proof of it until the nodes authenticate.
+func (a *auditService) verifyNode(ctx context.Context, id string, sourceIP string) error {
+	if a.nodes == nil {
+		return ErrRegistryUnavailable
+	}
+	addr, err := a.nodes(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRegistryUnavailable, err)
+	}
+	if addr == "" {
+		return fmt.Errorf("%w: node %v is not registered", ErrNodeUnverified, id)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.Equal(net.ParseIP(sourceIP)) {
+		return fmt.Errorf("%w: node %v registered %v rather than %v", ErrNodeUnverified, id, host, sourceIP)
+	}
+	return nil
+}
+
+// changeEvent returns the event of the change of the policy from before to after made by the
+// actor of ctx, either may be nil for creations and deletions. It is appended along with the
+// change by the store.
+func changeEvent(ctx context.Context, kind string, before, after *v1.Policy) *v1.AuditEvent {
+	change := changeFrom(ctx)
+	event := &v1.AuditEvent{
+		Time:     time.Now(),
+		Kind:     kind,
+		Actor:    change.Actor,
+		SourceIP: change.SourceIP,
+		Reason:   change.Reason,
+		Before:   before,
+		After:    after,
+	}
+	if after != nil {
+		event.PolicyID = after.ID
+	} else {
+		event.PolicyID = before.ID
+	}
+
+	return event
+}
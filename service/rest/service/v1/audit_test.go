@@ -0,0 +1,44 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store/local"
+)
+
+func TestAuditReportVerifyNode(t *testing.T) {
+	s, err := local.GetLocalStorageFactoryOr()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	nodes := func(ctx context.Context, id string) (string, error) {
+		if id == "1" {
+			return "192.168.176.128:50051", nil
+		}
+		return "", nil
+	}
+	audit := NewService(s, nil, nodes).Audit()
+	report := func(nodeID string, sourceIP string) error {
+		event := &v1.AuditEvent{Time: time.Now(), Kind: v1.AUDIT_APPLY, PolicyID: "lb", NodeID: nodeID}
+		return audit.Report(WithChange(context.Background(), &Change{SourceIP: sourceIP}), []*v1.AuditEvent{event})
+	}
+
+	if err := report("1", "192.168.176.128"); err != nil {
+		t.Fatalf("Expected the event of the registered node recorded, got %v", err)
+	}
+	if err := report("1", "192.168.176.1"); !errors.Is(err, ErrNodeUnverified) {
+		t.Fatalf("Expected the event from another address refused, got %v", err)
+	}
+	if err := report("2", "192.168.176.128"); !errors.Is(err, ErrNodeUnverified) {
+		t.Fatalf("Expected the event of an unregistered node refused, got %v", err)
+	}
+
+	events, err := audit.List(context.Background(), &v1.AuditQuery{Actor: "1"})
+	if err != nil || len(events) != 1 || events[0].SourceIP != "192.168.176.128" {
+		t.Fatalf("Expected the event of node 1 recorded only, got %v err=%v", events, err)
+	}
+}
@@ -0,0 +1,79 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+)
+
+// ErrMonitorUnavailable is returned when the reports of the nodes can not be read.
+var ErrMonitorUnavailable = errors.New("the reports of the nodes are not available")
+
+// MonitorReports returns the reports published by the nodes by their ids, see
+// v1.MonitorReport.
+type MonitorReports func(ctx context.Context) (map[string]*v1.MonitorReport, error)
+
+type MonitorSrv interface {
+	// List returns how much traffic every monitor policy would have dropped
+	List(context.Context) ([]*v1.MonitorTraffic, error)
+	// Get returns how much traffic the monitor policy of id would have dropped
+	Get(context.Context, string) (*v1.MonitorTraffic, error)
+}
+
+type monitorService struct {
+	store   store.Factory
+	reports MonitorReports
+}
+
+func newMonitor(srv *service) *monitorService {
+	return &monitorService{store: srv.store, reports: srv.reports}
+}
+
+func (m *monitorService) List(ctx context.Context) ([]*v1.MonitorTraffic, error) {
+	policies, err := m.store.Policy().List()
+	if err != nil {
+		return nil, err
+	}
+	reports, err := m.readReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := []*v1.MonitorTraffic{}
+	for _, policy := range policies {
+		if policy.Action == v1.ACTION_MONITOR {
+			traffic = append(traffic, v1.NewMonitorTraffic(policy, reports))
+		}
+	}
+	return traffic, nil
+}
+
+// Get returns a v1.ValidationError if the policy of id is not monitored.
+func (m *monitorService) Get(ctx context.Context, id string) (*v1.MonitorTraffic, error) {
+	policy, err := m.store.Policy().Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if policy.Action != v1.ACTION_MONITOR {
+		return nil, v1.ValidationError{fmt.Sprintf("policy %v is not monitored, its action is %v", id, policy.Action)}
+	}
+	reports, err := m.readReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return v1.NewMonitorTraffic(policy, reports), nil
+}
+
+func (m *monitorService) readReports(ctx context.Context) (map[string]*v1.MonitorReport, error) {
+	if m.reports == nil {
+		return nil, ErrMonitorUnavailable
+	}
+	reports, err := m.reports(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMonitorUnavailable, err)
+	}
+	return reports, nil
+}
@@ -2,15 +2,22 @@ package v1
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/google/uuid"
 	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
 	"github.com/p1nant0m/xdp-tracing/service/rest/store"
 )
 
+// PolicySrv changes the policies on behalf of the Change of the context, every change is
+// recorded in the audit log, see WithChange.
 type PolicySrv interface {
-	Create(context.Context, v1.Policy) error
+	Create(context.Context, *v1.Policy) error
+	Get(context.Context, string) (*v1.Policy, error)
 	Delete(context.Context, string) error
-	List(context.Context) ([]string, error)
+	List(context.Context) ([]*v1.Policy, error)
+	Promote(context.Context, string) (*v1.Policy, error)
 }
 
 type policyService struct {
@@ -21,23 +28,62 @@ func newPolicy(srv *service) *policyService {
 	return &policyService{store: srv.store}
 }
 
-func (p *policyService) Create(ctx context.Context, policy v1.Policy) error {
-	if err := p.store.Policy().Create(policy.Policy); err != nil {
+// Create fills the omitted fields of policy and stores it, an id is generated when it is
+// not given. A v1.ValidationError is returned if policy is malformed or can not be enforced
+// by the nodes.
+func (p *policyService) Create(ctx context.Context, policy *v1.Policy) error {
+	if policy.ID == "" {
+		policy.ID = uuid.New().String()
+	}
+	policy.Default()
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	if err := policy.CheckEnforceable(); err != nil {
 		return err
 	}
+	if policy.Expired(time.Now()) {
+		return v1.ValidationError{fmt.Sprintf("expiry %v is in the past", policy.Expiry)}
+	}
 
-	return nil
+	return p.store.Policy().Create(policy, changeEvent(ctx, v1.AUDIT_CREATE, nil, policy))
 }
 
-func (p *policyService) Delete(ctx context.Context, policy string) error {
-	if err := p.store.Policy().Delete(string(policy)); err != nil {
+func (p *policyService) Get(ctx context.Context, id string) (*v1.Policy, error) {
+	return p.store.Policy().Get(id)
+}
+
+// Delete records the policy last read before it is deleted as the one before the change.
+func (p *policyService) Delete(ctx context.Context, id string) error {
+	policy, err := p.store.Policy().Get(id)
+	if err != nil {
 		return err
 	}
+	return p.store.Policy().Delete(id, changeEvent(ctx, v1.AUDIT_DELETE, policy, nil))
+}
 
-	return nil
+// Promote turns the monitor policy of id into a drop policy, so that the traffic it has been
+// counting is dropped by the nodes. A v1.ValidationError is returned if the policy is not
+// monitored, and store.ErrPolicyConflict if it is changed meanwhile.
+func (p *policyService) Promote(ctx context.Context, id string) (*v1.Policy, error) {
+	policy, err := p.store.Policy().Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if policy.Action != v1.ACTION_MONITOR {
+		return nil, v1.ValidationError{fmt.Sprintf("policy %v is not monitored, its action is %v", id, policy.Action)}
+	}
+
+	// the memory store hands out the policy stored
+	promoted := *policy
+	promoted.Action = v1.ACTION_DROP
+	if err := p.store.Policy().Update(&promoted, changeEvent(ctx, v1.AUDIT_PROMOTE, policy, &promoted)); err != nil {
+		return nil, err
+	}
+	return &promoted, nil
 }
 
-func (p *policyService) List(ctx context.Context) ([]string, error) {
+func (p *policyService) List(ctx context.Context) ([]*v1.Policy, error) {
 	policies, err := p.store.Policy().List()
 	if err != nil {
 		return nil, err
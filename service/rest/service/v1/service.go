@@ -4,18 +4,34 @@ import "github.com/p1nant0m/xdp-tracing/service/rest/store"
 
 type Service interface {
 	Policy() PolicySrv
+	Monitor() MonitorSrv
+	Audit() AuditSrv
 }
 
 type service struct {
-	store store.Factory
+	store   store.Factory
+	reports MonitorReports
+	nodes   NodeAddress
 }
 
-func NewService(store store.Factory) Service {
+// NewService returns the Service on store, reports and nodes may be nil if the reports and
+// the registration of the nodes are not available.
+func NewService(store store.Factory, reports MonitorReports, nodes NodeAddress) Service {
 	return &service{
-		store: store,
+		store:   store,
+		reports: reports,
+		nodes:   nodes,
 	}
 }
 
 func (s *service) Policy() PolicySrv {
 	return newPolicy(s)
 }
+
+func (s *service) Monitor() MonitorSrv {
+	return newMonitor(s)
+}
+
+func (s *service) Audit() AuditSrv {
+	return newAudit(s)
+}
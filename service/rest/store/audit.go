@@ -0,0 +1,14 @@
+package store
+
+import (
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+// AuditStore defines the audit log storage interface. The log is append-only, there is no
+// way to change or delete an event once it is appended.
+type AuditStore interface {
+	// Append sets the Seq of event and stores it
+	Append(*v1.AuditEvent) error
+	// List returns the events selected by the query in the order of appending
+	List(*v1.AuditQuery) ([]*v1.AuditEvent, error)
+}
@@ -0,0 +1,68 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	bolt "go.etcd.io/bbolt"
+)
+
+// auditBucket holds the audit log as JSON, keyed by the sequence of the bucket in big
+// endian so that the events are iterated in the order of appending.
+var auditBucket = []byte("audit")
+
+type audit struct {
+	ds *datastore
+}
+
+func newAudit(ds *datastore) *audit {
+	return &audit{ds}
+}
+
+func (a *audit) Append(event *v1.AuditEvent) error {
+	return a.ds.db.Update(func(tx *bolt.Tx) error {
+		return appendEvent(tx, event)
+	})
+}
+
+// appendEvent appends event to the audit log in tx unless it is nil.
+func appendEvent(tx *bolt.Tx, event *v1.AuditEvent) error {
+	if event == nil {
+		return nil
+	}
+	bucket := tx.Bucket(auditBucket)
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	event.Seq = int64(seq)
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return bucket.Put(key, value)
+}
+
+func (a *audit) List(query *v1.AuditQuery) ([]*v1.AuditEvent, error) {
+	var events []*v1.AuditEvent
+	err := a.ds.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).ForEach(func(k, v []byte) error {
+			event := &v1.AuditEvent{}
+			if err := json.Unmarshal(v, event); err != nil {
+				return err
+			}
+			if query.Matches(event) {
+				events = append(events, event)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return query.Select(events), nil
+}
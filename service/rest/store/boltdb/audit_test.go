@@ -0,0 +1,48 @@
+package boltdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/options"
+)
+
+func TestAuditStore(t *testing.T) {
+	opts := options.NewBoltOptions()
+	opts.Path = filepath.Join(t.TempDir(), "xdp-tracing.db")
+	factory, err := GetBoltFactoryOr(opts)
+	if err != nil {
+		t.Fatalf("Expected the bolt store opened, got %v", err)
+	}
+
+	start := time.Date(2022, 8, 20, 10, 0, 0, 0, time.UTC)
+	events := []*v1.AuditEvent{
+		{Time: start, Kind: v1.AUDIT_CREATE, PolicyID: "a", Actor: "alice"},
+		{Time: start.Add(time.Minute), Kind: v1.AUDIT_CREATE, PolicyID: "b", Actor: "bob"},
+		{Time: start.Add(2 * time.Minute), Kind: v1.AUDIT_APPLY, PolicyID: "a", Actor: "node-1", NodeID: "node-1"},
+		{Time: start.Add(3 * time.Minute), Kind: v1.AUDIT_DELETE, PolicyID: "a", Actor: "alice"},
+	}
+	for _, event := range events {
+		if err := factory.Audit().Append(event); err != nil {
+			t.Fatalf("Expected event appended, got %v", err)
+		}
+	}
+	if events[3].Seq <= events[0].Seq {
+		t.Fatalf("Expected increasing sequences, got %v and %v", events[0].Seq, events[3].Seq)
+	}
+
+	list, err := factory.Audit().List(&v1.AuditQuery{PolicyID: "a", Since: start.Add(time.Minute)})
+	if err != nil || len(list) != 2 || list[0].Kind != v1.AUDIT_APPLY || list[1].Kind != v1.AUDIT_DELETE {
+		t.Fatalf("Expected policy a applied and deleted since 10:01, got %v err=%v", list, err)
+	}
+	list, err = factory.Audit().List(&v1.AuditQuery{Actor: "alice", Limit: 1})
+	if err != nil || len(list) != 1 || list[0].Kind != v1.AUDIT_DELETE {
+		t.Fatalf("Expected the latest change of alice, got %v err=%v", list, err)
+	}
+	list, err = factory.Audit().List(&v1.AuditQuery{Until: start.Add(time.Minute)})
+	if err != nil || len(list) != 1 || list[0].PolicyID != "a" {
+		t.Fatalf("Expected policy a created before 10:01, got %v err=%v", list, err)
+	}
+}
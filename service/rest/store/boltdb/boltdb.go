@@ -0,0 +1,53 @@
+package boltdb
+
+import (
+	"fmt"
+
+	"github.com/p1nant0m/xdp-tracing/pkg/db"
+	"github.com/p1nant0m/xdp-tracing/pkg/options"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+type datastore struct {
+	db *bolt.DB
+}
+
+func (ds *datastore) Policy() store.PolicyStore {
+	return newPolicy(ds)
+}
+
+func (ds *datastore) Audit() store.AuditStore {
+	return newAudit(ds)
+}
+
+var boltFactory store.Factory
+
+// GetBoltFactoryOr returns the store.Factory keeping data in the bolt file of opts, opts is
+// only used by the first call.
+func GetBoltFactoryOr(opts *options.BoltOptions) (store.Factory, error) {
+	if boltFactory != nil {
+		return boltFactory, nil
+	}
+	if opts == nil {
+		return nil, fmt.Errorf("failed to get bolt store factory: options are nil")
+	}
+
+	dbIns, err := db.NewBoltDB(opts.Path, opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt file %v: %w", opts.Path, err)
+	}
+	if err := dbIns.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{policyBucket, auditBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	boltFactory = &datastore{db: dbIns}
+	return boltFactory, nil
+}
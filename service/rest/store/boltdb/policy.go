@@ -0,0 +1,125 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"sort"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+// policyBucket holds the policies as JSON by their ids. The revision of a policy is taken
+// from the sequence of the bucket when it is written, the policies are listed in the order
+// of their revisions.
+var policyBucket = []byte("policies")
+
+type policy struct {
+	ds *datastore
+}
+
+func newPolicy(ds *datastore) *policy {
+	return &policy{ds}
+}
+
+func (p *policy) List() ([]*v1.Policy, error) {
+	var policies []*v1.Policy
+	err := p.ds.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(policyBucket).ForEach(func(k, v []byte) error {
+			policy := &v1.Policy{}
+			if err := json.Unmarshal(v, policy); err != nil {
+				return err
+			}
+			policies = append(policies, policy)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Revision < policies[j].Revision })
+	return policies, nil
+}
+
+func (p *policy) Get(id string) (*v1.Policy, error) {
+	policy := &v1.Policy{}
+	err := p.ds.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(policyBucket).Get([]byte(id))
+		if v == nil {
+			return store.ErrPolicyNotFound
+		}
+		return json.Unmarshal(v, policy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (p *policy) Create(policy *v1.Policy, event *v1.AuditEvent) error {
+	return p.ds.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(policyBucket)
+		if bucket.Get([]byte(policy.ID)) != nil {
+			return store.ErrPolicyExists
+		}
+
+		revision, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		policy.Revision = int64(revision)
+		value, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(policy.ID), value); err != nil {
+			return err
+		}
+		return appendEvent(tx, event)
+	})
+}
+
+func (p *policy) Update(policy *v1.Policy, event *v1.AuditEvent) error {
+	return p.ds.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(policyBucket)
+		v := bucket.Get([]byte(policy.ID))
+		if v == nil {
+			return store.ErrPolicyNotFound
+		}
+		stored := &v1.Policy{}
+		if err := json.Unmarshal(v, stored); err != nil {
+			return err
+		}
+		if policy.Revision != 0 && policy.Revision != stored.Revision {
+			return store.ErrPolicyConflict
+		}
+
+		revision, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		policy.Revision = int64(revision)
+		value, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(policy.ID), value); err != nil {
+			return err
+		}
+		return appendEvent(tx, event)
+	})
+}
+
+func (p *policy) Delete(id string, event *v1.AuditEvent) error {
+	return p.ds.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(policyBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return store.ErrPolicyNotFound
+		}
+		if err := bucket.Delete([]byte(id)); err != nil {
+			return err
+		}
+		return appendEvent(tx, event)
+	})
+}
@@ -0,0 +1,61 @@
+package boltdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/options"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+)
+
+func TestPolicyStore(t *testing.T) {
+	opts := options.NewBoltOptions()
+	opts.Path = filepath.Join(t.TempDir(), "xdp-tracing.db")
+	factory, err := GetBoltFactoryOr(opts)
+	if err != nil {
+		t.Fatalf("Expected the bolt store opened, got %v", err)
+	}
+
+	policies := factory.Policy()
+	for _, id := range []string{"b", "a"} {
+		if err := policies.Create(&v1.Policy{ID: id, CIDR: "10.0.0.1/32"}, nil); err != nil {
+			t.Fatalf("Expected policy %v created, got %v", id, err)
+		}
+	}
+	if err := policies.Create(&v1.Policy{ID: "a"}, nil); err != store.ErrPolicyExists {
+		t.Fatalf("Expected ErrPolicyExists, got %v", err)
+	}
+
+	list, err := policies.List()
+	if err != nil || len(list) != 2 || list[0].ID != "b" || list[1].Revision <= list[0].Revision {
+		t.Fatalf("Expected policies b and a in the order of creation, got %v err=%v", list, err)
+	}
+
+	stale := *list[1]
+	list[1].Action = v1.ACTION_DROP
+	if err := policies.Update(list[1], nil); err != nil || list[1].Revision <= stale.Revision {
+		t.Fatalf("Expected policy a updated to a new revision, got %v err=%v", list[1].Revision, err)
+	}
+	now := time.Now()
+	if err := policies.Update(&stale, &v1.AuditEvent{Time: now, Kind: v1.AUDIT_PROMOTE, PolicyID: "a"}); err != store.ErrPolicyConflict {
+		t.Fatalf("Expected ErrPolicyConflict, got %v", err)
+	}
+
+	// the change is recorded along with it, or not at all
+	deleted := &v1.AuditEvent{Time: now, Kind: v1.AUDIT_DELETE, PolicyID: "b"}
+	if err := policies.Delete("b", deleted); err != nil {
+		t.Fatalf("Expected policy b deleted, got %v", err)
+	}
+	if events, err := factory.Audit().List(&v1.AuditQuery{Since: now}); err != nil || len(events) != 1 ||
+		events[0].Kind != v1.AUDIT_DELETE || events[0].Seq != deleted.Seq {
+		t.Fatalf("Expected only the deletion of b recorded, got %v err=%v", events, err)
+	}
+	if _, err := policies.Get("b"); err != store.ErrPolicyNotFound {
+		t.Fatalf("Expected ErrPolicyNotFound, got %v", err)
+	}
+	if policy, err := policies.Get("a"); err != nil || policy.CIDR != "10.0.0.1/32" {
+		t.Fatalf("Expected policy a kept, got %v err=%v", policy, err)
+	}
+}
@@ -0,0 +1,113 @@
+package etcd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// AUDIT_KEY_PREFIX is the prefix of the keys of the audit log. Each event is stored as JSON
+// under the prefix followed by its time in nanoseconds, zero-padded so that a range of time
+// is a range of keys, and a random suffix. The Seq of an event is the revision it is
+// appended at.
+const AUDIT_KEY_PREFIX = "audit:"
+
+type audit struct {
+	ds *datastore
+}
+
+func newAudit(ds *datastore) *audit {
+	return &audit{ds}
+}
+
+// Append stores event under a new key, the write is made only if the key does not exist so
+// that an event is never overwritten.
+func (a *audit) Append(event *v1.AuditEvent) error {
+	ctx, cancel := a.ds.context()
+	defer cancel()
+
+	event.Seq = 0 // known once written
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	key := newAuditKey(event)
+	resp, err := a.ds.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return errors.New("the key of the audit event already exists")
+	}
+	event.Seq = resp.Header.Revision
+	return nil
+}
+
+func (a *audit) List(query *v1.AuditQuery) ([]*v1.AuditEvent, error) {
+	ctx, cancel := a.ds.context()
+	defer cancel()
+
+	start, end := AUDIT_KEY_PREFIX, clientv3.GetPrefixRangeEnd(AUDIT_KEY_PREFIX)
+	if !query.Since.IsZero() {
+		start = auditKey(query.Since)
+	}
+	if !query.Until.IsZero() {
+		end = auditKey(query.Until)
+	}
+	resp, err := a.ds.client.Get(ctx, start, clientv3.WithRange(end))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*v1.AuditEvent, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		event := &v1.AuditEvent{}
+		if err := json.Unmarshal(kv.Value, event); err != nil {
+			return nil, fmt.Errorf("failed to decode audit event %s: %v", kv.Key, err)
+		}
+		event.Seq = kv.CreateRevision
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	return query.Select(events), nil
+}
+
+// appendOps returns the operations appending event in the transaction of a change, none if
+// it is nil. The Seq of event is the revision of the transaction.
+func appendOps(event *v1.AuditEvent) ([]clientv3.Op, error) {
+	if event == nil {
+		return nil, nil
+	}
+	event.Seq = 0 // known once written
+	value, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return []clientv3.Op{clientv3.OpPut(newAuditKey(event), string(value))}, nil
+}
+
+// appended sets the Seq of event appended at revision unless it is nil.
+func appended(event *v1.AuditEvent, revision int64) {
+	if event != nil {
+		event.Seq = revision
+	}
+}
+
+// newAuditKey returns a key for event, the random suffix keeps apart the events of the
+// same time.
+func newAuditKey(event *v1.AuditEvent) string {
+	return auditKey(event.Time) + ":" + uuid.New().String()
+}
+
+func auditKey(t time.Time) string {
+	return fmt.Sprintf("%v%020d", AUDIT_KEY_PREFIX, t.UnixNano())
+}
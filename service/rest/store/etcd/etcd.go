@@ -0,0 +1,45 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ETCD_REQUEST_TIMEOUT bounds every request of the store to etcd.
+const ETCD_REQUEST_TIMEOUT = 3 * time.Second
+
+type datastore struct {
+	client *clientv3.Client
+}
+
+func (ds *datastore) Policy() store.PolicyStore {
+	return newPolicy(ds)
+}
+
+func (ds *datastore) Audit() store.AuditStore {
+	return newAudit(ds)
+}
+
+func (ds *datastore) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), ETCD_REQUEST_TIMEOUT)
+}
+
+var etcdFactory store.Factory
+
+// GetEtcdFactoryOr returns the store.Factory keeping data in etcd through client, client is
+// only used by the first call.
+func GetEtcdFactoryOr(client *clientv3.Client) (store.Factory, error) {
+	if etcdFactory != nil {
+		return etcdFactory, nil
+	}
+	if client == nil {
+		return nil, errors.New("failed to get etcd store factory: etcd client is nil")
+	}
+
+	etcdFactory = &datastore{client: client}
+	return etcdFactory, nil
+}
@@ -0,0 +1,32 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ListMonitorReports returns the reports published by the nodes in etcd by their ids, see
+// v1.MONITOR_KEY_PREFIX. A report which can not be decoded is skipped.
+func ListMonitorReports(ctx context.Context, client *clientv3.Client) (map[string]*v1.MonitorReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, ETCD_REQUEST_TIMEOUT)
+	defer cancel()
+
+	resp, err := client.Get(ctx, v1.MONITOR_KEY_PREFIX, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make(map[string]*v1.MonitorReport, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		report := &v1.MonitorReport{}
+		if err := json.Unmarshal(kv.Value, report); err != nil {
+			continue
+		}
+		reports[strings.TrimPrefix(string(kv.Key), v1.MONITOR_KEY_PREFIX)] = report
+	}
+	return reports, nil
+}
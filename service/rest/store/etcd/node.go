@@ -0,0 +1,24 @@
+package etcd
+
+import (
+	"context"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// GetNodeAddress returns the address of the gRPC server which the node of id registered in
+// etcd, see v1.NODE_KEY_PREFIX. It returns "" if the node is not registered.
+func GetNodeAddress(ctx context.Context, client *clientv3.Client, id string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ETCD_REQUEST_TIMEOUT)
+	defer cancel()
+
+	resp, err := client.Get(ctx, v1.NODE_KEY_PREFIX+id)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+	return string(resp.Kvs[0].Value), nil
+}
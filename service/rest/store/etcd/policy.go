@@ -0,0 +1,250 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// POLICY_KEY_PREFIX is the prefix of the keys of policies, each policy is stored as JSON
+// under the prefix followed by its id. The policies are listed in the order of creation, by
+// the create revisions of their keys.
+const POLICY_KEY_PREFIX = "policy:"
+
+type policy struct {
+	ds *datastore
+}
+
+func newPolicy(ds *datastore) *policy {
+	return &policy{ds}
+}
+
+func (p *policy) List() ([]*v1.Policy, error) {
+	ctx, cancel := p.ds.context()
+	defer cancel()
+
+	policies, _, err := listPolicies(ctx, p.ds.client)
+	if err != nil {
+		return nil, err
+	}
+	return policies.list(), nil
+}
+
+func (p *policy) Get(id string) (*v1.Policy, error) {
+	ctx, cancel := p.ds.context()
+	defer cancel()
+
+	resp, err := p.ds.client.Get(ctx, POLICY_KEY_PREFIX+id)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, store.ErrPolicyNotFound
+	}
+	return decodePolicy(resp.Kvs[0])
+}
+
+// Create stores policy unless the key of its id exists, the check and the write are made
+// in a single transaction so that concurrent creations of the same id never overwrite
+// each other.
+func (p *policy) Create(policy *v1.Policy, event *v1.AuditEvent) error {
+	ctx, cancel := p.ds.context()
+	defer cancel()
+
+	policy.Revision = 0 // known once written
+	value, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	audit, err := appendOps(event)
+	if err != nil {
+		return err
+	}
+	key := POLICY_KEY_PREFIX + policy.ID
+	resp, err := p.ds.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(append([]clientv3.Op{clientv3.OpPut(key, string(value))}, audit...)...).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return store.ErrPolicyExists
+	}
+	policy.Revision = resp.Header.Revision
+	appended(event, resp.Header.Revision)
+	return nil
+}
+
+// Update stores policy if the key of its id exists, and has not been modified since the
+// revision of policy unless it is 0.
+func (p *policy) Update(policy *v1.Policy, event *v1.AuditEvent) error {
+	ctx, cancel := p.ds.context()
+	defer cancel()
+
+	key := POLICY_KEY_PREFIX + policy.ID
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), ">", 0)
+	if policy.Revision != 0 {
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", policy.Revision)
+	}
+
+	revision := policy.Revision
+	policy.Revision = 0 // known once written
+	value, err := json.Marshal(policy)
+	if err != nil {
+		policy.Revision = revision
+		return err
+	}
+	audit, err := appendOps(event)
+	if err != nil {
+		policy.Revision = revision
+		return err
+	}
+	resp, err := p.ds.client.Txn(ctx).
+		If(cmp).
+		Then(append([]clientv3.Op{clientv3.OpPut(key, string(value))}, audit...)...).
+		Else(clientv3.OpGet(key, clientv3.WithCountOnly())).
+		Commit()
+	if err != nil {
+		policy.Revision = revision
+		return err
+	}
+	if !resp.Succeeded {
+		policy.Revision = revision
+		if resp.Responses[0].GetResponseRange().Count == 0 {
+			return store.ErrPolicyNotFound
+		}
+		return store.ErrPolicyConflict
+	}
+	policy.Revision = resp.Header.Revision
+	appended(event, resp.Header.Revision)
+	return nil
+}
+
+// Delete removes the key of id if it exists, the check and the removal are made in a single
+// transaction along with appending event.
+func (p *policy) Delete(id string, event *v1.AuditEvent) error {
+	ctx, cancel := p.ds.context()
+	defer cancel()
+
+	audit, err := appendOps(event)
+	if err != nil {
+		return err
+	}
+	key := POLICY_KEY_PREFIX + id
+	resp, err := p.ds.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0)).
+		Then(append([]clientv3.Op{clientv3.OpDelete(key)}, audit...)...).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return store.ErrPolicyNotFound
+	}
+	appended(event, resp.Header.Revision)
+	return nil
+}
+
+// WatchPolicies calls fn with every policy stored in etcd, first with the policies listed
+// and then whenever they change, until ctx is done. The policies are watched from the
+// revision they were listed at, so no change is missed in between, and are listed again
+// if the watch fails, e.g. the revision has been compacted.
+func WatchPolicies(ctx context.Context, client *clientv3.Client, fn func([]*v1.Policy)) error {
+	for {
+		policies, revision, err := listPolicies(ctx, client)
+		if err != nil {
+			return err
+		}
+		fn(policies.list())
+
+		watchCh := client.Watch(ctx, POLICY_KEY_PREFIX, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+		for resp := range watchCh {
+			if resp.Err() != nil {
+				break
+			}
+			for _, event := range resp.Events {
+				policies.apply(event)
+			}
+			fn(policies.list())
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// policySet holds the policies stored in etcd by their keys.
+type policySet map[string]*storedPolicy
+
+type storedPolicy struct {
+	policy         *v1.Policy
+	createRevision int64
+}
+
+func listPolicies(ctx context.Context, client *clientv3.Client) (policySet, int64, error) {
+	resp, err := client.Get(ctx, POLICY_KEY_PREFIX, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	policies := make(policySet, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		policies.put(kv)
+	}
+	return policies, resp.Header.Revision, nil
+}
+
+// apply updates the set with an event of the watch on POLICY_KEY_PREFIX.
+func (s policySet) apply(event *clientv3.Event) {
+	switch event.Type {
+	case clientv3.EventTypePut:
+		s.put(event.Kv)
+	case clientv3.EventTypeDelete:
+		delete(s, string(event.Kv.Key))
+	}
+}
+
+// put adds the policy of kv, a value which can not be decoded is skipped as it would be
+// refused by every node.
+func (s policySet) put(kv *mvccpb.KeyValue) {
+	policy, err := decodePolicy(kv)
+	if err != nil {
+		delete(s, string(kv.Key))
+		return
+	}
+	s[string(kv.Key)] = &storedPolicy{policy: policy, createRevision: kv.CreateRevision}
+}
+
+// list returns the policies in the order of creation.
+func (s policySet) list() []*v1.Policy {
+	stored := make([]*storedPolicy, 0, len(s))
+	for _, p := range s {
+		stored = append(stored, p)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].createRevision < stored[j].createRevision })
+
+	policies := make([]*v1.Policy, 0, len(stored))
+	for _, p := range stored {
+		policies = append(policies, p.policy)
+	}
+	return policies
+}
+
+func decodePolicy(kv *mvccpb.KeyValue) (*v1.Policy, error) {
+	policy := &v1.Policy{}
+	if err := json.Unmarshal(kv.Value, policy); err != nil {
+		return nil, fmt.Errorf("failed to decode policy %v: %v",
+			strings.TrimPrefix(string(kv.Key), POLICY_KEY_PREFIX), err)
+	}
+	policy.Revision = kv.ModRevision
+	return policy, nil
+}
@@ -0,0 +1,26 @@
+package etcd
+
+import (
+	"testing"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestPolicySetApply(t *testing.T) {
+	s := make(policySet)
+	s.put(&mvccpb.KeyValue{Key: []byte(POLICY_KEY_PREFIX + "b"), Value: []byte(`{"id":"b"}`), CreateRevision: 5, ModRevision: 5})
+	s.put(&mvccpb.KeyValue{Key: []byte(POLICY_KEY_PREFIX + "a"), Value: []byte(`{"id":"a"}`), CreateRevision: 7, ModRevision: 9})
+
+	policies := s.list()
+	if len(policies) != 2 || policies[0].ID != "b" || policies[1].ID != "a" || policies[1].Revision != 9 {
+		t.Fatalf("Expected policies b and a of revision 9 in the order of creation, got %v", policies)
+	}
+
+	s.apply(&clientv3.Event{Type: clientv3.EventTypeDelete, Kv: &mvccpb.KeyValue{Key: []byte(POLICY_KEY_PREFIX + "b")}})
+	s.apply(&clientv3.Event{Type: clientv3.EventTypePut,
+		Kv: &mvccpb.KeyValue{Key: []byte(POLICY_KEY_PREFIX + "a"), Value: []byte(`{`), CreateRevision: 7, ModRevision: 10}})
+	if policies := s.list(); len(policies) != 0 {
+		t.Fatalf("Expected no policy left, got %v", policies)
+	}
+}
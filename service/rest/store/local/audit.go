@@ -0,0 +1,28 @@
+package local
+
+import (
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+type audit struct {
+	ds *datastore
+}
+
+func newAudit(ds *datastore) *audit {
+	return &audit{ds}
+}
+
+func (a *audit) Append(event *v1.AuditEvent) error {
+	a.ds.mu.Lock()
+	defer a.ds.mu.Unlock()
+
+	a.ds.appendEvent(event)
+	return nil
+}
+
+func (a *audit) List(query *v1.AuditQuery) ([]*v1.AuditEvent, error) {
+	a.ds.mu.Lock()
+	defer a.ds.mu.Unlock()
+
+	return query.Select(a.ds.events), nil
+}
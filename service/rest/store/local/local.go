@@ -1,18 +1,45 @@
 package local
 
 import (
+	"sync"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
 	"github.com/p1nant0m/xdp-tracing/pkg/db"
 	"github.com/p1nant0m/xdp-tracing/service/rest/store"
 )
 
 type datastore struct {
-	db *db.LocalStorage
+	db *db.LocalStorage // ids of policies in the order of creation
+
+	mu       sync.Mutex
+	policies map[string]*v1.Policy
+	revision int64            // of the policy written last, see nextRevision
+	events   []*v1.AuditEvent // the audit log
+}
+
+// nextRevision returns the revision of a policy written, the caller should hold mu.
+func (ds *datastore) nextRevision() int64 {
+	ds.revision++
+	return ds.revision
+}
+
+// appendEvent appends event to the audit log unless it is nil, the caller should hold mu.
+func (ds *datastore) appendEvent(event *v1.AuditEvent) {
+	if event == nil {
+		return
+	}
+	event.Seq = int64(len(ds.events) + 1)
+	ds.events = append(ds.events, event)
 }
 
 func (ds *datastore) Policy() store.PolicyStore {
 	return newPolicy(ds)
 }
 
+func (ds *datastore) Audit() store.AuditStore {
+	return newAudit(ds)
+}
+
 var localStorageFactory store.Factory
 
 func GetLocalStorageFactoryOr() (store.Factory, error) {
@@ -21,7 +48,7 @@ func GetLocalStorageFactoryOr() (store.Factory, error) {
 	}
 
 	dbIns, _ := db.NewLocalStorage()
-	localStorageFactory = &datastore{dbIns}
+	localStorageFactory = &datastore{db: dbIns, policies: make(map[string]*v1.Policy)}
 
 	return localStorageFactory, nil
 }
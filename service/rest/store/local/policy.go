@@ -1,5 +1,10 @@
 package local
 
+import (
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+)
+
 type policy struct {
 	ds *datastore
 }
@@ -8,17 +13,77 @@ func newPolicy(ds *datastore) *policy {
 	return &policy{ds}
 }
 
-func (p *policy) List() ([]string, error) {
-	policies, err := p.ds.db.List()
-	return policies, err
+func (p *policy) List() ([]*v1.Policy, error) {
+	p.ds.mu.Lock()
+	defer p.ds.mu.Unlock()
+
+	ids, err := p.ds.db.List()
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make([]*v1.Policy, 0, len(ids))
+	for _, id := range ids {
+		policies = append(policies, p.ds.policies[id])
+	}
+	return policies, nil
 }
 
-func (p *policy) Create(policy string) error {
-	err := p.ds.db.Append(policy)
-	return err
+func (p *policy) Get(id string) (*v1.Policy, error) {
+	p.ds.mu.Lock()
+	defer p.ds.mu.Unlock()
+
+	policy, exists := p.ds.policies[id]
+	if !exists {
+		return nil, store.ErrPolicyNotFound
+	}
+	return policy, nil
 }
 
-func (p *policy) Delete(policy string) error {
-	err := p.ds.db.Delete(policy)
-	return err
+func (p *policy) Create(policy *v1.Policy, event *v1.AuditEvent) error {
+	p.ds.mu.Lock()
+	defer p.ds.mu.Unlock()
+
+	if _, exists := p.ds.policies[policy.ID]; exists {
+		return store.ErrPolicyExists
+	}
+	if err := p.ds.db.Append(policy.ID); err != nil {
+		return err
+	}
+	policy.Revision = p.ds.nextRevision()
+	p.ds.policies[policy.ID] = policy
+	p.ds.appendEvent(event)
+	return nil
+}
+
+func (p *policy) Update(policy *v1.Policy, event *v1.AuditEvent) error {
+	p.ds.mu.Lock()
+	defer p.ds.mu.Unlock()
+
+	stored, exists := p.ds.policies[policy.ID]
+	if !exists {
+		return store.ErrPolicyNotFound
+	}
+	if policy.Revision != 0 && policy.Revision != stored.Revision {
+		return store.ErrPolicyConflict
+	}
+	policy.Revision = p.ds.nextRevision()
+	p.ds.policies[policy.ID] = policy
+	p.ds.appendEvent(event)
+	return nil
+}
+
+func (p *policy) Delete(id string, event *v1.AuditEvent) error {
+	p.ds.mu.Lock()
+	defer p.ds.mu.Unlock()
+
+	if _, exists := p.ds.policies[id]; !exists {
+		return store.ErrPolicyNotFound
+	}
+	if err := p.ds.db.Delete(id); err != nil {
+		return err
+	}
+	delete(p.ds.policies, id)
+	p.ds.appendEvent(event)
+	return nil
 }
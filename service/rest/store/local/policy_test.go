@@ -0,0 +1,33 @@
+package local
+
+import (
+	"testing"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/db"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store"
+)
+
+func TestPolicyUpdateRevision(t *testing.T) {
+	dbIns, _ := db.NewLocalStorage()
+	policies := (&datastore{db: dbIns, policies: make(map[string]*v1.Policy)}).Policy()
+	if err := policies.Create(&v1.Policy{ID: "a", Action: v1.ACTION_MONITOR}, nil); err != nil {
+		t.Fatalf("Expected policy a created, got %v", err)
+	}
+
+	stored, err := policies.Get("a")
+	if err != nil || stored.Revision == 0 {
+		t.Fatalf("Expected policy a with a revision, got %v err=%v", stored, err)
+	}
+	updated, stale := *stored, *stored
+	updated.Action = v1.ACTION_DROP
+	if err := policies.Update(&updated, nil); err != nil || updated.Revision <= stale.Revision {
+		t.Fatalf("Expected policy a updated to a new revision, got %v err=%v", updated.Revision, err)
+	}
+	if err := policies.Update(&stale, nil); err != store.ErrPolicyConflict {
+		t.Fatalf("Expected ErrPolicyConflict, got %v", err)
+	}
+	if policy, _ := policies.Get("a"); policy.Action != v1.ACTION_DROP {
+		t.Fatalf("Expected policy a kept dropped, got %v", policy.Action)
+	}
+}
@@ -1,8 +1,26 @@
 package store
 
-// PolicyStore defines the policy storage interface.
+import (
+	"errors"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+var (
+	ErrPolicyNotFound = errors.New("the policy is not found")
+	ErrPolicyExists   = errors.New("a policy with the same id already exists")
+	ErrPolicyConflict = errors.New("the policy has been changed since it was read")
+)
+
+// PolicyStore defines the policy storage interface, policies are identified by their ids.
+// The changes are appended to the audit log along with the event given, unless it is nil,
+// in the same transaction so that no change is made without being recorded.
 type PolicyStore interface {
-	List() ([]string, error)
-	Create(string) error
-	Delete(string) error
+	List() ([]*v1.Policy, error)
+	Get(id string) (*v1.Policy, error)
+	Create(*v1.Policy, *v1.AuditEvent) error
+	// Update replaces the stored policy of the same id. ErrPolicyConflict is returned if
+	// the policy has a revision which is no longer the stored one.
+	Update(*v1.Policy, *v1.AuditEvent) error
+	Delete(id string, event *v1.AuditEvent) error
 }
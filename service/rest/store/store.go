@@ -3,4 +3,5 @@ package store
 // Factory defines the iam platform storage interface.
 type Factory interface {
 	Policy() PolicyStore
+	Audit() AuditStore
 }
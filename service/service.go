@@ -53,6 +53,9 @@ const (
 	ETCD  = "etcd"
 )
 
+// GRPC_SERVER_NAME is the name in the certificates of the gRPC servers, see strategy/x509
+const GRPC_SERVER_NAME = "server.grpc.io"
+
 // ---------------------------------------------------- Redis Service ------------------------------
 
 type RedisService struct {
@@ -223,6 +226,15 @@ func DecodeSession(keySerdString string, valueSerdString string) (*Key, *Value)
 	return key, value
 }
 
+// EncodeKey serializes key the same way as EncodeSession does.
+func EncodeKey(key *Key) string {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(key); err != nil {
+		panic(err.Error())
+	}
+	return buf.String()
+}
+
 func EncodeSession(key *Key, value *Value) (string, string) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -247,6 +259,9 @@ func EncodeSession(key *Key, value *Value) (string, string) {
 
 //---------------------------------------------------- Etcd Service ------------------------------
 
+// Reporter returns the report of the node published in the registry, see EtcdService.
+type Reporter func() (interface{}, error)
+
 type EtcdService struct {
 	Ctx         context.Context
 	Client      *clientv3.Client
@@ -254,6 +269,9 @@ type EtcdService struct {
 	StopCh      chan struct{}
 	NodeID      string
 	ServiceType string
+	// Reporters are refreshed along with the host information, each report is put as JSON
+	// under the key prefix followed by the node id
+	Reporters map[string]Reporter
 }
 
 func NewEtcdService(ctx context.Context) *EtcdService {
@@ -303,6 +321,9 @@ func (etcdService *EtcdService) Serve() {
 	etcdService.Client.Put(etcdService.Ctx, fmt.Sprintf("node:%v", etcdService.NodeID),
 		utils.LocalIPObtain()+":"+strconv.Itoa(gRPCListenPort), clientv3.WithLease(leaseResp.ID))
 	specConf := extractSpecConfig()
+	if ebpfConfig := extractEbpfConfig(); specConf != nil && ebpfConfig != nil {
+		specConf.FilterModes = ebpfConfig.Allowlist.FilterModes()
+	}
 	// Regist the information related to the machine and periodically refresh
 	go func() {
 		for {
@@ -313,6 +334,7 @@ func (etcdService *EtcdService) Serve() {
 			out, _ := json.Marshal(hostInfo)
 			etcdService.Client.Put(etcdService.Ctx, fmt.Sprintf("host-info:%v", etcdService.NodeID),
 				string(out), clientv3.WithLease(leaseResp.ID))
+			etcdService.putReports(leaseResp.ID)
 
 			select {
 			case <-etcdService.Ctx.Done():
@@ -339,6 +361,24 @@ func (etcdService *EtcdService) Serve() {
 	}()
 }
 
+// putReports puts the reports of Reporters with lease, a report failed is skipped until
+// the next refresh.
+func (etcdService *EtcdService) putReports(lease clientv3.LeaseID) {
+	for prefix, reporter := range etcdService.Reporters {
+		report, err := reporter()
+		if err != nil {
+			logrus.Warnf("[Etcd Service] failed to make report %v err=%v", prefix, err)
+			continue
+		}
+		out, err := json.Marshal(report)
+		if err != nil {
+			logrus.Warnf("[Etcd Service] failed to marshal report %v err=%v", prefix, err)
+			continue
+		}
+		etcdService.Client.Put(etcdService.Ctx, prefix+etcdService.NodeID, string(out), clientv3.WithLease(lease))
+	}
+}
+
 //
 func (etcdService *EtcdService) Stop() {
 	// etcdService.Ctx has already Done, use new context to the task
@@ -346,6 +386,9 @@ func (etcdService *EtcdService) Stop() {
 	defer cancel()
 	etcdService.Client.Delete(ctx, fmt.Sprintf("node:%v", etcdService.NodeID))
 	etcdService.Client.Delete(ctx, fmt.Sprintf("host-info:%v", etcdService.NodeID))
+	for prefix := range etcdService.Reporters {
+		etcdService.Client.Delete(ctx, prefix+etcdService.NodeID)
+	}
 	close(etcdService.StopCh)
 }
 
@@ -361,12 +404,14 @@ type GrpcService struct {
 	Listener   *net.Listener
 }
 
-func NewGrpcService(ctx context.Context) *GrpcService {
+// NewGrpcService returns the GrpcService serving server
+func NewGrpcService(ctx context.Context, server *strategy.Server) *GrpcService {
 	grpcConfig := extractgRPCConfig()
 
 	return &GrpcService{
 		Ctx:     ctx,
 		Configs: grpcConfig,
+		Server:  server,
 	}
 }
 
@@ -377,9 +422,6 @@ func (grpcService *GrpcService) Conn() error {
 	}
 
 	grpcService.Listener = &listener
-	grpcService.Server = &strategy.Server{
-		LocalStrategyCh: make(chan *strategy.PolicyOp, 64),
-	}
 
 	credsRootPath := grpcService.Configs.CredentialPath
 	creds, err := credentials.NewServerTLSFromFile(credsRootPath+"server.crt", credsRootPath+"server.key")
@@ -399,6 +441,26 @@ func (grpcService *GrpcService) Serve() {
 	}
 }
 
+// WatchController subscribes the Server to the policy controller at Configs.Controller until
+// the context is done, the controller is verified with ca.crt under the credential path.
+func (grpcService *GrpcService) WatchController() error {
+	credsRootPath := grpcService.Configs.CredentialPath
+	creds, err := credentials.NewClientTLSFromFile(credsRootPath+"ca.crt", GRPC_SERVER_NAME)
+	if err != nil {
+		return err
+	}
+
+	conn, err := grpc.Dial(grpcService.Configs.Controller, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer conn.Close()
+		grpcService.Server.Watch(grpcService.Ctx, strategy.NewStrategyClient(conn))
+	}()
+	return nil
+}
+
 func (GrpcService *GrpcService) Stop() {
 	GrpcService.gRPCServer.GracefulStop()
 }
@@ -0,0 +1,287 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/sirupsen/logrus"
+)
+
+// ALLOWLIST_MAX_PORTS is the most ports a policy may allow, the ports map holds single
+// ports so a range is expanded.
+const ALLOWLIST_MAX_PORTS = v1.POLICY_MAX_PASS_PORTS
+
+// AllowlistMap is a map of the sources or local ports passed by the xdp_allowlist stage
+// on the interfaces in allowlist mode. The value counts the packets passed.
+type AllowlistMap[K comparable] interface {
+	Put(key K, value uint32) error
+	Delete(key K) error
+	Keys() ([]K, error)
+}
+
+// ManagementSet is always passed on the interfaces in allowlist mode, it is written to
+// the data plane from the configuration and never touched by the policies, see
+// ebpf.BPFManager.SetManagementSet.
+type ManagementSet struct {
+	Sources []ebpf.LPMKey
+	Ports   []ebpf.PortKey
+}
+
+// AllowlistKeys returns the keys of the allowlist maps which enforce policy. The xdp_allowlist
+// stage passes inbound packets either by their sources, whatever protocol and port, or by
+// their local TCP and UDP ports, whatever source, so any other policy is rejected.
+func AllowlistKeys(policy *v1.Policy) ([]ebpf.LPMKey, []ebpf.PortKey, error) {
+	switch {
+	case policy.Action != v1.ACTION_PASS:
+		return nil, nil, fmt.Errorf("action %v is not supported by the allowlist", policy.Action)
+	case policy.Direction != v1.DIRECTION_INGRESS:
+		return nil, nil, fmt.Errorf("direction %v is not supported by the allowlist", policy.Direction)
+	}
+
+	ipNet, err := policy.IPNet()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(policy.Ports) == 0 {
+		if policy.Protocol != v1.PROTOCOL_ANY {
+			return nil, nil, fmt.Errorf("sources are allowed for protocol any only, got %v", policy.Protocol)
+		}
+		key, err := ebpf.NewLPMKey(ipNet)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []ebpf.LPMKey{key}, nil, nil
+	}
+
+	if ones, _ := ipNet.Mask.Size(); ones != 0 {
+		return nil, nil, fmt.Errorf("ports are allowed for every source only, got %v", ipNet)
+	}
+	protocol := uint8(ebpf.IPPROTO_TCP)
+	if policy.Protocol == v1.PROTOCOL_UDP {
+		protocol = ebpf.IPPROTO_UDP
+	}
+
+	var keys []ebpf.PortKey
+	for _, r := range policy.Ports {
+		end := r.End
+		if end == 0 {
+			end = r.Start
+		}
+		for port := int(r.Start); port <= int(end); port++ {
+			if len(keys) == ALLOWLIST_MAX_PORTS {
+				return nil, nil, fmt.Errorf("at most %v ports are allowed by a policy", ALLOWLIST_MAX_PORTS)
+			}
+			keys = append(keys, ebpf.PortKey{Port: uint16(port), Protocol: protocol})
+		}
+	}
+	return nil, keys, nil
+}
+
+// Allowlist enforces pass policies on the allowlist maps, keys are shared by the policies
+// like Blocklist. Before a set of policies is enforced, the sessions of the node which it
+// would cut off are warned about, see CutOff.
+type Allowlist struct {
+	sources    AllowlistMap[ebpf.LPMKey]
+	ports      AllowlistMap[ebpf.PortKey]
+	management *ManagementSet
+
+	sourceHolders map[ebpf.LPMKey]map[string]struct{}
+	portHolders   map[ebpf.PortKey]map[string]struct{}
+
+	// Sessions returns the sessions of the interfaces in allowlist mode, nil skips the
+	// check of CutOff
+	Sessions func() ([]Session, error)
+}
+
+// NewAllowlist returns the Allowlist enforcing policies on sources and ports, management
+// is the set always passed besides them.
+func NewAllowlist(sources AllowlistMap[ebpf.LPMKey], ports AllowlistMap[ebpf.PortKey],
+	management *ManagementSet) *Allowlist {
+	if management == nil {
+		management = &ManagementSet{}
+	}
+	return &Allowlist{
+		sources:       sources,
+		ports:         ports,
+		management:    management,
+		sourceHolders: make(map[ebpf.LPMKey]map[string]struct{}),
+		portHolders:   make(map[ebpf.PortKey]map[string]struct{}),
+	}
+}
+
+// Restore takes over the keys left in the pinned maps by the previous process, they are
+// kept until the first set of policies is enforced, see Server.RestoredGrace. It returns the
+// number of keys restored.
+func (a *Allowlist) Restore() (int, error) {
+	sources, err := a.sources.Keys()
+	if err != nil {
+		return 0, err
+	}
+	ports, err := a.ports.Keys()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range sources {
+		holdKey(a.sourceHolders, key, restoredHolder)
+	}
+	for _, key := range ports {
+		holdKey(a.portHolders, key, restoredHolder)
+	}
+	return len(sources) + len(ports), nil
+}
+
+// Check returns why policy can not be enforced by the allowlist, nil if it can.
+func (a *Allowlist) Check(policy *v1.Policy) error {
+	_, _, err := AllowlistKeys(policy)
+	return err
+}
+
+// Enforce replaces the policies enforced on the maps with policies, the same way as
+// Blocklist.Enforce does. The keys of both maps are put before any is deleted.
+func (a *Allowlist) Enforce(policies []*v1.Policy) error {
+	sourceHolders := make(map[ebpf.LPMKey]map[string]struct{})
+	portHolders := make(map[ebpf.PortKey]map[string]struct{})
+	for _, policy := range policies {
+		sources, ports, err := AllowlistKeys(policy)
+		if err != nil {
+			return fmt.Errorf("policy %v: %v", policy.ID, err)
+		}
+		for _, key := range sources {
+			holdKey(sourceHolders, key, policy.ID)
+		}
+		for _, key := range ports {
+			holdKey(portHolders, key, policy.ID)
+		}
+	}
+	a.warnCutOff(sourceHolders, portHolders)
+
+	if err := putKeys[ebpf.LPMKey](a.sources, a.sourceHolders, sourceHolders); err != nil {
+		return err
+	}
+	if err := putKeys[ebpf.PortKey](a.ports, a.portHolders, portHolders); err != nil {
+		// the sources put are the stale ones from the view of the current holders
+		deleteKeys[ebpf.LPMKey](a.sources, sourceHolders, a.sourceHolders)
+		return err
+	}
+
+	failed := deleteKeys[ebpf.LPMKey](a.sources, a.sourceHolders, sourceHolders)
+	if err := deleteKeys[ebpf.PortKey](a.ports, a.portHolders, portHolders); err != nil {
+		failed = err
+	}
+	a.sourceHolders, a.portHolders = sourceHolders, portHolders
+	return failed
+}
+
+// Installed reads the maps and returns the PolicyEntries of every policy enforced by their
+// ids. The allowlist drops nothing on behalf of policies, so Drops are always 0. The keys
+// held by no policy are left to Blocklist.Installed to be reported.
+func (a *Allowlist) Installed() (map[string]*PolicyEntries, []string, error) {
+	sources, err := a.sources.Keys()
+	if err != nil {
+		return nil, nil, err
+	}
+	ports, err := a.ports.Keys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make(map[string]*PolicyEntries)
+	countEntries(entries, a.sourceHolders, sources)
+	countEntries(entries, a.portHolders, ports)
+	return entries, nil, nil
+}
+
+// CutOff returns the sessions of the node which the allowlist would cut off with the
+// policies enforced, that is neither the remote address nor the local port is allowed.
+func (a *Allowlist) CutOff() ([]Session, error) {
+	return a.cutOff(a.sourceHolders, a.portHolders)
+}
+
+func (a *Allowlist) cutOff(sourceHolders map[ebpf.LPMKey]map[string]struct{},
+	portHolders map[ebpf.PortKey]map[string]struct{}) ([]Session, error) {
+	if a.Sessions == nil {
+		return nil, nil
+	}
+	sessions, err := a.Sessions()
+	if err != nil {
+		return nil, err
+	}
+
+	var nets []*net.IPNet
+	for _, key := range a.management.Sources {
+		nets = append(nets, key.IPNet())
+	}
+	for key := range sourceHolders {
+		nets = append(nets, key.IPNet())
+	}
+	ports := make(map[ebpf.PortKey]bool, len(portHolders)+len(a.management.Ports))
+	for _, key := range a.management.Ports {
+		ports[key] = true
+	}
+	for key := range portHolders {
+		ports[key] = true
+	}
+
+	var cut []Session
+	for _, session := range sessions {
+		if ports[ebpf.PortKey{Port: session.LocalPort, Protocol: ebpf.IPPROTO_TCP}] || containsNet(nets, session.RemoteIP) {
+			continue
+		}
+		cut = append(cut, session)
+	}
+	sort.Slice(cut, func(i, j int) bool { return cut[i].String() < cut[j].String() })
+	return cut, nil
+}
+
+// warnCutOff warns about the sessions which the allowlist would cut off with the holders,
+// it is only a dry run and never stops the policies from being enforced.
+func (a *Allowlist) warnCutOff(sourceHolders map[ebpf.LPMKey]map[string]struct{},
+	portHolders map[ebpf.PortKey]map[string]struct{}) {
+	cut, err := a.cutOff(sourceHolders, portHolders)
+	if err != nil {
+		logrus.Warnf("[gRPC Server] failed to check the sessions against the allowlist err=%v", err)
+		return
+	}
+	for _, session := range cut {
+		logrus.Warnf("[gRPC Server] the allowlist would cut off the session %v", session)
+	}
+}
+
+func countEntries[K comparable](entries map[string]*PolicyEntries, holders map[K]map[string]struct{}, installed []K) {
+	found := make(map[K]bool, len(installed))
+	for _, key := range installed {
+		found[key] = true
+	}
+
+	for key, ids := range holders {
+		for id := range ids {
+			if id == restoredHolder {
+				continue
+			}
+			if entries[id] == nil {
+				entries[id] = &PolicyEntries{}
+			}
+			entries[id].Entries++
+			if found[key] {
+				entries[id].Installed++
+			}
+		}
+	}
+}
+
+func containsNet(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+)
+
+type fakeAllowlistMap[K comparable] struct {
+	keys    map[K]uint32
+	failPut K // the key failed to be put
+}
+
+func newFakeAllowlistMap[K comparable](failPut K) *fakeAllowlistMap[K] {
+	return &fakeAllowlistMap[K]{keys: make(map[K]uint32), failPut: failPut}
+}
+
+func (m *fakeAllowlistMap[K]) Put(key K, value uint32) error {
+	if key == m.failPut {
+		return errors.New("map is full")
+	}
+	m.keys[key] = value
+	return nil
+}
+
+func (m *fakeAllowlistMap[K]) Delete(key K) error {
+	delete(m.keys, key)
+	return nil
+}
+
+func (m *fakeAllowlistMap[K]) Keys() ([]K, error) {
+	var keys []K
+	for key := range m.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func newPassPolicy(id string, cidr string, protocol string, ports ...v1.PortRange) *v1.Policy {
+	policy := &v1.Policy{ID: id, CIDR: cidr, Protocol: protocol, Ports: ports, Action: v1.ACTION_PASS}
+	policy.Default()
+	return policy
+}
+
+func TestAllowlistKeys(t *testing.T) {
+	sources, ports, err := AllowlistKeys(newPassPolicy("office", "10.1.0.0/16", v1.PROTOCOL_ANY))
+	if err != nil || len(ports) != 0 || len(sources) != 1 || sources[0].String() != "10.1.0.0/16" {
+		t.Fatalf("Expected source 10.1.0.0/16, got %v %v err=%v", sources, ports, err)
+	}
+
+	sources, ports, err = AllowlistKeys(newPassPolicy("web", "0.0.0.0/0", v1.PROTOCOL_TCP,
+		v1.PortRange{Start: 80}, v1.PortRange{Start: 8080, End: 8081}))
+	if err != nil || len(sources) != 0 || len(ports) != 3 || ports[2] != (ebpf.PortKey{Port: 8081, Protocol: ebpf.IPPROTO_TCP}) {
+		t.Fatalf("Expected ports tcp/80, tcp/8080 and tcp/8081, got %v %v err=%v", sources, ports, err)
+	}
+
+	for _, policy := range []*v1.Policy{
+		newDropPolicy("drop", "10.0.0.1"),
+		newPassPolicy("tcp-source", "10.0.0.1", v1.PROTOCOL_TCP),
+		newPassPolicy("source-port", "10.0.0.1", v1.PROTOCOL_TCP, v1.PortRange{Start: 22}),
+		newPassPolicy("many-ports", "0.0.0.0/0", v1.PROTOCOL_UDP, v1.PortRange{Start: 1000, End: 2000}),
+	} {
+		if _, _, err := AllowlistKeys(policy); err == nil {
+			t.Errorf("Expected policy %v rejected, got %v", policy.ID, err)
+		}
+	}
+}
+
+func TestFilterEnforce(t *testing.T) {
+	blocked := fakeBlocklistMap{}
+	failed := ebpf.PortKey{Port: 53, Protocol: ebpf.IPPROTO_UDP}
+	sources, ports := newFakeAllowlistMap(ebpf.LPMKey{}), newFakeAllowlistMap(failed)
+	management := &ManagementSet{Ports: []ebpf.PortKey{{Port: 22, Protocol: ebpf.IPPROTO_TCP}}}
+	filter := &Filter{Blocklist: NewBlocklist(blocked), Allowlist: NewAllowlist(sources, ports, management)}
+
+	office := newPassPolicy("office", "10.1.0.0/16", v1.PROTOCOL_ANY)
+	web := newPassPolicy("web", "0.0.0.0/0", v1.PROTOCOL_TCP, v1.PortRange{Start: 80})
+	host := newDropPolicy("host", "10.1.0.9")
+	if err := filter.Enforce([]*v1.Policy{office, web, host}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(sources.keys) != 1 || len(ports.keys) != 1 || len(blocked) != 1 {
+		t.Fatalf("Expected 1 source, 1 port and 1 blocked address, got %v %v %v", sources.keys, ports.keys, blocked)
+	}
+
+	// the policies of before are kept when any entry fails to be put
+	dns := newPassPolicy("dns", "0.0.0.0/0", v1.PROTOCOL_UDP, v1.PortRange{Start: 53})
+	if err := filter.Enforce([]*v1.Policy{dns}); err == nil {
+		t.Fatalf("Expected udp/53 failed to be put")
+	}
+	entries, _, err := filter.Installed()
+	if err != nil || len(entries) != 3 || entries["web"].Installed != 1 || len(blocked) != 1 {
+		t.Fatalf("Expected the policies of before installed, got %v err=%v", entries, err)
+	}
+
+	filter.Allowlist.Sessions = func() ([]Session, error) {
+		return []Session{
+			{LocalIP: net.IPv4(10, 0, 0, 2), LocalPort: 22, RemoteIP: net.IPv4(192, 168, 0, 1), RemotePort: 50000},
+			{LocalIP: net.IPv4(10, 0, 0, 2), LocalPort: 80, RemoteIP: net.IPv4(192, 168, 0, 1), RemotePort: 50001},
+			{LocalIP: net.IPv4(10, 0, 0, 2), LocalPort: 9000, RemoteIP: net.IPv4(10, 1, 2, 3), RemotePort: 50002},
+			{LocalIP: net.IPv4(10, 0, 0, 2), LocalPort: 9000, RemoteIP: net.IPv4(192, 168, 0, 1), RemotePort: 50003},
+		}, nil
+	}
+	cut, err := filter.CutOff()
+	if err != nil || len(cut) != 1 || cut[0].RemotePort != 50003 {
+		t.Fatalf("Expected only the session from 192.168.0.1 to port 9000 cut off, got %v err=%v", cut, err)
+	}
+
+	filter.Allowlist = nil
+	if err := filter.Check(office); err == nil {
+		t.Fatalf("Expected pass policies rejected without interfaces in allowlist mode")
+	}
+}
+
+func TestReadSessions(t *testing.T) {
+	table := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0200000A:0016 0100A8C0:C350 01 00000000:00000000 02:000A3D6C 00000000     0        0 1 1 ffff 20 4 1 10 -1
+   1: 00000000:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2 1 ffff 100 0 0 10 0
+   2: 0100007F:1F90 0100007F:C351 01 00000000:00000000 00:00000000 00000000     0        0 3 1 ffff 20 4 1 10 -1`
+	sessions, err := readSessions(bufio.NewScanner(strings.NewReader(table)), []net.IP{net.IPv4(10, 0, 0, 2)})
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("Expected 1 established session of 10.0.0.2, got %v err=%v", sessions, err)
+	}
+	if s := sessions[0].String(); s != "192.168.0.1:50000 -> 10.0.0.2:22" {
+		t.Fatalf("Expected session 192.168.0.1:50000 -> 10.0.0.2:22, got %v", s)
+	}
+}
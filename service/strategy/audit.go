@@ -0,0 +1,132 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"reflect"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+// rejectedPolicy is a policy which this node can not enforce, and why.
+type rejectedPolicy struct {
+	policy *v1.Policy
+	reason string
+}
+
+func policyIDs(policies []*v1.Policy) map[string]bool {
+	ids := make(map[string]bool, len(policies))
+	for _, policy := range policies {
+		ids[policy.ID] = true
+	}
+	return ids
+}
+
+// auditApplied returns the events of enforcing the policies of active in place of those of
+// activePolicies, and rejecting the policies of rejected. Only the changes are recorded, the
+// policies enforced or rejected the same way as before are left out, and so are those applied
+// but not active which are recorded by auditActive once they are enforced. The caller should
+// hold policyCacheMu.
+func (s *Server) auditApplied(generation uint64, active []*v1.Policy, rejected []*rejectedPolicy) []*v1.AuditEvent {
+	var (
+		now    = time.Now()
+		events []*v1.AuditEvent
+	)
+	for _, policy := range active {
+		before := s.policyCache[policy.ID]
+		if s.activePolicies[policy.ID] && reflect.DeepEqual(before, policy) {
+			continue
+		}
+		event := s.newAuditEvent(now, generation, v1.AUDIT_APPLY, policy.ID, v1.AUDIT_RESULT_OK)
+		event.Before, event.After = before, policy
+		events = append(events, event)
+	}
+
+	for _, r := range rejected {
+		if before := s.rejectedPolicies[r.policy.ID]; before != nil && reflect.DeepEqual(before.policy, r.policy) {
+			continue
+		}
+		event := s.newAuditEvent(now, generation, v1.AUDIT_APPLY, r.policy.ID, v1.AUDIT_RESULT_REJECTED)
+		event.After, event.Reason = r.policy, r.reason
+		events = append(events, event)
+	}
+
+	enforced := policyIDs(active)
+	for _, id := range s.cachedPolicyIDs() {
+		if !s.activePolicies[id] || enforced[id] {
+			continue
+		}
+		event := s.newAuditEvent(now, generation, v1.AUDIT_REVOKE, id, v1.AUDIT_RESULT_OK)
+		event.Before = s.policyCache[id]
+		events = append(events, event)
+	}
+	return events
+}
+
+// auditActive returns the events of enforcing the policies of active in place of those of
+// activePolicies, which happens on this node alone as the policies expire or enter or leave
+// the windows of their schedules. The caller should hold policyCacheMu.
+func (s *Server) auditActive(now time.Time, active []*v1.Policy) []*v1.AuditEvent {
+	var events []*v1.AuditEvent
+	for _, policy := range active {
+		if s.activePolicies[policy.ID] {
+			continue
+		}
+		event := s.newAuditEvent(now, s.appliedGeneration, v1.AUDIT_APPLY, policy.ID, v1.AUDIT_RESULT_OK)
+		event.After, event.Reason = policy, "entered a window of its schedule"
+		events = append(events, event)
+	}
+
+	enforced := policyIDs(active)
+	for _, id := range s.cachedPolicyIDs() {
+		if !s.activePolicies[id] || enforced[id] {
+			continue
+		}
+		policy := s.policyCache[id]
+		event := s.newAuditEvent(now, s.appliedGeneration, v1.AUDIT_REVOKE, id, v1.AUDIT_RESULT_OK)
+		event.Before, event.Reason = policy, "left the windows of its schedule"
+		if policy.Expired(now) {
+			event.Reason = "expired"
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// auditFailed returns the events of failing to enforce the policies of active which are new
+// or changed, those enforced before still are.
+func (s *Server) auditFailed(generation uint64, active []*v1.Policy, err error) []*v1.AuditEvent {
+	now := time.Now()
+	var events []*v1.AuditEvent
+	for _, policy := range active {
+		before := s.policyCache[policy.ID]
+		if s.activePolicies[policy.ID] && reflect.DeepEqual(before, policy) {
+			continue
+		}
+		event := s.newAuditEvent(now, generation, v1.AUDIT_APPLY, policy.ID, v1.AUDIT_RESULT_FAILED)
+		event.Before, event.After, event.Reason = before, policy, err.Error()
+		events = append(events, event)
+	}
+	return events
+}
+
+func (s *Server) newAuditEvent(now time.Time, generation uint64, kind string, id string, result string) *v1.AuditEvent {
+	return &v1.AuditEvent{
+		Time:       now,
+		Kind:       kind,
+		PolicyID:   id,
+		NodeID:     s.NodeID,
+		Generation: generation,
+		Result:     result,
+	}
+}
+
+// audit hands events to Server.Audit unless there is none.
+func (s *Server) audit(events []*v1.AuditEvent) {
+	if s.Audit != nil && len(events) != 0 {
+		s.Audit(events)
+	}
+}
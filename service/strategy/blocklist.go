@@ -0,0 +1,237 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+)
+
+// BLOCKLIST_MIN_PREFIX_LEN is the shortest prefix enforced by the blocklist map, which holds
+// single addresses, so a prefix is expanded into at most 256 addresses.
+const BLOCKLIST_MIN_PREFIX_LEN = v1.POLICY_MIN_DROP_PREFIX_LEN
+
+// restoredHolder holds the keys found in the pinned blocklist map, or failed to be deleted
+// from it, until the next set of policies is enforced
+const restoredHolder = ""
+
+// BlocklistMap is the map of source addresses dropped by the XDP programs, keyed by the
+// address in host byte order. The value counts the packets dropped from the address.
+type BlocklistMap interface {
+	Put(key uint32, value uint32) error
+	Delete(key uint32) error
+	Keys() ([]uint32, error)
+	Iterate(fn func(key uint32, value uint32) bool) error
+}
+
+// BlocklistKeys returns the keys of the blocklist map which enforce policy. The XDP programs
+// only drop, or monitor, inbound TCP packets by their source addresses, so any other policy
+// is rejected.
+func BlocklistKeys(policy *v1.Policy) ([]uint32, error) {
+	switch {
+	case policy.Action != v1.ACTION_DROP && policy.Action != v1.ACTION_MONITOR:
+		return nil, fmt.Errorf("action %v is not supported by the blocklist", policy.Action)
+	case policy.Direction != v1.DIRECTION_INGRESS:
+		return nil, fmt.Errorf("direction %v is not supported by the blocklist", policy.Direction)
+	case policy.Protocol != v1.PROTOCOL_TCP:
+		return nil, fmt.Errorf("protocol %v is not supported by the blocklist", policy.Protocol)
+	case len(policy.Ports) > 0:
+		return nil, fmt.Errorf("ports are not supported by the blocklist")
+	}
+
+	ipNet, err := policy.IPNet()
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := ipNet.Mask.Size()
+	if ones < BLOCKLIST_MIN_PREFIX_LEN {
+		return nil, fmt.Errorf("prefix %v is shorter than /%v", ipNet, BLOCKLIST_MIN_PREFIX_LEN)
+	}
+
+	base := binary.BigEndian.Uint32(ipNet.IP.To4())
+	keys := make([]uint32, 0, 1<<(32-ones))
+	for i := uint32(0); i < 1<<(32-ones); i++ {
+		keys = append(keys, base+i)
+	}
+	return keys, nil
+}
+
+// Blocklist enforces policies on the blocklist map. A key is shared by the policies covering it,
+// and is only removed from the map when none of them is enforced.
+type Blocklist struct {
+	m       BlocklistMap
+	action  string                         // action of the policies enforced, drop or monitor
+	holders map[uint32]map[string]struct{} // ids of the policies holding each key
+}
+
+// NewBlocklist returns the Blocklist enforcing drop policies on m.
+func NewBlocklist(m BlocklistMap) *Blocklist {
+	return &Blocklist{m: m, action: v1.ACTION_DROP, holders: make(map[uint32]map[string]struct{})}
+}
+
+// NewMonitor returns the Blocklist enforcing monitor policies on m, whose sources are
+// counted and sampled but passed by the XDP programs. The drops reported by Installed are
+// the packets which would have been dropped.
+func NewMonitor(m BlocklistMap) *Blocklist {
+	return &Blocklist{m: m, action: v1.ACTION_MONITOR, holders: make(map[uint32]map[string]struct{})}
+}
+
+// Restore takes over the keys left in the pinned map by the previous process, they are kept
+// until the first set of policies is enforced, see Server.RestoredGrace. It returns the
+// number of keys restored.
+func (b *Blocklist) Restore() (int, error) {
+	keys, err := b.m.Keys()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		holdKey(b.holders, key, restoredHolder)
+	}
+	return len(keys), nil
+}
+
+// Check returns why policy can not be enforced by the blocklist, nil if it can.
+func (b *Blocklist) Check(policy *v1.Policy) error {
+	_, err := b.keys(policy)
+	return err
+}
+
+func (b *Blocklist) keys(policy *v1.Policy) ([]uint32, error) {
+	if policy.Action != b.action {
+		return nil, fmt.Errorf("action %v is not supported by the %v blocklist", policy.Action, b.action)
+	}
+	return BlocklistKeys(policy)
+}
+
+// Enforce replaces the policies enforced on the map with policies. The keys newly held are
+// put before the stale ones are deleted, so that the policies kept are enforced throughout,
+// and the keys put are deleted again if any of them fails. A key failed to be deleted is
+// kept in the map, and deleted by the next call.
+func (b *Blocklist) Enforce(policies []*v1.Policy) error {
+	holders := make(map[uint32]map[string]struct{})
+	for _, policy := range policies {
+		keys, err := b.keys(policy)
+		if err != nil {
+			return fmt.Errorf("policy %v: %v", policy.ID, err)
+		}
+		for _, key := range keys {
+			holdKey(holders, key, policy.ID)
+		}
+	}
+
+	if err := putKeys[uint32](b.m, b.holders, holders); err != nil {
+		return err
+	}
+	err := deleteKeys[uint32](b.m, b.holders, holders)
+	b.holders = holders
+	return err
+}
+
+// Installed reads the map and returns the PolicyEntries of every policy enforced by their
+// ids, and the addresses in the map held by no policy. The drops of an address shared by
+// several policies are counted for each of them.
+func (b *Blocklist) Installed() (map[string]*PolicyEntries, []string, error) {
+	values := make(map[uint32]uint32)
+	err := b.m.Iterate(func(key uint32, value uint32) bool {
+		values[key] = value
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make(map[string]*PolicyEntries)
+	for key, holders := range b.holders {
+		value, installed := values[key]
+		for id := range holders {
+			if id == restoredHolder {
+				continue
+			}
+			if entries[id] == nil {
+				entries[id] = &PolicyEntries{}
+			}
+			entries[id].Entries++
+			if installed {
+				entries[id].Installed++
+				entries[id].Drops += uint64(value)
+			}
+		}
+	}
+
+	var unowned []uint32
+	for key := range values {
+		holders := b.holders[key]
+		if _, restored := holders[restoredHolder]; len(holders) == 0 || (restored && len(holders) == 1) {
+			unowned = append(unowned, key)
+		}
+	}
+	sort.Slice(unowned, func(i, j int) bool { return unowned[i] < unowned[j] })
+	addrs := make([]string, 0, len(unowned))
+	for _, key := range unowned {
+		addrs = append(addrs, keyToIP(key).String())
+	}
+	return entries, addrs, nil
+}
+
+func keyToIP(key uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, key)
+	return ip
+}
+
+// keyedMap is a map of the data plane whose keys are held by policies, see putKeys.
+type keyedMap[K comparable] interface {
+	Put(key K, value uint32) error
+	Delete(key K) error
+}
+
+// putKeys puts the keys of desired missing from current into m, the keys put are deleted
+// again if any of them fails.
+func putKeys[K comparable](m keyedMap[K], current, desired map[K]map[string]struct{}) error {
+	var put []K
+	for key := range desired {
+		if _, exists := current[key]; exists {
+			continue
+		}
+		if err := m.Put(key, 0); err != nil {
+			for _, key := range put {
+				m.Delete(key)
+			}
+			return err
+		}
+		put = append(put, key)
+	}
+	return nil
+}
+
+// deleteKeys deletes the keys of current missing from desired from m. A key failed to be
+// deleted is held by restoredHolder in desired, so that it is deleted by the next call.
+func deleteKeys[K comparable](m keyedMap[K], current, desired map[K]map[string]struct{}) error {
+	var failed error
+	for key := range current {
+		if _, exists := desired[key]; exists {
+			continue
+		}
+		if err := m.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			holdKey(desired, key, restoredHolder)
+			failed = err
+		}
+	}
+	return failed
+}
+
+func holdKey[K comparable](holders map[K]map[string]struct{}, key K, id string) {
+	if holders[key] == nil {
+		holders[key] = make(map[string]struct{})
+	}
+	holders[key][id] = struct{}{}
+}
@@ -0,0 +1,154 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"testing"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+type fakeBlocklistMap map[uint32]uint32
+
+func (m fakeBlocklistMap) Put(key uint32, value uint32) error {
+	m[key] = value
+	return nil
+}
+
+func (m fakeBlocklistMap) Delete(key uint32) error {
+	delete(m, key)
+	return nil
+}
+
+func (m fakeBlocklistMap) Keys() ([]uint32, error) {
+	var keys []uint32
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m fakeBlocklistMap) Iterate(fn func(key uint32, value uint32) bool) error {
+	for key, value := range m {
+		if !fn(key, value) {
+			break
+		}
+	}
+	return nil
+}
+
+func newDropPolicy(id string, cidr string) *v1.Policy {
+	policy := &v1.Policy{ID: id, CIDR: cidr}
+	policy.Default()
+	return policy
+}
+
+func TestBlocklistKeys(t *testing.T) {
+	keys, err := BlocklistKeys(newDropPolicy("host", "172.17.0.11"))
+	if err != nil || len(keys) != 1 || keys[0] != 0xac11000b {
+		t.Fatalf("Expected key 0xac11000b, got %x err=%v", keys, err)
+	}
+
+	if keys, err = BlocklistKeys(newDropPolicy("net", "10.0.0.0/24")); err != nil || len(keys) != 256 {
+		t.Fatalf("Expected 256 keys of 10.0.0.0/24, got %v err=%v", len(keys), err)
+	}
+
+	for _, policy := range []*v1.Policy{
+		newDropPolicy("wide", "10.0.0.0/16"),
+		{ID: "egress", CIDR: "10.0.0.1/32", Protocol: v1.PROTOCOL_TCP, Direction: v1.DIRECTION_EGRESS, Action: v1.ACTION_DROP},
+		{ID: "udp", CIDR: "10.0.0.1/32", Protocol: v1.PROTOCOL_UDP, Direction: v1.DIRECTION_INGRESS, Action: v1.ACTION_DROP},
+		{ID: "pass", CIDR: "10.0.0.1/32", Protocol: v1.PROTOCOL_TCP, Direction: v1.DIRECTION_INGRESS, Action: v1.ACTION_PASS},
+	} {
+		if _, err := BlocklistKeys(policy); err == nil {
+			t.Errorf("Expected policy %v rejected, got %v", policy.ID, err)
+		}
+	}
+}
+
+func TestBlocklistEnforce(t *testing.T) {
+	m := fakeBlocklistMap{0xac11000b: 0, 0xac11000c: 0}
+	blocklist := NewBlocklist(m)
+	if n, err := blocklist.Restore(); err != nil || n != 2 {
+		t.Fatalf("Expected 2 keys restored, got %v err=%v", n, err)
+	}
+
+	host := newDropPolicy("host", "172.17.0.11")
+	net := newDropPolicy("net", "172.17.0.8/30")
+	if err := blocklist.Enforce([]*v1.Policy{host, net}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, exists := m[0xac11000c]; exists || len(m) != 4 {
+		t.Fatalf("Expected the keys of 172.17.0.8/30 only, got %x", m)
+	}
+
+	if err := blocklist.Enforce([]*v1.Policy{net}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, exists := m[0xac11000b]; !exists || len(m) != 4 {
+		t.Fatalf("Expected the key shared with policy net kept, got %x", m)
+	}
+
+	// the set is refused as a whole if any policy can not be enforced
+	if err := blocklist.Enforce([]*v1.Policy{host, newDropPolicy("wide", "10.0.0.0/16")}); err == nil {
+		t.Fatalf("Expected an error, got %v", err)
+	}
+	if len(m) != 4 {
+		t.Fatalf("Expected the map left as it was, got %x", m)
+	}
+
+	if err := blocklist.Enforce(nil); err != nil || len(m) != 0 {
+		t.Fatalf("Expected the map emptied, got %x err=%v", m, err)
+	}
+}
+
+func TestFilterPromoteMonitor(t *testing.T) {
+	blocked, monitored := fakeBlocklistMap{}, fakeBlocklistMap{}
+	filter := &Filter{Blocklist: NewBlocklist(blocked), Monitor: NewMonitor(monitored)}
+
+	policy := newDropPolicy("trial", "172.17.0.11")
+	policy.Action = v1.ACTION_MONITOR
+	if err := filter.Blocklist.Check(policy); err == nil {
+		t.Fatalf("Expected monitor policies rejected by the drop blocklist")
+	}
+	if err := filter.Enforce([]*v1.Policy{policy}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(blocked) != 0 || len(monitored) != 1 {
+		t.Fatalf("Expected the address monitored only, got %x %x", blocked, monitored)
+	}
+
+	monitored[0xac11000b] = 42
+	entries, _, err := filter.Installed()
+	if err != nil || entries["trial"].Drops != 42 {
+		t.Fatalf("Expected 42 packets which would have been dropped, got %v err=%v", entries, err)
+	}
+
+	promoted := *policy
+	promoted.Action = v1.ACTION_DROP
+	if err := filter.Enforce([]*v1.Policy{&promoted}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(blocked) != 1 || len(monitored) != 0 {
+		t.Fatalf("Expected the address dropped only, got %x %x", blocked, monitored)
+	}
+}
+
+func TestFilterFirstEnforceFailed(t *testing.T) {
+	blocked := fakeBlocklistMap{0xac11000b: 7}
+	filter := &Filter{Blocklist: NewBlocklist(blocked)}
+	if n, err := filter.Blocklist.Restore(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 key restored, got %v err=%v", n, err)
+	}
+
+	// the monitor policy fails without a monitor map after the drop policy is enforced
+	trial := newDropPolicy("trial", "172.17.0.12")
+	trial.Action = v1.ACTION_MONITOR
+	if err := filter.Enforce([]*v1.Policy{newDropPolicy("kept", "172.17.0.11"), trial}); err == nil {
+		t.Fatalf("Expected the monitor policy failed")
+	}
+	if _, ok := blocked[0xac11000b]; !ok {
+		t.Fatalf("Expected the restored address kept, got %x", blocked)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// EXPIRY_RETRY_PERIOD is how long to wait before enforcing the active policies again when
+// it fails.
+const EXPIRY_RETRY_PERIOD = 5 * time.Second
+
+// RESTORED_GRACE_PERIOD is how long the keys restored from the pinned maps are kept by the
+// agent while the policy controller pushes no set of policies, see Server.RestoredGrace.
+const RESTORED_GRACE_PERIOD = 10 * time.Minute
+
+// Expire enforces the policies applied on this node which are active whenever any of them
+// expires, or enters or leaves a window of its schedule, until ctx is done. So the policies
+// keep to their lifetime even if the node has lost the policy controller. The keys restored
+// from the pinned maps, whose lifetime is unknown, are deleted once RestoredGrace passes
+// without any set of policies applied.
+func (s *Server) Expire(ctx context.Context) {
+	var (
+		retry   = false
+		release time.Time // when the restored keys are deleted, zero once they are not held
+	)
+	if s.RestoredGrace > 0 {
+		release = time.Now().Add(s.RestoredGrace)
+	}
+	for {
+		var (
+			timer   *time.Timer
+			timerCh <-chan time.Time
+		)
+		s.policyCacheMu.Lock()
+		_, next := v1.ActivePolicies(s.cachedPolicies(), time.Now())
+		if s.policyCache != nil {
+			// the restored keys have been replaced by the set applied
+			release = time.Time{}
+		}
+		replaced := s.replaced()
+		s.policyCacheMu.Unlock()
+		if !release.IsZero() && (next.IsZero() || release.Before(next)) {
+			next = release
+		}
+		switch {
+		case retry:
+			timer = time.NewTimer(EXPIRY_RETRY_PERIOD)
+		case !next.IsZero():
+			timer = time.NewTimer(time.Until(next))
+		}
+		if timer != nil {
+			timerCh = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-replaced:
+			// the active policies have been enforced by ApplyStrategy
+			retry = false
+		case <-timerCh:
+			now := time.Now()
+			err := s.enforceActive(now)
+			if retry = err != nil; retry {
+				logrus.Warnf("[gRPC Server] failed to enforce the active policies, retry in %v err=%v",
+					EXPIRY_RETRY_PERIOD, err)
+			} else if !release.IsZero() && !now.Before(release) {
+				logrus.Warnf("[gRPC Server] no policies applied in %v, the keys restored from the pinned maps are deleted",
+					s.RestoredGrace)
+				release = time.Time{}
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// enforceActive enforces the policies of the cache active at now, and records the policies
+// starting or ceasing to be enforced in the audit log.
+func (s *Server) enforceActive(now time.Time) error {
+	s.policyCacheMu.Lock()
+	defer s.policyCacheMu.Unlock()
+
+	active, _ := v1.ActivePolicies(s.cachedPolicies(), now)
+	if err := s.Enforcer.Enforce(active); err != nil {
+		return err
+	}
+	s.audit(s.auditActive(now, active))
+	s.activePolicies = policyIDs(active)
+	logrus.Infof("[gRPC Server] enforced %v active of %v policies applied", len(active), len(s.policyCache))
+	return nil
+}
+
+// cachedPolicies returns the policies of policyCache in the order of their ids, the caller
+// should hold policyCacheMu.
+func (s *Server) cachedPolicies() []*v1.Policy {
+	policies := make([]*v1.Policy, 0, len(s.policyCache))
+	for _, id := range s.cachedPolicyIDs() {
+		policies = append(policies, s.policyCache[id])
+	}
+	return policies
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"fmt"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+// SessionGuard is implemented by the PolicyEnforcer which may cut off the sessions of the
+// node, GetNodeStatus reports them.
+type SessionGuard interface {
+	CutOff() ([]Session, error)
+}
+
+// filterActions are the actions in the order Filter enforces them, the drop policies are
+// enforced before the monitor policies so that a policy promoted from monitor to drop is
+// never missing from the data plane.
+var filterActions = []string{v1.ACTION_PASS, v1.ACTION_DROP, v1.ACTION_MONITOR}
+
+// Filter enforces every policy by its action: drop policies on the Blocklist, monitor
+// policies on the Monitor, and pass policies on the Allowlist, which is nil when no
+// interface of the node is in allowlist mode, see v1.Policy.FilterMode.
+type Filter struct {
+	Blocklist *Blocklist
+	Monitor   *Blocklist // nil if the eBPF object has no monitor map
+	Allowlist *Allowlist
+
+	enforced map[string][]*v1.Policy // policies enforced last by their actions
+}
+
+// enforcer returns the PolicyEnforcer of the policies of action.
+func (f *Filter) enforcer(action string) (PolicyEnforcer, error) {
+	switch action {
+	case v1.ACTION_PASS:
+		if f.Allowlist == nil {
+			return nil, fmt.Errorf("no interface of the node is in allowlist mode")
+		}
+		return f.Allowlist, nil
+	case v1.ACTION_MONITOR:
+		if f.Monitor == nil {
+			return nil, fmt.Errorf("monitor is not supported by the node")
+		}
+		return f.Monitor, nil
+	}
+	return f.Blocklist, nil
+}
+
+// Check returns why policy can not be enforced by its action, nil if it can.
+func (f *Filter) Check(policy *v1.Policy) error {
+	enforcer, err := f.enforcer(policy.Action)
+	if err != nil {
+		return err
+	}
+	return enforcer.Check(policy)
+}
+
+// Enforce enforces the policies of every action in the order of filterActions, and
+// enforces the policies of before again for the actions already enforced if any fails.
+// Nothing is enforced again if it fails the first time, so that the keys taken over by
+// Restore are kept along with the policies just enforced.
+func (f *Filter) Enforce(policies []*v1.Policy) error {
+	grouped := make(map[string][]*v1.Policy, len(filterActions))
+	for _, policy := range policies {
+		grouped[policy.Action] = append(grouped[policy.Action], policy)
+	}
+
+	for i, action := range filterActions {
+		enforcer, err := f.enforcer(action)
+		if err != nil {
+			if len(grouped[action]) == 0 {
+				continue
+			}
+			err = fmt.Errorf("policy %v: %v", grouped[action][0].ID, err)
+		} else {
+			err = enforcer.Enforce(grouped[action])
+		}
+		if err == nil {
+			continue
+		}
+
+		if f.enforced == nil {
+			return err
+		}
+		for _, action := range filterActions[:i] {
+			if enforcer, e := f.enforcer(action); e == nil {
+				enforcer.Enforce(f.enforced[action])
+			}
+		}
+		return err
+	}
+	f.enforced = grouped
+	return nil
+}
+
+// Installed merges the PolicyEntries of every list, the entries held by no policy are
+// those of the blocklist and the monitor.
+func (f *Filter) Installed() (map[string]*PolicyEntries, []string, error) {
+	entries := make(map[string]*PolicyEntries)
+	var unowned []string
+	for _, action := range filterActions {
+		enforcer, err := f.enforcer(action)
+		if err != nil {
+			continue
+		}
+		installed, stale, err := enforcer.Installed()
+		if err != nil {
+			return nil, nil, err
+		}
+		for id, e := range installed {
+			entries[id] = e
+		}
+		unowned = append(unowned, stale...)
+	}
+	return entries, unowned, nil
+}
+
+// CutOff returns the sessions the Allowlist would cut off, nil without an Allowlist.
+func (f *Filter) CutOff() ([]Session, error) {
+	if f.Allowlist == nil {
+		return nil, nil
+	}
+	return f.Allowlist.CutOff()
+}
@@ -6,50 +6,251 @@ package strategy
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"strings"
-)
+	"sync"
+	"time"
 
-var localPolicyCache map[string]struct{} = make(map[string]struct{})
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
 
 const (
-	REVOKE  = "revoke"
-	INSTALL = "install"
+	STATUS_OK       = "OK"
+	STATUS_REJECTED = "REJECTED"
 )
 
-type PolicyOp struct {
-	Type string
-	Rule string
+// PolicyEntries is how a policy is enforced on the data plane of the node.
+type PolicyEntries struct {
+	Entries   int    // entries held by the policy
+	Installed int    // entries found on the data plane
+	Drops     uint64 // packets dropped by the entries
+}
+
+// PolicyEnforcer enforces the policies on the data plane of the node, see Filter.
+type PolicyEnforcer interface {
+	// Check returns why policy can not be enforced, nil if it can
+	Check(policy *v1.Policy) error
+	// Enforce replaces the policies enforced with policies, it either succeeds or leaves the
+	// policies enforced before
+	Enforce(policies []*v1.Policy) error
+	// Installed returns the PolicyEntries of the policies enforced by their ids, and the
+	// entries found on the data plane on behalf of no policy
+	Installed() (map[string]*PolicyEntries, []string, error)
+}
+
+// Dataplane reports the XDP programs and maps of the node, see ebpf.BPFManager.
+type Dataplane interface {
+	Status() []ebpf.ProgramStatus
+	MapUsage(mapNames ...string) ([]ebpf.MapUsage, error)
+	DropStats() (map[string]uint64, error)
 }
 
 type Server struct {
 	UnimplementedStrategyServer
-	LocalStrategyCh chan *PolicyOp
+	NodeID    string
+	Enforcer  PolicyEnforcer
+	Dataplane Dataplane // reported by GetNodeStatus, nil if not available
+	Maps      []string  // names of the maps reported by GetNodeStatus
+	// filter modes of the interfaces by their names reported by GetNodeStatus, see
+	// v1.NodeInfo
+	FilterModes map[string]string
+	// RestoredGrace is how long the keys restored from the pinned maps are kept while no set
+	// of policies is applied, see Expire. They are kept until a set is applied if it is 0
+	RestoredGrace time.Duration
+
+	// Audit records the changes of the policies applied on this node, see auditApplied.
+	// It is called with the policies locked so it should not block, nil if not recorded
+	Audit func(events []*v1.AuditEvent)
+
+	// policyCache holds the policies applied on this node by their ids, nil until a set is
+	// applied, and appliedGeneration the generation of the set they were applied from. Both
+	// are replaced as a whole by ApplyStrategy. Only the policies active at the time are
+	// enforced, see Expire.
+	policyCache       map[string]*v1.Policy
+	appliedGeneration uint64
+	rejectedPolicies  map[string]*rejectedPolicy // see auditApplied
+	activePolicies    map[string]bool            // see auditActive
+	cacheReplaced     chan struct{}              // wakes Expire up when the cache is replaced
+	policyCacheMu     sync.Mutex                 // protects the fields above
 }
 
-func (s *Server) InstallStrategy(ctx context.Context,
-	in *UpdateStrategy) (*UpdateStrategyReply, error) {
-	rulesList := strings.Split(string(in.Blockoutrules), " ")
-	for _, rule := range rulesList {
-		if _, exists := localPolicyCache[rule]; !exists {
-			localPolicyCache[rule] = struct{}{}
-			s.LocalStrategyCh <- &PolicyOp{Type: INSTALL, Rule: rule}
+// ApplyStrategy replaces the policies enforced on this node with the set of the request, and
+// acknowledges its generation. The request is refused as a whole if any policy is malformed,
+// while the policies which can not be enforced by this node are rejected one by one in the
+// results, the others are still applied. A set of the generation already applied is
+// acknowledged without being applied again.
+func (s *Server) ApplyStrategy(ctx context.Context, in *StrategySet) (*StrategyAck, error) {
+	policies, err := PoliciesFromProto(in.Policies)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		accepted []*v1.Policy
+		rejected []string
+		refused  []*rejectedPolicy
+		results  = make([]*RuleResult, 0, len(policies))
+	)
+	for _, policy := range policies {
+		if err := s.Enforcer.Check(policy); err != nil {
+			rejected = append(rejected, fmt.Sprintf("%v: %v", policy.ID, err))
+			refused = append(refused, &rejectedPolicy{policy: policy, reason: err.Error()})
+			results = append(results, &RuleResult{Id: policy.ID, Code: RuleCode_RULE_REJECTED, Reason: err.Error()})
+			continue
 		}
+		accepted = append(accepted, policy)
+		results = append(results, &RuleResult{Id: policy.ID, Code: RuleCode_RULE_APPLIED})
 	}
-	return &UpdateStrategyReply{Status: "OK"}, nil
+
+	s.policyCacheMu.Lock()
+	defer s.policyCacheMu.Unlock()
+
+	if in.Generation != 0 && in.Generation == s.appliedGeneration {
+		return &StrategyAck{Generation: s.appliedGeneration, Status: replyStatus(rejected), Results: results}, nil
+	}
+	active, _ := v1.ActivePolicies(accepted, time.Now())
+	if err := s.Enforcer.Enforce(active); err != nil {
+		logrus.Warnf("[gRPC Server] failed to apply generation %v err=%v", in.Generation, err)
+		s.audit(s.auditFailed(in.Generation, active, err))
+		return nil, status.Errorf(codes.Internal, "failed to apply generation %v: %v", in.Generation, err)
+	}
+
+	s.audit(s.auditApplied(in.Generation, active, refused))
+	s.policyCache = make(map[string]*v1.Policy, len(accepted))
+	for _, policy := range accepted {
+		s.policyCache[policy.ID] = policy
+	}
+	s.rejectedPolicies = make(map[string]*rejectedPolicy, len(refused))
+	for _, r := range refused {
+		s.rejectedPolicies[r.policy.ID] = r
+	}
+	s.activePolicies = policyIDs(active)
+	s.appliedGeneration = in.Generation
+	select {
+	case s.replaced() <- struct{}{}:
+	default:
+	}
+	logrus.Infof("[gRPC Server] applied generation %v with %v policies, %v active, %v rejected",
+		s.appliedGeneration, len(accepted), len(active), len(rejected))
+	return &StrategyAck{Generation: s.appliedGeneration, Status: replyStatus(rejected), Results: results}, nil
 }
 
-func (s *Server) RevokeStrategy(ctx context.Context,
-	in *UpdateStrategy) (*UpdateStrategyReply, error) {
-	rulesList := strings.Split(string(in.Blockoutrules), " ")
-	for _, rule := range rulesList {
-		if _, exists := localPolicyCache[rule]; exists {
-			delete(localPolicyCache, rule)
-			s.LocalStrategyCh <- &PolicyOp{Type: REVOKE, Rule: rule}
+// GetStrategyGeneration reports the generation applied by this node.
+func (s *Server) GetStrategyGeneration(ctx context.Context,
+	in *StrategyGenerationRequest) (*StrategyAck, error) {
+	return &StrategyAck{Generation: s.generation(), Status: STATUS_OK}, nil
+}
+
+// ListStrategies reports the policies applied by this node, and what is actually installed
+// on the data plane for each of them.
+func (s *Server) ListStrategies(ctx context.Context,
+	in *ListStrategiesRequest) (*ListStrategiesReply, error) {
+	s.policyCacheMu.Lock()
+	defer s.policyCacheMu.Unlock()
+
+	entries, unowned, err := s.Enforcer.Installed()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read the data plane: %v", err)
+	}
+
+	reply := &ListStrategiesReply{Generation: s.appliedGeneration, Unowned: unowned}
+	for _, id := range s.cachedPolicyIDs() {
+		installed := &InstalledStrategy{Policy: PolicyToProto(s.policyCache[id])}
+		if e, exists := entries[id]; exists {
+			installed.Entries, installed.Installed, installed.Drops = uint32(e.Entries), uint32(e.Installed), e.Drops
 		}
+		reply.Strategies = append(reply.Strategies, installed)
 	}
-	return &UpdateStrategyReply{Status: "OK"}, nil
+	return reply, nil
 }
 
-func (s *Server) GetLocalStrategyCh() chan *PolicyOp {
-	return s.LocalStrategyCh
+// GetNodeStatus reports the XDP programs attached, the usage of maps, the packets dropped,
+// the filter modes of interfaces and the generation applied by this node, along with the
+// sessions the policies applied would cut off.
+func (s *Server) GetNodeStatus(ctx context.Context, in *NodeStatusRequest) (*NodeStatus, error) {
+	s.policyCacheMu.Lock()
+	reply := &NodeStatus{NodeId: s.NodeID, Generation: s.appliedGeneration, Policies: uint32(len(s.policyCache)),
+		FilterModes: s.FilterModes}
+	guard, guarded := s.Enforcer.(SessionGuard)
+	var (
+		cut []Session
+		err error
+	)
+	if guarded {
+		cut, err = guard.CutOff()
+	}
+	s.policyCacheMu.Unlock()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check the sessions: %v", err)
+	}
+	for _, session := range cut {
+		reply.CutOffSessions = append(reply.CutOffSessions, session.String())
+	}
+	if s.Dataplane == nil {
+		return reply, nil
+	}
+
+	for _, prog := range s.Dataplane.Status() {
+		reply.Programs = append(reply.Programs, &ProgramStatus{
+			Name:      prog.Name,
+			HookPoint: prog.HookPoint,
+			State:     prog.State,
+			Pinned:    prog.Pinned,
+			Error:     prog.Error,
+		})
+	}
+
+	usages, err := s.Dataplane.MapUsage(s.Maps...)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read the usage of maps: %v", err)
+	}
+	for _, usage := range usages {
+		reply.Maps = append(reply.Maps, &MapUsage{
+			Name:       usage.Name,
+			Entries:    uint32(usage.Entries),
+			MaxEntries: uint32(usage.MaxEntries),
+		})
+	}
+
+	if reply.Drops, err = s.Dataplane.DropStats(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read the drop counters: %v", err)
+	}
+	return reply, nil
+}
+
+func (s *Server) generation() uint64 {
+	s.policyCacheMu.Lock()
+	defer s.policyCacheMu.Unlock()
+	return s.appliedGeneration
+}
+
+// replaced returns the channel waking Expire up, the caller should hold policyCacheMu.
+func (s *Server) replaced() chan struct{} {
+	if s.cacheReplaced == nil {
+		s.cacheReplaced = make(chan struct{}, 1)
+	}
+	return s.cacheReplaced
+}
+
+// cachedPolicyIDs returns the ids of policyCache in order, the caller should hold
+// policyCacheMu.
+func (s *Server) cachedPolicyIDs() []string {
+	ids := make([]string, 0, len(s.policyCache))
+	for id := range s.policyCache {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func replyStatus(rejected []string) string {
+	if len(rejected) == 0 {
+		return STATUS_OK
+	}
+	return STATUS_REJECTED + ": " + strings.Join(rejected, "; ")
 }
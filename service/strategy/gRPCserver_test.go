@@ -0,0 +1,184 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+func TestApplyStrategy(t *testing.T) {
+	m := fakeBlocklistMap{}
+	s := &Server{Enforcer: NewBlocklist(m)}
+	set := &StrategySet{Generation: 7, Policies: PoliciesToProto([]*v1.Policy{
+		newDropPolicy("host", "172.17.0.11"),
+		newDropPolicy("wide", "10.0.0.0/16"),
+	})}
+
+	ack, err := s.ApplyStrategy(context.Background(), set)
+	if err != nil || ack.Generation != 7 || !strings.HasPrefix(ack.Status, STATUS_REJECTED+": wide") {
+		t.Fatalf("Expected generation 7 applied with policy wide rejected, got %v err=%v", ack, err)
+	}
+	if _, exists := s.policyCache["host"]; !exists || len(s.policyCache) != 1 || len(m) != 1 {
+		t.Fatalf("Expected only policy host applied, got %v", s.policyCache)
+	}
+
+	ack, err = s.ApplyStrategy(context.Background(), &StrategySet{Generation: 8})
+	if err != nil || ack.Generation != 8 || len(s.policyCache) != 0 || len(m) != 0 {
+		t.Fatalf("Expected generation 8 revoking every policy, got %v err=%v", ack, err)
+	}
+
+	set.Policies = append(set.Policies, &Policy{Id: "malformed", Cidr: "10.0.0.1/33"})
+	if _, err := s.ApplyStrategy(context.Background(), set); err == nil {
+		t.Fatalf("Expected the set with a malformed policy refused, got %v", err)
+	}
+	if ack, _ := s.GetStrategyGeneration(context.Background(), &StrategyGenerationRequest{}); ack.Generation != 8 {
+		t.Fatalf("Expected generation 8 kept, got %v", ack.Generation)
+	}
+}
+
+func TestApplyStrategyServers(t *testing.T) {
+	first, second := &Server{Enforcer: NewBlocklist(fakeBlocklistMap{})}, &Server{Enforcer: NewBlocklist(fakeBlocklistMap{})}
+	set := &StrategySet{Generation: 3, Policies: PoliciesToProto([]*v1.Policy{newDropPolicy("host", "172.17.0.11")})}
+	if _, err := first.ApplyStrategy(context.Background(), set); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// the servers keep the policies they applied apart
+	if second.generation() != 0 || len(second.policyCache) != 0 {
+		t.Fatalf("Expected nothing applied on the second server, got generation %v with %v",
+			second.generation(), second.policyCache)
+	}
+	if ack, err := second.ApplyStrategy(context.Background(), set); err != nil || ack.Generation != 3 || len(second.policyCache) != 1 {
+		t.Fatalf("Expected generation 3 applied on the second server, got %v err=%v", ack, err)
+	}
+}
+
+func TestListStrategies(t *testing.T) {
+	m := fakeBlocklistMap{0xac110001: 3}
+	blocklist := NewBlocklist(m)
+	s := &Server{Enforcer: blocklist}
+	set := &StrategySet{Generation: 9, Policies: PoliciesToProto([]*v1.Policy{newDropPolicy("host", "172.17.0.11")})}
+	if _, err := s.ApplyStrategy(context.Background(), set); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	m[0xac11000b] = 5
+
+	reply, err := s.ListStrategies(context.Background(), &ListStrategiesRequest{})
+	if err != nil || reply.Generation != 9 || len(reply.Strategies) != 1 {
+		t.Fatalf("Expected policy host of generation 9 listed, got %v err=%v", reply, err)
+	}
+	if installed := reply.Strategies[0]; installed.Installed != 1 || installed.Drops != 5 {
+		t.Fatalf("Expected 1 entry installed with 5 drops, got %v", installed)
+	}
+	if len(reply.Unowned) != 1 || reply.Unowned[0] != "172.17.0.1" {
+		t.Fatalf("Expected 172.17.0.1 unowned, got %v", reply.Unowned)
+	}
+}
+
+func TestApplyStrategyAudit(t *testing.T) {
+	var events []*v1.AuditEvent
+	s := &Server{NodeID: "node-1", Enforcer: NewBlocklist(fakeBlocklistMap{}),
+		Audit: func(e []*v1.AuditEvent) { events = append(events, e...) }}
+	apply := func(generation uint64, policies ...*v1.Policy) {
+		events = nil
+		set := &StrategySet{Generation: generation, Policies: PoliciesToProto(policies)}
+		if _, err := s.ApplyStrategy(context.Background(), set); err != nil {
+			t.Fatalf("Expected generation %v applied, got %v", generation, err)
+		}
+	}
+
+	apply(10, newDropPolicy("host", "172.17.0.11"), newDropPolicy("wide", "10.0.0.0/16"))
+	if len(events) != 2 || events[0].Result != v1.AUDIT_RESULT_OK || events[1].Result != v1.AUDIT_RESULT_REJECTED ||
+		events[1].PolicyID != "wide" || events[1].NodeID != "node-1" || events[1].Generation != 10 {
+		t.Fatalf("Expected host applied and wide rejected, got %v", events)
+	}
+
+	// only the changes are recorded
+	apply(11, newDropPolicy("host", "172.17.0.11"), newDropPolicy("wide", "10.0.0.0/16"), newDropPolicy("db", "172.17.0.12"))
+	if len(events) != 1 || events[0].PolicyID != "db" || events[0].Kind != v1.AUDIT_APPLY {
+		t.Fatalf("Expected only db applied, got %v", events)
+	}
+
+	apply(12, newDropPolicy("db", "172.17.0.12"))
+	if len(events) != 1 || events[0].PolicyID != "host" || events[0].Kind != v1.AUDIT_REVOKE || events[0].Before == nil {
+		t.Fatalf("Expected host revoked, got %v", events)
+	}
+
+	// a policy is recorded once it is enforced rather than applied
+	start := time.Now().UTC().Add(2 * time.Hour).Truncate(time.Minute)
+	scheduled := newDropPolicy("scheduled", "172.17.0.13")
+	scheduled.Schedule = &v1.Schedule{Start: start.Format("15:04"), End: start.Add(time.Hour).Format("15:04")}
+	apply(13, newDropPolicy("db", "172.17.0.12"), scheduled)
+	if len(events) != 0 {
+		t.Fatalf("Expected nothing recorded before scheduled is enforced, got %v", events)
+	}
+	if err := s.enforceActive(start.Add(time.Minute)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events) != 1 || events[0].PolicyID != "scheduled" || events[0].Kind != v1.AUDIT_APPLY {
+		t.Fatalf("Expected scheduled applied once, got %v", events)
+	}
+}
+
+func TestEnforceActiveAudit(t *testing.T) {
+	var events []*v1.AuditEvent
+	s := &Server{NodeID: "node-1", Enforcer: NewBlocklist(fakeBlocklistMap{}),
+		Audit: func(e []*v1.AuditEvent) { events = append(events, e...) }}
+
+	expiring := newDropPolicy("expiring", "172.17.0.11")
+	expiry := time.Now().Add(time.Minute)
+	expiring.Expiry = &expiry
+	set := &StrategySet{Generation: 13, Policies: PoliciesToProto([]*v1.Policy{expiring, newDropPolicy("kept", "172.17.0.12")})}
+	if _, err := s.ApplyStrategy(context.Background(), set); err != nil {
+		t.Fatalf("Expected generation 13 applied, got %v", err)
+	}
+
+	// the policy expires on the node without the policy controller
+	events = nil
+	if err := s.enforceActive(expiry); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events) != 1 || events[0].PolicyID != "expiring" || events[0].Kind != v1.AUDIT_REVOKE ||
+		events[0].Reason != "expired" || events[0].Generation != 13 {
+		t.Fatalf("Expected expiring revoked, got %v", events)
+	}
+
+	events = nil
+	if err := s.enforceActive(expiry.Add(time.Minute)); err != nil || len(events) != 0 {
+		t.Fatalf("Expected nothing recorded again, got %v err=%v", events, err)
+	}
+}
+
+func TestExpireRestored(t *testing.T) {
+	blocklist := NewBlocklist(fakeBlocklistMap{0xac11000b: 1})
+	if n, err := blocklist.Restore(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 key restored, got %v err=%v", n, err)
+	}
+	s := &Server{Enforcer: blocklist, RestoredGrace: 50 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Expire(ctx)
+
+	// no set of policies is applied within the grace period
+	deadline := time.Now().Add(time.Second)
+	for {
+		reply, err := s.ListStrategies(context.Background(), &ListStrategiesRequest{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(reply.Unowned) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the restored key deleted, got %v", reply.Unowned)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
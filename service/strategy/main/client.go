@@ -8,18 +8,22 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/imroc/req/v3"
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
 	"github.com/p1nant0m/xdp-tracing/service"
+	"github.com/p1nant0m/xdp-tracing/service/rest/store/etcd"
 	"github.com/p1nant0m/xdp-tracing/service/strategy"
 	"github.com/sirupsen/logrus"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -29,93 +33,65 @@ import (
 	"google.golang.org/grpc/keepalive"
 )
 
-type RPCType uint32
-
 const (
 	SERVER_NAME = "server.grpc.io"
 )
 
+// Prefixes of the keys registered by the nodes in etcd, see (*service.EtcdService).Serve
 const (
-	InstallStrategy RPCType = iota
-	RevokeStrategy
+	NODE_KEY_PREFIX      = v1.NODE_KEY_PREFIX
+	HOST_INFO_KEY_PREFIX = "host-info:"
 )
 
-func (r RPCType) ToString() string {
-	switch r {
-	case InstallStrategy:
-		return "InstallStrategy"
-	case RevokeStrategy:
-		return "RevokeStrategy"
-	default:
-		return "Unknown Type"
-	}
-}
-
 const (
 	KEEP_ALIVE_TIMEOUT = 65
-	MaximumRetries     = 5
 )
 
-type Retry struct {
-	RPCParams   *sendRPCParams
-	RPCType     RPCType
-	Reason      string
-	RetryPeriod time.Duration
-	RetryTimes  int // TODO: Maximum retry times
-}
-
 type PolicyController interface {
-	Read() string
-	Append(string)
-	Generate(context.Context) // Generate() will trace new comming policy and trigger [InstallStrategyRPC] Or [RevokeStrategyRPC]
-	ToByte() []byte
+	Policies() []*v1.Policy
+	Append(*v1.Policy)
+	Generate(context.Context) // Generate() will trace new comming policy and set the desired policies of the cluster
 }
 
 type remoteHostCache struct {
-	Storage map[string]string
+	Storage map[string]string              // gRPC addresses of the nodes by their keys
+	Specs   map[string]*service.SpecConfig // specs advertised by the nodes by their ids
 	mu      sync.Mutex
 }
 
-type sendRPCParams struct {
-	Ctx        context.Context
-	IPAddr     string
-	NodeID     string
-	Policy     []byte
-	RetryTimes int
+// nodeInfo returns how the node of nodeKey is selected by policies, the caller should
+// hold remoteHost.mu.
+func (r *remoteHostCache) nodeInfo(nodeKey string) *v1.NodeInfo {
+	nodeID := strings.TrimPrefix(nodeKey, NODE_KEY_PREFIX)
+	return r.Specs[nodeID].NodeInfo(nodeID)
 }
 
 var (
 	remoteHost remoteHostCache                  = remoteHostCache{Storage: make(map[string]string)} // Take local records for cluster's nodes
 	connCache  map[string]*grpc.ClientConn      = make(map[string]*grpc.ClientConn)                 // Reuse the long-alive connection
 	creds      credentials.TransportCredentials                                                     // Credentials used for TLS connection to the gRPC server
-	recycleCh  chan *Retry                      = make(chan *Retry, 10)
+	connMu     sync.Mutex                                                                           // Protects connCache from the sync loops of the nodes
 )
 
-type Empty struct{}
-
-var empty Empty
-
 type PolicyControFromRest struct {
 	mu     sync.Mutex
-	record map[string]Empty
-	Policy []string
+	Policy []*v1.Policy
 }
 
-func (rContro *PolicyControFromRest) Read() string {
+func (rContro *PolicyControFromRest) Policies() []*v1.Policy {
 	rContro.mu.Lock()
 	defer rContro.mu.Unlock()
-	return strings.Join(rContro.Policy, " ")
+	return append([]*v1.Policy(nil), rContro.Policy...)
 }
 
-func (rContro *PolicyControFromRest) Append(policy string) {
+func (rContro *PolicyControFromRest) Append(policy *v1.Policy) {
 	rContro.mu.Lock()
 	defer rContro.mu.Unlock()
-	rContro.record[policy] = empty
 	rContro.Policy = append(rContro.Policy, policy)
 }
 
 func (rContro *PolicyControFromRest) Generate(ctx context.Context) {
-	// This should using Read() and Append() to operate Policy safely
+	// This should using Policies() and Append() to operate Policy safely
 	/** TODO: Request RestAPI for the newest judgement about the Application Data Flow
 	Make Policy based on the prediction whether an action was malicious or not **/
 	opts := service.ExtractRestConfig()
@@ -130,134 +106,121 @@ func (rContro *PolicyControFromRest) Generate(ctx context.Context) {
 		SetBaseURL("http://" + opts.Addr)
 
 	resp, err := client.R().Get("/healthz")
-	if resp.IsError() || err != nil {
-		logrus.Fatalf("cannot make communication with apiServer", "err=", err)
+	if err != nil || resp.IsError() {
+		logrus.Fatalf("cannot make communication with apiServer err=%v", err)
 	}
 
 	ticker := time.NewTicker(time.Second * 3)
 	for {
 		<-ticker.C
 		policies := struct {
-			Policies []string `json:"data"`
+			Policies []*v1.Policy `json:"data"`
 		}{}
 		resp, err := client.R().
 			SetResult(&policies).
 			Get("/v1/policies")
 
 		if err != nil {
-			logrus.Warning("error occurs when request /v1/policies", "err=", err)
+			logrus.Warnf("error occurs when request /v1/policies err=%v", err)
+			continue
 		}
 
 		if resp.IsSuccess() {
-			mark := make(map[string]Empty, len(policies.Policies))
-			for _, item := range policies.Policies {
-				mark[item] = empty
-				if _, exists := rContro.record[item]; !exists {
-					rContro.mu.Lock()
-					rContro.record[item] = empty
-					rContro.mu.Unlock()
-					go SendRPCToPeers(ctx, InstallStrategy, []byte(item))
-				}
-			}
-
-			for _, item := range rContro.Policy {
-				if _, exists := mark[item]; !exists {
-					rContro.mu.Lock()
-					delete(rContro.record, item)
-					rContro.mu.Unlock()
-					go SendRPCToPeers(ctx, RevokeStrategy, []byte(item))
-				}
-			}
+			current := validPolicies(policies.Policies)
 
 			rContro.mu.Lock()
-			rContro.Policy = policies.Policies
-			logrus.Debugf("Policy: %v", rContro.Policy)
+			rContro.Policy = current
+			logrus.Debugf("Policy: %v", len(rContro.Policy))
 			rContro.mu.Unlock()
 
+			// every node is driven to the policies selecting it, nothing is pushed unless they change
+			targets.SetPolicies(current)
 		}
 
 	}
 }
 
-func (rContro *PolicyControFromRest) ToByte() []byte {
-	// This should using Read() and Append() to operate Policy safely
-	return []byte(rContro.Read())
+// PolicyControFromEtcd watches the policies stored in etcd by the REST server, see
+// service/rest/store/etcd, it is used instead of PolicyControFromRest when rest.store of
+// the config is etcd.
+type PolicyControFromEtcd struct {
+	mu     sync.Mutex
+	Policy []*v1.Policy
+}
+
+func (eContro *PolicyControFromEtcd) Policies() []*v1.Policy {
+	eContro.mu.Lock()
+	defer eContro.mu.Unlock()
+	return append([]*v1.Policy(nil), eContro.Policy...)
+}
+
+func (eContro *PolicyControFromEtcd) Append(policy *v1.Policy) {
+	eContro.mu.Lock()
+	defer eContro.mu.Unlock()
+	eContro.Policy = append(eContro.Policy, policy)
+}
+
+func (eContro *PolicyControFromEtcd) Generate(ctx context.Context) {
+	etcdService := service.NewEtcdService(ctx)
+	if err := etcdService.Conn(); err != nil {
+		logrus.Fatalf("[Policy Controller] failed to connect to etcd err=%v", err)
+	}
+
+	err := etcd.WatchPolicies(ctx, etcdService.Client, func(policies []*v1.Policy) {
+		current := validPolicies(policies)
+
+		eContro.mu.Lock()
+		eContro.Policy = current
+		logrus.Debugf("Policy: %v", len(eContro.Policy))
+		eContro.mu.Unlock()
+
+		// every node is driven to the policies selecting it, nothing is pushed unless they change
+		targets.SetPolicies(current)
+	})
+	if err != nil {
+		logrus.Fatalf("[Policy Controller] failed to watch policies in etcd err=%v", err)
+	}
+}
+
+// validPolicies returns the policies which are well-formed.
+func validPolicies(policies []*v1.Policy) []*v1.Policy {
+	var valid []*v1.Policy
+	for _, item := range policies {
+		// the API server validates policies, a malformed one would be refused by every node
+		if err := item.Validate(); err != nil {
+			logrus.Warnf("[Policy Controller] skip malformed policy %v err=%v", item.ID, err)
+			continue
+		}
+		valid = append(valid, item)
+	}
+	return valid
 }
 
 // testPolicyController uses for testing gRPC configuration and whether eBPF agent
 // can apply policy to eBPF kernel program
 type testPolicyContro struct {
 	mu     sync.Mutex // using for protecting the operation in Policy
-	Policy []string
+	Policy []*v1.Policy
 }
 
-func (tContro *testPolicyContro) Read() string {
+func (tContro *testPolicyContro) Policies() []*v1.Policy {
 	tContro.mu.Lock()
 	defer tContro.mu.Unlock()
-	return strings.Join(tContro.Policy, " ")
+	return append([]*v1.Policy(nil), tContro.Policy...)
 }
 
-func (tContro *testPolicyContro) Append(policy string) {
+func (tContro *testPolicyContro) Append(policy *v1.Policy) {
 	tContro.mu.Lock()
 	defer tContro.mu.Unlock()
 	tContro.Policy = append(tContro.Policy, policy)
 }
 
 func (tContro *testPolicyContro) Generate(ctx context.Context) {
-	// This should using Read() and Append() to operate Policy safely
-	newRules := "172.17.0.11"
-	tContro.Append(newRules)
-	go SendRPCToPeers(ctx, RevokeStrategy, []byte(newRules))
-}
-
-func (tContro *testPolicyContro) ToByte() []byte {
-	// This should using Read() and Append() to operate Policy safely
-	return []byte(tContro.Read())
-}
-
-// SendRPCToPeers will call registed RPC method based on input "rpcTpye" to every node in the cluster
-func SendRPCToPeers(ctx context.Context, rpcType RPCType, policy []byte) {
-	logrus.Debugf("SendRPCToPeers called rpcType: %v policy %v", rpcType.ToString(), policy)
-	var goFunc func(*sendRPCParams, chan<- *Retry)
-
-	// Choosing different Handle Function for Sending RPC
-	switch rpcType {
-	case InstallStrategy:
-		goFunc = sendInstallStrategyRPC
-	case RevokeStrategy:
-		goFunc = sendRevokeStrategyRPC
-	}
-
-	remoteHost.mu.Lock()
-	for nodeID, IPAddr := range remoteHost.Storage {
-		go goFunc(&sendRPCParams{Ctx: ctx, IPAddr: IPAddr, NodeID: nodeID, Policy: policy}, recycleCh)
-	}
-	remoteHost.mu.Unlock()
-}
-
-func slowlyRetry(ctx context.Context) {
-	for retryEvent := range recycleCh {
-		go func(retryEvent *Retry) {
-			time.Sleep(time.Second * 3) // Wait for network recover or key node:.* expire
-			remoteHost.mu.Lock()
-			if _, exists := remoteHost.Storage[retryEvent.RPCParams.NodeID]; !exists {
-				// Retry will be invalid since the remote host is disconnected
-				remoteHost.mu.Unlock()
-				return
-			}
-			remoteHost.mu.Unlock()
-			switch retryEvent.RPCType {
-			case InstallStrategy:
-				logrus.Warnf("[Policy Controller] Retrying InstallStrategyRPC %v:%v",
-					retryEvent.RPCParams.NodeID, retryEvent.RPCParams.IPAddr)
-				sendInstallStrategyRPC(retryEvent.RPCParams, recycleCh)
-			case RevokeStrategy:
-				logrus.Warnf("[Policy Controller] Retrying RevokeStrategyRPC %v:%v",
-					retryEvent.RPCParams.NodeID, retryEvent.RPCParams.IPAddr)
-				sendRevokeStrategyRPC(retryEvent.RPCParams, recycleCh)
-			}
-		}(retryEvent)
-	}
+	// This should using Policies() and Append() to operate Policy safely
+	newRule := &v1.Policy{ID: "test", CIDR: "172.17.0.11"}
+	newRule.Default()
+	tContro.Append(newRule)
+	targets.SetPolicies(tContro.Policies())
 }
 
 // makeTLSConfiguration return crendentials for TLS Connection based on client key and client's
@@ -317,11 +280,19 @@ func main() {
 
 	// Make TLS Configuration for gRPC Client
 	creds = makeTLSConfiguration(os.Args[2])
-	var testGen PolicyController = &PolicyControFromRest{record: make(map[string]Empty)}
+	var testGen PolicyController = &PolicyControFromRest{}
+	if restConfig := service.ExtractRestConfig(); restConfig != nil && restConfig.Store == service.REST_STORE_ETCD {
+		testGen = &PolicyControFromEtcd{}
+	}
 
-	nodeWatcher(ctx, testGen) // this goroutine trace the modification of cluster nodes, and sync the cluster policy
-	go testGen.Generate(ctx)  // this goroutine used for receiving new policy instrcution
-	go slowlyRetry(ctx)       // this goroutine will retired the failure RPCs until it is success or remote host be removed
+	nodeWatcher(ctx)         // this goroutine trace the modification of cluster nodes, and sync the cluster policy
+	go testGen.Generate(ctx) // this goroutine used for receiving new policy instrcution
+	go targets.Expire(ctx)   // this goroutine revokes the policies expired from the nodes
+	if grpcConfig := service.ExtractgRPCConfig(); grpcConfig != nil && grpcConfig.Controller != "" {
+		if err := serveSubscriptions(ctx, grpcConfig.Controller, os.Args[2]); err != nil {
+			logrus.Fatalf("[Policy Controller] failed to serve subscriptions of nodes err=%v", err)
+		}
+	}
 
 	<-ctx.Done()
 }
@@ -334,8 +305,11 @@ func makeClient(ipAddr string) (strategy.StrategyClient, error) {
 		exists bool
 	)
 
+	connMu.Lock()
+	defer connMu.Unlock()
 	// Firstly Check whether there is a long-alive connection for the client
-	if conn, exists = connCache[ipAddr]; exists && conn.GetState() == connectivity.Connecting {
+	if conn, exists = connCache[ipAddr]; exists && conn.GetState() != connectivity.Shutdown &&
+		conn.GetState() != connectivity.TransientFailure {
 		// Cache Connection can be reused
 		goto out
 	} else {
@@ -358,111 +332,135 @@ out:
 	return c, nil
 }
 
-// sendInstallStrategyRPC sends InstallStrategy to gRPC Server Endpoint, it takes remote address,
-// nodeID and policy cotroller as input. No matter what happen (network partition, network failures or
-// remote server crash) it should ensure the every call will be treaded properly
-// (in the end it will go to remote server or remote server leave the cluster)
-func sendInstallStrategyRPC(params *sendRPCParams, recycle chan<- *Retry) {
-	logrus.Infof("[Policy Controller] trying sending InstallStrategyRPC to %v", params.IPAddr)
-	c, err := makeClient(params.IPAddr)
-	if err != nil {
-		logrus.Warnf("[Policy Controller] error occurs when making Client err=", err.Error())
-		recycle <- &Retry{RPCParams: params, RPCType: InstallStrategy, Reason: err.Error()}
-		return
-	}
-	// Contact the server and print out its response.
-	ctxT, cancel := context.WithTimeout(params.Ctx, time.Second*1)
-	defer cancel()
-
-	r, err := c.InstallStrategy(ctxT, &strategy.UpdateStrategy{Blockoutrules: params.Policy})
-	if err != nil {
-		recycle <- &Retry{RPCParams: params, RPCType: InstallStrategy, Reason: err.Error()}
-		logrus.Warnf("[Policy Controller] error occurs when sending InstallStrategyRPC to %v err=%v", params.IPAddr, err)
-		return
-	}
-	logrus.Infof("[Policy Controller] response from %v status=%v", params.IPAddr, r.Status)
-}
-
-func sendRevokeStrategyRPC(params *sendRPCParams, recycle chan<- *Retry) {
-	logrus.Infof("[Policy Controller] trying sending RevokeStrategyRPC to %v", params.IPAddr)
-	c, err := makeClient(params.IPAddr)
-	if err != nil {
-		logrus.Warnf("[Policy Controller] error occurs when making Client err=", err.Error())
-		recycle <- &Retry{RPCParams: params, RPCType: RevokeStrategy, Reason: err.Error()}
-		return
+// addRegisteredNodes starts to drive the nodes already recorded in remoteHost, the
+// addresses are copied so that targets.AddNode is called without holding remoteHost.mu.
+func addRegisteredNodes(ctx context.Context) {
+	remoteHost.mu.Lock()
+	nodes := make(map[string]string, len(remoteHost.Storage))
+	for key, ipAddr := range remoteHost.Storage {
+		nodes[key] = ipAddr
 	}
-	// Contact the server and print out its response.
-	ctxT, cancel := context.WithTimeout(params.Ctx, time.Second*1)
-	defer cancel()
-
-	r, err := c.RevokeStrategy(ctxT, &strategy.UpdateStrategy{Blockoutrules: params.Policy})
-	if err != nil {
-		recycle <- &Retry{RPCParams: params, RPCType: RevokeStrategy, Reason: err.Error()}
-		logrus.Warnf("[Policy Controller] error occurs when sending RevokeStrategyRPC to %v err=%v", params.IPAddr, err)
-		return
+	remoteHost.mu.Unlock()
+	for key, ipAddr := range nodes {
+		targets.AddNode(ctx, key, ipAddr)
 	}
-	logrus.Infof("[Policy Controller] response from %v status=%v", params.IPAddr, r.Status)
 }
 
 // nodeWatcher will connect to Etcd Server which used for Service Discovery and setup a wather in
 // observating the changes of cluster's node. If there is a new node join in the cluster, it should be
-// applied the strategy that any other nodes has, (syn process) and if a node leave the cluster, we should
-// keep things go right
-func nodeWatcher(ctx context.Context, policyGen PolicyController) {
+// applied the strategy selecting it, (syn process) and if a node leave the cluster or change its spec,
+// we should keep things go right
+func nodeWatcher(ctx context.Context) {
 	var etcdService *service.EtcdService = service.NewEtcdService(ctx)
 	if err := etcdService.Conn(); err != nil {
 		logrus.Fatalf("[etcd Service] failed to start etcd componet err=%v", err.Error())
 	}
 
-	// Retrieve cluster's node that has already registed
+	// Retrieve cluster's node that has already registed, and the specs they advertise at the same revision
 	kvs, err := etcdService.Client.Get(ctx, "node", clientv3.WithPrefix())
 	if err != nil {
 		logrus.Fatal("[etcd Service] error occurs when trying to get key with prefix [node]")
 	}
+	rev := kvs.Header.Revision
+	remoteHost.mu.Lock()
 	for _, kv := range kvs.Kvs {
 		logrus.Info("[Policy Controller] Cluster already has node before Policy Controller Start", "node=", string(kv.Key))
 		remoteHost.Storage[string(kv.Key)] = string(kv.Value)
 	}
-	watchCh := etcdService.Client.Watch(ctx, "node", clientv3.WithPrefix())
+	remoteHost.mu.Unlock()
+	kvs, err = etcdService.Client.Get(ctx, "host-info", clientv3.WithPrefix(), clientv3.WithRev(rev))
+	if err != nil {
+		logrus.Fatal("[etcd Service] error occurs when trying to get key with prefix [host-info]")
+	}
+	for _, kv := range kvs.Kvs {
+		updateNodeSpec(string(kv.Key), kv.Value)
+	}
+	addRegisteredNodes(ctx)
+	// both watchers start right after the revision read above, so that no change is missed
+	nodeCh := etcdService.Client.Watch(ctx, "node", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	specCh := etcdService.Client.Watch(ctx, "host-info", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
 
 	go func() {
-		logrus.Info("[Policy Controller] Successfully Start Etcd Service and Setup Wather on key with prefix [node]")
-		for watch := range watchCh {
+		logrus.Info("[Policy Controller] Successfully Start Etcd Service and Setup Wather on key with prefix [node] and [host-info]")
+		for {
+			var (
+				watch clientv3.WatchResponse
+				ok    bool
+			)
+			select {
+			case watch, ok = <-nodeCh:
+			case watch, ok = <-specCh:
+			case <-etcdService.StopCh:
+				return
+			case <-ctx.Done():
+				return
+			}
+			if !ok {
+				return
+			}
+
+			specChanged := false
 			for _, event := range watch.Events {
-				remoteHost.mu.Lock()
-				switch event.Type {
+				key := string(event.Kv.Key)
+				switch {
+				case strings.HasPrefix(key, HOST_INFO_KEY_PREFIX) && event.Type == clientv3.EventTypePut:
+					// the node refreshes its host information periodically, the spec rarely changes
+					specChanged = updateNodeSpec(key, event.Kv.Value) || specChanged
+
+				case strings.HasPrefix(key, HOST_INFO_KEY_PREFIX) && event.Type == clientv3.EventTypeDelete:
+					remoteHost.mu.Lock()
+					delete(remoteHost.Specs, strings.TrimPrefix(key, HOST_INFO_KEY_PREFIX))
+					remoteHost.mu.Unlock()
 
-				case clientv3.EventTypeDelete:
+				case event.Type == clientv3.EventTypeDelete:
 					// Node Server Instance Disconnnected from clusters
-					delete(remoteHost.Storage, string(event.Kv.Key)) // remove disconnected server from localcache
+					remoteHost.mu.Lock()
+					delete(remoteHost.Storage, key) // remove disconnected server from localcache
 					remoteHost.mu.Unlock()
-					logrus.Warnf("[Policy Controller] remote server disconnected %v", string(event.Kv.Key))
-
-				case clientv3.EventTypePut:
-					// New Node Server Instance Connected to clusters
-					remoteHost.Storage[string(event.Kv.Key)] = string(event.Kv.Value)
-					logrus.Warnf("[Policy Controller] remote server connected %v", string(event.Kv.Key))
-					// Send Single RPC to new node to sync policy across the cluster
-					sendInstallStrategyRPC(&sendRPCParams{
-						NodeID: string(event.Kv.Key),
-						IPAddr: string(event.Kv.Value),
-						Ctx:    ctx,
-						Policy: policyGen.ToByte()}, recycleCh)
+					targets.RemoveNode(key)
+					logrus.Warnf("[Policy Controller] remote server disconnected %v", key)
 
+				case event.Type == clientv3.EventTypePut:
+					// New Node Server Instance Connected to clusters, it has no policy installed
+					remoteHost.mu.Lock()
+					remoteHost.Storage[key] = string(event.Kv.Value)
 					remoteHost.mu.Unlock()
+					// Sync the policies selecting the node, the node has none applied
+					targets.AddNode(ctx, key, string(event.Kv.Value))
+					logrus.Warnf("[Policy Controller] remote server connected %v", key)
+
 				default:
 					logrus.Warnf("[Policy Controller] not intention type received %v", event.Type.String())
-					remoteHost.mu.Unlock()
 				}
 			}
 
-			select {
-			case <-etcdService.StopCh:
-				return
-			case <-ctx.Done():
-				return
-			default:
+			if specChanged {
+				// the nodes selected by the policies may have changed
+				targets.Resync()
 			}
 		}
 	}()
 }
+
+// updateNodeSpec records the spec advertised in the host information of key, it reports
+// whether the spec has changed.
+func updateNodeSpec(key string, value []byte) bool {
+	spec := &service.SpecConfig{}
+	if err := json.Unmarshal(value, spec); err != nil {
+		logrus.Warnf("[Policy Controller] malformed host information of %v err=%v", key, err)
+		return false
+	}
+
+	nodeID := strings.TrimPrefix(key, HOST_INFO_KEY_PREFIX)
+	remoteHost.mu.Lock()
+	defer remoteHost.mu.Unlock()
+	if old, exists := remoteHost.Specs[nodeID]; exists && reflect.DeepEqual(old, spec) {
+		return false
+	}
+	if remoteHost.Specs == nil {
+		remoteHost.Specs = make(map[string]*service.SpecConfig)
+	}
+	remoteHost.Specs[nodeID] = spec
+	logrus.Infof("[Policy Controller] node %v advertises spec %+v", nodeID, *spec)
+	return true
+}
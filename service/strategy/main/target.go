@@ -0,0 +1,387 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/service/strategy"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RESYNC_PERIOD    = 30 * time.Second // how often a node in sync is checked for drift
+	MIN_RETRY_PERIOD = time.Second
+	MAX_RETRY_PERIOD = 30 * time.Second
+	RPC_TIMEOUT      = 3 * time.Second
+)
+
+// policyTargets holds the desired policies of the cluster and drives every node to them. The
+// desired policies are versioned by a generation which is bumped on every change of the
+// policies or of the nodes selected by them. Each node is pushed the full set of the policies
+// selecting it, tagged with the generation, until it acknowledges the generation, and is
+// checked periodically afterwards, so that a node which lost an RPC or restarted is pushed
+// the set again. A node subscribing to the controller with Watch is sent the set through
+// the subscription instead. Only the policies active at the time are sent, see Expire.
+type policyTargets struct {
+	mu          sync.Mutex
+	generation  uint64
+	policies    []*v1.Policy
+	active      []*v1.Policy             // policies active at the last evaluation, sent to the nodes
+	next        time.Time                // when the active policies may change next, zero if never
+	rescheduled chan struct{}            // wakes Expire up when next changes
+	synced      bool                     // whether the policies have been set, nothing is pushed before
+	nodes       map[string]*nodeSync     // by node keys
+	watchers    map[chan struct{}]string // node keys of the subscriptions by their kick channels
+
+	// dial returns the client of the node at ipAddr, makeClient if nil
+	dial func(ipAddr string) (strategy.StrategyClient, error)
+}
+
+// nodeSync is the state of a node driven by policyTargets
+type nodeSync struct {
+	ipAddr  string
+	applied uint64        // generation acknowledged by the node
+	kick    chan struct{} // wakes the sync loop of the node up when the generation is bumped
+	cancel  context.CancelFunc
+}
+
+// The generation starts from the time the controller starts, so that a node which applied
+// a generation of the previous controller never acknowledges a new generation by accident.
+var targets = newPolicyTargets(uint64(time.Now().UnixNano()))
+
+func newPolicyTargets(generation uint64) *policyTargets {
+	return &policyTargets{
+		generation:  generation,
+		rescheduled: make(chan struct{}, 1),
+		nodes:       make(map[string]*nodeSync),
+		watchers:    make(map[chan struct{}]string),
+	}
+}
+
+// SetPolicies replaces the desired policies, it bumps the generation if the active ones
+// have changed.
+func (t *policyTargets) SetPolicies(policies []*v1.Policy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.synced && reflect.DeepEqual(t.policies, policies) {
+		return
+	}
+	t.policies = policies
+	if changed := t.refresh(time.Now()); changed || !t.synced {
+		t.synced = true
+		t.bump()
+	}
+}
+
+// Expire bumps the generation whenever a policy expires, or enters or leaves a window of
+// its schedule, until ctx is done. So the nodes are revoked the policies expired.
+func (t *policyTargets) Expire(ctx context.Context) {
+	for {
+		var (
+			timer   *time.Timer
+			timerCh <-chan time.Time
+		)
+		t.mu.Lock()
+		if !t.next.IsZero() {
+			timer = time.NewTimer(time.Until(t.next))
+			timerCh = timer.C
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-t.rescheduled:
+		case <-timerCh:
+			t.mu.Lock()
+			if t.refresh(time.Now()) {
+				t.bump()
+			}
+			t.mu.Unlock()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// refresh evaluates the policies active at now, it reports whether they have changed. The
+// caller should hold t.mu.
+func (t *policyTargets) refresh(now time.Time) bool {
+	active, next := v1.ActivePolicies(t.policies, now)
+	if !next.Equal(t.next) {
+		t.next = next
+		kickOr(t.rescheduled)
+	}
+	if reflect.DeepEqual(active, t.active) {
+		return false
+	}
+
+	activeIDs := make(map[string]bool, len(active))
+	for _, policy := range active {
+		activeIDs[policy.ID] = true
+	}
+	for _, policy := range t.active {
+		switch {
+		case activeIDs[policy.ID]:
+		case policy.Expired(now):
+			logrus.Infof("[Policy Controller] revoking policy %v, it expired at %v", policy.ID, policy.Expiry)
+		case !policy.Schedule.Contains(now):
+			logrus.Infof("[Policy Controller] revoking policy %v, it is out of its schedule", policy.ID)
+		}
+	}
+	t.active = active
+	return true
+}
+
+// Resync bumps the generation, it should be called when the nodes selected by the policies
+// may have changed, e.g. a node changed its spec.
+func (t *policyTargets) Resync() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bump()
+}
+
+// AddNode starts to drive the node of nodeKey listening at ipAddr, a node added again is
+// treated as restarted without any policy applied.
+func (t *policyTargets) AddNode(ctx context.Context, nodeKey string, ipAddr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if node, exists := t.nodes[nodeKey]; exists {
+		node.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	node := &nodeSync{ipAddr: ipAddr, kick: make(chan struct{}, 1), cancel: cancel}
+	t.nodes[nodeKey] = node
+	node.kick <- struct{}{}
+	go t.syncLoop(ctx, nodeKey, node)
+}
+
+// RemoveNode stops driving the node of nodeKey, which left the cluster.
+func (t *policyTargets) RemoveNode(nodeKey string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if node, exists := t.nodes[nodeKey]; exists {
+		node.cancel()
+		delete(t.nodes, nodeKey)
+	}
+}
+
+// Watch sends the node of nodeKey subscribing to the controller the desired set whenever its
+// generation differs from the one applied by the node, until ctx is done or send fails. The
+// node is not pushed to while it is subscribed.
+func (t *policyTargets) Watch(ctx context.Context, nodeKey string, generation uint64,
+	send func(*strategy.StrategySet) error) error {
+	kick := make(chan struct{}, 1)
+	t.mu.Lock()
+	t.watchers[kick] = nodeKey
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.watchers, kick)
+		t.mu.Unlock()
+	}()
+
+	for {
+		desired, policies, ok := t.desired(nodeKey)
+		if ok && desired != generation {
+			err := send(&strategy.StrategySet{Generation: desired, Policies: strategy.PoliciesToProto(policies)})
+			if err != nil {
+				return err
+			}
+			generation = desired
+			t.mu.Lock()
+			if node, exists := t.nodes[nodeKey]; exists {
+				node.applied = desired
+			}
+			t.mu.Unlock()
+			logrus.Infof("[Policy Controller] sent generation %v with %v policies to subscriber %v",
+				desired, len(policies), nodeKey)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-kick:
+		}
+	}
+}
+
+func (t *policyTargets) bump() {
+	t.generation++
+	for _, node := range t.nodes {
+		kickOr(node.kick)
+	}
+	for kick := range t.watchers {
+		kickOr(kick)
+	}
+}
+
+// watched reports whether the node of nodeKey has subscribed to the controller.
+func (t *policyTargets) watched(nodeKey string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range t.watchers {
+		if key == nodeKey {
+			return true
+		}
+	}
+	return false
+}
+
+func kickOr(kick chan struct{}) {
+	select {
+	case kick <- struct{}{}:
+	default:
+	}
+}
+
+// desired returns the generation and the policies selecting the node of nodeKey, ok is false
+// until the policies are set.
+func (t *policyTargets) desired(nodeKey string) (generation uint64, policies []*v1.Policy, ok bool) {
+	// remoteHost.mu is never taken under t.mu, AddNode is called once it is released
+	remoteHost.mu.Lock()
+	node := remoteHost.nodeInfo(nodeKey)
+	remoteHost.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.generation, selectPolicies(node, t.active), t.synced
+}
+
+// syncLoop drives the node until ctx is done: the desired set is pushed whenever the
+// generation is bumped or the node is found drifted, and pushed again with backoff until
+// the node acknowledges it.
+func (t *policyTargets) syncLoop(ctx context.Context, nodeKey string, node *nodeSync) {
+	ticker := time.NewTicker(RESYNC_PERIOD)
+	defer ticker.Stop()
+	wait, retry := MIN_RETRY_PERIOD, MIN_RETRY_PERIOD
+
+	for {
+		var retryCh <-chan time.Time
+		if generation, _, ok := t.desired(nodeKey); ok && t.ackedGeneration(node) != generation {
+			retryCh = time.After(wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-node.kick:
+		case <-retryCh:
+		case <-ticker.C:
+			// the node may have restarted or been pushed by another controller
+			if err := t.check(ctx, nodeKey, node); err != nil {
+				logrus.Warnf("[Policy Controller] failed to check the generation of %v err=%v", nodeKey, err)
+				continue
+			}
+		}
+
+		if err := t.push(ctx, nodeKey, node); err != nil {
+			logrus.Warnf("[Policy Controller] failed to push policies to %v, retry in %v err=%v", nodeKey, retry, err)
+			if wait, retry = retry, retry*2; retry > MAX_RETRY_PERIOD {
+				retry = MAX_RETRY_PERIOD
+			}
+			continue
+		}
+		wait, retry = MIN_RETRY_PERIOD, MIN_RETRY_PERIOD
+	}
+}
+
+// push sends the node the desired set unless it has acknowledged the generation.
+func (t *policyTargets) push(ctx context.Context, nodeKey string, node *nodeSync) error {
+	generation, policies, ok := t.desired(nodeKey)
+	if !ok || t.ackedGeneration(node) == generation || t.watched(nodeKey) {
+		return nil
+	}
+
+	c, err := t.client(node.ipAddr)
+	if err != nil {
+		return err
+	}
+	ctxT, cancel := context.WithTimeout(ctx, RPC_TIMEOUT)
+	defer cancel()
+
+	ack, err := c.ApplyStrategy(ctxT, &strategy.StrategySet{
+		Generation: generation, Policies: strategy.PoliciesToProto(policies)})
+	if err != nil {
+		return err
+	}
+	if ack.Generation != generation {
+		return fmt.Errorf("node acknowledged generation %v rather than %v", ack.Generation, generation)
+	}
+	t.setAcked(node, ack.Generation)
+
+	// the node can not enforce some of the policies, pushing them again does not help
+	for _, result := range ack.Results {
+		if result.Code == strategy.RuleCode_RULE_REJECTED {
+			logrus.Warnf("[Policy Controller] %v rejected policy %v: %v", nodeKey, result.Id, result.Reason)
+		}
+	}
+	logrus.Infof("[Policy Controller] %v applied generation %v with %v policies", nodeKey, generation, len(policies))
+	return nil
+}
+
+// check asks the node for the generation it has applied, the node is pushed again if it
+// does not match the acknowledged one.
+func (t *policyTargets) check(ctx context.Context, nodeKey string, node *nodeSync) error {
+	if t.watched(nodeKey) {
+		return nil
+	}
+	c, err := t.client(node.ipAddr)
+	if err != nil {
+		return err
+	}
+	ctxT, cancel := context.WithTimeout(ctx, RPC_TIMEOUT)
+	defer cancel()
+
+	ack, err := c.GetStrategyGeneration(ctxT, &strategy.StrategyGenerationRequest{})
+	if err != nil {
+		return err
+	}
+	if acked := t.ackedGeneration(node); ack.Generation != acked {
+		logrus.Warnf("[Policy Controller] %v drifted: applied generation %v, acknowledged %v",
+			nodeKey, ack.Generation, acked)
+		t.setAcked(node, ack.Generation)
+	}
+	return nil
+}
+
+func (t *policyTargets) ackedGeneration(node *nodeSync) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return node.applied
+}
+
+func (t *policyTargets) setAcked(node *nodeSync, generation uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node.applied = generation
+}
+
+func (t *policyTargets) client(ipAddr string) (strategy.StrategyClient, error) {
+	if t.dial != nil {
+		return t.dial(ipAddr)
+	}
+	return makeClient(ipAddr)
+}
+
+// selectPolicies returns the policies selecting node.
+func selectPolicies(node *v1.NodeInfo, policies []*v1.Policy) []*v1.Policy {
+	selected := make([]*v1.Policy, 0, len(policies))
+	for _, policy := range policies {
+		if policy.Targets(node) {
+			selected = append(selected, policy)
+		}
+	}
+	return selected
+}
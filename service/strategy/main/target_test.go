@@ -0,0 +1,219 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"github.com/p1nant0m/xdp-tracing/service"
+	"github.com/p1nant0m/xdp-tracing/service/strategy"
+	"google.golang.org/grpc"
+)
+
+// fakeNode acknowledges the sets it is pushed, except the first one which is lost
+type fakeNode struct {
+	strategy.StrategyClient // the RPCs not used by policyTargets
+
+	mu         sync.Mutex
+	pushes     int
+	generation uint64
+	policies   []*strategy.Policy
+}
+
+func (n *fakeNode) ApplyStrategy(ctx context.Context, in *strategy.StrategySet,
+	opts ...grpc.CallOption) (*strategy.StrategyAck, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.pushes++; n.pushes == 1 {
+		return nil, errors.New("connection reset")
+	}
+	n.generation, n.policies = in.Generation, in.Policies
+	return &strategy.StrategyAck{Generation: n.generation, Status: strategy.STATUS_OK}, nil
+}
+
+func (n *fakeNode) GetStrategyGeneration(ctx context.Context, in *strategy.StrategyGenerationRequest,
+	opts ...grpc.CallOption) (*strategy.StrategyAck, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return &strategy.StrategyAck{Generation: n.generation, Status: strategy.STATUS_OK}, nil
+}
+
+func newTargetedPolicy(id string, selector *v1.NodeSelector) *v1.Policy {
+	policy := &v1.Policy{ID: id, CIDR: "172.17.0.11", NodeSelector: selector}
+	policy.Default()
+	return policy
+}
+
+func TestSelectPolicies(t *testing.T) {
+	lb := &v1.NodeInfo{ID: "1", Name: "lb-1", Labels: map[string]string{"tier": "edge"}}
+	all := newTargetedPolicy("all", nil)
+	edge := newTargetedPolicy("edge", &v1.NodeSelector{Labels: map[string]string{"tier": "edge"}})
+	db := newTargetedPolicy("db", &v1.NodeSelector{Name: "db-*"})
+
+	if selected := selectPolicies(lb, []*v1.Policy{all, edge, db}); len(selected) != 2 || selected[1] != edge {
+		t.Fatalf("Expected policies all and edge selected, got %v", len(selected))
+	}
+}
+
+func TestPolicyTargetsPushUntilAcknowledged(t *testing.T) {
+	node := &fakeNode{}
+	targets := newPolicyTargets(100)
+	targets.dial = func(string) (strategy.StrategyClient, error) { return node, nil }
+
+	remoteHost.mu.Lock()
+	remoteHost.Specs = map[string]*service.SpecConfig{"1": {Name: "lb-1"}}
+	remoteHost.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	targets.AddNode(ctx, NODE_KEY_PREFIX+"1", "127.0.0.1:50051")
+	targets.SetPolicies([]*v1.Policy{
+		newTargetedPolicy("lb", &v1.NodeSelector{Name: "lb-*"}),
+		newTargetedPolicy("db", &v1.NodeSelector{Name: "db-*"}),
+	})
+
+	// the first push is lost and retried after MIN_RETRY_PERIOD
+	deadline := time.Now().Add(3 * MIN_RETRY_PERIOD)
+	for time.Now().Before(deadline) {
+		node.mu.Lock()
+		generation, policies := node.generation, node.policies
+		node.mu.Unlock()
+		if generation == 101 {
+			if len(policies) != 1 || policies[0].Id != "lb" {
+				t.Fatalf("Expected only policy lb pushed, got %v", policies)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected generation 101 acknowledged, got %v after %v pushes", node.generation, node.pushes)
+}
+
+func TestPolicyTargetsWatch(t *testing.T) {
+	targets := newPolicyTargets(200)
+	targets.SetPolicies([]*v1.Policy{newTargetedPolicy("all", nil)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sets := make(chan *strategy.StrategySet, 2)
+	done := make(chan error)
+	go func() {
+		done <- targets.Watch(ctx, NODE_KEY_PREFIX+"2", 0, func(set *strategy.StrategySet) error {
+			sets <- set
+			return nil
+		})
+	}()
+
+	if set := <-sets; set.Generation != 201 || len(set.Policies) != 1 {
+		t.Fatalf("Expected generation 201 with policy all sent, got %v", set)
+	}
+	targets.SetPolicies(nil)
+	if set := <-sets; set.Generation != 202 || len(set.Policies) != 0 {
+		t.Fatalf("Expected generation 202 without policies sent, got %v", set)
+	}
+	if !targets.watched(NODE_KEY_PREFIX + "2") {
+		t.Fatalf("Expected node 2 watched")
+	}
+
+	cancel()
+	if err := <-done; err != nil || targets.watched(NODE_KEY_PREFIX+"2") {
+		t.Fatalf("Expected the subscription closed, got %v", err)
+	}
+}
+
+func TestPolicyTargetsExpire(t *testing.T) {
+	targets := newPolicyTargets(300)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go targets.Expire(ctx)
+
+	expiring := newTargetedPolicy("expiring", nil)
+	expiry := time.Now().Add(100 * time.Millisecond)
+	expiring.Expiry = &expiry
+	targets.SetPolicies([]*v1.Policy{expiring, newTargetedPolicy("kept", nil)})
+
+	sets := make(chan *strategy.StrategySet, 2)
+	go targets.Watch(ctx, NODE_KEY_PREFIX+"3", 0, func(set *strategy.StrategySet) error {
+		sets <- set
+		return nil
+	})
+
+	if set := <-sets; set.Generation != 301 || len(set.Policies) != 2 {
+		t.Fatalf("Expected generation 301 with 2 policies sent, got %v", set)
+	}
+	select {
+	case set := <-sets:
+		if set.Generation != 302 || len(set.Policies) != 1 || set.Policies[0].Id != "kept" {
+			t.Fatalf("Expected generation 302 revoking policy expiring, got %v", set)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected policy expiring revoked")
+	}
+}
+
+func TestAddRegisteredNodes(t *testing.T) {
+	nodes := map[string]*fakeNode{"127.0.0.1:50052": {}, "127.0.0.1:50053": {}}
+	saved := targets
+	defer func() { targets = saved }()
+	targets = newPolicyTargets(400)
+	targets.dial = func(ipAddr string) (strategy.StrategyClient, error) { return nodes[ipAddr], nil }
+	targets.SetPolicies([]*v1.Policy{newTargetedPolicy("all", nil)})
+
+	// both nodes were registered before the controller started
+	remoteHost.mu.Lock()
+	remoteHost.Storage = map[string]string{
+		NODE_KEY_PREFIX + "4": "127.0.0.1:50052",
+		NODE_KEY_PREFIX + "5": "127.0.0.1:50053",
+	}
+	remoteHost.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the sync loops look the nodes up while they are added
+	looking := make(chan struct{})
+	go func(targets *policyTargets) {
+		close(looking)
+		for ctx.Err() == nil {
+			targets.desired(NODE_KEY_PREFIX + "4")
+		}
+	}(targets)
+	<-looking
+	added := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			addRegisteredNodes(ctx)
+		}
+		close(added)
+	}()
+	select {
+	case <-added:
+		remoteHost.mu.Lock()
+		remoteHost.Storage = make(map[string]string)
+		remoteHost.mu.Unlock()
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the registered nodes added without blocking")
+	}
+
+	deadline := time.Now().Add(3 * MIN_RETRY_PERIOD)
+	for time.Now().Before(deadline) {
+		acknowledged := 0
+		for _, node := range nodes {
+			node.mu.Lock()
+			if node.generation == 401 {
+				acknowledged++
+			}
+			node.mu.Unlock()
+		}
+		if acknowledged == len(nodes) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected generation 401 acknowledged by both nodes")
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/p1nant0m/xdp-tracing/service/strategy"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// controllerServer serves the nodes subscribing to the policy controller with WatchStrategies,
+// see strategy.Server.Watch.
+type controllerServer struct {
+	strategy.UnimplementedStrategyServer
+}
+
+func (s *controllerServer) WatchStrategies(in *strategy.WatchStrategiesRequest,
+	stream strategy.Strategy_WatchStrategiesServer) error {
+	if in.NodeId == "" {
+		return status.Error(codes.InvalidArgument, "node id is required")
+	}
+	nodeKey := NODE_KEY_PREFIX + in.NodeId
+	logrus.Infof("[Policy Controller] %v subscribed with generation %v", nodeKey, in.Generation)
+	defer logrus.Infof("[Policy Controller] subscription of %v closed", nodeKey)
+	return targets.Watch(stream.Context(), nodeKey, in.Generation, stream.Send)
+}
+
+// serveSubscriptions listens at the port of addr for the nodes subscribing to the policy
+// controller until ctx is done, the server certificate and key are loaded from credsPath.
+func serveSubscriptions(ctx context.Context, addr string, credsPath string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	creds, err := credentials.NewServerTLSFromFile(credsPath+"server.crt", credsPath+"server.key")
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer(grpc.Creds(creds))
+	strategy.RegisterStrategyServer(server, &controllerServer{})
+	go func() {
+		<-ctx.Done()
+		// subscriptions never end by themselves, stop rather than wait for them
+		server.Stop()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logrus.Warnf("[Policy Controller] subscription server stopped err=%v", err)
+		}
+	}()
+	logrus.Infof("[Policy Controller] serving subscriptions of nodes at :%v", port)
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+)
+
+// MonitorReport reports the packets which the monitor policies applied on this node would
+// have dropped, it is published in the registry under v1.MONITOR_KEY_PREFIX.
+func (s *Server) MonitorReport() (*v1.MonitorReport, error) {
+	s.policyCacheMu.Lock()
+	defer s.policyCacheMu.Unlock()
+
+	entries, _, err := s.Enforcer.Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &v1.MonitorReport{Generation: s.appliedGeneration, WouldDrop: make(map[string]uint64), Timestamp: time.Now()}
+	for id, policy := range s.policyCache {
+		if policy.Action != v1.ACTION_MONITOR {
+			continue
+		}
+		// an inactive policy has no entries and would have dropped nothing
+		if e, exists := entries[id]; exists {
+			report.WouldDrop[id] = e.Drops
+		} else {
+			report.WouldDrop[id] = 0
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	v1 "github.com/p1nant0m/xdp-tracing/pkg/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PolicyToProto converts policy into its wire representation.
+func PolicyToProto(policy *v1.Policy) *Policy {
+	p := &Policy{
+		Id:          policy.ID,
+		Cidr:        policy.CIDR,
+		Protocol:    policy.Protocol,
+		Direction:   policy.Direction,
+		Action:      policy.Action,
+		Priority:    policy.Priority,
+		Labels:      policy.Labels,
+		Description: policy.Description,
+	}
+	for _, r := range policy.Ports {
+		p.Ports = append(p.Ports, &PortRange{Start: uint32(r.Start), End: uint32(r.End)})
+	}
+	if policy.Expiry != nil {
+		p.Expiry = policy.Expiry.Unix()
+	}
+	if s := policy.NodeSelector; s != nil {
+		p.NodeSelector = &NodeSelector{NodeIds: s.NodeIDs, Name: s.Name, Roles: s.Roles, Labels: s.Labels,
+			FilterMode: s.FilterMode}
+	}
+	if s := policy.Schedule; s != nil {
+		p.Schedule = &Schedule{Days: s.Days, Start: s.Start, End: s.End, Location: s.Location}
+	}
+	return p
+}
+
+// PoliciesToProto converts policies into their wire representation.
+func PoliciesToProto(policies []*v1.Policy) []*Policy {
+	ret := make([]*Policy, 0, len(policies))
+	for _, policy := range policies {
+		ret = append(ret, PolicyToProto(policy))
+	}
+	return ret
+}
+
+// PolicyFromProto converts the wire representation p into Policy, it fails on ports out of
+// range but leaves the other fields to (*v1.Policy).Validate.
+func PolicyFromProto(p *Policy) (*v1.Policy, error) {
+	policy := &v1.Policy{
+		ID:          p.Id,
+		CIDR:        p.Cidr,
+		Protocol:    p.Protocol,
+		Direction:   p.Direction,
+		Action:      p.Action,
+		Priority:    p.Priority,
+		Labels:      p.Labels,
+		Description: p.Description,
+	}
+	for _, r := range p.Ports {
+		if r.Start > math.MaxUint16 || r.End > math.MaxUint16 {
+			return nil, fmt.Errorf("port range %v-%v exceeds 65535", r.Start, r.End)
+		}
+		policy.Ports = append(policy.Ports, v1.PortRange{Start: uint16(r.Start), End: uint16(r.End)})
+	}
+	if p.Expiry != 0 {
+		expiry := time.Unix(p.Expiry, 0)
+		policy.Expiry = &expiry
+	}
+	if s := p.NodeSelector; s != nil {
+		policy.NodeSelector = &v1.NodeSelector{NodeIDs: s.NodeIds, Name: s.Name, Roles: s.Roles, Labels: s.Labels,
+			FilterMode: s.FilterMode}
+	}
+	if s := p.Schedule; s != nil {
+		policy.Schedule = &v1.Schedule{Days: s.Days, Start: s.Start, End: s.End, Location: s.Location}
+	}
+	return policy, nil
+}
+
+// PoliciesFromProto converts and validates the policies received through gRPC, the error
+// carries codes.InvalidArgument.
+func PoliciesFromProto(in []*Policy) ([]*v1.Policy, error) {
+	policies := make([]*v1.Policy, 0, len(in))
+	for i, p := range in {
+		if p.Id == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "policy #%v: id is required", i)
+		}
+
+		policy, err := PolicyFromProto(p)
+		if err == nil {
+			err = policy.Validate()
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "policy %v: %v", p.Id, err)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
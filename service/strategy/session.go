@@ -0,0 +1,103 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	PROC_NET_TCP          = "/proc/net/tcp"
+	tcpStateEstablished   = "01"
+	procNetTCPAddrLen     = 8 // hex digits of an IPv4 address in PROC_NET_TCP
+	procNetTCPMinFieldNum = 4
+)
+
+// Session is an established TCP connection of the node.
+type Session struct {
+	LocalIP    net.IP
+	LocalPort  uint16
+	RemoteIP   net.IP
+	RemotePort uint16
+}
+
+func (s Session) String() string {
+	return fmt.Sprintf("%v:%v -> %v:%v", s.RemoteIP, s.RemotePort, s.LocalIP, s.LocalPort)
+}
+
+// EstablishedSessions returns the established IPv4 TCP sessions of the node whose local
+// addresses are in localIPs.
+func EstablishedSessions(localIPs []net.IP) ([]Session, error) {
+	f, err := os.Open(PROC_NET_TCP)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readSessions(bufio.NewScanner(f), localIPs)
+}
+
+// readSessions parses the table of PROC_NET_TCP, the first line is the header.
+func readSessions(scanner *bufio.Scanner, localIPs []net.IP) ([]Session, error) {
+	var sessions []Session
+	for header := true; scanner.Scan(); header = false {
+		fields := strings.Fields(scanner.Text())
+		if header || len(fields) < procNetTCPMinFieldNum || fields[3] != tcpStateEstablished {
+			continue
+		}
+
+		var (
+			session Session
+			err     error
+		)
+		if session.LocalIP, session.LocalPort, err = parseProcNetAddr(fields[1]); err != nil {
+			return nil, err
+		}
+		if session.RemoteIP, session.RemotePort, err = parseProcNetAddr(fields[2]); err != nil {
+			return nil, err
+		}
+		if containsIP(localIPs, session.LocalIP) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, scanner.Err()
+}
+
+// parseProcNetAddr parses the address like "0100007F:0016" of PROC_NET_TCP, where the IP
+// address is printed as a u32 of the host byte order and the port in hex.
+func parseProcNetAddr(s string) (net.IP, uint16, error) {
+	addr, port, found := strings.Cut(s, ":")
+	if !found || len(addr) != procNetTCPAddrLen {
+		return nil, 0, fmt.Errorf("malformed address %q in %v", s, PROC_NET_TCP)
+	}
+
+	raw, err := hex.DecodeString(addr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed address %q in %v: %w", s, PROC_NET_TCP, err)
+	}
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed port %q in %v: %w", s, PROC_NET_TCP, err)
+	}
+
+	// the nodes are little endian as the eBPF object is built for, so the bytes of the
+	// address are reversed
+	return net.IPv4(raw[3], raw[2], raw[1], raw[0]).To4(), uint16(p), nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -22,8 +22,11 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type StrategyClient interface {
-	InstallStrategy(ctx context.Context, in *UpdateStrategy, opts ...grpc.CallOption) (*UpdateStrategyReply, error)
-	RevokeStrategy(ctx context.Context, in *UpdateStrategy, opts ...grpc.CallOption) (*UpdateStrategyReply, error)
+	ApplyStrategy(ctx context.Context, in *StrategySet, opts ...grpc.CallOption) (*StrategyAck, error)
+	GetStrategyGeneration(ctx context.Context, in *StrategyGenerationRequest, opts ...grpc.CallOption) (*StrategyAck, error)
+	ListStrategies(ctx context.Context, in *ListStrategiesRequest, opts ...grpc.CallOption) (*ListStrategiesReply, error)
+	GetNodeStatus(ctx context.Context, in *NodeStatusRequest, opts ...grpc.CallOption) (*NodeStatus, error)
+	WatchStrategies(ctx context.Context, in *WatchStrategiesRequest, opts ...grpc.CallOption) (Strategy_WatchStrategiesClient, error)
 }
 
 type strategyClient struct {
@@ -34,30 +37,83 @@ func NewStrategyClient(cc grpc.ClientConnInterface) StrategyClient {
 	return &strategyClient{cc}
 }
 
-func (c *strategyClient) InstallStrategy(ctx context.Context, in *UpdateStrategy, opts ...grpc.CallOption) (*UpdateStrategyReply, error) {
-	out := new(UpdateStrategyReply)
-	err := c.cc.Invoke(ctx, "/strategy.Strategy/InstallStrategy", in, out, opts...)
+func (c *strategyClient) ApplyStrategy(ctx context.Context, in *StrategySet, opts ...grpc.CallOption) (*StrategyAck, error) {
+	out := new(StrategyAck)
+	err := c.cc.Invoke(ctx, "/strategy.Strategy/ApplyStrategy", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *strategyClient) RevokeStrategy(ctx context.Context, in *UpdateStrategy, opts ...grpc.CallOption) (*UpdateStrategyReply, error) {
-	out := new(UpdateStrategyReply)
-	err := c.cc.Invoke(ctx, "/strategy.Strategy/RevokeStrategy", in, out, opts...)
+func (c *strategyClient) GetStrategyGeneration(ctx context.Context, in *StrategyGenerationRequest, opts ...grpc.CallOption) (*StrategyAck, error) {
+	out := new(StrategyAck)
+	err := c.cc.Invoke(ctx, "/strategy.Strategy/GetStrategyGeneration", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
+func (c *strategyClient) ListStrategies(ctx context.Context, in *ListStrategiesRequest, opts ...grpc.CallOption) (*ListStrategiesReply, error) {
+	out := new(ListStrategiesReply)
+	err := c.cc.Invoke(ctx, "/strategy.Strategy/ListStrategies", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *strategyClient) GetNodeStatus(ctx context.Context, in *NodeStatusRequest, opts ...grpc.CallOption) (*NodeStatus, error) {
+	out := new(NodeStatus)
+	err := c.cc.Invoke(ctx, "/strategy.Strategy/GetNodeStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *strategyClient) WatchStrategies(ctx context.Context, in *WatchStrategiesRequest, opts ...grpc.CallOption) (Strategy_WatchStrategiesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Strategy_ServiceDesc.Streams[0], "/strategy.Strategy/WatchStrategies", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &strategyWatchStrategiesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Strategy_WatchStrategiesClient interface {
+	Recv() (*StrategySet, error)
+	grpc.ClientStream
+}
+
+type strategyWatchStrategiesClient struct {
+	grpc.ClientStream
+}
+
+func (x *strategyWatchStrategiesClient) Recv() (*StrategySet, error) {
+	m := new(StrategySet)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // StrategyServer is the server API for Strategy service.
 // All implementations must embed UnimplementedStrategyServer
 // for forward compatibility
 type StrategyServer interface {
-	InstallStrategy(context.Context, *UpdateStrategy) (*UpdateStrategyReply, error)
-	RevokeStrategy(context.Context, *UpdateStrategy) (*UpdateStrategyReply, error)
+	ApplyStrategy(context.Context, *StrategySet) (*StrategyAck, error)
+	GetStrategyGeneration(context.Context, *StrategyGenerationRequest) (*StrategyAck, error)
+	ListStrategies(context.Context, *ListStrategiesRequest) (*ListStrategiesReply, error)
+	GetNodeStatus(context.Context, *NodeStatusRequest) (*NodeStatus, error)
+	WatchStrategies(*WatchStrategiesRequest, Strategy_WatchStrategiesServer) error
 	mustEmbedUnimplementedStrategyServer()
 }
 
@@ -65,11 +121,20 @@ type StrategyServer interface {
 type UnimplementedStrategyServer struct {
 }
 
-func (UnimplementedStrategyServer) InstallStrategy(context.Context, *UpdateStrategy) (*UpdateStrategyReply, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method InstallStrategy not implemented")
+func (UnimplementedStrategyServer) ApplyStrategy(context.Context, *StrategySet) (*StrategyAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApplyStrategy not implemented")
+}
+func (UnimplementedStrategyServer) GetStrategyGeneration(context.Context, *StrategyGenerationRequest) (*StrategyAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStrategyGeneration not implemented")
+}
+func (UnimplementedStrategyServer) ListStrategies(context.Context, *ListStrategiesRequest) (*ListStrategiesReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListStrategies not implemented")
 }
-func (UnimplementedStrategyServer) RevokeStrategy(context.Context, *UpdateStrategy) (*UpdateStrategyReply, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method RevokeStrategy not implemented")
+func (UnimplementedStrategyServer) GetNodeStatus(context.Context, *NodeStatusRequest) (*NodeStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNodeStatus not implemented")
+}
+func (UnimplementedStrategyServer) WatchStrategies(*WatchStrategiesRequest, Strategy_WatchStrategiesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStrategies not implemented")
 }
 func (UnimplementedStrategyServer) mustEmbedUnimplementedStrategyServer() {}
 
@@ -84,42 +149,99 @@ func RegisterStrategyServer(s grpc.ServiceRegistrar, srv StrategyServer) {
 	s.RegisterService(&Strategy_ServiceDesc, srv)
 }
 
-func _Strategy_InstallStrategy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(UpdateStrategy)
+func _Strategy_ApplyStrategy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StrategySet)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StrategyServer).ApplyStrategy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/strategy.Strategy/ApplyStrategy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StrategyServer).ApplyStrategy(ctx, req.(*StrategySet))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Strategy_GetStrategyGeneration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StrategyGenerationRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(StrategyServer).InstallStrategy(ctx, in)
+		return srv.(StrategyServer).GetStrategyGeneration(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/strategy.Strategy/InstallStrategy",
+		FullMethod: "/strategy.Strategy/GetStrategyGeneration",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(StrategyServer).InstallStrategy(ctx, req.(*UpdateStrategy))
+		return srv.(StrategyServer).GetStrategyGeneration(ctx, req.(*StrategyGenerationRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Strategy_RevokeStrategy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(UpdateStrategy)
+func _Strategy_ListStrategies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListStrategiesRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(StrategyServer).RevokeStrategy(ctx, in)
+		return srv.(StrategyServer).ListStrategies(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/strategy.Strategy/RevokeStrategy",
+		FullMethod: "/strategy.Strategy/ListStrategies",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(StrategyServer).RevokeStrategy(ctx, req.(*UpdateStrategy))
+		return srv.(StrategyServer).ListStrategies(ctx, req.(*ListStrategiesRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Strategy_GetNodeStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StrategyServer).GetNodeStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/strategy.Strategy/GetNodeStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StrategyServer).GetNodeStatus(ctx, req.(*NodeStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Strategy_WatchStrategies_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStrategiesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StrategyServer).WatchStrategies(m, &strategyWatchStrategiesServer{stream})
+}
+
+type Strategy_WatchStrategiesServer interface {
+	Send(*StrategySet) error
+	grpc.ServerStream
+}
+
+type strategyWatchStrategiesServer struct {
+	grpc.ServerStream
+}
+
+func (x *strategyWatchStrategiesServer) Send(m *StrategySet) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Strategy_ServiceDesc is the grpc.ServiceDesc for Strategy service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -128,14 +250,28 @@ var Strategy_ServiceDesc = grpc.ServiceDesc{
 	HandlerType: (*StrategyServer)(nil),
 	Methods: []grpc.MethodDesc{
 		{
-			MethodName: "InstallStrategy",
-			Handler:    _Strategy_InstallStrategy_Handler,
+			MethodName: "ApplyStrategy",
+			Handler:    _Strategy_ApplyStrategy_Handler,
 		},
 		{
-			MethodName: "RevokeStrategy",
-			Handler:    _Strategy_RevokeStrategy_Handler,
+			MethodName: "GetStrategyGeneration",
+			Handler:    _Strategy_GetStrategyGeneration_Handler,
+		},
+		{
+			MethodName: "ListStrategies",
+			Handler:    _Strategy_ListStrategies_Handler,
+		},
+		{
+			MethodName: "GetNodeStatus",
+			Handler:    _Strategy_GetNodeStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStrategies",
+			Handler:       _Strategy_WatchStrategies_Handler,
+			ServerStreams: true,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "strategy.proto",
 }
@@ -0,0 +1,69 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package strategy
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	WATCH_MIN_RETRY_PERIOD = time.Second
+	WATCH_MAX_RETRY_PERIOD = 30 * time.Second
+)
+
+// Watch subscribes to the policy controller through client and applies the sets it sends
+// until ctx is done. The subscription carries the generation applied, so the controller only
+// sends a set when it differs. The subscription is made again with backoff when it breaks or
+// a set fails to be applied, which has the controller send the set again.
+func (s *Server) Watch(ctx context.Context, client StrategyClient) {
+	retry := WATCH_MIN_RETRY_PERIOD
+	for {
+		applied, err := s.watchOnce(ctx, client)
+		if ctx.Err() != nil {
+			return
+		}
+		if applied {
+			retry = WATCH_MIN_RETRY_PERIOD
+		}
+		logrus.Warnf("[gRPC Server] subscription to policy controller broke, retry in %v err=%v", retry, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		if retry *= 2; retry > WATCH_MAX_RETRY_PERIOD {
+			retry = WATCH_MAX_RETRY_PERIOD
+		}
+	}
+}
+
+// watchOnce applies the sets received from a single subscription, it reports whether any
+// set has been applied.
+func (s *Server) watchOnce(ctx context.Context, client StrategyClient) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.WatchStrategies(ctx, &WatchStrategiesRequest{NodeId: s.NodeID, Generation: s.generation()})
+	if err != nil {
+		return false, err
+	}
+	logrus.Infof("[gRPC Server] subscribed to policy controller with generation %v", s.generation())
+
+	applied := false
+	for {
+		set, err := stream.Recv()
+		if err != nil {
+			return applied, err
+		}
+		if _, err := s.ApplyStrategy(ctx, set); err != nil {
+			return applied, err
+		}
+		applied = true
+	}
+}
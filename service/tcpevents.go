@@ -0,0 +1,59 @@
+// Copyright 2022 p1nant0m <wgblike@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/p1nant0m/xdp-tracing/pkg/ebpf"
+)
+
+const (
+	// SESSION_TCP_STATS_KEY_PREFIX prefixes the serialized session key to make the Redis key
+	// of the counters of TCP retransmissions, resets and state changes of the connection.
+	SESSION_TCP_STATS_KEY_PREFIX = "session-tcp-stats:"
+	// SESSION_TCP_STATS_TTL is how long the counters are kept after the last update, so the
+	// counters of closed connections do not pile up in Redis.
+	SESSION_TCP_STATS_TTL = 24 * time.Hour
+)
+
+// SessionTCPStatsKey returns the Redis key of the counters of the session keyed by key.
+func SessionTCPStatsKey(key string) string {
+	return SESSION_TCP_STATS_KEY_PREFIX + key
+}
+
+func EncodeTCPConnStats(stats *ebpf.TCPConnStats) (string, error) {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func DecodeTCPConnStats(statsSerdString string) (*ebpf.TCPConnStats, error) {
+	stats := &ebpf.TCPConnStats{}
+	if err := json.Unmarshal([]byte(statsSerdString), stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// TCPConnSessionKeys returns the serialized keys of both sessions the connection consists of,
+// the egress one from the local end and the ingress one to the local end. Nil is returned
+// for connections which are not IPv4.
+func TCPConnSessionKeys(conn *ebpf.TCPConn) []string {
+	localIP, remoteIP := net.ParseIP(conn.LocalIP).To4(), net.ParseIP(conn.RemoteIP).To4()
+	if localIP == nil || remoteIP == nil {
+		return nil
+	}
+
+	return []string{
+		EncodeKey(&Key{SrcIP: localIP, DstIP: remoteIP, SrcPort: layers.TCPPort(conn.LocalPort), DstPort: layers.TCPPort(conn.RemotePort)}),
+		EncodeKey(&Key{SrcIP: remoteIP, DstIP: localIP, SrcPort: layers.TCPPort(conn.RemotePort), DstPort: layers.TCPPort(conn.LocalPort)}),
+	}
+}